@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/grafov/m3u8"
+	log "github.com/sirupsen/logrus"
+)
+
+// abrState is what an ABR algorithm knows when picking the rendition of
+// the next segment. Bitrates are in Mb/s and times in seconds.
+type abrState struct {
+	Ladder          []float64
+	Buffer          float64
+	MaxBuffer       float64
+	SegmentDuration float64
+	Estimate        float64
+	// Last is the index in Ladder of the previous segment's rendition, or
+	// -1 before the first.
+	Last int
+}
+
+// ABR is an adaptive bitrate algorithm, picking the index in the ladder of
+// the rendition to fetch the next segment of.
+type ABR interface {
+	Choose(state *abrState) int
+}
+
+// abrAlgorithms are the ABR algorithms simulated by name.
+var abrAlgorithms = map[string]func() ABR{
+	"throughput": func() ABR { return throughputABR{safety: 0.9} },
+	"bola":       func() ABR { return bolaABR{gamma: 5} },
+}
+
+// throughputABR picks the highest rendition below a safe fraction of the
+// estimated bandwidth, as hls.js and Shaka Player do by default.
+type throughputABR struct {
+	safety float64
+}
+
+func (t throughputABR) Choose(state *abrState) int {
+	choice := 0
+	for i, bitrate := range state.Ladder {
+		if bitrate <= t.safety*state.Estimate {
+			choice = i
+		}
+	}
+	return choice
+}
+
+// bolaABR is BOLA-BASIC, which ignores bandwidth and picks renditions by
+// how full the buffer is, maximizing the utility, the log of its bitrate
+// relative to the lowest, gained per bit downloaded.
+type bolaABR struct {
+	gamma float64
+}
+
+func (b bolaABR) Choose(state *abrState) int {
+	utilities := make([]float64, len(state.Ladder))
+	for i, bitrate := range state.Ladder {
+		utilities[i] = math.Log(bitrate / state.Ladder[0])
+	}
+	v := (state.MaxBuffer - state.SegmentDuration) / (utilities[len(utilities)-1] + b.gamma)
+	choice, best := 0, math.Inf(-1)
+	for i, bitrate := range state.Ladder {
+		score := (v*(utilities[i]+b.gamma) - state.Buffer) / bitrate
+		if score > best {
+			choice, best = i, score
+		}
+	}
+	return choice
+}
+
+// throughputSample is the throughput in Mb/s of a segment download recorded
+// by a run.
+type throughputSample struct {
+	Mbps float64
+}
+
+// ABRResult is how a simulated player fared with an ABR algorithm: the
+// segments it fetched of every rendition by bitrate, how often it switched,
+// how long it took to start and how often and long it stalled after that.
+type ABRResult struct {
+	Algorithm    string         `json:"algorithm"`
+	Segments     int            `json:"segments"`
+	Choices      map[string]int `json:"choices"`
+	AverageMbps  float64        `json:"average_mbps"`
+	Switches     int            `json:"switches"`
+	Startup      float64        `json:"startup_seconds"`
+	Rebuffers    int            `json:"rebuffers"`
+	RebufferTime float64        `json:"rebuffer_seconds"`
+	FinalBuffer  float64        `json:"final_buffer_seconds"`
+}
+
+// simulateABR plays back a trace of throughputs with abr picking from the
+// ladder. Every segment downloads at the throughput of the next sample of
+// the trace, taking as long as its rendition's bitrate makes it.
+func simulateABR(name string, abr ABR, ladder []float64, trace []throughputSample, segmentDuration, maxBuffer float64) *ABRResult {
+	result := &ABRResult{Algorithm: name, Choices: map[string]int{}}
+	fast, slow := newEWMA(bandwidthFastHalfLife), newEWMA(bandwidthSlowHalfLife)
+	state := &abrState{Ladder: ladder, MaxBuffer: maxBuffer, SegmentDuration: segmentDuration, Last: -1}
+	var total float64
+	for i, sample := range trace {
+		if i > 0 {
+			state.Estimate = math.Min(fast.get(), slow.get())
+		}
+		choice := 0
+		if i > 0 {
+			choice = abr.Choose(state)
+		}
+		bitrate := ladder[choice]
+		download := bitrate * segmentDuration / sample.Mbps
+		switch {
+		case i == 0:
+			result.Startup = download
+		case download > state.Buffer:
+			result.Rebuffers++
+			result.RebufferTime += download - state.Buffer
+			state.Buffer = 0
+		default:
+			state.Buffer -= download
+		}
+		// Players wait for the buffer to drain below its maximum before
+		// fetching more.
+		state.Buffer = math.Min(state.Buffer+segmentDuration, maxBuffer)
+		fast.sample(download, sample.Mbps)
+		slow.sample(download, sample.Mbps)
+		if state.Last >= 0 && choice != state.Last {
+			result.Switches++
+		}
+		state.Last = choice
+		result.Choices[strconv.FormatFloat(bitrate, 'f', -1, 64)]++
+		total += bitrate
+	}
+	result.Segments = len(trace)
+	if len(trace) > 0 {
+		result.AverageMbps = total / float64(len(trace))
+	}
+	result.FinalBuffer = state.Buffer
+	return result
+}
+
+// abrTrace returns the throughputs of the variant's segments recorded in an
+// archive, their average duration and the ladder of its master playlist, if
+// one was recorded.
+func abrTrace(a *Archive) ([]throughputSample, float64, []float64) {
+	var trace []throughputSample
+	var ladder []float64
+	var durations float64
+	for _, event := range a.Events {
+		switch {
+		case event.Kind == KindSegment && event.ErrorCategory == "" && event.Rendition == "" && event.Timings != nil && event.Timings.Total > 0:
+			trace = append(trace, throughputSample{Mbps: mbps(event.Bytes, event.Timings.Total)})
+			durations += event.Duration
+		case event.Kind == KindPlaylist && ladder == nil:
+			body, err := a.ReadFile(event)
+			if err != nil {
+				continue
+			}
+			playlist, listType, err := m3u8.DecodeFrom(bytes.NewReader(body), true)
+			if err != nil || listType != m3u8.MASTER {
+				continue
+			}
+			for _, variant := range playlist.(*m3u8.MasterPlaylist).Variants {
+				if variant != nil && !variant.Iframe {
+					ladder = append(ladder, float64(variant.Bandwidth)/1000000)
+				}
+			}
+		}
+	}
+	var duration float64
+	if len(trace) > 0 {
+		duration = durations / float64(len(trace))
+	}
+	return trace, duration, ladder
+}
+
+// parseLadder reads a comma separated list of bitrates in bits per second.
+func parseLadder(value string) ([]float64, error) {
+	var ladder []float64
+	for _, b := range strings.Split(value, ",") {
+		bps, err := strconv.ParseFloat(strings.TrimSpace(b), 64)
+		if err != nil || bps <= 0 {
+			return nil, fmt.Errorf("bitrate %q of -ladder is not a positive number of bits per second", b)
+		}
+		ladder = append(ladder, bps/1000000)
+	}
+	return ladder, nil
+}
+
+func abrCommand(args []string) {
+	fs := flag.NewFlagSet("abr", flag.ExitOnError)
+	algorithms := fs.String("algorithms", "throughput,bola", "comma separated ABR `algorithms` to compare, out of throughput and bola")
+	ladderFlag := fs.String("ladder", "", "comma separated `bitrates` in bits per second of the renditions to choose from (default those of the recorded master playlist)")
+	segmentDuration := fs.Float64("segment-duration", 0, "duration of the simulated segments in seconds (default the average of those recorded)")
+	maxBuffer := fs.Float64("max-buffer", 30, "seconds of media the simulated player buffers at most")
+	jsonOut := fs.String("json", "", "also write the results as JSON to this `file`")
+	fs.Usage = func() {
+		os.Stderr.Write([]byte("Usage: hlsbenchmark abr [flags] archive-dir|archive.tar.gz|events.jsonl\n"))
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, envPrefix+"ABR_", args)
+	if fs.NArg() < 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	archive, err := OpenArchive(fs.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer archive.Close()
+	trace, duration, ladder := abrTrace(archive)
+	if *ladderFlag != "" {
+		if ladder, err = parseLadder(*ladderFlag); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *segmentDuration > 0 {
+		duration = *segmentDuration
+	}
+	switch {
+	case len(trace) == 0:
+		log.Fatal("No segment downloads of the variant were recorded to simulate")
+	case len(ladder) == 0:
+		log.Fatal("No master playlist was recorded, give the renditions to choose from with -ladder")
+	case duration <= 0:
+		log.Fatal("Recorded segments have no duration, give one with -segment-duration")
+	}
+	sort.Float64s(ladder)
+
+	log.WithField("Segments", len(trace)).
+		WithField("Ladder", ladder).
+		WithField("SegmentDuration", duration).
+		Info("Simulating ABR against the recorded throughputs")
+	var results []*ABRResult
+	for _, name := range strings.Split(*algorithms, ",") {
+		name = strings.TrimSpace(name)
+		newABR, ok := abrAlgorithms[name]
+		if !ok {
+			log.Fatalf("Unknown ABR algorithm %q", name)
+		}
+		r := simulateABR(name, newABR(), ladder, trace, duration, *maxBuffer)
+		log.WithField("AverageMbps", r.AverageMbps).
+			WithField("Switches", r.Switches).
+			WithField("Startup", r.Startup).
+			WithField("Rebuffers", r.Rebuffers).
+			WithField("RebufferSeconds", r.RebufferTime).
+			WithField("Choices", r.Choices).
+			Infof("ABR %v", name)
+		results = append(results, r)
+	}
+	if *jsonOut != "" {
+		b, err := json.MarshalIndent(results, "", "  ")
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := ioutil.WriteFile(*jsonOut, b, 0644); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grafov/m3u8"
+)
+
+var (
+	adMarkers = flag.String("ad-markers", "", "comma separated ways of telling ad segments from content: cue (between EXT-X-CUE-OUT and EXT-X-CUE-IN), daterange (during an EXT-X-DATERANGE with SCTE35-OUT) and discontinuity (every other stretch between discontinuities)")
+	adHosts   = flag.String("ad-hosts", "", "comma separated hosts whose segments are ads")
+)
+
+// The Class of segments when telling ads from content.
+const (
+	ClassAd      = "ad"
+	ClassContent = "content"
+)
+
+func hasAdMarker(marker string) bool {
+	for _, m := range strings.Split(*adMarkers, ",") {
+		if strings.TrimSpace(m) == marker {
+			return true
+		}
+	}
+	return false
+}
+
+func isAdHost(uri string) bool {
+	u, err := url.Parse(uri)
+	if err != nil || *adHosts == "" {
+		return false
+	}
+	for _, host := range strings.Split(*adHosts, ",") {
+		if strings.EqualFold(strings.TrimSpace(host), u.Hostname()) {
+			return true
+		}
+	}
+	return false
+}
+
+// adBreak is the time an EXT-X-DATERANGE with SCTE35-OUT covers, in seconds
+// since the epoch.
+type adBreak struct {
+	start, end float64
+}
+
+// adMarks returns whether the markers in body make each segment of mpl an
+// ad. The decoder only keeps some of them, so the playlist is read again.
+func adMarks(body []byte, mpl *m3u8.MediaPlaylist) []bool {
+	marks := make([]bool, len(mpl.Segments))
+	cue, discontinuity := hasAdMarker("cue"), hasAdMarker("discontinuity")
+	// An odd discontinuity sequence means the window starts in a break.
+	inCue, inDiscontinuity := false, mpl.DiscontinuitySeq%2 == 1
+	var breaks []adBreak
+	i := 0
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "#EXT-X-CUE-OUT"):
+			inCue = true
+		case strings.HasPrefix(line, "#EXT-X-CUE-IN"):
+			inCue = false
+		case line == "#EXT-X-DISCONTINUITY":
+			inDiscontinuity = !inDiscontinuity
+		case strings.HasPrefix(line, "#EXT-X-DATERANGE:"):
+			attrs := parseAttributes(strings.TrimPrefix(line, "#EXT-X-DATERANGE:"))
+			if _, ok := attrs["SCTE35-OUT"]; !ok {
+				continue
+			}
+			start, err := time.Parse(time.RFC3339Nano, attrs["START-DATE"])
+			if err != nil {
+				continue
+			}
+			duration := attrs["DURATION"]
+			if duration == "" {
+				duration = attrs["PLANNED-DURATION"]
+			}
+			seconds, err := strconv.ParseFloat(duration, 64)
+			if err != nil {
+				continue
+			}
+			from := float64(start.UnixNano()) / 1e9
+			breaks = append(breaks, adBreak{from, from + seconds})
+		case strings.HasPrefix(line, "#"):
+		default:
+			if i < len(marks) {
+				marks[i] = (cue && inCue) || (discontinuity && inDiscontinuity)
+			}
+			i++
+		}
+	}
+	if hasAdMarker("daterange") && len(breaks) > 0 && hasProgramDateTime(mpl) {
+		for i, t := range segmentTimes(mpl, true) {
+			for _, b := range breaks {
+				if t >= b.start && t < b.end {
+					marks[i] = true
+				}
+			}
+		}
+	}
+	return marks
+}
+
+// classifyAds sets the Class of the segments of mpl to ad or content, going
+// by -ad-markers and -ad-hosts.
+func classifyAds(body []byte, mpl *m3u8.MediaPlaylist, segments []*SegmentDownload) {
+	if *adMarkers == "" && *adHosts == "" {
+		return
+	}
+	marks := adMarks(body, mpl)
+	for _, segment := range segments {
+		i := segment.Sequence - mpl.SeqNo
+		if (i < uint64(len(marks)) && marks[i]) || isAdHost(segment.URI) {
+			segment.Class = ClassAd
+		} else {
+			segment.Class = ClassContent
+		}
+	}
+}
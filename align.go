@@ -0,0 +1,220 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/grafov/m3u8"
+	log "github.com/sirupsen/logrus"
+)
+
+var checkAlignment = flag.Bool("check-alignment", false, "fetch the playlists of every other variant of a master playlist along with the one benchmarked, flagging media sequences, discontinuities and PROGRAM-DATE-TIMEs that drift apart, as those break seamless switching")
+
+// alignmentTolerance is how far apart, in seconds, the PROGRAM-DATE-TIMEs
+// and durations of the same segment of different variants may be.
+const alignmentTolerance = 0.1
+
+// otherVariant is a variant checked against the one benchmarked.
+type otherVariant struct {
+	URL       string
+	Bandwidth uint32
+}
+
+// alignedSegment is what has to match between the segments of the same
+// media sequence number of every variant.
+type alignedSegment struct {
+	discontinuitySeq uint64
+	discontinuity    bool
+	dateTime         time.Time
+	duration         float64
+}
+
+// alignedSegments returns the segments of mpl by media sequence number.
+func alignedSegments(mpl *m3u8.MediaPlaylist) map[uint64]alignedSegment {
+	segments := map[uint64]alignedSegment{}
+	dseq := mpl.DiscontinuitySeq
+	for i, s := range mpl.Segments {
+		if s == nil {
+			continue
+		}
+		if s.Discontinuity {
+			dseq++
+		}
+		segments[mpl.SeqNo+uint64(i)] = alignedSegment{dseq, s.Discontinuity, s.ProgramDateTime, s.Duration}
+	}
+	return segments
+}
+
+// lastSequence returns the media sequence number of the last segment of
+// mpl.
+func lastSequence(mpl *m3u8.MediaPlaylist) uint64 {
+	return mpl.SeqNo + uint64(mpl.Count()) - 1
+}
+
+// AlignmentChecker compares the playlists of the other variants of a master
+// playlist with that of the one benchmarked with -check-alignment. Players
+// switch variants by media sequence number, or by PROGRAM-DATE-TIME, so
+// both have to refer to the same media in every variant.
+type AlignmentChecker struct {
+	mu       sync.Mutex
+	others   []*otherVariant
+	checked  map[string]uint64
+	segments int
+	problems map[string]int
+	drift    float64
+	edgeGap  uint64
+}
+
+func NewAlignmentChecker() *AlignmentChecker {
+	return &AlignmentChecker{checked: map[string]uint64{}, problems: map[string]int{}}
+}
+
+// SetVariants notes the variants of a master playlist at base other than
+// the one selected, leaving out I-frame playlists.
+func (ac *AlignmentChecker) SetVariants(base *url.URL, master *m3u8.MasterPlaylist, selected *m3u8.Variant) {
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+	ac.others = nil
+	seen := map[string]bool{selected.URI: true}
+	for _, v := range master.Variants {
+		if v == nil || v.Iframe || seen[v.URI] {
+			continue
+		}
+		seen[v.URI] = true
+		uri, _, err := resolvePlaylist(base, v.URI)
+		if err != nil {
+			log.Warn(err)
+			continue
+		}
+		ac.others = append(ac.others, &otherVariant{uri, v.Bandwidth})
+	}
+}
+
+// Check fetches the playlist of every other variant and compares it with
+// mpl, that of the variant benchmarked.
+func (ac *AlignmentChecker) Check(run *Run, mpl *m3u8.MediaPlaylist) {
+	ac.mu.Lock()
+	others := ac.others
+	ac.mu.Unlock()
+	for _, v := range others {
+		playlist, err := fetchPlaylist(run, v.URL, "")
+		if err != nil {
+			log.Warnf("Not checking the alignment of variant %v: %v", v.URL, err)
+			continue
+		}
+		if playlist == nil || playlist.Type != m3u8.MEDIA {
+			continue
+		}
+		ac.compare(run.Defects, mpl, v, playlist.Playlist.(*m3u8.MediaPlaylist))
+	}
+}
+
+func (ac *AlignmentChecker) problem(defects *StreamDefects, rule, detail string) {
+	ac.problems[rule]++
+	defects.Record(rule, detail)
+}
+
+// compare compares the segments of other not yet compared with those of
+// the same media sequence number of ref.
+func (ac *AlignmentChecker) compare(defects *StreamDefects, ref *m3u8.MediaPlaylist, v *otherVariant, other *m3u8.MediaPlaylist) {
+	if ref.Count() == 0 || other.Count() == 0 {
+		return
+	}
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+	refLast, otherLast := lastSequence(ref), lastSequence(other)
+	gap := refLast - otherLast
+	if otherLast > refLast {
+		gap = otherLast - refLast
+	}
+	if gap > ac.edgeGap {
+		ac.edgeGap = gap
+	}
+	if gap > 1 {
+		ac.problem(defects, "live edges of variants more than a segment apart", fmt.Sprintf("variant %v ends at media sequence %d, the one benchmarked at %d", v.Bandwidth, otherLast, refLast))
+	}
+	refSegments := alignedSegments(ref)
+	checked, ok := ac.checked[v.URL]
+	for seq, s := range alignedSegments(other) {
+		r, both := refSegments[seq]
+		if !both || (ok && seq <= checked) {
+			continue
+		}
+		if seq > ac.checked[v.URL] {
+			ac.checked[v.URL] = seq
+		}
+		ac.segments++
+		if s.discontinuity != r.discontinuity {
+			ac.problem(defects, "EXT-X-DISCONTINUITY in some variants only", fmt.Sprintf("media sequence %d of variant %v", seq, v.Bandwidth))
+		} else if s.discontinuitySeq != r.discontinuitySeq {
+			ac.problem(defects, "variants disagree on the discontinuity sequence", fmt.Sprintf("media sequence %d is in discontinuity sequence %d of variant %v and %d of the one benchmarked", seq, s.discontinuitySeq, v.Bandwidth, r.discontinuitySeq))
+		}
+		if !s.dateTime.IsZero() && !r.dateTime.IsZero() {
+			drift := math.Abs(s.dateTime.Sub(r.dateTime).Seconds())
+			if drift > ac.drift {
+				ac.drift = drift
+			}
+			if drift > alignmentTolerance {
+				ac.problem(defects, "EXT-X-PROGRAM-DATE-TIME differs between variants", fmt.Sprintf("media sequence %d of variant %v is %.3fs apart from the one benchmarked", seq, v.Bandwidth, drift))
+			}
+		}
+		if math.Abs(s.duration-r.duration) > alignmentTolerance {
+			ac.problem(defects, "segment durations differ between variants", fmt.Sprintf("media sequence %d lasts %.3fs in variant %v and %.3fs in the one benchmarked", seq, s.duration, v.Bandwidth, r.duration))
+		}
+	}
+}
+
+// AlignmentReport summarizes the alignment of the other variants with the
+// one benchmarked: how many segments were compared, the problems found,
+// how far apart their PROGRAM-DATE-TIMEs got at most and by how many
+// segments their live edges did.
+type AlignmentReport struct {
+	Variants   int            `json:"variants"`
+	Segments   int            `json:"segments"`
+	Problems   map[string]int `json:"problems,omitempty"`
+	MaxDrift   time.Duration  `json:"max_drift"`
+	MaxEdgeGap uint64         `json:"max_edge_gap"`
+}
+
+// Report returns nil unless segments were compared.
+func (ac *AlignmentChecker) Report() *AlignmentReport {
+	if ac == nil {
+		return nil
+	}
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+	if ac.segments == 0 {
+		return nil
+	}
+	report := &AlignmentReport{
+		Variants:   len(ac.others),
+		Segments:   ac.segments,
+		Problems:   map[string]int{},
+		MaxDrift:   time.Duration(ac.drift * float64(time.Second)),
+		MaxEdgeGap: ac.edgeGap,
+	}
+	for rule, n := range ac.problems {
+		report.Problems[rule] = n
+	}
+	return report
+}
+
+func (ac *AlignmentChecker) LogSummary() {
+	r := ac.Report()
+	if r == nil {
+		return
+	}
+	entry := log.WithField("Variants", r.Variants).
+		WithField("Segments", r.Segments).
+		WithField("MaxDrift", r.MaxDrift).
+		WithField("MaxEdgeGap", r.MaxEdgeGap)
+	if len(r.Problems) > 0 {
+		entry.WithField("Problems", r.Problems).Warn("Variants are misaligned")
+		return
+	}
+	entry.Info("Variants are aligned")
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sort"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ProtocolTracker counts the protocols negotiated with ALPN by every new
+// connection, overall and by the address connected to, as CDNs sometimes
+// fall back to HTTP/1.1 on some of their edges only.
+type ProtocolTracker struct {
+	mu          sync.Mutex
+	Connections map[string]int
+	Edges       map[string]map[string]int
+}
+
+func NewProtocolTracker() *ProtocolTracker {
+	return &ProtocolTracker{
+		Connections: map[string]int{},
+		Edges:       map[string]map[string]int{},
+	}
+}
+
+// negotiatedProtocol returns the ALPN protocol of conn, which is HTTP/1.1
+// if none was negotiated.
+func negotiatedProtocol(conn net.Conn) string {
+	if tc, ok := conn.(*tls.Conn); ok {
+		if p := tc.ConnectionState().NegotiatedProtocol; p != "" {
+			return p
+		}
+	}
+	return "http/1.1"
+}
+
+// Trace returns req set up to record the protocol of the connection it
+// gets, if it is a new one.
+func (pt *ProtocolTracker) Trace(req *http.Request) *http.Request {
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			if info.Reused || info.Conn == nil {
+				return
+			}
+			edge, _, err := net.SplitHostPort(info.Conn.RemoteAddr().String())
+			if err != nil {
+				edge = info.Conn.RemoteAddr().String()
+			}
+			pt.add(edge, negotiatedProtocol(info.Conn))
+		},
+	}
+	return req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+}
+
+func (pt *ProtocolTracker) add(edge, protocol string) {
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+	pt.Connections[protocol]++
+	if pt.Edges[edge] == nil {
+		pt.Edges[edge] = map[string]int{}
+	}
+	pt.Edges[edge][protocol]++
+}
+
+// ProtocolReport is the protocols negotiated by new connections. Downgraded
+// are the edges that fell back to HTTP/1.1 while others spoke HTTP/2.
+type ProtocolReport struct {
+	Connections map[string]int            `json:"connections"`
+	Edges       map[string]map[string]int `json:"edges"`
+	Downgraded  []string                  `json:"downgraded,omitempty"`
+}
+
+// Report returns nil if no connections were made.
+func (pt *ProtocolTracker) Report() *ProtocolReport {
+	if pt == nil {
+		return nil
+	}
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+	if len(pt.Connections) == 0 {
+		return nil
+	}
+	report := &ProtocolReport{
+		Connections: map[string]int{},
+		Edges:       map[string]map[string]int{},
+	}
+	for p, n := range pt.Connections {
+		report.Connections[p] = n
+	}
+	for edge, counts := range pt.Edges {
+		report.Edges[edge] = map[string]int{}
+		for p, n := range counts {
+			report.Edges[edge][p] = n
+		}
+		if counts["http/1.1"] > 0 && pt.Connections["h2"] > 0 {
+			report.Downgraded = append(report.Downgraded, edge)
+		}
+	}
+	sort.Strings(report.Downgraded)
+	return report
+}
+
+func (pt *ProtocolTracker) LogSummary() {
+	r := pt.Report()
+	if r == nil {
+		return
+	}
+	log.WithField("Connections", r.Connections).Info("Negotiated protocols")
+	for _, edge := range r.Downgraded {
+		log.WithField("Protocols", r.Edges[edge]).
+			Warnf("Edge %v fell back to HTTP/1.1 while others spoke HTTP/2", edge)
+	}
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/grafov/m3u8"
+	log "github.com/sirupsen/logrus"
+)
+
+// Archive is a run recorded with -record, or a bare events.jsonl.
+type Archive struct {
+	Dir    string
+	Info   RunInfo
+	Events []RequestResult
+
+	tmpDir string
+}
+
+func OpenArchive(name string) (*Archive, error) {
+	a := &Archive{Dir: name}
+	eventsFile := filepath.Join(name, "events.jsonl")
+	switch {
+	case isTarball(name):
+		dir, err := ioutil.TempDir("", "hlsbenchmark-analyze")
+		if err != nil {
+			return nil, err
+		}
+		a.Dir, a.tmpDir = dir, dir
+		eventsFile = filepath.Join(dir, "events.jsonl")
+		if err := extractTarball(name, dir); err != nil {
+			a.Close()
+			return nil, err
+		}
+	case strings.HasSuffix(name, ".jsonl"):
+		a.Dir = filepath.Dir(name)
+		eventsFile = name
+	}
+
+	if info, err := ioutil.ReadFile(filepath.Join(a.Dir, "run.json")); err == nil {
+		if err := json.Unmarshal(info, &a.Info); err != nil {
+			a.Close()
+			return nil, err
+		}
+	}
+
+	f, err := os.Open(eventsFile)
+	if err != nil {
+		a.Close()
+		return nil, err
+	}
+	defer f.Close()
+	dec := json.NewDecoder(bufio.NewReader(f))
+	for {
+		var event RequestResult
+		if err := dec.Decode(&event); err == io.EOF {
+			break
+		} else if err != nil {
+			a.Close()
+			return nil, err
+		}
+		a.Events = append(a.Events, event)
+	}
+	return a, nil
+}
+
+// ReadFile returns the recorded body of an event, if it was archived.
+func (a *Archive) ReadFile(event RequestResult) ([]byte, error) {
+	if event.File == "" {
+		return nil, fmt.Errorf("no body recorded for %v", event.URI)
+	}
+	return ioutil.ReadFile(filepath.Join(a.Dir, filepath.FromSlash(event.File)))
+}
+
+func (a *Archive) Close() error {
+	if a.tmpDir == "" {
+		return nil
+	}
+	return os.RemoveAll(a.tmpDir)
+}
+
+func extractTarball(name, dir string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if !strings.HasSuffix(name, ".tar") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		target := filepath.Join(dir, filepath.FromSlash(hdr.Name))
+		if !strings.HasPrefix(target, filepath.Clean(dir)+string(os.PathSeparator)) {
+			return fmt.Errorf("invalid path in archive: %v", hdr.Name)
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		out, err := os.Create(target)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(out, tr)
+		out.Close()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Analyze rebuilds the summaries of a recorded run exactly as the live run
+// would have produced them.
+func (a *Archive) Analyze() *RunSummary {
+	results := ResultSummary{}
+	errs := NewErrorSummary()
+	history := NewPlaylistHistory()
+	defects := NewStreamDefects()
+	parsing := NewParseStats()
+	keys := NewKeyTracker()
+	for _, event := range a.Events {
+		if event.ErrorCategory != "" {
+			errs.RecordAt(event.CompletedAt, event.ErrorCategory, event.Error)
+			switch event.Kind {
+			case KindKey:
+				keys.Failed()
+			case KindLicense:
+				keys.LicenseFailed()
+			}
+			continue
+		}
+		switch event.Kind {
+		case KindSegment:
+			results.Add(&event)
+		case KindKey:
+			if event.Timings != nil {
+				keys.Fetched(event.Timings.Total)
+			}
+		case KindLicense:
+			if event.Timings != nil {
+				keys.Licensed(event.Timings.Total)
+			}
+		case KindPlaylist:
+			if event.ParseTime > 0 {
+				parsing.Add(event.ParseTime, event.Bytes)
+			}
+			body, err := a.ReadFile(event)
+			if err != nil {
+				log.Warn(err)
+				continue
+			}
+			playlist, listType, err := m3u8.DecodeFrom(bytes.NewReader(body), true)
+			if err != nil {
+				errs.RecordAt(event.CompletedAt, ErrValidation, err.Error())
+				continue
+			}
+			// The playlists of renditions are fetched along with the
+			// variant's and would look like rewrites of it.
+			if listType == m3u8.MEDIA && event.Rendition == "" {
+				mpl := playlist.(*m3u8.MediaPlaylist)
+				for _, problem := range history.Observe(event.RequestedAt, mpl) {
+					errs.RecordAt(event.RequestedAt, ErrIntegrity, problem)
+				}
+				defects.Observe(body, mpl)
+				keys.ObservePlaylist(event.RequestedAt, mpl)
+			}
+		}
+	}
+	return &RunSummary{
+		RunID:       a.Info.RunID,
+		PlaylistURL: a.Info.PlaylistURL,
+		Start:       a.Info.Start,
+		End:         a.Info.End,
+		Results:     results,
+		Errors:      errs,
+		History:     history,
+		Defects:     defects,
+		Parsing:     parsing,
+		Keys:        keys,
+		Environment: a.Info.Environment,
+	}
+}
+
+func analyzeCommand(args []string) {
+	fs := flag.NewFlagSet("analyze", flag.ExitOnError)
+	var outputs outputSpecs
+	fs.Var(&outputs, "output", "`type[:target]` to report results to, can be repeated (default console)")
+	fs.Usage = func() {
+		os.Stderr.Write([]byte("Usage: hlsbenchmark analyze archive-dir|archive.tar.gz|events.jsonl\n"))
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, envPrefix+"ANALYZE_", args)
+	if fs.NArg() < 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	archive, err := OpenArchive(fs.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer archive.Close()
+
+	log.WithField("PlaylistURL", archive.Info.PlaylistURL).
+		WithField("Start", archive.Info.Start).
+		WithField("End", archive.Info.End).
+		Infof("Analyzing %d recorded requests", len(archive.Events))
+	output, err := NewOutputSink(outputs)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for i := range archive.Events {
+		output.Result(&archive.Events[i])
+	}
+	output.Summary(archive.Analyze())
+	if err := output.Close(); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// The half-lives in seconds of the fast and slow moving averages of
+// BandwidthEstimator, and the smallest download it learns from, as in
+// Shaka Player.
+const (
+	bandwidthFastHalfLife = 2
+	bandwidthSlowHalfLife = 5
+	bandwidthMinBytes     = 16000
+)
+
+// ewma is an exponentially weighted moving average whose samples are
+// weighted by how long they took, correcting for the bias towards zero of
+// its first few.
+type ewma struct {
+	alpha       float64
+	estimate    float64
+	totalWeight float64
+}
+
+func newEWMA(halfLife float64) *ewma {
+	return &ewma{alpha: math.Exp(math.Log(0.5) / halfLife)}
+}
+
+func (e *ewma) sample(weight, value float64) {
+	adjAlpha := math.Pow(e.alpha, weight)
+	e.estimate = value*(1-adjAlpha) + adjAlpha*e.estimate
+	e.totalWeight += weight
+}
+
+func (e *ewma) get() float64 {
+	return e.estimate / (1 - math.Pow(e.alpha, e.totalWeight))
+}
+
+// BandwidthSample is a download's throughput in Mb/s, measured from the
+// request to its last byte as players do, and what the estimator believed
+// once it was taken into account.
+type BandwidthSample struct {
+	At       time.Time `json:"at"`
+	Mbps     float64   `json:"mbps"`
+	Estimate float64   `json:"estimate"`
+}
+
+// BandwidthEstimator estimates bandwidth from segment downloads the way
+// players do to pick renditions: the lower of a fast and a slow moving
+// average, so it is quick to drop and slow to rise.
+type BandwidthEstimator struct {
+	mu     sync.Mutex
+	fast   *ewma
+	slow   *ewma
+	series []*BandwidthSample
+}
+
+func NewBandwidthEstimator() *BandwidthEstimator {
+	return &BandwidthEstimator{
+		fast: newEWMA(bandwidthFastHalfLife),
+		slow: newEWMA(bandwidthSlowHalfLife),
+	}
+}
+
+// Add samples a download of n bytes that took d to complete at at.
+func (be *BandwidthEstimator) Add(at time.Time, n int64, d time.Duration) {
+	if n < bandwidthMinBytes || d <= 0 {
+		return
+	}
+	rate := mbps(n, d)
+	be.mu.Lock()
+	defer be.mu.Unlock()
+	be.fast.sample(d.Seconds(), rate)
+	be.slow.sample(d.Seconds(), rate)
+	be.series = append(be.series, &BandwidthSample{
+		At:       at,
+		Mbps:     rate,
+		Estimate: math.Min(be.fast.get(), be.slow.get()),
+	})
+}
+
+// BandwidthReport is the estimate at the end of the run, the distributions
+// of the estimates and of the throughputs measured, and their time series.
+type BandwidthReport struct {
+	Samples   int                `json:"samples"`
+	Estimate  float64            `json:"estimate"`
+	Estimates map[string]float64 `json:"estimates"`
+	Measured  map[string]float64 `json:"measured"`
+	Series    []*BandwidthSample `json:"series"`
+}
+
+// Report returns nil if no downloads were large enough to sample.
+func (be *BandwidthEstimator) Report() *BandwidthReport {
+	if be == nil {
+		return nil
+	}
+	be.mu.Lock()
+	defer be.mu.Unlock()
+	if len(be.series) == 0 {
+		return nil
+	}
+	var estimates, measured []float64
+	for _, s := range be.series {
+		estimates = append(estimates, s.Estimate)
+		measured = append(measured, s.Mbps)
+	}
+	return &BandwidthReport{
+		Samples:   len(be.series),
+		Estimate:  be.series[len(be.series)-1].Estimate,
+		Estimates: ratePercentiles(estimates),
+		Measured:  ratePercentiles(measured),
+		Series:    append([]*BandwidthSample(nil), be.series...),
+	}
+}
+
+func (be *BandwidthEstimator) LogSummary() {
+	r := be.Report()
+	if r == nil {
+		return
+	}
+	log.WithField("Samples", r.Samples).
+		WithField("Estimate", r.Estimate).
+		WithField("Estimatep50", r.Estimates["p50"]).
+		WithField("Measuredp50", r.Measured["p50"]).
+		Info("Bandwidth estimate in Mb/s")
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// isCacheHit reports whether the CDN says it served resp from its cache.
+func isCacheHit(resp *http.Response) bool {
+	return strings.Contains(strings.ToUpper(resp.Header.Get("X-Cache")), "HIT")
+}
+
+// cacheStatus returns whether the cache closest to the client served resp
+// from its cache, as HIT, MISS, EXPIRED, STALE, REVALIDATED or BYPASS, going
+// by its Cache-Status, CF-Cache-Status or X-Cache header, or "" if none say.
+func cacheStatus(resp *http.Response) string {
+	if statuses := resp.Header.Get("Cache-Status"); statuses != "" {
+		members := splitTopLevel(statuses, ',')
+		params := parseStructuredParams(splitTopLevel(members[len(members)-1], ';')[1:])
+		switch {
+		case params["hit"] != "":
+			return "HIT"
+		case params["fwd"] == "stale":
+			return "EXPIRED"
+		case params["fwd"] == "bypass", params["fwd"] == "method", params["fwd"] == "request":
+			return "BYPASS"
+		case params["fwd"] != "":
+			return "MISS"
+		}
+	}
+	if status := strings.ToUpper(resp.Header.Get("CF-Cache-Status")); status != "" {
+		return status
+	}
+	// Fastly lists the status of shields first, the edge last.
+	caches := strings.Split(strings.ToUpper(resp.Header.Get("X-Cache")), ",")
+	switch edge := caches[len(caches)-1]; {
+	case strings.Contains(edge, "REFRESH"):
+		return "REVALIDATED"
+	case strings.Contains(edge, "EXPIRED"):
+		return "EXPIRED"
+	case strings.Contains(edge, "STALE"):
+		return "STALE"
+	case strings.Contains(edge, "HIT"):
+		return "HIT"
+	case strings.Contains(edge, "MISS"):
+		return "MISS"
+	}
+	return ""
+}
+
+// SegmentBreakdown splits segment downloads into groups, such as the
+// renditions they belong to, that are often delivered from elsewhere.
+type SegmentBreakdown struct {
+	mu     sync.Mutex
+	times  map[string][]time.Duration
+	bytes  map[string]int64
+	hits   map[string]int
+	misses map[string]int
+}
+
+func NewSegmentBreakdown() *SegmentBreakdown {
+	return &SegmentBreakdown{
+		times:  map[string][]time.Duration{},
+		bytes:  map[string]int64{},
+		hits:   map[string]int{},
+		misses: map[string]int{},
+	}
+}
+
+func (sb *SegmentBreakdown) Add(group string, resp *http.Response, n int64, d time.Duration) {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	sb.times[group] = append(sb.times[group], d)
+	sb.bytes[group] += n
+	if isCacheHit(resp) {
+		sb.hits[group]++
+	} else {
+		sb.misses[group]++
+	}
+}
+
+// BreakdownReport summarizes the segments of one group.
+type BreakdownReport struct {
+	Segments    int                      `json:"segments"`
+	Bytes       int64                    `json:"bytes"`
+	CacheHits   int                      `json:"cache_hits"`
+	CacheMisses int                      `json:"cache_misses"`
+	Latency     map[string]time.Duration `json:"latency,omitempty"`
+}
+
+// Report returns nil if no segments were put in a group.
+func (sb *SegmentBreakdown) Report() map[string]*BreakdownReport {
+	if sb == nil {
+		return nil
+	}
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	if len(sb.times) == 0 {
+		return nil
+	}
+	reports := map[string]*BreakdownReport{}
+	for group, times := range sb.times {
+		reports[group] = &BreakdownReport{
+			Segments:    len(times),
+			Bytes:       sb.bytes[group],
+			CacheHits:   sb.hits[group],
+			CacheMisses: sb.misses[group],
+			Latency:     latencyPercentiles(times),
+		}
+	}
+	return reports
+}
+
+func (sb *SegmentBreakdown) LogSummary() {
+	for group, r := range sb.Report() {
+		entry := log.WithField("Segments", r.Segments).
+			WithField("Bytes", r.Bytes).
+			WithField("CacheHits", r.CacheHits).
+			WithField("CacheMisses", r.CacheMisses)
+		for name, d := range r.Latency {
+			entry = entry.WithField("Latency"+name, d)
+		}
+		entry.Infof("%v segments", group)
+	}
+}
+
+// CacheStatusBreakdown splits segment downloads by their cacheStatus, to
+// tell how much a miss costs.
+type CacheStatusBreakdown struct {
+	*SegmentBreakdown
+}
+
+func NewCacheStatusBreakdown() *CacheStatusBreakdown {
+	return &CacheStatusBreakdown{NewSegmentBreakdown()}
+}
+
+// Report returns nil if no segments had a cache status.
+func (cb *CacheStatusBreakdown) Report() map[string]*BreakdownReport {
+	if cb == nil {
+		return nil
+	}
+	return cb.SegmentBreakdown.Report()
+}
+
+func (cb *CacheStatusBreakdown) LogSummary() {
+	reports := cb.Report()
+	if reports == nil {
+		return
+	}
+	cb.SegmentBreakdown.LogSummary()
+	if hit, miss := reports["HIT"], reports["MISS"]; hit != nil && miss != nil {
+		log.WithField("HITp50", hit.Latency["p50"]).
+			WithField("MISSp50", miss.Latency["p50"]).
+			Infof("Cache misses cost %v on median", miss.Latency["p50"]-hit.Latency["p50"])
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var bundleTo = flag.String("bundle", "", "package the summary, every request's result, the flags used and host details into this .tar.gz file at the end of the run")
+
+// Environment describes where a run was made from, so that the results of
+// different probes describe themselves.
+type Environment struct {
+	Hostname  string    `json:"hostname"`
+	OS        string    `json:"os"`
+	Kernel    string    `json:"kernel,omitempty"`
+	Arch      string    `json:"arch"`
+	CPUs      int       `json:"cpus"`
+	GoVersion string    `json:"go_version"`
+	Addresses []string  `json:"addresses,omitempty"`
+	Resolver  *Resolver `json:"resolver,omitempty"`
+}
+
+// Resolver is the system's DNS configuration, from /etc/resolv.conf.
+type Resolver struct {
+	Nameservers []string `json:"nameservers,omitempty"`
+	Search      []string `json:"search,omitempty"`
+	Options     []string `json:"options,omitempty"`
+}
+
+func currentEnvironment() Environment {
+	hostname, _ := os.Hostname()
+	return Environment{
+		Hostname:  hostname,
+		OS:        runtime.GOOS,
+		Kernel:    kernelRelease(),
+		Arch:      runtime.GOARCH,
+		CPUs:      runtime.NumCPU(),
+		GoVersion: runtime.Version(),
+		Addresses: localAddresses(),
+		Resolver:  systemResolver(),
+	}
+}
+
+// kernelRelease returns the release of the kernel, as uname -r does.
+func kernelRelease() string {
+	if b, err := ioutil.ReadFile("/proc/sys/kernel/osrelease"); err == nil {
+		return strings.TrimSpace(string(b))
+	}
+	if runtime.GOOS == "windows" {
+		return ""
+	}
+	out, err := exec.Command("uname", "-r").Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// localAddresses returns the addresses of the host's interfaces other than
+// loopback ones.
+func localAddresses() []string {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil
+	}
+	var addresses []string
+	for _, addr := range addrs {
+		ipnet, ok := addr.(*net.IPNet)
+		if !ok || ipnet.IP.IsLoopback() {
+			continue
+		}
+		addresses = append(addresses, ipnet.IP.String())
+	}
+	return addresses
+}
+
+// systemResolver returns the configuration of /etc/resolv.conf, nil if
+// there is none.
+func systemResolver() *Resolver {
+	b, err := ioutil.ReadFile("/etc/resolv.conf")
+	if err != nil {
+		return nil
+	}
+	resolver := &Resolver{}
+	for _, line := range strings.Split(string(b), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		switch fields[0] {
+		case "nameserver":
+			resolver.Nameservers = append(resolver.Nameservers, fields[1])
+		case "search", "domain":
+			resolver.Search = append(resolver.Search, fields[1:]...)
+		case "options":
+			resolver.Options = append(resolver.Options, fields[1:]...)
+		}
+	}
+	return resolver
+}
+
+func (e *Environment) LogSummary() {
+	if e == nil {
+		return
+	}
+	entry := log.WithField("Hostname", e.Hostname).
+		WithField("OS", e.OS).
+		WithField("Kernel", e.Kernel).
+		WithField("Arch", e.Arch).
+		WithField("CPUs", e.CPUs).
+		WithField("GoVersion", e.GoVersion).
+		WithField("Addresses", e.Addresses)
+	if e.Resolver != nil {
+		entry = entry.WithField("Nameservers", e.Resolver.Nameservers)
+	}
+	entry.Info("Environment")
+}
+
+// flagConfig returns the value of every flag, including the defaults.
+func flagConfig() map[string]string {
+	config := map[string]string{}
+	flag.VisitAll(func(f *flag.Flag) {
+		config[f.Name] = f.Value.String()
+	})
+	return config
+}
+
+// bundleSink writes a single archive of a run:
+//
+//	run.json
+//	summary.json
+//	events.jsonl
+//	config.json
+//	environment.json
+type bundleSink struct {
+	target string
+	dir    string
+	info   RunInfo
+	events *os.File
+	enc    *json.Encoder
+	// err is the first error writing events, returned by Close.
+	err error
+}
+
+func newBundleSink(target string, info RunInfo) (*bundleSink, error) {
+	dir, err := ioutil.TempDir("", "hlsbenchmark-bundle")
+	if err != nil {
+		return nil, err
+	}
+	events, err := os.Create(filepath.Join(dir, "events.jsonl"))
+	if err != nil {
+		return nil, err
+	}
+	return &bundleSink{
+		target: target,
+		dir:    dir,
+		info:   info,
+		events: events,
+		enc:    json.NewEncoder(events),
+	}, nil
+}
+
+func (b *bundleSink) writeJSON(name string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(b.dir, name), data, 0644)
+}
+
+func (b *bundleSink) Result(result *RequestResult) {
+	if err := b.enc.Encode(result); err != nil && b.err == nil {
+		b.err = err
+		log.Errorf("Bundle incomplete: %v", err)
+	}
+}
+
+func (b *bundleSink) Summary(s *RunSummary) {
+	b.info.End = s.End
+	if err := b.writeJSON("summary.json", newJSONSummary(s)); err != nil {
+		log.Error(err)
+	}
+}
+
+func (b *bundleSink) Close() error {
+	defer os.RemoveAll(b.dir)
+	if err := b.events.Close(); err != nil {
+		return err
+	}
+	files := map[string]interface{}{
+		"run.json":         b.info,
+		"config.json":      flagConfig(),
+		"environment.json": currentEnvironment(),
+	}
+	for name, v := range files {
+		if err := b.writeJSON(name, v); err != nil {
+			return err
+		}
+	}
+	if err := writeTarball(b.target, b.dir); err != nil {
+		return err
+	}
+	return b.err
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// HeaderFields are the values of the headers captured with -capture-header
+// by field.
+type HeaderFields map[string]string
+
+type headerCapture struct {
+	header, field string
+}
+
+// headerCaptures is the repeatable -capture-header flag, Header or
+// Header=field. The field defaults to the header in snake case.
+type headerCaptures []headerCapture
+
+func (hc *headerCaptures) String() string {
+	var captures []string
+	for _, c := range *hc {
+		captures = append(captures, c.header+"="+c.field)
+	}
+	return strings.Join(captures, ", ")
+}
+
+func (hc *headerCaptures) Set(value string) error {
+	parts := strings.SplitN(value, "=", 2)
+	header := http.CanonicalHeaderKey(strings.TrimSpace(parts[0]))
+	if header == "" {
+		return fmt.Errorf("capture header %q is not of the form Header or Header=field", value)
+	}
+	field := strings.ToLower(strings.Replace(header, "-", "_", -1))
+	if len(parts) == 2 {
+		field = strings.TrimSpace(parts[1])
+	}
+	if field == "" {
+		return fmt.Errorf("capture header %q has an empty field name", value)
+	}
+	*hc = append(*hc, headerCapture{header, field})
+	return nil
+}
+
+// fields returns the values of the captured headers in header by field,
+// or nil if none were sent.
+func (hc headerCaptures) fields(header http.Header) HeaderFields {
+	var fields HeaderFields
+	for _, c := range hc {
+		if values := header[c.header]; len(values) > 0 {
+			if fields == nil {
+				fields = HeaderFields{}
+			}
+			fields[c.field] = strings.Join(values, ", ")
+		}
+	}
+	return fields
+}
+
+// names returns the fields captured, in the order they were given.
+func (hc headerCaptures) names() []string {
+	var names []string
+	for _, c := range hc {
+		names = append(names, c.field)
+	}
+	return names
+}
+
+var capturedHeaders headerCaptures
+
+func init() {
+	flag.Var(&capturedHeaders, "capture-header", "`Header[=field]` copy a response header, such as a CDN's debug headers, into a field of every result, named after the header in snake case by default, can be repeated")
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ocsp"
+)
+
+// certExpirySoon is how close to expiring a certificate has to be to be
+// reported as a weak link of its chain.
+const certExpirySoon = 30 * 24 * time.Hour
+
+// OCSPStaple is the status of a certificate according to the OCSP response
+// stapled to the handshake. Verified is whether the response was checked to
+// be signed by the certificate's issuer, which it can't be when the chain
+// has no issuer.
+type OCSPStaple struct {
+	Status     string    `json:"status"`
+	Verified   bool      `json:"verified"`
+	ThisUpdate time.Time `json:"this_update,omitempty"`
+	NextUpdate time.Time `json:"next_update,omitempty"`
+}
+
+// parseStaple reads the status of leaf from a stapled OCSP response,
+// verifying its signature if issuer isn't nil.
+func parseStaple(der []byte, leaf, issuer *x509.Certificate) (*OCSPStaple, error) {
+	resp, err := ocsp.ParseResponseForCert(der, leaf, issuer)
+	if re, ok := err.(ocsp.ResponseError); ok {
+		return &OCSPStaple{Status: fmt.Sprintf("unsuccessful (%v)", re.Status)}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	staple := &OCSPStaple{Verified: issuer != nil, ThisUpdate: resp.ThisUpdate, NextUpdate: resp.NextUpdate}
+	switch resp.Status {
+	case ocsp.Good:
+		staple.Status = "good"
+	case ocsp.Revoked:
+		staple.Status = "revoked"
+	default:
+		staple.Status = "unknown"
+	}
+	return staple, nil
+}
+
+// CertInfo describes a certificate of a chain.
+type CertInfo struct {
+	Subject            string    `json:"subject"`
+	Issuer             string    `json:"issuer"`
+	NotAfter           time.Time `json:"not_after"`
+	ExpiresInDays      int       `json:"expires_in_days"`
+	Key                string    `json:"key"`
+	SignatureAlgorithm string    `json:"signature_algorithm"`
+}
+
+// keyDescription returns the algorithm and size of cert's public key, and
+// whether it is too weak to be trusted.
+func keyDescription(cert *x509.Certificate) (string, bool) {
+	switch key := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		bits := key.N.BitLen()
+		return fmt.Sprintf("RSA %d", bits), bits < 2048
+	case *ecdsa.PublicKey:
+		bits := key.Curve.Params().BitSize
+		return fmt.Sprintf("ECDSA %d", bits), bits < 256
+	default:
+		return cert.PublicKeyAlgorithm.String(), false
+	}
+}
+
+// ChainReport is a certificate chain presented by the hosts listed, and
+// the weak or soon to be invalid links found in it.
+type ChainReport struct {
+	Hosts    []string    `json:"hosts"`
+	Chain    []*CertInfo `json:"chain"`
+	Sent     int         `json:"sent"`
+	OCSP     *OCSPStaple `json:"ocsp,omitempty"`
+	Problems []string    `json:"problems,omitempty"`
+}
+
+// checkChain describes the chain verified for a connection and what's wrong
+// with it. Go refuses connections whose chains don't verify, so those only
+// ever turn up as TLS errors.
+func checkChain(state *tls.ConnectionState, now time.Time) *ChainReport {
+	chain := state.PeerCertificates
+	if len(state.VerifiedChains) > 0 {
+		chain = state.VerifiedChains[0]
+	}
+	report := &ChainReport{Sent: len(state.PeerCertificates)}
+	for i, cert := range chain {
+		key, weak := keyDescription(cert)
+		report.Chain = append(report.Chain, &CertInfo{
+			Subject:            cert.Subject.String(),
+			Issuer:             cert.Issuer.String(),
+			NotAfter:           cert.NotAfter,
+			ExpiresInDays:      int(cert.NotAfter.Sub(now).Hours() / 24),
+			Key:                key,
+			SignatureAlgorithm: cert.SignatureAlgorithm.String(),
+		})
+		if weak {
+			report.Problems = append(report.Problems, fmt.Sprintf("%v has a weak %v key", cert.Subject, key))
+		}
+		// The signatures of roots are never checked.
+		root := i == len(chain)-1 && i > 0
+		switch cert.SignatureAlgorithm {
+		case x509.MD5WithRSA, x509.SHA1WithRSA, x509.DSAWithSHA1, x509.ECDSAWithSHA1:
+			if !root {
+				report.Problems = append(report.Problems, fmt.Sprintf("%v is signed with %v", cert.Subject, cert.SignatureAlgorithm))
+			}
+		}
+		if cert.NotAfter.Sub(now) < certExpirySoon {
+			report.Problems = append(report.Problems, fmt.Sprintf("%v expires in %d days", cert.Subject, report.Chain[i].ExpiresInDays))
+		}
+	}
+	if len(chain) > 2 && len(state.PeerCertificates) == 1 {
+		report.Problems = append(report.Problems, "no intermediate certificates were sent, so clients without them cached can't verify the chain")
+	}
+	if len(state.OCSPResponse) == 0 {
+		report.Problems = append(report.Problems, "no OCSP response was stapled")
+		return report
+	}
+	var issuer *x509.Certificate
+	if len(chain) > 1 {
+		issuer = chain[1]
+	}
+	staple, err := parseStaple(state.OCSPResponse, chain[0], issuer)
+	if err != nil {
+		report.Problems = append(report.Problems, fmt.Sprintf("stapled OCSP response is invalid: %v", err))
+		return report
+	}
+	report.OCSP = staple
+	if !staple.Verified {
+		report.Problems = append(report.Problems, "stapled OCSP response can't be verified without the issuer's certificate")
+	}
+	if staple.Status != "good" {
+		report.Problems = append(report.Problems, fmt.Sprintf("stapled OCSP response says the certificate is %v", staple.Status))
+	}
+	if !staple.NextUpdate.IsZero() && staple.NextUpdate.Before(now) {
+		report.Problems = append(report.Problems, fmt.Sprintf("stapled OCSP response expired at %v", staple.NextUpdate))
+	}
+	return report
+}
+
+// CertTracker checks the certificate chain of every distinct leaf
+// certificate that responses came with.
+type CertTracker struct {
+	mu     sync.Mutex
+	chains map[string]*ChainReport
+}
+
+func NewCertTracker() *CertTracker {
+	return &CertTracker{chains: map[string]*ChainReport{}}
+}
+
+// Observe checks the chain resp came with, if it's a new one.
+func (ct *CertTracker) Observe(resp *http.Response) {
+	if resp.TLS == nil || len(resp.TLS.PeerCertificates) == 0 {
+		return
+	}
+	sum := sha256.Sum256(resp.TLS.PeerCertificates[0].Raw)
+	fingerprint := hex.EncodeToString(sum[:])
+	host := resp.Request.URL.Hostname()
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+	report, ok := ct.chains[fingerprint]
+	if !ok {
+		report = checkChain(resp.TLS, time.Now())
+		ct.chains[fingerprint] = report
+		for _, problem := range report.Problems {
+			log.WithField("Host", host).Warnf("Certificate chain: %v", problem)
+		}
+	}
+	for _, h := range report.Hosts {
+		if h == host {
+			return
+		}
+	}
+	report.Hosts = append(report.Hosts, host)
+	sort.Strings(report.Hosts)
+}
+
+// FirstExpiry returns when the first certificate of all the chains seen
+// expires, or false if none were.
+func (ct *CertTracker) FirstExpiry() (time.Time, bool) {
+	if ct == nil {
+		return time.Time{}, false
+	}
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+	var first time.Time
+	for _, r := range ct.chains {
+		for _, cert := range r.Chain {
+			if first.IsZero() || cert.NotAfter.Before(first) {
+				first = cert.NotAfter
+			}
+		}
+	}
+	return first, !first.IsZero()
+}
+
+// Report returns the chains by the SHA-256 fingerprint of their leaf
+// certificate, or nil if there were no TLS connections.
+func (ct *CertTracker) Report() map[string]*ChainReport {
+	if ct == nil {
+		return nil
+	}
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+	if len(ct.chains) == 0 {
+		return nil
+	}
+	reports := map[string]*ChainReport{}
+	for fingerprint, r := range ct.chains {
+		copied := *r
+		copied.Hosts = append([]string(nil), r.Hosts...)
+		reports[fingerprint] = &copied
+	}
+	return reports
+}
+
+func (ct *CertTracker) LogSummary() {
+	for _, r := range ct.Report() {
+		for _, cert := range r.Chain {
+			log.WithField("NotAfter", cert.NotAfter).
+				WithField("ExpiresInDays", cert.ExpiresInDays).
+				Debugf("Certificate %v", cert.Subject)
+		}
+		entry := log.WithField("Hosts", r.Hosts).WithField("Links", len(r.Chain))
+		if r.OCSP != nil {
+			entry = entry.WithField("OCSP", r.OCSP.Status)
+		}
+		if len(r.Problems) > 0 {
+			entry.WithField("Problems", len(r.Problems)).Warnf("Certificate chain of %v", r.Chain[0].Subject)
+		} else {
+			entry.Infof("Certificate chain of %v", r.Chain[0].Subject)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+// testCertificate creates a certificate for name, self-signed if parent is
+// nil.
+func testCertificate(t *testing.T, name string, serial int64, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(serial),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  parent == nil,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+func TestParseStaple(t *testing.T) {
+	issuer, issuerKey := testCertificate(t, "Test CA", 1, nil, nil)
+	other, otherKey := testCertificate(t, "Other CA", 2, nil, nil)
+	leaf, _ := testCertificate(t, "cdn.example.com", 3, issuer, issuerKey)
+	thisUpdate := time.Now().Add(-time.Minute).UTC().Truncate(time.Second)
+	staple := func(status int, signer *x509.Certificate, key *ecdsa.PrivateKey) []byte {
+		der, err := ocsp.CreateResponse(signer, signer, ocsp.Response{
+			Status:       status,
+			SerialNumber: leaf.SerialNumber,
+			ThisUpdate:   thisUpdate,
+			NextUpdate:   thisUpdate.Add(24 * time.Hour),
+			RevokedAt:    thisUpdate,
+		}, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return der
+	}
+
+	tests := []struct {
+		name     string
+		der      []byte
+		issuer   *x509.Certificate
+		status   string
+		verified bool
+		err      bool
+	}{
+		{"good", staple(ocsp.Good, issuer, issuerKey), issuer, "good", true, false},
+		{"revoked", staple(ocsp.Revoked, issuer, issuerKey), issuer, "revoked", true, false},
+		{"unknown", staple(ocsp.Unknown, issuer, issuerKey), issuer, "unknown", true, false},
+		{"without the issuer", staple(ocsp.Good, issuer, issuerKey), nil, "good", false, false},
+		{"signed by another CA", staple(ocsp.Good, other, otherKey), issuer, "", false, true},
+		{"unsuccessful", ocsp.TryLaterErrorResponse, issuer, "unsuccessful (try later)", false, false},
+		{"garbage", []byte("not OCSP"), issuer, "", false, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := parseStaple(test.der, leaf, test.issuer)
+			if test.err {
+				if err == nil {
+					t.Errorf("parseStaple = %+v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Status != test.status || got.Verified != test.verified {
+				t.Errorf("parseStaple = %v, verified %v, want %v, verified %v", got.Status, got.Verified, test.status, test.verified)
+			}
+			if test.status == "good" && !got.ThisUpdate.Equal(thisUpdate) {
+				t.Errorf("this update = %v, want %v", got.ThisUpdate, thisUpdate)
+			}
+		})
+	}
+}
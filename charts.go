@@ -0,0 +1,431 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"html"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+var chartsDir = flag.String("charts", "", "draw charts of the run into this `directory` once it is over: latency, the latency of every segment over time, throughput, the histogram of their throughputs, and cache, the share of every cache status")
+
+// chartFormats is the -charts-format flag, the formats charts are drawn in.
+type chartFormats []string
+
+func (cf *chartFormats) String() string {
+	return strings.Join(*cf, ",")
+}
+
+func (cf *chartFormats) Set(value string) error {
+	var formats []string
+	for _, format := range strings.Split(value, ",") {
+		format = strings.TrimSpace(format)
+		if format != "svg" && format != "png" {
+			return fmt.Errorf("chart format %q is not svg or png", format)
+		}
+		formats = append(formats, format)
+	}
+	*cf = formats
+	return nil
+}
+
+var chartFormat = chartFormats{"svg"}
+
+func init() {
+	flag.Var(&chartFormat, "charts-format", "`formats` of -charts, svg, png or both as svg,png")
+}
+
+// The size of charts and of the margin around their plots, in pixels.
+const (
+	chartWidth  = 800
+	chartHeight = 400
+	chartMargin = 60
+)
+
+// chartColors are the colors of the slices of pie charts.
+var chartColors = []string{"#4e79a7", "#f28e2b", "#e15759", "#76b7b2", "#59a14f", "#edc948", "#b07aa1", "#ff9da7", "#9c755f", "#bab0ac"}
+
+// chartCanvas is what charts are drawn on, in pixels from the top left
+// corner, with colors as #rrggbb. Text is placed by its baseline and
+// anchored at its start, middle or end.
+type chartCanvas interface {
+	text(x, y float64, anchor string, size int, s string)
+	// verticalText draws s rotated to read upwards, centered on x, y.
+	verticalText(x, y float64, s string)
+	line(x1, y1, x2, y2 float64, color string)
+	rect(x, y, width, height float64, color string)
+	circle(cx, cy, r float64, color string, opacity float64)
+	// slice draws the slice of a pie between the angles from and to, in
+	// radians clockwise from the x axis.
+	slice(cx, cy, r, from, to float64, color string)
+	encode() ([]byte, error)
+}
+
+// newChart returns a canvas in format with title drawn on it.
+func newChart(format, title string) chartCanvas {
+	var c chartCanvas
+	if format == "png" {
+		c = newPNGChart()
+	} else {
+		c = newSVGChart()
+	}
+	c.text(chartWidth/2, 24, "middle", 16, title)
+	return c
+}
+
+// svgChart draws a chart into an SVG document.
+type svgChart struct {
+	bytes.Buffer
+}
+
+func newSVGChart() *svgChart {
+	c := &svgChart{}
+	fmt.Fprintf(c, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" font-family="sans-serif" font-size="12">`+"\n", chartWidth, chartHeight, chartWidth, chartHeight)
+	fmt.Fprintf(c, `<rect width="%d" height="%d" fill="white"/>`+"\n", chartWidth, chartHeight)
+	return c
+}
+
+func (c *svgChart) text(x, y float64, anchor string, size int, s string) {
+	fmt.Fprintf(c, `<text x="%.1f" y="%.1f" text-anchor="%s" font-size="%d">%s</text>`+"\n", x, y, anchor, size, html.EscapeString(s))
+}
+
+func (c *svgChart) verticalText(x, y float64, s string) {
+	fmt.Fprintf(c, `<text x="%.1f" y="%.1f" text-anchor="middle" transform="rotate(-90 %.1f %.1f)">%s</text>`+"\n", x, y, x, y, html.EscapeString(s))
+}
+
+func (c *svgChart) line(x1, y1, x2, y2 float64, color string) {
+	fmt.Fprintf(c, `<line x1="%.1f" y1="%.1f" x2="%.1f" y2="%.1f" stroke="%s"/>`+"\n", x1, y1, x2, y2, color)
+}
+
+func (c *svgChart) rect(x, y, width, height float64, color string) {
+	fmt.Fprintf(c, `<rect x="%.1f" y="%.1f" width="%.1f" height="%.1f" fill="%s"/>`+"\n", x, y, width, height, color)
+}
+
+func (c *svgChart) circle(cx, cy, r float64, color string, opacity float64) {
+	fmt.Fprintf(c, `<circle cx="%.1f" cy="%.1f" r="%.1f" fill="%s"`, cx, cy, r, color)
+	if opacity < 1 {
+		fmt.Fprintf(c, ` fill-opacity="%g"`, opacity)
+	}
+	c.WriteString("/>\n")
+}
+
+func (c *svgChart) slice(cx, cy, r, from, to float64, color string) {
+	if to-from >= 2*math.Pi {
+		c.circle(cx, cy, r, color, 1)
+		return
+	}
+	large := 0
+	if to-from > math.Pi {
+		large = 1
+	}
+	fmt.Fprintf(c, `<path d="M %.1f %.1f L %.1f %.1f A %.1f %.1f 0 %d 1 %.1f %.1f Z" fill="%s"/>`+"\n",
+		cx, cy, cx+r*math.Cos(from), cy+r*math.Sin(from), r, r, large, cx+r*math.Cos(to), cy+r*math.Sin(to), color)
+}
+
+func (c *svgChart) encode() ([]byte, error) {
+	c.WriteString("</svg>\n")
+	return c.Bytes(), nil
+}
+
+// pngChart rasterizes a chart into a PNG image, with the fixed size font of
+// golang.org/x/image whatever the size asked for.
+type pngChart struct {
+	img *image.RGBA
+}
+
+func newPNGChart() *pngChart {
+	c := &pngChart{img: image.NewRGBA(image.Rect(0, 0, chartWidth, chartHeight))}
+	draw.Draw(c.img, c.img.Bounds(), image.White, image.Point{}, draw.Src)
+	return c
+}
+
+// pngColor parses a #rrggbb color, or black or white.
+func pngColor(s string, opacity float64) color.NRGBA {
+	c := color.NRGBA{A: uint8(math.Round(opacity * 255))}
+	switch s {
+	case "white":
+		c.R, c.G, c.B = 255, 255, 255
+	case "black":
+	default:
+		var r, g, b uint8
+		if _, err := fmt.Sscanf(s, "#%02x%02x%02x", &r, &g, &b); err == nil {
+			c.R, c.G, c.B = r, g, b
+		}
+	}
+	return c
+}
+
+// fill blends c over the pixels within bounds that inside reports.
+func (c *pngChart) fill(bounds image.Rectangle, col color.NRGBA, inside func(x, y float64) bool) {
+	src := image.NewUniform(col)
+	bounds = bounds.Intersect(c.img.Bounds())
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if inside(float64(x)+0.5, float64(y)+0.5) {
+				draw.Draw(c.img, image.Rect(x, y, x+1, y+1), src, image.Point{}, draw.Over)
+			}
+		}
+	}
+}
+
+func (c *pngChart) text(x, y float64, anchor string, size int, s string) {
+	d := &font.Drawer{Dst: c.img, Src: image.Black, Face: basicfont.Face7x13}
+	width := d.MeasureString(s)
+	switch anchor {
+	case "middle":
+		x -= float64(width.Round()) / 2
+	case "end":
+		x -= float64(width.Round())
+	}
+	d.Dot = fixed.P(int(math.Round(x)), int(math.Round(y)))
+	d.DrawString(s)
+}
+
+func (c *pngChart) verticalText(x, y float64, s string) {
+	face := basicfont.Face7x13
+	d := &font.Drawer{Src: image.Black, Face: face}
+	width := d.MeasureString(s).Round()
+	height := face.Height
+	horizontal := image.NewRGBA(image.Rect(0, 0, width, height))
+	d.Dst = horizontal
+	d.Dot = fixed.P(0, face.Ascent)
+	d.DrawString(s)
+	// Rotate a quarter turn counterclockwise, centered on x, y.
+	left, top := int(math.Round(x))-height/2, int(math.Round(y))-width/2
+	for hy := 0; hy < height; hy++ {
+		for hx := 0; hx < width; hx++ {
+			if _, _, _, a := horizontal.At(hx, hy).RGBA(); a > 0 {
+				c.img.Set(left+hy, top+width-1-hx, horizontal.At(hx, hy))
+			}
+		}
+	}
+}
+
+func (c *pngChart) line(x1, y1, x2, y2 float64, col string) {
+	steps := int(math.Max(math.Abs(x2-x1), math.Abs(y2-y1)))
+	src := pngColor(col, 1)
+	for i := 0; i <= steps; i++ {
+		f := 0.0
+		if steps > 0 {
+			f = float64(i) / float64(steps)
+		}
+		c.img.Set(int(x1+f*(x2-x1)), int(y1+f*(y2-y1)), src)
+	}
+}
+
+func (c *pngChart) rect(x, y, width, height float64, col string) {
+	r := image.Rect(int(math.Round(x)), int(math.Round(y)), int(math.Round(x+width)), int(math.Round(y+height)))
+	draw.Draw(c.img, r, image.NewUniform(pngColor(col, 1)), image.Point{}, draw.Over)
+}
+
+func (c *pngChart) circle(cx, cy, r float64, col string, opacity float64) {
+	bounds := image.Rect(int(cx-r), int(cy-r), int(cx+r)+1, int(cy+r)+1)
+	c.fill(bounds, pngColor(col, opacity), func(x, y float64) bool {
+		return math.Hypot(x-cx, y-cy) <= r
+	})
+}
+
+func (c *pngChart) slice(cx, cy, r, from, to float64, col string) {
+	bounds := image.Rect(int(cx-r), int(cy-r), int(cx+r)+1, int(cy+r)+1)
+	c.fill(bounds, pngColor(col, 1), func(x, y float64) bool {
+		if math.Hypot(x-cx, y-cy) > r {
+			return false
+		}
+		angle := math.Atan2(y-cy, x-cx)
+		for angle < from {
+			angle += 2 * math.Pi
+		}
+		return angle < to
+	})
+}
+
+func (c *pngChart) encode() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, c.img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// plotX and plotY map a value between 0 and 1 into the plot.
+func plotX(f float64) float64 {
+	return chartMargin + f*(chartWidth-2*chartMargin)
+}
+
+func plotY(f float64) float64 {
+	return chartHeight - chartMargin - f*(chartHeight-2*chartMargin)
+}
+
+// chartAxes draws the axes of a plot, with five ticks along the y axis up
+// to maxY, labeled by label.
+func chartAxes(c chartCanvas, xLabel, yLabel string, maxY float64, label func(float64) string) {
+	c.line(plotX(0), plotY(0), plotX(1), plotY(0), "black")
+	c.line(plotX(0), plotY(0), plotX(0), plotY(1), "black")
+	for i := 0; i <= 5; i++ {
+		f := float64(i) / 5
+		c.line(plotX(0)-4, plotY(f), plotX(1), plotY(f), "#e0e0e0")
+		c.text(plotX(0)-8, plotY(f)+4, "end", 11, label(f*maxY))
+	}
+	c.text(plotX(0.5), chartHeight-16, "middle", 12, xLabel)
+	c.verticalText(16, plotY(0.5), yLabel)
+}
+
+// latencySample is when a segment was requested and how long it took.
+type latencySample struct {
+	at    time.Time
+	total time.Duration
+}
+
+// latencyChart plots the latency of every segment over the run.
+func latencyChart(format string, samples []latencySample, start time.Time) ([]byte, error) {
+	c := newChart(format, "Segment latency over time")
+	var span time.Duration
+	var maxLatency time.Duration
+	for _, s := range samples {
+		if d := s.at.Sub(start); d > span {
+			span = d
+		}
+		if s.total > maxLatency {
+			maxLatency = s.total
+		}
+	}
+	if span <= 0 {
+		span = time.Second
+	}
+	if maxLatency <= 0 {
+		maxLatency = time.Millisecond
+	}
+	chartAxes(c, "Time into the run", "Latency", float64(maxLatency), func(v float64) string {
+		return time.Duration(v).Round(time.Millisecond).String()
+	})
+	for i := 0; i <= 5; i++ {
+		f := float64(i) / 5
+		c.text(plotX(f), plotY(0)+18, "middle", 11, time.Duration(f*float64(span)).Round(time.Second).String())
+	}
+	for _, s := range samples {
+		c.circle(plotX(float64(s.at.Sub(start))/float64(span)), plotY(float64(s.total)/float64(maxLatency)), 2, chartColors[0], 0.6)
+	}
+	return c.encode()
+}
+
+// throughputChart draws the histogram of segment throughputs by the buckets
+// of throughputBuckets.
+func throughputChart(format string, histogram map[string]int) ([]byte, error) {
+	c := newChart(format, "Segment throughput")
+	var buckets []string
+	for _, le := range throughputBuckets {
+		buckets = append(buckets, strconv.FormatFloat(le, 'g', -1, 64))
+	}
+	buckets = append(buckets, "+Inf")
+	highest := 1
+	for _, n := range histogram {
+		if n > highest {
+			highest = n
+		}
+	}
+	chartAxes(c, "Mb/s, up to", "Segments", float64(highest), func(v float64) string {
+		return strconv.Itoa(int(math.Round(v)))
+	})
+	width := 1 / float64(len(buckets))
+	for i, bucket := range buckets {
+		n := histogram[bucket]
+		x, y := plotX(float64(i)*width), plotY(float64(n)/float64(highest))
+		c.rect(x+2, y, plotX(width)-plotX(0)-4, plotY(0)-y, chartColors[0])
+		c.text(plotX((float64(i)+0.5)*width), plotY(0)+18, "middle", 11, bucket)
+	}
+	return c.encode()
+}
+
+// cacheChart draws the share of segments of every cache status as a pie.
+func cacheChart(format string, statuses map[string]*BreakdownReport) ([]byte, error) {
+	c := newChart(format, "Cache statuses")
+	var names []string
+	total := 0
+	for name, r := range statuses {
+		names = append(names, name)
+		total += r.Segments
+	}
+	sort.Slice(names, func(i, j int) bool { return statuses[names[i]].Segments > statuses[names[j]].Segments })
+	cx, cy, radius := float64(chartWidth)/3, float64(chartHeight)/2+10, float64(chartHeight)/2-chartMargin
+	angle := -math.Pi / 2
+	for i, name := range names {
+		share := float64(statuses[name].Segments) / float64(total)
+		color := chartColors[i%len(chartColors)]
+		end := angle + share*2*math.Pi
+		c.slice(cx, cy, radius, angle, end, color)
+		angle = end
+		y := float64(chartMargin + 20*i)
+		c.rect(chartWidth*2/3, y, 12, 12, color)
+		c.text(float64(chartWidth*2/3+20), y+11, "start", 12, fmt.Sprintf("%v: %d (%.1f%%)", name, statuses[name].Segments, 100*share))
+	}
+	return c.encode()
+}
+
+// chartSink draws the charts of -charts once the run is over.
+type chartSink struct {
+	dir     string
+	samples []latencySample
+	charts  map[string][]byte
+}
+
+func (cs *chartSink) Result(r *RequestResult) {
+	if r.Kind != KindSegment || r.ErrorCategory != "" || r.Timings == nil {
+		return
+	}
+	cs.samples = append(cs.samples, latencySample{r.RequestedAt, r.Timings.Total})
+}
+
+func (cs *chartSink) Summary(s *RunSummary) {
+	cs.charts = map[string][]byte{}
+	render := func(name string, chart func(format string) ([]byte, error)) {
+		for _, format := range chartFormat {
+			b, err := chart(format)
+			if err != nil {
+				log.Errorf("Drawing %v chart: %v", name, err)
+				continue
+			}
+			cs.charts[name+"."+format] = b
+		}
+	}
+	if len(cs.samples) > 0 {
+		render("latency", func(format string) ([]byte, error) { return latencyChart(format, cs.samples, s.Start) })
+	}
+	if tr := s.Results.Throughput(); tr != nil && len(tr.Histogram) > 0 {
+		render("throughput", func(format string) ([]byte, error) { return throughputChart(format, tr.Histogram) })
+	}
+	if statuses := s.CacheStatuses.Report(); len(statuses) > 0 {
+		render("cache", func(format string) ([]byte, error) { return cacheChart(format, statuses) })
+	}
+}
+
+func (cs *chartSink) Close() error {
+	if len(cs.charts) == 0 {
+		return nil
+	}
+	if err := os.MkdirAll(cs.dir, 0755); err != nil {
+		return err
+	}
+	for name, chart := range cs.charts {
+		if err := ioutil.WriteFile(filepath.Join(cs.dir, name), chart, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	checkpointFile     = flag.String("checkpoint", "", "periodically save the state of the run to this `file`, removed once the run is over, so that -resume can carry on with it")
+	checkpointInterval = flag.Duration("checkpoint-interval", time.Minute, "how often the state of the run is saved with -checkpoint")
+	resume             = flag.Bool("resume", false, "carry on with the run saved to -checkpoint, if there is one, rather than starting a new one")
+)
+
+// checkpointState is what is saved of a run: who it is, where in the
+// playlist it got to, the segments it had yet to fetch and the aggregates
+// of those it had. The other trackers start over when it is resumed.
+type checkpointState struct {
+	RunID        string                        `json:"run_id"`
+	PlaylistURL  string                        `json:"playlist_url"`
+	Start        time.Time                     `json:"start"`
+	SavedAt      time.Time                     `json:"saved_at"`
+	JoinSequence uint64                        `json:"join_sequence"`
+	LastSequence *uint64                       `json:"last_sequence,omitempty"`
+	Pending      []*SegmentDownload            `json:"pending,omitempty"`
+	Results      ResultSummary                 `json:"results"`
+	Errors       map[ErrorCategory]*ErrorStats `json:"errors,omitempty"`
+}
+
+// resumed is the state loaded with -resume, taken by the next run made.
+var resumed *checkpointState
+
+// loadCheckpoint loads the state of the run of playlistURL saved to
+// -checkpoint, to be resumed by the next run, if there is one.
+func loadCheckpoint(playlistURL string) error {
+	if *checkpointFile == "" {
+		return fmt.Errorf("-resume needs -checkpoint")
+	}
+	b, err := ioutil.ReadFile(*checkpointFile)
+	if os.IsNotExist(err) {
+		log.Infof("No checkpoint to resume in %v, starting a new run", *checkpointFile)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	state := &checkpointState{}
+	if err := json.Unmarshal(b, state); err != nil {
+		return fmt.Errorf("%v: %v", *checkpointFile, err)
+	}
+	if state.PlaylistURL != playlistURL {
+		return fmt.Errorf("%v is a checkpoint of a run of %v, not %v", *checkpointFile, state.PlaylistURL, playlistURL)
+	}
+	resumed = state
+	return nil
+}
+
+// Checkpoint follows the segments of a run's variant from being queued to
+// being downloaded, and saves the state of the run with -checkpoint.
+type Checkpoint struct {
+	mu        sync.Mutex
+	joinSeq   uint64
+	last      *uint64
+	pending   map[uint64]*SegmentDownload
+	results   *ResultSummary
+	resultsMu *sync.Mutex
+	resumed   *checkpointState
+}
+
+func NewCheckpoint() *Checkpoint {
+	return &Checkpoint{pending: map[uint64]*SegmentDownload{}}
+}
+
+// resume makes run carry on with the state loaded with -resume, if any.
+func (c *Checkpoint) resume(run *Run) {
+	state := resumed
+	if state == nil {
+		return
+	}
+	resumed = nil
+	run.ID, run.Start = state.RunID, state.Start
+	for category, stats := range state.Errors {
+		run.Errors.Categories[category] = stats
+	}
+	c.resumed = state
+	log.WithField("RunID", state.RunID).
+		WithField("SavedAt", state.SavedAt).
+		WithField("Pending", len(state.Pending)).
+		Info("Resuming run")
+}
+
+// Results returns the aggregates to carry on from.
+func (c *Checkpoint) Results() ResultSummary {
+	if c.resumed == nil {
+		return ResultSummary{}
+	}
+	return c.resumed.Results
+}
+
+// Track makes the aggregates guarded by mu part of the state saved.
+func (c *Checkpoint) Track(results *ResultSummary, mu *sync.Mutex) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.results, c.resultsMu = results, mu
+}
+
+// Resumed returns the media sequence a resumed run carries on from, after
+// the last segment it downloaded, and the segments it had yet to.
+func (c *Checkpoint) Resumed() (uint64, []*SegmentDownload, bool) {
+	state := c.resumed
+	if state == nil {
+		return 0, nil, false
+	}
+	seq := state.JoinSequence
+	if state.LastSequence != nil && *state.LastSequence+1 > seq {
+		seq = *state.LastSequence + 1
+	}
+	return seq, state.Pending, true
+}
+
+// Joined notes the media sequence the run joined the playlist at.
+func (c *Checkpoint) Joined(seq uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.joinSeq = seq
+}
+
+// Queued notes a segment of the variant as yet to be downloaded.
+func (c *Checkpoint) Queued(v *SegmentDownload) {
+	if *checkpointFile == "" {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pending[v.Sequence] = v
+}
+
+// Done notes a segment of the variant as downloaded, whether it succeeded
+// or not. Other segments, not Queued, are ignored.
+func (c *Checkpoint) Done(v *SegmentDownload) {
+	if *checkpointFile == "" {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.pending[v.Sequence] != v {
+		return
+	}
+	delete(c.pending, v.Sequence)
+	if c.last == nil || v.Sequence > *c.last {
+		seq := v.Sequence
+		c.last = &seq
+	}
+}
+
+// Run saves the state of run every -checkpoint-interval until ctx is done.
+func (c *Checkpoint) Run(ctx context.Context, run *Run) {
+	ticker := time.NewTicker(*checkpointInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := c.save(run); err != nil {
+				log.Errorf("Saving checkpoint: %v", err)
+			}
+		}
+	}
+}
+
+// Close saves the state of a run that was stopped or aborted one last
+// time, or removes that of a run that is over.
+func (c *Checkpoint) Close(run *Run) {
+	if *checkpointFile == "" {
+		return
+	}
+	if run.ctx.Err() != nil || run.Err != nil {
+		if err := c.save(run); err != nil {
+			log.Errorf("Saving checkpoint: %v", err)
+		}
+		return
+	}
+	if err := os.Remove(*checkpointFile); err != nil && !os.IsNotExist(err) {
+		log.Error(err)
+	}
+}
+
+func (c *Checkpoint) save(run *Run) error {
+	state := &checkpointState{
+		RunID:       run.ID,
+		PlaylistURL: run.PlaylistURL,
+		Start:       run.Start,
+		SavedAt:     time.Now(),
+	}
+	c.mu.Lock()
+	state.JoinSequence, state.LastSequence = c.joinSeq, c.last
+	for _, v := range c.pending {
+		state.Pending = append(state.Pending, v)
+	}
+	results, resultsMu := c.results, c.resultsMu
+	c.mu.Unlock()
+	sort.Slice(state.Pending, func(i, j int) bool { return state.Pending[i].Sequence < state.Pending[j].Sequence })
+
+	run.Errors.mu.Lock()
+	state.Errors = run.Errors.Categories
+	var b []byte
+	var err error
+	if results != nil {
+		resultsMu.Lock()
+		state.Results = *results
+		b, err = json.Marshal(state)
+		resultsMu.Unlock()
+	} else {
+		b, err = json.Marshal(state)
+	}
+	run.Errors.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	tmp := *checkpointFile + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, *checkpointFile)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var chunkTiming = flag.Bool("chunk-timing", false, "time the arrival of the chunks of segments sent without a Content-Length, as CMAF chunks of low-latency streams are, reporting the gaps between them")
+
+// chunkTimer times the reads of a body that return data. Go decodes chunked
+// transfer encoding itself, so chunks arriving close together may be read
+// at once, but the gaps between them are those a player would see.
+type chunkTimer struct {
+	io.ReadCloser
+	start    time.Time
+	arrivals []time.Time
+}
+
+func newChunkTimer(body io.ReadCloser) *chunkTimer {
+	return &chunkTimer{ReadCloser: body, start: time.Now()}
+}
+
+func (ct *chunkTimer) Read(p []byte) (int, error) {
+	n, err := ct.ReadCloser.Read(p)
+	if n > 0 {
+		ct.arrivals = append(ct.arrivals, time.Now())
+	}
+	return n, err
+}
+
+// gaps returns the time between successive arrivals.
+func (ct *chunkTimer) gaps() []time.Duration {
+	var gaps []time.Duration
+	for i := 1; i < len(ct.arrivals); i++ {
+		gaps = append(gaps, ct.arrivals[i].Sub(ct.arrivals[i-1]))
+	}
+	return gaps
+}
+
+// ChunkStats gathers the gaps between the chunks of every segment timed
+// with -chunk-timing.
+type ChunkStats struct {
+	mu        sync.Mutex
+	responses int
+	chunks    int
+	gaps      []time.Duration
+}
+
+func NewChunkStats() *ChunkStats {
+	return &ChunkStats{}
+}
+
+// Add records the chunks of a body, returning the longest gap between them.
+func (cs *ChunkStats) Add(ct *chunkTimer) time.Duration {
+	gaps := ct.gaps()
+	var max time.Duration
+	for _, gap := range gaps {
+		if gap > max {
+			max = gap
+		}
+	}
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.responses++
+	cs.chunks += len(ct.arrivals)
+	cs.gaps = append(cs.gaps, gaps...)
+	return max
+}
+
+// ChunkReport summarizes the chunks of the segments timed.
+type ChunkReport struct {
+	Responses int                      `json:"responses"`
+	Chunks    int                      `json:"chunks"`
+	Gap       map[string]time.Duration `json:"gap,omitempty"`
+}
+
+// Report returns nil if no chunked segments were timed.
+func (cs *ChunkStats) Report() *ChunkReport {
+	if cs == nil {
+		return nil
+	}
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	if cs.responses == 0 {
+		return nil
+	}
+	return &ChunkReport{
+		Responses: cs.responses,
+		Chunks:    cs.chunks,
+		Gap:       latencyPercentiles(cs.gaps),
+	}
+}
+
+func (cs *ChunkStats) LogSummary() {
+	r := cs.Report()
+	if r == nil {
+		return
+	}
+	entry := log.WithField("Responses", r.Responses).WithField("Chunks", r.Chunks)
+	for name, d := range r.Gap {
+		entry = entry.WithField("Gap"+name, d)
+	}
+	entry.Info("Chunked segments")
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"flag"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/grafov/m3u8"
+	log "github.com/sirupsen/logrus"
+)
+
+var ntpServer = flag.String("ntp-server", "", "query this NTP `server` at startup and correct live latencies going by EXT-X-PROGRAM-DATE-TIME for the local clock's offset from it")
+
+// ntpEpoch is how far the NTP epoch, 1900, is ahead of the Unix one.
+const ntpEpoch = 2208988800
+
+// clockOffset is what to add to the local clock to get the time according
+// to -ntp-server.
+var clockOffset time.Duration
+
+// wallClock returns the local time corrected for clockOffset.
+func wallClock(t time.Time) time.Time {
+	return t.Add(clockOffset)
+}
+
+func ntpTime(b []byte) time.Time {
+	secs := int64(binary.BigEndian.Uint32(b[0:4])) - ntpEpoch
+	frac := int64(binary.BigEndian.Uint32(b[4:8]))
+	return time.Unix(secs, frac*1000000000>>32)
+}
+
+// queryNTP returns the offset of the local clock from an NTP server, going
+// by a single SNTP exchange.
+func queryNTP(server string) (time.Duration, error) {
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "123")
+	}
+	conn, err := net.DialTimeout("udp", server, 5*time.Second)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	req := make([]byte, 48)
+	// No leap second warning, version 4, client mode.
+	req[0] = 0<<6 | 4<<3 | 3
+	sent := time.Now()
+	if _, err := conn.Write(req); err != nil {
+		return 0, err
+	}
+	resp := make([]byte, 48)
+	n, err := conn.Read(resp)
+	received := time.Now()
+	if err != nil {
+		return 0, err
+	}
+	if n < 48 {
+		return 0, errors.New("short NTP response")
+	}
+	if resp[0]&7 != 4 || resp[1] == 0 {
+		return 0, errors.New("NTP server is unsynchronized or didn't answer as a server")
+	}
+	serverReceived, serverSent := ntpTime(resp[32:40]), ntpTime(resp[40:48])
+	return (serverReceived.Sub(sent) + serverSent.Sub(received)) / 2, nil
+}
+
+// syncClock sets clockOffset from -ntp-server, leaving it at zero if the
+// server can't be reached.
+func syncClock() {
+	if *ntpServer == "" {
+		return
+	}
+	offset, err := queryNTP(*ntpServer)
+	if err != nil {
+		log.Warnf("Not correcting the clock: %v", err)
+		return
+	}
+	clockOffset = offset
+	log.WithField("Offset", offset).Infof("Correcting the clock going by %v", *ntpServer)
+}
+
+// LiveLatencyTracker measures how far behind the live edge a live playlist
+// is every time it's fetched: the time it was fetched less the time the end
+// of its last segment was recorded, going by EXT-X-PROGRAM-DATE-TIME.
+type LiveLatencyTracker struct {
+	mu        sync.Mutex
+	latencies []time.Duration
+}
+
+func NewLiveLatencyTracker() *LiveLatencyTracker {
+	return &LiveLatencyTracker{}
+}
+
+// Observe records the latency of a snapshot of a dated live playlist.
+func (lt *LiveLatencyTracker) Observe(at time.Time, mpl *m3u8.MediaPlaylist) {
+	if mpl.Closed || !hasProgramDateTime(mpl) {
+		return
+	}
+	var end time.Time
+	for _, s := range mpl.Segments {
+		if s == nil {
+			continue
+		}
+		if !s.ProgramDateTime.IsZero() {
+			end = s.ProgramDateTime
+		}
+		end = end.Add(time.Duration(s.Duration * float64(time.Second)))
+	}
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+	lt.latencies = append(lt.latencies, wallClock(at).Sub(end))
+}
+
+// LiveLatencyReport is the live latency of a playlist, and the offset the
+// local clock was corrected by to measure it.
+type LiveLatencyReport struct {
+	Snapshots   int                      `json:"snapshots"`
+	ClockOffset time.Duration            `json:"clock_offset"`
+	Latency     map[string]time.Duration `json:"latency"`
+}
+
+// Report returns nil unless a dated live playlist was fetched.
+func (lt *LiveLatencyTracker) Report() *LiveLatencyReport {
+	if lt == nil {
+		return nil
+	}
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+	if len(lt.latencies) == 0 {
+		return nil
+	}
+	return &LiveLatencyReport{
+		Snapshots:   len(lt.latencies),
+		ClockOffset: clockOffset,
+		Latency:     latencyPercentiles(lt.latencies),
+	}
+}
+
+func (lt *LiveLatencyTracker) LogSummary() {
+	r := lt.Report()
+	if r == nil {
+		return
+	}
+	entry := log.WithField("Snapshots", r.Snapshots).WithField("ClockOffset", r.ClockOffset)
+	for name, d := range r.Latency {
+		entry = entry.WithField("Latency"+name, d)
+	}
+	entry.Info("Live latency")
+}
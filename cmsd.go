@@ -0,0 +1,207 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// splitTopLevel splits a structured header field on sep outside of quoted
+// strings, trimming every member.
+func splitTopLevel(s string, sep byte) []string {
+	var members []string
+	quoted, escaped, start := false, false, 0
+	for i := 0; i < len(s); i++ {
+		switch {
+		case escaped:
+			escaped = false
+		case s[i] == '\\' && quoted:
+			escaped = true
+		case s[i] == '"':
+			quoted = !quoted
+		case s[i] == sep && !quoted:
+			members = append(members, strings.TrimSpace(s[start:i]))
+			start = i + 1
+		}
+	}
+	return append(members, strings.TrimSpace(s[start:]))
+}
+
+// parseStructuredParams reads key=value members of a structured header
+// field, as in the CMSD-Static dictionary or the parameters of a
+// CMSD-Dynamic item. Keys without a value are true.
+func parseStructuredParams(members []string) map[string]string {
+	params := map[string]string{}
+	for _, m := range members {
+		if m == "" {
+			continue
+		}
+		kv := strings.SplitN(m, "=", 2)
+		if len(kv) == 1 {
+			params[kv[0]] = "?1"
+			continue
+		}
+		params[strings.TrimSpace(kv[0])] = strings.Trim(strings.TrimSpace(kv[1]), `"`)
+	}
+	return params
+}
+
+// CMSDHop is what one server on the way said about delivering a response in
+// CMSD-Dynamic, such as its estimated throughput to the client, etp, in
+// kbit/s and its rtt in milliseconds.
+type CMSDHop struct {
+	Server string            `json:"server"`
+	Params map[string]string `json:"params,omitempty"`
+}
+
+// CMSD is the Common Media Server Data, CTA-5006, a response came with:
+// CMSD-Static about the object, such as its duration d in milliseconds, set
+// by the origin, and CMSD-Dynamic from every server it passed, the one
+// closest to the client last.
+type CMSD struct {
+	Static  map[string]string `json:"static,omitempty"`
+	Dynamic []*CMSDHop        `json:"dynamic,omitempty"`
+}
+
+// parseCMSD returns the CMSD of a response, or nil if it has none.
+func parseCMSD(header http.Header) *CMSD {
+	static, dynamic := header.Get("CMSD-Static"), strings.Join(header["Cmsd-Dynamic"], ",")
+	if static == "" && dynamic == "" {
+		return nil
+	}
+	cmsd := &CMSD{}
+	if static != "" {
+		cmsd.Static = parseStructuredParams(splitTopLevel(static, ','))
+	}
+	if dynamic != "" {
+		for _, item := range splitTopLevel(dynamic, ',') {
+			members := splitTopLevel(item, ';')
+			cmsd.Dynamic = append(cmsd.Dynamic, &CMSDHop{
+				Server: strings.Trim(members[0], `"`),
+				Params: parseStructuredParams(members[1:]),
+			})
+		}
+	}
+	return cmsd
+}
+
+// param returns an integer parameter of the hop closest to the client.
+func (c *CMSD) param(key string) (int, bool) {
+	if len(c.Dynamic) == 0 {
+		return 0, false
+	}
+	v, err := strconv.Atoi(c.Dynamic[len(c.Dynamic)-1].Params[key])
+	return v, err == nil
+}
+
+// CMSDStats compares the throughput and round trip times servers reported
+// in CMSD-Dynamic with those measured, by the server closest to the client.
+type CMSDStats struct {
+	mu        sync.Mutex
+	responses int
+	servers   map[string]*cmsdServer
+}
+
+type cmsdServer struct {
+	responses int
+	etp       []float64
+	measured  []float64
+	rtt       []time.Duration
+}
+
+func NewCMSDStats() *CMSDStats {
+	return &CMSDStats{servers: map[string]*cmsdServer{}}
+}
+
+func (cs *CMSDStats) Add(result *RequestResult) {
+	if result.CMSD == nil {
+		return
+	}
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.responses++
+	if len(result.CMSD.Dynamic) == 0 {
+		return
+	}
+	name := result.CMSD.Dynamic[len(result.CMSD.Dynamic)-1].Server
+	server, ok := cs.servers[name]
+	if !ok {
+		server = &cmsdServer{}
+		cs.servers[name] = server
+	}
+	server.responses++
+	if etp, ok := result.CMSD.param("etp"); ok {
+		server.etp = append(server.etp, float64(etp))
+		if result.Timings != nil && result.Timings.ContentTransfer > 0 {
+			kbps := float64(result.Bytes) * 8 / 1000 / result.Timings.ContentTransfer.Seconds()
+			server.measured = append(server.measured, kbps)
+		}
+	}
+	if rtt, ok := result.CMSD.param("rtt"); ok {
+		server.rtt = append(server.rtt, time.Duration(rtt)*time.Millisecond)
+	}
+}
+
+// CMSDServerReport is the estimated throughput in kbit/s and round trip
+// times a server reported, and the throughput measured of the responses it
+// estimated it for.
+type CMSDServerReport struct {
+	Responses int                      `json:"responses"`
+	ETP       map[string]float64       `json:"etp_kbps,omitempty"`
+	Measured  map[string]float64       `json:"measured_kbps,omitempty"`
+	RTT       map[string]time.Duration `json:"rtt,omitempty"`
+}
+
+// CMSDReport is how many responses came with CMSD, and what the servers
+// closest to the client said in it.
+type CMSDReport struct {
+	Responses int                          `json:"responses"`
+	Servers   map[string]*CMSDServerReport `json:"servers,omitempty"`
+}
+
+// Report returns nil if no responses came with CMSD.
+func (cs *CMSDStats) Report() *CMSDReport {
+	if cs == nil {
+		return nil
+	}
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	if cs.responses == 0 {
+		return nil
+	}
+	report := &CMSDReport{Responses: cs.responses, Servers: map[string]*CMSDServerReport{}}
+	for name, server := range cs.servers {
+		report.Servers[name] = &CMSDServerReport{
+			Responses: server.responses,
+			ETP:       ratePercentiles(server.etp),
+			Measured:  ratePercentiles(server.measured),
+			RTT:       latencyPercentiles(server.rtt),
+		}
+	}
+	return report
+}
+
+func (cs *CMSDStats) LogSummary() {
+	r := cs.Report()
+	if r == nil {
+		return
+	}
+	log.WithField("Responses", r.Responses).Info("CMSD")
+	for name, server := range r.Servers {
+		entry := log.WithField("Responses", server.Responses)
+		if server.ETP != nil {
+			entry = entry.WithField("ETPp50", server.ETP["p50"])
+		}
+		if server.Measured != nil {
+			entry = entry.WithField("Measuredp50", server.Measured["p50"])
+		}
+		if server.RTT != nil {
+			entry = entry.WithField("RTTp50", server.RTT["p50"])
+		}
+		entry.Infof("CMSD of %v", name)
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestSplitTopLevel(t *testing.T) {
+	tests := []struct {
+		in   string
+		sep  byte
+		want []string
+	}{
+		{"a, b ,c", ',', []string{"a", "b", "c"}},
+		{`n="a,b", d=5`, ',', []string{`n="a,b"`, "d=5"}},
+		{`n="say \"hi\", ok", su`, ',', []string{`n="say \"hi\", ok"`, "su"}},
+		{`"edge";etp=96;rtt=8`, ';', []string{`"edge"`, "etp=96", "rtt=8"}},
+		{"", ',', []string{""}},
+	}
+	for _, test := range tests {
+		if got := splitTopLevel(test.in, test.sep); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("splitTopLevel(%q, %q) = %q, want %q", test.in, test.sep, got, test.want)
+		}
+	}
+}
+
+func TestParseCMSD(t *testing.T) {
+	tests := []struct {
+		name   string
+		header http.Header
+		want   *CMSD
+	}{
+		{"none", http.Header{"Content-Type": {"video/mp2t"}}, nil},
+		{
+			"static",
+			http.Header{"Cmsd-Static": {`ot=v, sf=h, st=v, d=5000, br=2000, n="Origin, Inc", su`}},
+			&CMSD{Static: map[string]string{"ot": "v", "sf": "h", "st": "v", "d": "5000", "br": "2000", "n": "Origin, Inc", "su": "?1"}},
+		},
+		{
+			"dynamic",
+			http.Header{"Cmsd-Dynamic": {`"CDNA-SHIELD";etp=480;rtt=40, "CDNA-EDGE";etp=96;rtt=8;mb=2000`}},
+			&CMSD{Dynamic: []*CMSDHop{
+				{Server: "CDNA-SHIELD", Params: map[string]string{"etp": "480", "rtt": "40"}},
+				{Server: "CDNA-EDGE", Params: map[string]string{"etp": "96", "rtt": "8", "mb": "2000"}},
+			}},
+		},
+		{
+			"dynamic over several fields",
+			http.Header{"Cmsd-Dynamic": {`"origin"`, `"edge";etp=96`}},
+			&CMSD{Dynamic: []*CMSDHop{
+				{Server: "origin", Params: map[string]string{}},
+				{Server: "edge", Params: map[string]string{"etp": "96"}},
+			}},
+		},
+	}
+	for _, test := range tests {
+		if got := parseCMSD(test.header); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%v: parseCMSD = %+v, want %+v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestCMSDParam(t *testing.T) {
+	cmsd := parseCMSD(http.Header{"Cmsd-Dynamic": {`"shield";etp=480;rtt=40, "edge";etp=96;rtt=x`}})
+	if etp, ok := cmsd.param("etp"); !ok || etp != 96 {
+		t.Errorf("etp = %v, %v; want that of the edge, 96", etp, ok)
+	}
+	if rtt, ok := cmsd.param("rtt"); ok {
+		t.Errorf("rtt = %v, want none as it isn't a number", rtt)
+	}
+	if _, ok := (&CMSD{Static: map[string]string{"d": "5000"}}).param("etp"); ok {
+		t.Error("param of CMSD without CMSD-Dynamic succeeded")
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"flag"
+)
+
+var coalesceRanges = flag.Int("coalesce-ranges", 0, "fetch up to `n` adjacent byte range segments of the same file with a single range request, breaking latency down by whether requests were coalesced")
+
+// canCoalesce reports whether next carries on from the bytes of cur in the
+// same file, so both can be fetched with one range request.
+func canCoalesce(cur, next *SegmentDownload) bool {
+	return cur.URI == next.URI && cur.Limit > 0 && next.Limit > 0 &&
+		next.Offset == cur.Offset+cur.Limit &&
+		cur.Encryption == next.Encryption &&
+		cur.Rendition == next.Rendition &&
+		cur.Class == next.Class
+}
+
+// coalesceSegments merges runs of up to -coalesce-ranges adjacent byte range
+// segments into one download of all their bytes, taking the media sequence
+// number and time of the first of them.
+func coalesceSegments(segments []*SegmentDownload) []*SegmentDownload {
+	if *coalesceRanges < 2 {
+		return segments
+	}
+	var merged []*SegmentDownload
+	for _, s := range segments {
+		if n := len(merged); n > 0 {
+			last := merged[n-1]
+			if last.Coalesced < *coalesceRanges && canCoalesce(last, s) {
+				last.Limit += s.Limit
+				last.Duration += s.Duration
+				last.Tags = append(last.Tags, s.Tags...)
+				last.Paired = append(last.Paired, s.Paired...)
+				last.Coalesced++
+				continue
+			}
+		}
+		copied := *s
+		copied.Coalesced = 1
+		merged = append(merged, &copied)
+	}
+	return merged
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/grafov/m3u8"
+	log "github.com/sirupsen/logrus"
+)
+
+var controlSocket = flag.String("control", "", "listen on a Unix socket at this `path` for commands adjusting the run while it goes on: concurrency, verbose, summary and variant")
+
+// controlHelp describes the commands of -control.
+const controlHelp = `concurrency [n]        show or set how many segments are fetched at once
+verbose on|off         turn debug logging on or off
+summary                log the summary of the run so far and reply with it as JSON
+variant bandwidth|uri  switch to another variant of the master playlist
+help                   show this`
+
+// Control adjusts a run on the commands of -control, without restarting
+// it: how many segments it fetches at once, how much it logs and which
+// variant it benchmarks.
+type Control struct {
+	mu        sync.Mutex
+	workers   int
+	retiring  int
+	spawn     func()
+	results   *ResultSummary
+	resultsMu *sync.Mutex
+	// variant is the variant to switch to, by bandwidth or URI, and switched
+	// whether the playlist loop has yet to.
+	variant  string
+	switched bool
+}
+
+func NewControl() *Control {
+	return &Control{}
+}
+
+// Workers notes that n workers fetch segments, spawn adding another, or
+// that they are done if spawn is nil.
+func (c *Control) Workers(n int, spawn func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.workers, c.retiring, c.spawn = n, 0, spawn
+}
+
+// Retire reports whether a worker should stop, as there are fewer wanted.
+func (c *Control) Retire() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.retiring == 0 {
+		return false
+	}
+	c.retiring--
+	c.workers--
+	return true
+}
+
+// Track makes the aggregates guarded by mu part of the summaries reported.
+func (c *Control) Track(results *ResultSummary, mu *sync.Mutex) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.results, c.resultsMu = results, mu
+}
+
+// Select makes the run benchmark the variant of the master playlist of
+// bandwidth or URI variant rather than that of -variant.
+func (c *Control) Select(variant string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.variant = variant
+}
+
+// Switched returns whether a switch to another variant was asked for that
+// the playlist loop has yet to make, by going back to the master playlist.
+func (c *Control) Switched() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switched := c.switched
+	c.switched = false
+	return switched
+}
+
+// Variant returns the variant of master asked for, or nil if none was or
+// the master playlist doesn't have it.
+func (c *Control) Variant(master *m3u8.MasterPlaylist) *m3u8.Variant {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.variant == "" {
+		return nil
+	}
+	for _, v := range master.Variants {
+		if v == nil || v.Iframe {
+			continue
+		}
+		if v.URI == c.variant || strconv.FormatUint(uint64(v.Bandwidth), 10) == c.variant {
+			return v
+		}
+	}
+	log.Warnf("The master playlist has no variant %v to switch to", c.variant)
+	c.variant = ""
+	return nil
+}
+
+// Serve listens on -control for commands until ctx is done.
+func (c *Control) Serve(ctx context.Context, run *Run) {
+	os.Remove(*controlSocket)
+	l, err := net.Listen("unix", *controlSocket)
+	if err != nil {
+		log.Errorf("Not listening for commands: %v", err)
+		return
+	}
+	defer os.Remove(*controlSocket)
+	go func() {
+		<-ctx.Done()
+		l.Close()
+	}()
+	log.Infof("Listening for commands on %v", *controlSocket)
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go c.handle(run, conn)
+	}
+}
+
+// handle answers the commands of conn, one per line.
+func (c *Control) handle(run *Run, conn net.Conn) {
+	defer conn.Close()
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		reply, err := c.command(run, fields[0], fields[1:])
+		if err != nil {
+			reply = "error: " + err.Error()
+		}
+		if _, err := fmt.Fprintln(conn, reply); err != nil {
+			return
+		}
+	}
+}
+
+func (c *Control) command(run *Run, name string, args []string) (string, error) {
+	switch name {
+	case "concurrency":
+		if len(args) == 0 {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			return strconv.Itoa(c.workers - c.retiring), nil
+		}
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n < 1 {
+			return "", fmt.Errorf("concurrency must be a positive number, not %q", args[0])
+		}
+		return c.resize(n)
+	case "verbose":
+		switch strings.Join(args, " ") {
+		case "on":
+			log.SetLevel(log.DebugLevel)
+		case "off":
+			log.SetLevel(log.InfoLevel)
+		default:
+			return "", fmt.Errorf("verbose takes on or off")
+		}
+		log.Infof("Verbose logging %v", args[0])
+		return "ok", nil
+	case "summary":
+		return c.summary(run)
+	case "variant":
+		if len(args) != 1 {
+			return "", fmt.Errorf("variant takes the bandwidth or URI of a variant")
+		}
+		c.mu.Lock()
+		c.variant, c.switched = args[0], true
+		c.mu.Unlock()
+		log.Infof("Switching to variant %v", args[0])
+		return "ok", nil
+	case "help":
+		return controlHelp, nil
+	}
+	return "", fmt.Errorf("unknown command %q, try help", name)
+}
+
+// resize makes n workers fetch segments, adding workers or retiring some
+// as they finish the segment at hand.
+func (c *Control) resize(n int) (string, error) {
+	c.mu.Lock()
+	if c.spawn == nil {
+		c.mu.Unlock()
+		return "", fmt.Errorf("no segments are being fetched")
+	}
+	current := c.workers - c.retiring
+	spawn := c.spawn
+	for ; current < n && c.retiring > 0; current++ {
+		c.retiring--
+	}
+	added := n - current
+	if added < 0 {
+		c.retiring -= added
+		added = 0
+	}
+	c.workers += added
+	c.mu.Unlock()
+	for i := 0; i < added; i++ {
+		spawn()
+	}
+	log.Infof("Fetching %d segments at once", n)
+	return "ok", nil
+}
+
+// summary logs the summary of the run so far and returns it as JSON. The
+// trackers the playlist loop updates without resultsMu are summarized from
+// snapshots taken under their own locks.
+func (c *Control) summary(run *Run) (string, error) {
+	c.mu.Lock()
+	results, resultsMu := c.results, c.resultsMu
+	c.mu.Unlock()
+	if results == nil {
+		return "", fmt.Errorf("no segments are being fetched")
+	}
+	resultsMu.Lock()
+	summary := run.summarize(*results)
+	consoleSink{}.Summary(summary)
+	b, err := json.Marshal(newJSONSummary(summary))
+	resultsMu.Unlock()
+	return string(b), err
+}
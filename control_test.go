@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"math/rand"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// TestControlSummary asks for summaries of a live run while it goes on,
+// which with -race checks that they don't race with the playlist and segment
+// loops.
+func TestControlSummary(t *testing.T) {
+	level := log.GetLevel()
+	log.SetLevel(log.ErrorLevel)
+	defer log.SetLevel(level)
+	origin := &mockOrigin{
+		start:           time.Now(),
+		segmentDuration: 100 * time.Millisecond,
+		segments:        0,
+		window:          3,
+		payload:         tsPayload(4 * tsPacketSize),
+		rng:             rand.New(rand.NewSource(1)),
+	}
+	server := httptest.NewServer(origin.handler())
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	run, err := newRun(ctx, server.URL+"/live.m3u8", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan *RunSummary)
+	go func() { done <- run.Execute() }()
+
+	summaries := 0
+	for {
+		select {
+		case summary := <-done:
+			if summaries == 0 {
+				t.Fatal("the run ended before a summary could be taken")
+			}
+			if len(summary.Results.Total) == 0 {
+				t.Error("the run fetched no segments")
+			}
+			return
+		case <-time.After(20 * time.Millisecond):
+		}
+		reply, err := run.Control.summary(run)
+		if err != nil {
+			continue
+		}
+		var summary jsonSummary
+		if err := json.Unmarshal([]byte(reply), &summary); err != nil {
+			t.Fatalf("summary %q: %v", reply, err)
+		}
+		if summary.RunID != run.ID {
+			t.Errorf("summary of run %v, want %v", summary.RunID, run.ID)
+		}
+		summaries++
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCronErrors(t *testing.T) {
+	for _, spec := range []string{
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"a * * * *",
+		"1-a * * * *",
+	} {
+		if _, err := parseCron(spec); err == nil {
+			t.Errorf("parseCron(%q) succeeded, want an error", spec)
+		}
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	at := func(year int, month time.Month, day, hour, min, sec int) time.Time {
+		return time.Date(year, month, day, hour, min, sec, 0, time.UTC)
+	}
+	tests := []struct {
+		spec string
+		from time.Time
+		want time.Time
+	}{
+		{"*/15 * * * *", at(2024, 6, 3, 10, 7, 30), at(2024, 6, 3, 10, 15, 0)},
+		// Strictly after, even on a matching minute.
+		{"*/15 * * * *", at(2024, 6, 3, 10, 15, 0), at(2024, 6, 3, 10, 30, 0)},
+		{"0 0 * * *", at(2024, 1, 1, 23, 59, 0), at(2024, 1, 2, 0, 0, 0)},
+		{"10,40 * * * *", at(2024, 6, 3, 10, 11, 0), at(2024, 6, 3, 10, 40, 0)},
+		{"0 9-17/4 * * *", at(2024, 6, 3, 14, 0, 0), at(2024, 6, 3, 17, 0, 0)},
+		// 2024-06-01 is a Saturday.
+		{"0 9 * * 1-5", at(2024, 6, 1, 10, 0, 0), at(2024, 6, 3, 9, 0, 0)},
+		{"0 0 * * 7", at(2024, 6, 3, 0, 0, 0), at(2024, 6, 9, 0, 0, 0)},
+		// Either restricted day field may match.
+		{"0 0 1 * 0", at(2024, 6, 3, 0, 0, 0), at(2024, 6, 9, 0, 0, 0)},
+		{"0 0 5 * 0", at(2024, 6, 3, 0, 0, 0), at(2024, 6, 5, 0, 0, 0)},
+		{"5 4 * 12 *", at(2024, 6, 3, 0, 0, 0), at(2024, 12, 1, 4, 5, 0)},
+		{"0 0 1 1 *", at(2024, 12, 31, 23, 59, 0), at(2025, 1, 1, 0, 0, 0)},
+		{"30 2 29 2 *", at(2024, 3, 1, 0, 0, 0), at(2028, 2, 29, 2, 30, 0)},
+		{"0 0 31 2 *", at(2024, 1, 1, 0, 0, 0), time.Time{}},
+	}
+	for _, test := range tests {
+		cron, err := parseCron(test.spec)
+		if err != nil {
+			t.Errorf("parseCron(%q): %v", test.spec, err)
+			continue
+		}
+		if got := cron.Next(test.from); !got.Equal(test.want) {
+			t.Errorf("%q after %v = %v, want %v", test.spec, test.from, got, test.want)
+		}
+	}
+}
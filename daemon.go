@@ -0,0 +1,917 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	daemonAddr      = flag.String("daemon", "", "instead of benchmarking one playlist, listen on this address for runs to be started, stopped and streamed over HTTP")
+	scheduleHistory = flag.Int("schedule-history", 100, "number of finished runs kept per schedule in daemon mode")
+	runHistory      = flag.Int("run-history", 100, "number of finished runs started over the API, rather than by a schedule, kept in daemon mode")
+	daemonGRPC      = flag.String("daemon-grpc", "", "with -daemon, also serve StartRun, StopRun and StreamResults over gRPC on this address, see daemonpb/daemon.proto")
+	scheduleState   = flag.String("schedule-state", "", "JSON `file` the daemon keeps its schedules and the runs they retain in, so they survive a restart")
+)
+
+// scheduleSpecs is the repeatable -schedule flag, each value being a cron
+// expression, a playlist URL and optionally a maximum run duration.
+type scheduleSpecs []string
+
+func (ss *scheduleSpecs) String() string {
+	return strings.Join(*ss, ", ")
+}
+
+func (ss *scheduleSpecs) Set(value string) error {
+	if _, err := parseScheduleSpec(value); err != nil {
+		return err
+	}
+	*ss = append(*ss, value)
+	return nil
+}
+
+var schedules scheduleSpecs
+
+func init() {
+	flag.Var(&schedules, "schedule", "`\"cron playlist-url [duration]\"` to run in daemon mode, e.g. \"*/15 * * * * https://host/live.m3u8 2m\", can be repeated")
+}
+
+// ScheduleConfig is everything needed to create a schedule. The flags of
+// Profile, and then Flags, are set on top of the daemon's for its runs.
+type ScheduleConfig struct {
+	Cron        string                `json:"cron"`
+	PlaylistURL string                `json:"playlist_url"`
+	Duration    string                `json:"duration,omitempty"`
+	Profile     string                `json:"profile,omitempty"`
+	Flags       map[string]flagValues `json:"flags,omitempty"`
+}
+
+func parseScheduleSpec(value string) (ScheduleConfig, error) {
+	fields := strings.Fields(value)
+	if len(fields) < 6 || len(fields) > 7 {
+		return ScheduleConfig{}, fmt.Errorf("schedule %q is not of the form \"cron playlist-url [duration]\"", value)
+	}
+	config := ScheduleConfig{
+		Cron:        strings.Join(fields[:5], " "),
+		PlaylistURL: fields[5],
+	}
+	if len(fields) == 7 {
+		config.Duration = fields[6]
+	}
+	_, _, _, err := config.parse()
+	return config, err
+}
+
+func (sc ScheduleConfig) parse() (*cronSchedule, time.Duration, flagOverrides, error) {
+	cron, err := parseCron(sc.Cron)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	var duration time.Duration
+	if sc.Duration != "" {
+		if duration, err = time.ParseDuration(sc.Duration); err != nil {
+			return nil, 0, nil, err
+		}
+	}
+	if sc.PlaylistURL == "" {
+		return nil, 0, nil, fmt.Errorf("schedule has no playlist URL")
+	}
+	if err := checkRemotePlaylist(sc.PlaylistURL); err != nil {
+		return nil, 0, nil, err
+	}
+	overrides, err := sc.overrides()
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	return cron, duration, overrides, nil
+}
+
+// daemonFlags are the flags of the daemon itself, which schedules can't set.
+var daemonFlags = map[string]bool{
+	"config":           true,
+	"daemon":           true,
+	"daemon-grpc":      true,
+	"profile":          true,
+	"run-history":      true,
+	"schedule":         true,
+	"schedule-history": true,
+	"schedule-state":   true,
+}
+
+// flagOverrides are the values flags have for runs of a schedule with flags
+// of its own.
+type flagOverrides map[*flag.Flag]reflect.Value
+
+// overrides works out the values of the flags the schedule sets, each
+// starting from the daemon's value so that a repeatable flag such as
+// -output adds to it. They're set on copies, leaving the flags themselves
+// alone until a run swaps them in. Runs with flags of their own also get
+// connections of their own, as if with -transport isolated, since the
+// shared connections were made with the daemon's.
+func (sc ScheduleConfig) overrides() (flagOverrides, error) {
+	values := map[string]flagValues{}
+	if sc.Profile != "" {
+		config, err := loadConfig(*configFile)
+		if err != nil {
+			return nil, err
+		}
+		profile, ok := config.Profiles[sc.Profile]
+		if !ok {
+			profile, ok = builtinProfiles[sc.Profile]
+		}
+		if !ok {
+			return nil, fmt.Errorf("no profile %q, expected one of %v or one from -config", sc.Profile, strings.Join(profileNames(builtinProfiles), ", "))
+		}
+		for name, v := range profile.Flags {
+			values[name] = v
+		}
+	}
+	for name, v := range sc.Flags {
+		values[name] = v
+	}
+	if len(values) == 0 {
+		return nil, nil
+	}
+	if _, ok := values["transport"]; !ok {
+		values["transport"] = flagValues{"isolated"}
+	}
+
+	overrides := flagOverrides{}
+	for name, list := range values {
+		f := flag.Lookup(name)
+		if f == nil {
+			return nil, fmt.Errorf("unknown flag %q", name)
+		}
+		current := reflect.ValueOf(f.Value)
+		if daemonFlags[name] || current.Kind() != reflect.Ptr {
+			return nil, fmt.Errorf("-%v can't be set per schedule", name)
+		}
+		value := reflect.New(current.Elem().Type())
+		value.Elem().Set(current.Elem())
+		for _, v := range list {
+			if err := value.Interface().(flag.Value).Set(v); err != nil {
+				return nil, fmt.Errorf("invalid value %q for -%v: %v", v, name, err)
+			}
+		}
+		overrides[f] = value.Elem()
+	}
+	return overrides, nil
+}
+
+// swap sets the flags to their overridden values, returning the values they
+// had, which swapping back restores.
+func (fo flagOverrides) swap() flagOverrides {
+	previous := flagOverrides{}
+	for f, value := range fo {
+		current := reflect.ValueOf(f.Value).Elem()
+		saved := reflect.New(current.Type()).Elem()
+		saved.Set(current)
+		current.Set(value)
+		previous[f] = saved
+	}
+	return previous
+}
+
+// streamSink broadcasts results to subscribers. Slow subscribers miss
+// results rather than holding up the run.
+type streamSink struct {
+	mu          sync.Mutex
+	subscribers map[chan *RequestResult]bool
+	closed      bool
+
+	// Results are also published to feed as events of run runID.
+	runID string
+	feed  *eventFeed
+}
+
+func newStreamSink(runID string, feed *eventFeed) *streamSink {
+	return &streamSink{
+		subscribers: map[chan *RequestResult]bool{},
+		runID:       runID,
+		feed:        feed,
+	}
+}
+
+func (ss *streamSink) Subscribe() chan *RequestResult {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	ch := make(chan *RequestResult, 256)
+	if ss.closed {
+		close(ch)
+		return ch
+	}
+	ss.subscribers[ch] = true
+	return ch
+}
+
+func (ss *streamSink) Unsubscribe(ch chan *RequestResult) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	if ss.subscribers[ch] {
+		delete(ss.subscribers, ch)
+		close(ch)
+	}
+}
+
+func (ss *streamSink) Result(result *RequestResult) {
+	ss.feed.Publish(runEvent{Run: ss.runID, Result: result})
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	for ch := range ss.subscribers {
+		select {
+		case ch <- result:
+		default:
+		}
+	}
+}
+
+func (ss *streamSink) Summary(*RunSummary) {}
+
+func (ss *streamSink) Close() error {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	ss.closed = true
+	for ch := range ss.subscribers {
+		close(ch)
+	}
+	ss.subscribers = map[chan *RequestResult]bool{}
+	return nil
+}
+
+// notFoundError is the error of operations on runs and schedules that
+// don't exist.
+type notFoundError string
+
+func (nf notFoundError) Error() string {
+	return string(nf)
+}
+
+// conflictError is the error of starting a run while the flags of another
+// are in the way, see Daemon.
+type conflictError string
+
+func (c conflictError) Error() string {
+	return string(c)
+}
+
+type daemonRun struct {
+	ID          string       `json:"id"`
+	PlaylistURL string       `json:"playlist_url"`
+	Schedule    string       `json:"schedule,omitempty"`
+	State       string       `json:"state"`
+	Start       time.Time    `json:"start"`
+	End         *time.Time   `json:"end,omitempty"`
+	Error       string       `json:"error,omitempty"`
+	Summary     *jsonSummary `json:"summary,omitempty"`
+
+	cancel context.CancelFunc
+	stream *streamSink
+}
+
+const (
+	stateRunning  = "running"
+	stateStopping = "stopping"
+	stateFinished = "finished"
+)
+
+type daemonSchedule struct {
+	ScheduleConfig
+	ID string `json:"id"`
+	// Spec is the -schedule value the schedule came from, if it did.
+	Spec string    `json:"spec,omitempty"`
+	Next time.Time `json:"next"`
+	// Runs holds the IDs of the most recent runs, oldest first.
+	Runs []string `json:"runs"`
+
+	cron      *cronSchedule
+	duration  time.Duration
+	overrides flagOverrides
+	stop      chan struct{}
+}
+
+// Daemon runs benchmarks on request or on a schedule. StartRun, StopRun and
+// StreamResults are served over gRPC with -daemon-grpc, see
+// daemonpb/daemon.proto, and along with schedules over HTTP as:
+//
+//	POST   /runs               {"playlist_url": "...", "duration": "5m"}
+//	GET    /runs
+//	GET    /runs/{id}
+//	DELETE /runs/{id}
+//	GET    /runs/{id}/results  newline delimited JSON until the run ends
+//	GET    /runs/{id}/events   the same as Server-Sent Events
+//	GET    /events             Server-Sent Events of every run
+//	POST   /schedules          {"cron": "*/15 * * * *", "playlist_url": "...", "duration": "2m",
+//	                            "profile": "stress", "flags": {"output": "json:probe.jsonl"}}
+//	GET    /schedules
+//	GET    /schedules/{id}     the schedule along with its retained runs
+//	DELETE /schedules/{id}
+//
+// Runs use the flags the daemon was started with, apart from those of
+// schedules with a profile or flags of their own. As flags are global, such
+// a run only starts when no other is going, and no other starts until it
+// ends.
+type Daemon struct {
+	mu        sync.Mutex
+	runs      map[string]*daemonRun
+	schedules map[string]*daemonSchedule
+	// started holds the IDs of the runs started over the API, oldest
+	// first, as schedules hold theirs.
+	started []string
+	feed    *eventFeed
+	// active is the number of runs going, and exclusive whether one of
+	// them has flags of its own.
+	active    int
+	exclusive bool
+	// stopping is whether Stop was called, and done counts the runs
+	// going down for it to wait for.
+	stopping bool
+	done     sync.WaitGroup
+}
+
+func NewDaemon() *Daemon {
+	return &Daemon{
+		runs:      map[string]*daemonRun{},
+		schedules: map[string]*daemonSchedule{},
+		feed:      newEventFeed(),
+	}
+}
+
+// StartRun starts benchmarking playlistURL, for at most duration if it isn't
+// zero.
+func (d *Daemon) StartRun(playlistURL string, duration time.Duration) (string, error) {
+	return d.startRun(playlistURL, duration, "", nil)
+}
+
+func (d *Daemon) startRun(playlistURL string, duration time.Duration, schedule string, overrides flagOverrides) (string, error) {
+	if err := checkRemotePlaylist(playlistURL); err != nil {
+		return "", err
+	}
+	d.mu.Lock()
+	switch {
+	case d.stopping:
+		d.mu.Unlock()
+		return "", conflictError("the daemon is stopping")
+	case d.exclusive:
+		d.mu.Unlock()
+		return "", conflictError("a run with flags of its own is going, no other can start until it ends")
+	case overrides != nil && d.active > 0:
+		d.mu.Unlock()
+		return "", conflictError(fmt.Sprintf("a run with flags of its own can't start while %d others are going", d.active))
+	}
+	d.active++
+	var restore flagOverrides
+	if overrides != nil {
+		d.exclusive = true
+		restore = overrides.swap()
+	}
+	d.mu.Unlock()
+	// ended is called with d.mu held.
+	ended := func() {
+		d.active--
+		if restore != nil {
+			restore.swap()
+			d.exclusive = false
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	if duration > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), duration)
+	}
+	stream := newStreamSink("", d.feed)
+	run, err := NewRun(ctx, playlistURL, stream)
+	if err != nil {
+		cancel()
+		d.mu.Lock()
+		ended()
+		d.mu.Unlock()
+		return "", err
+	}
+	stream.runID = run.ID
+	dr := &daemonRun{
+		ID:          run.ID,
+		PlaylistURL: playlistURL,
+		Schedule:    schedule,
+		State:       stateRunning,
+		Start:       run.Start,
+		cancel:      cancel,
+		stream:      stream,
+	}
+	d.mu.Lock()
+	d.runs[dr.ID] = dr
+	if schedule == "" {
+		d.started = append(d.started, dr.ID)
+	}
+	d.done.Add(1)
+	d.mu.Unlock()
+	d.feed.Publish(runEvent{Run: dr.ID, State: stateRunning})
+
+	go func() {
+		summary := run.Execute()
+		cancel()
+		d.mu.Lock()
+		end := time.Now()
+		dr.End = &end
+		dr.State = stateFinished
+		dr.Summary = newJSONSummary(summary)
+		if run.Err != nil {
+			dr.Error = run.Err.Error()
+		}
+		ended()
+		if schedule != "" {
+			d.saveLocked()
+		} else {
+			d.trimStartedLocked()
+		}
+		d.mu.Unlock()
+		d.feed.Publish(runEvent{Run: dr.ID, State: stateFinished})
+		d.done.Done()
+	}()
+	return dr.ID, nil
+}
+
+// trimStartedLocked forgets the oldest finished runs started over the API
+// beyond -run-history.
+func (d *Daemon) trimStartedLocked() {
+	finished := 0
+	for _, id := range d.started {
+		if d.runs[id].State == stateFinished {
+			finished++
+		}
+	}
+	kept := d.started[:0]
+	for _, id := range d.started {
+		if finished > *runHistory && d.runs[id].State == stateFinished {
+			delete(d.runs, id)
+			finished--
+			continue
+		}
+		kept = append(kept, id)
+	}
+	d.started = kept
+}
+
+// Stop stops every run and waits for them to end, with their summaries and
+// outputs. No run starts after.
+func (d *Daemon) Stop() {
+	d.mu.Lock()
+	d.stopping = true
+	for id, dr := range d.runs {
+		if dr.State == stateRunning {
+			dr.State = stateStopping
+			dr.cancel()
+			log.WithField("RunID", id).Info("Stopping run")
+		}
+	}
+	d.mu.Unlock()
+	d.done.Wait()
+}
+
+func (d *Daemon) StopRun(id string) (*daemonRun, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	dr, ok := d.runs[id]
+	if !ok {
+		return nil, notFoundError(fmt.Sprintf("no run %v", id))
+	}
+	if dr.State == stateRunning {
+		dr.State = stateStopping
+		dr.cancel()
+		log.WithField("RunID", id).Info("Stopping run")
+	}
+	stopped := *dr
+	return &stopped, nil
+}
+
+// StreamResults calls send with the results of run id as they come, until
+// the run ends, ctx is done or send fails.
+func (d *Daemon) StreamResults(ctx context.Context, id string, send func(*RequestResult) error) error {
+	d.mu.Lock()
+	dr, ok := d.runs[id]
+	d.mu.Unlock()
+	if !ok {
+		return notFoundError(fmt.Sprintf("no run %v", id))
+	}
+
+	ch := dr.stream.Subscribe()
+	defer dr.stream.Unsubscribe(ch)
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case result, ok := <-ch:
+			if !ok {
+				return nil
+			}
+			if err := send(result); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// AddSchedule starts running config on its cron schedule, skipping a run if
+// the previous one is still going.
+func (d *Daemon) AddSchedule(config ScheduleConfig) (string, error) {
+	return d.addSchedule(&daemonSchedule{ScheduleConfig: config, ID: randomHex(8), Runs: []string{}})
+}
+
+// addSchedule starts running ds, a new schedule or one restored from
+// -schedule-state.
+func (d *Daemon) addSchedule(ds *daemonSchedule) (string, error) {
+	cron, duration, overrides, err := ds.parse()
+	if err != nil {
+		return "", err
+	}
+	ds.cron = cron
+	ds.duration = duration
+	ds.overrides = overrides
+	ds.Next = cron.Next(time.Now())
+	ds.stop = make(chan struct{})
+	if ds.Next.IsZero() {
+		return "", fmt.Errorf("cron expression %q never matches", ds.Cron)
+	}
+	d.mu.Lock()
+	d.schedules[ds.ID] = ds
+	d.saveLocked()
+	d.mu.Unlock()
+	go d.runSchedule(ds)
+	log.WithField("ScheduleID", ds.ID).Infof("Scheduled %v at %q", ds.PlaylistURL, ds.Cron)
+	return ds.ID, nil
+}
+
+func (d *Daemon) runSchedule(ds *daemonSchedule) {
+	for {
+		d.mu.Lock()
+		next := ds.Next
+		d.mu.Unlock()
+		select {
+		case <-ds.stop:
+			return
+		case <-time.After(time.Until(next)):
+		}
+
+		d.mu.Lock()
+		busy := false
+		if n := len(ds.Runs); n > 0 {
+			last, ok := d.runs[ds.Runs[n-1]]
+			busy = ok && last.State != stateFinished
+		}
+		ds.Next = ds.cron.Next(time.Now())
+		d.mu.Unlock()
+		if busy {
+			log.WithField("ScheduleID", ds.ID).Warn("Previous scheduled run still going, skipping this one")
+			continue
+		}
+
+		id, err := d.startRun(ds.PlaylistURL, ds.duration, ds.ID, ds.overrides)
+		if err != nil {
+			log.WithField("ScheduleID", ds.ID).Warnf("Skipping scheduled run: %v", err)
+			continue
+		}
+		d.mu.Lock()
+		ds.Runs = append(ds.Runs, id)
+		for len(ds.Runs) > *scheduleHistory && len(ds.Runs) > 1 {
+			if dr, ok := d.runs[ds.Runs[0]]; ok && dr.State != stateFinished {
+				break
+			}
+			delete(d.runs, ds.Runs[0])
+			ds.Runs = ds.Runs[1:]
+		}
+		d.saveLocked()
+		d.mu.Unlock()
+	}
+}
+
+func (d *Daemon) RemoveSchedule(id string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	ds, ok := d.schedules[id]
+	if !ok {
+		return notFoundError(fmt.Sprintf("no schedule %v", id))
+	}
+	close(ds.stop)
+	delete(d.schedules, id)
+	d.saveLocked()
+	return nil
+}
+
+// daemonState is what's kept in the -schedule-state file.
+type daemonState struct {
+	Schedules []*daemonSchedule `json:"schedules"`
+	Runs      []*daemonRun      `json:"runs"`
+}
+
+// saveLocked writes the schedules and their runs to -schedule-state, if
+// set, with d.mu held. Failing to is logged, leaving the previous state.
+func (d *Daemon) saveLocked() {
+	if *scheduleState == "" {
+		return
+	}
+	state := daemonState{Schedules: []*daemonSchedule{}, Runs: []*daemonRun{}}
+	for _, ds := range d.schedules {
+		state.Schedules = append(state.Schedules, ds)
+		for _, runID := range ds.Runs {
+			if dr, ok := d.runs[runID]; ok {
+				state.Runs = append(state.Runs, dr)
+			}
+		}
+	}
+	sort.Slice(state.Schedules, func(i, j int) bool { return state.Schedules[i].ID < state.Schedules[j].ID })
+	b, err := json.Marshal(state)
+	if err == nil {
+		tmp := *scheduleState + ".tmp"
+		if err = ioutil.WriteFile(tmp, b, 0644); err == nil {
+			err = os.Rename(tmp, *scheduleState)
+		}
+	}
+	if err != nil {
+		log.Errorf("Failed to save the schedules: %v", err)
+	}
+}
+
+// load restores the schedules and runs saved to -schedule-state, apart from
+// those of -schedule values no longer given. Runs that were going when the
+// daemon stopped are restored as finished with an error.
+func (d *Daemon) load() error {
+	if *scheduleState == "" {
+		return nil
+	}
+	data, err := ioutil.ReadFile(*scheduleState)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var state daemonState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("%v: %v", *scheduleState, err)
+	}
+	runs := map[string]*daemonRun{}
+	for _, dr := range state.Runs {
+		if dr.State != stateFinished {
+			dr.State = stateFinished
+			dr.Error = "the daemon stopped during the run"
+		}
+		runs[dr.ID] = dr
+	}
+	specs := map[string]bool{}
+	for _, spec := range schedules {
+		specs[spec] = true
+	}
+	for _, ds := range state.Schedules {
+		if ds.Spec != "" && !specs[ds.Spec] {
+			continue
+		}
+		kept := []string{}
+		for _, runID := range ds.Runs {
+			if dr, ok := runs[runID]; ok {
+				d.runs[runID] = dr
+				kept = append(kept, runID)
+			}
+		}
+		ds.Runs = kept
+		if _, err := d.addSchedule(ds); err != nil {
+			return fmt.Errorf("%v: schedule %v: %v", *scheduleState, ds.ID, err)
+		}
+	}
+	return nil
+}
+
+type scheduleWithRuns struct {
+	daemonSchedule
+	History []daemonRun `json:"history"`
+}
+
+func (d *Daemon) getSchedule(id string) (*scheduleWithRuns, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	ds, ok := d.schedules[id]
+	if !ok {
+		return nil, false
+	}
+	sr := &scheduleWithRuns{daemonSchedule: *ds, History: []daemonRun{}}
+	for _, runID := range ds.Runs {
+		if dr, ok := d.runs[runID]; ok {
+			sr.History = append(sr.History, *dr)
+		}
+	}
+	return sr, true
+}
+
+func (d *Daemon) listSchedules() []daemonSchedule {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	list := make([]daemonSchedule, 0, len(d.schedules))
+	for _, ds := range d.schedules {
+		list = append(list, *ds)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
+
+func (d *Daemon) list() []daemonRun {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	runs := make([]daemonRun, 0, len(d.runs))
+	for _, dr := range d.runs {
+		runs = append(runs, *dr)
+	}
+	sort.Slice(runs, func(i, j int) bool { return runs[i].Start.Before(runs[j].Start) })
+	return runs
+}
+
+func (d *Daemon) get(id string) (daemonRun, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	dr, ok := d.runs[id]
+	if !ok {
+		return daemonRun{}, false
+	}
+	return *dr, true
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+func (d *Daemon) serveSchedules(w http.ResponseWriter, r *http.Request, parts []string) {
+	switch {
+	case len(parts) == 1 && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, d.listSchedules())
+	case len(parts) == 1 && r.Method == http.MethodPost:
+		var config ScheduleConfig
+		if err := json.NewDecoder(r.Body).Decode(&config); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		id, err := d.AddSchedule(config)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		schedule, _ := d.getSchedule(id)
+		writeJSON(w, http.StatusCreated, schedule)
+	case len(parts) == 2 && r.Method == http.MethodGet:
+		schedule, ok := d.getSchedule(parts[1])
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		writeJSON(w, http.StatusOK, schedule)
+	case len(parts) == 2 && r.Method == http.MethodDelete:
+		if err := d.RemoveSchedule(parts[1]); err != nil {
+			writeError(w, http.StatusNotFound, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
+
+func (d *Daemon) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) == 1 && parts[0] == "events" && r.Method == http.MethodGet {
+		d.ServeEvents(w, r, "")
+		return
+	}
+	if parts[0] == "schedules" && len(parts) <= 2 {
+		d.serveSchedules(w, r, parts)
+		return
+	}
+	if parts[0] != "runs" || len(parts) > 3 {
+		http.NotFound(w, r)
+		return
+	}
+	switch {
+	case len(parts) == 1 && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, d.list())
+	case len(parts) == 1 && r.Method == http.MethodPost:
+		var body struct {
+			PlaylistURL string `json:"playlist_url"`
+			Duration    string `json:"duration"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.PlaylistURL == "" {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("expected {\"playlist_url\": \"...\"}"))
+			return
+		}
+		if err := checkRemotePlaylist(body.PlaylistURL); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		var duration time.Duration
+		if body.Duration != "" {
+			var err error
+			if duration, err = time.ParseDuration(body.Duration); err != nil {
+				writeError(w, http.StatusBadRequest, err)
+				return
+			}
+		}
+		id, err := d.StartRun(body.PlaylistURL, duration)
+		if _, ok := err.(conflictError); ok {
+			writeError(w, http.StatusConflict, err)
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		run, _ := d.get(id)
+		writeJSON(w, http.StatusCreated, run)
+	case len(parts) == 2 && r.Method == http.MethodGet:
+		run, ok := d.get(parts[1])
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		writeJSON(w, http.StatusOK, run)
+	case len(parts) == 2 && r.Method == http.MethodDelete:
+		run, err := d.StopRun(parts[1])
+		if err != nil {
+			writeError(w, http.StatusNotFound, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, run)
+	case len(parts) == 3 && parts[2] == "results" && r.Method == http.MethodGet:
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		enc := json.NewEncoder(w)
+		flusher, _ := w.(http.Flusher)
+		err := d.StreamResults(r.Context(), parts[1], func(result *RequestResult) error {
+			if err := enc.Encode(result); err != nil {
+				return err
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+			return nil
+		})
+		if _, ok := err.(notFoundError); ok {
+			writeError(w, http.StatusNotFound, err)
+		}
+	case len(parts) == 3 && parts[2] == "events" && r.Method == http.MethodGet:
+		d.ServeEvents(w, r, parts[1])
+	default:
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
+
+// ListenAndServe serves the daemon on addr, and over gRPC with -daemon-grpc,
+// until ctx is done and its runs have stopped.
+func (d *Daemon) ListenAndServe(ctx context.Context, addr string) error {
+	if err := d.load(); err != nil {
+		return err
+	}
+	restored := map[string]bool{}
+	for _, ds := range d.listSchedules() {
+		restored[ds.Spec] = true
+	}
+	for _, spec := range schedules {
+		if restored[spec] {
+			continue
+		}
+		config, _ := parseScheduleSpec(spec)
+		ds := &daemonSchedule{ScheduleConfig: config, ID: randomHex(8), Spec: spec, Runs: []string{}}
+		if _, err := d.addSchedule(ds); err != nil {
+			return err
+		}
+	}
+	errs := make(chan error, 2)
+	if *daemonGRPC != "" {
+		l, err := net.Listen("tcp", *daemonGRPC)
+		if err != nil {
+			return err
+		}
+		log.Infof("Daemon serving gRPC on %v", *daemonGRPC)
+		go func() { errs <- newGRPCServer(d).Serve(l) }()
+	}
+	log.Infof("Daemon listening on %v", addr)
+	go func() { errs <- http.ListenAndServe(addr, d) }()
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+	log.Info("Stopping the daemon")
+	d.Stop()
+	return nil
+}
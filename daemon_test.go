@@ -0,0 +1,27 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTrimStarted(t *testing.T) {
+	defer func(history int) { *runHistory = history }(*runHistory)
+	*runHistory = 1
+
+	d := NewDaemon()
+	for id, state := range map[string]string{"a": stateFinished, "b": stateRunning, "c": stateFinished, "d": stateFinished} {
+		d.runs[id] = &daemonRun{ID: id, State: state}
+	}
+	d.started = []string{"a", "b", "c", "d"}
+	d.trimStartedLocked()
+
+	if want := []string{"b", "d"}; !reflect.DeepEqual(d.started, want) {
+		t.Errorf("kept %v, want %v", d.started, want)
+	}
+	for _, id := range []string{"a", "c"} {
+		if _, ok := d.runs[id]; ok {
+			t.Errorf("run %v is still kept", id)
+		}
+	}
+}
@@ -0,0 +1,726 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.12
+// 	protoc        (unknown)
+// source: daemonpb/daemon.proto
+
+package daemonpb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	durationpb "google.golang.org/protobuf/types/known/durationpb"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type Run_State int32
+
+const (
+	Run_STATE_UNSPECIFIED Run_State = 0
+	Run_STATE_RUNNING     Run_State = 1
+	Run_STATE_STOPPING    Run_State = 2
+	Run_STATE_FINISHED    Run_State = 3
+)
+
+// Enum value maps for Run_State.
+var (
+	Run_State_name = map[int32]string{
+		0: "STATE_UNSPECIFIED",
+		1: "STATE_RUNNING",
+		2: "STATE_STOPPING",
+		3: "STATE_FINISHED",
+	}
+	Run_State_value = map[string]int32{
+		"STATE_UNSPECIFIED": 0,
+		"STATE_RUNNING":     1,
+		"STATE_STOPPING":    2,
+		"STATE_FINISHED":    3,
+	}
+)
+
+func (x Run_State) Enum() *Run_State {
+	p := new(Run_State)
+	*p = x
+	return p
+}
+
+func (x Run_State) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (Run_State) Descriptor() protoreflect.EnumDescriptor {
+	return file_daemonpb_daemon_proto_enumTypes[0].Descriptor()
+}
+
+func (Run_State) Type() protoreflect.EnumType {
+	return &file_daemonpb_daemon_proto_enumTypes[0]
+}
+
+func (x Run_State) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use Run_State.Descriptor instead.
+func (Run_State) EnumDescriptor() ([]byte, []int) {
+	return file_daemonpb_daemon_proto_rawDescGZIP(), []int{3, 0}
+}
+
+type StartRunRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// The playlist to benchmark, which has to be HTTP or HTTPS.
+	PlaylistUrl string `protobuf:"bytes,1,opt,name=playlist_url,json=playlistUrl,proto3" json:"playlist_url,omitempty"`
+	// How long the run lasts at most, for as long as the playlist does if
+	// unset.
+	Duration      *durationpb.Duration `protobuf:"bytes,2,opt,name=duration,proto3" json:"duration,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *StartRunRequest) Reset() {
+	*x = StartRunRequest{}
+	mi := &file_daemonpb_daemon_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *StartRunRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StartRunRequest) ProtoMessage() {}
+
+func (x *StartRunRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_daemonpb_daemon_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StartRunRequest.ProtoReflect.Descriptor instead.
+func (*StartRunRequest) Descriptor() ([]byte, []int) {
+	return file_daemonpb_daemon_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *StartRunRequest) GetPlaylistUrl() string {
+	if x != nil {
+		return x.PlaylistUrl
+	}
+	return ""
+}
+
+func (x *StartRunRequest) GetDuration() *durationpb.Duration {
+	if x != nil {
+		return x.Duration
+	}
+	return nil
+}
+
+type StopRunRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *StopRunRequest) Reset() {
+	*x = StopRunRequest{}
+	mi := &file_daemonpb_daemon_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *StopRunRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StopRunRequest) ProtoMessage() {}
+
+func (x *StopRunRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_daemonpb_daemon_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StopRunRequest.ProtoReflect.Descriptor instead.
+func (*StopRunRequest) Descriptor() ([]byte, []int) {
+	return file_daemonpb_daemon_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *StopRunRequest) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+type StreamResultsRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *StreamResultsRequest) Reset() {
+	*x = StreamResultsRequest{}
+	mi := &file_daemonpb_daemon_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *StreamResultsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StreamResultsRequest) ProtoMessage() {}
+
+func (x *StreamResultsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_daemonpb_daemon_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StreamResultsRequest.ProtoReflect.Descriptor instead.
+func (*StreamResultsRequest) Descriptor() ([]byte, []int) {
+	return file_daemonpb_daemon_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *StreamResultsRequest) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+type Run struct {
+	state       protoimpl.MessageState `protogen:"open.v1"`
+	Id          string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	PlaylistUrl string                 `protobuf:"bytes,2,opt,name=playlist_url,json=playlistUrl,proto3" json:"playlist_url,omitempty"`
+	// The ID of the schedule that started the run, if one did.
+	Schedule string                 `protobuf:"bytes,3,opt,name=schedule,proto3" json:"schedule,omitempty"`
+	State    Run_State              `protobuf:"varint,4,opt,name=state,proto3,enum=hlsbenchmark.daemon.Run_State" json:"state,omitempty"`
+	Start    *timestamppb.Timestamp `protobuf:"bytes,5,opt,name=start,proto3" json:"start,omitempty"`
+	// Unset until the run has finished.
+	End           *timestamppb.Timestamp `protobuf:"bytes,6,opt,name=end,proto3" json:"end,omitempty"`
+	Error         string                 `protobuf:"bytes,7,opt,name=error,proto3" json:"error,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Run) Reset() {
+	*x = Run{}
+	mi := &file_daemonpb_daemon_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Run) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Run) ProtoMessage() {}
+
+func (x *Run) ProtoReflect() protoreflect.Message {
+	mi := &file_daemonpb_daemon_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Run.ProtoReflect.Descriptor instead.
+func (*Run) Descriptor() ([]byte, []int) {
+	return file_daemonpb_daemon_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *Run) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *Run) GetPlaylistUrl() string {
+	if x != nil {
+		return x.PlaylistUrl
+	}
+	return ""
+}
+
+func (x *Run) GetSchedule() string {
+	if x != nil {
+		return x.Schedule
+	}
+	return ""
+}
+
+func (x *Run) GetState() Run_State {
+	if x != nil {
+		return x.State
+	}
+	return Run_STATE_UNSPECIFIED
+}
+
+func (x *Run) GetStart() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Start
+	}
+	return nil
+}
+
+func (x *Run) GetEnd() *timestamppb.Timestamp {
+	if x != nil {
+		return x.End
+	}
+	return nil
+}
+
+func (x *Run) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+// Timings is how long the phases of a request took.
+type Timings struct {
+	state            protoimpl.MessageState `protogen:"open.v1"`
+	DnsLookup        *durationpb.Duration   `protobuf:"bytes,1,opt,name=dns_lookup,json=dnsLookup,proto3" json:"dns_lookup,omitempty"`
+	TcpConnection    *durationpb.Duration   `protobuf:"bytes,2,opt,name=tcp_connection,json=tcpConnection,proto3" json:"tcp_connection,omitempty"`
+	TlsHandshake     *durationpb.Duration   `protobuf:"bytes,3,opt,name=tls_handshake,json=tlsHandshake,proto3" json:"tls_handshake,omitempty"`
+	ServerProcessing *durationpb.Duration   `protobuf:"bytes,4,opt,name=server_processing,json=serverProcessing,proto3" json:"server_processing,omitempty"`
+	ContentTransfer  *durationpb.Duration   `protobuf:"bytes,5,opt,name=content_transfer,json=contentTransfer,proto3" json:"content_transfer,omitempty"`
+	Total            *durationpb.Duration   `protobuf:"bytes,6,opt,name=total,proto3" json:"total,omitempty"`
+	unknownFields    protoimpl.UnknownFields
+	sizeCache        protoimpl.SizeCache
+}
+
+func (x *Timings) Reset() {
+	*x = Timings{}
+	mi := &file_daemonpb_daemon_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Timings) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Timings) ProtoMessage() {}
+
+func (x *Timings) ProtoReflect() protoreflect.Message {
+	mi := &file_daemonpb_daemon_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Timings.ProtoReflect.Descriptor instead.
+func (*Timings) Descriptor() ([]byte, []int) {
+	return file_daemonpb_daemon_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *Timings) GetDnsLookup() *durationpb.Duration {
+	if x != nil {
+		return x.DnsLookup
+	}
+	return nil
+}
+
+func (x *Timings) GetTcpConnection() *durationpb.Duration {
+	if x != nil {
+		return x.TcpConnection
+	}
+	return nil
+}
+
+func (x *Timings) GetTlsHandshake() *durationpb.Duration {
+	if x != nil {
+		return x.TlsHandshake
+	}
+	return nil
+}
+
+func (x *Timings) GetServerProcessing() *durationpb.Duration {
+	if x != nil {
+		return x.ServerProcessing
+	}
+	return nil
+}
+
+func (x *Timings) GetContentTransfer() *durationpb.Duration {
+	if x != nil {
+		return x.ContentTransfer
+	}
+	return nil
+}
+
+func (x *Timings) GetTotal() *durationpb.Duration {
+	if x != nil {
+		return x.Total
+	}
+	return nil
+}
+
+// RequestResult is the result of a request of a run, the same as a line of
+// -output json.
+type RequestResult struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	RunId string                 `protobuf:"bytes,1,opt,name=run_id,json=runId,proto3" json:"run_id,omitempty"`
+	Kind  string                 `protobuf:"bytes,2,opt,name=kind,proto3" json:"kind,omitempty"`
+	Uri   string                 `protobuf:"bytes,3,opt,name=uri,proto3" json:"uri,omitempty"`
+	Range string                 `protobuf:"bytes,4,opt,name=range,proto3" json:"range,omitempty"`
+	// The duration of the segment, in seconds.
+	Duration      float64                `protobuf:"fixed64,5,opt,name=duration,proto3" json:"duration,omitempty"`
+	Rendition     string                 `protobuf:"bytes,6,opt,name=rendition,proto3" json:"rendition,omitempty"`
+	Class         string                 `protobuf:"bytes,7,opt,name=class,proto3" json:"class,omitempty"`
+	Tags          []string               `protobuf:"bytes,8,rep,name=tags,proto3" json:"tags,omitempty"`
+	RequestedAt   *timestamppb.Timestamp `protobuf:"bytes,9,opt,name=requested_at,json=requestedAt,proto3" json:"requested_at,omitempty"`
+	CompletedAt   *timestamppb.Timestamp `protobuf:"bytes,10,opt,name=completed_at,json=completedAt,proto3" json:"completed_at,omitempty"`
+	StatusCode    int32                  `protobuf:"varint,11,opt,name=status_code,json=statusCode,proto3" json:"status_code,omitempty"`
+	Protocol      string                 `protobuf:"bytes,12,opt,name=protocol,proto3" json:"protocol,omitempty"`
+	Edge          string                 `protobuf:"bytes,13,opt,name=edge,proto3" json:"edge,omitempty"`
+	Pop           string                 `protobuf:"bytes,14,opt,name=pop,proto3" json:"pop,omitempty"`
+	Bytes         int64                  `protobuf:"varint,15,opt,name=bytes,proto3" json:"bytes,omitempty"`
+	Mbps          float64                `protobuf:"fixed64,16,opt,name=mbps,proto3" json:"mbps,omitempty"`
+	Timings       *Timings               `protobuf:"bytes,17,opt,name=timings,proto3" json:"timings,omitempty"`
+	ErrorCategory string                 `protobuf:"bytes,18,opt,name=error_category,json=errorCategory,proto3" json:"error_category,omitempty"`
+	Error         string                 `protobuf:"bytes,19,opt,name=error,proto3" json:"error,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RequestResult) Reset() {
+	*x = RequestResult{}
+	mi := &file_daemonpb_daemon_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RequestResult) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RequestResult) ProtoMessage() {}
+
+func (x *RequestResult) ProtoReflect() protoreflect.Message {
+	mi := &file_daemonpb_daemon_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RequestResult.ProtoReflect.Descriptor instead.
+func (*RequestResult) Descriptor() ([]byte, []int) {
+	return file_daemonpb_daemon_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *RequestResult) GetRunId() string {
+	if x != nil {
+		return x.RunId
+	}
+	return ""
+}
+
+func (x *RequestResult) GetKind() string {
+	if x != nil {
+		return x.Kind
+	}
+	return ""
+}
+
+func (x *RequestResult) GetUri() string {
+	if x != nil {
+		return x.Uri
+	}
+	return ""
+}
+
+func (x *RequestResult) GetRange() string {
+	if x != nil {
+		return x.Range
+	}
+	return ""
+}
+
+func (x *RequestResult) GetDuration() float64 {
+	if x != nil {
+		return x.Duration
+	}
+	return 0
+}
+
+func (x *RequestResult) GetRendition() string {
+	if x != nil {
+		return x.Rendition
+	}
+	return ""
+}
+
+func (x *RequestResult) GetClass() string {
+	if x != nil {
+		return x.Class
+	}
+	return ""
+}
+
+func (x *RequestResult) GetTags() []string {
+	if x != nil {
+		return x.Tags
+	}
+	return nil
+}
+
+func (x *RequestResult) GetRequestedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.RequestedAt
+	}
+	return nil
+}
+
+func (x *RequestResult) GetCompletedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.CompletedAt
+	}
+	return nil
+}
+
+func (x *RequestResult) GetStatusCode() int32 {
+	if x != nil {
+		return x.StatusCode
+	}
+	return 0
+}
+
+func (x *RequestResult) GetProtocol() string {
+	if x != nil {
+		return x.Protocol
+	}
+	return ""
+}
+
+func (x *RequestResult) GetEdge() string {
+	if x != nil {
+		return x.Edge
+	}
+	return ""
+}
+
+func (x *RequestResult) GetPop() string {
+	if x != nil {
+		return x.Pop
+	}
+	return ""
+}
+
+func (x *RequestResult) GetBytes() int64 {
+	if x != nil {
+		return x.Bytes
+	}
+	return 0
+}
+
+func (x *RequestResult) GetMbps() float64 {
+	if x != nil {
+		return x.Mbps
+	}
+	return 0
+}
+
+func (x *RequestResult) GetTimings() *Timings {
+	if x != nil {
+		return x.Timings
+	}
+	return nil
+}
+
+func (x *RequestResult) GetErrorCategory() string {
+	if x != nil {
+		return x.ErrorCategory
+	}
+	return ""
+}
+
+func (x *RequestResult) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+var File_daemonpb_daemon_proto protoreflect.FileDescriptor
+
+const file_daemonpb_daemon_proto_rawDesc = "" +
+	"\n" +
+	"\x15daemonpb/daemon.proto\x12\x13hlsbenchmark.daemon\x1a\x1egoogle/protobuf/duration.proto\x1a\x1fgoogle/protobuf/timestamp.proto\"k\n" +
+	"\x0fStartRunRequest\x12!\n" +
+	"\fplaylist_url\x18\x01 \x01(\tR\vplaylistUrl\x125\n" +
+	"\bduration\x18\x02 \x01(\v2\x19.google.protobuf.DurationR\bduration\" \n" +
+	"\x0eStopRunRequest\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\"&\n" +
+	"\x14StreamResultsRequest\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\"\xdb\x02\n" +
+	"\x03Run\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12!\n" +
+	"\fplaylist_url\x18\x02 \x01(\tR\vplaylistUrl\x12\x1a\n" +
+	"\bschedule\x18\x03 \x01(\tR\bschedule\x124\n" +
+	"\x05state\x18\x04 \x01(\x0e2\x1e.hlsbenchmark.daemon.Run.StateR\x05state\x120\n" +
+	"\x05start\x18\x05 \x01(\v2\x1a.google.protobuf.TimestampR\x05start\x12,\n" +
+	"\x03end\x18\x06 \x01(\v2\x1a.google.protobuf.TimestampR\x03end\x12\x14\n" +
+	"\x05error\x18\a \x01(\tR\x05error\"Y\n" +
+	"\x05State\x12\x15\n" +
+	"\x11STATE_UNSPECIFIED\x10\x00\x12\x11\n" +
+	"\rSTATE_RUNNING\x10\x01\x12\x12\n" +
+	"\x0eSTATE_STOPPING\x10\x02\x12\x12\n" +
+	"\x0eSTATE_FINISHED\x10\x03\"\x84\x03\n" +
+	"\aTimings\x128\n" +
+	"\n" +
+	"dns_lookup\x18\x01 \x01(\v2\x19.google.protobuf.DurationR\tdnsLookup\x12@\n" +
+	"\x0etcp_connection\x18\x02 \x01(\v2\x19.google.protobuf.DurationR\rtcpConnection\x12>\n" +
+	"\rtls_handshake\x18\x03 \x01(\v2\x19.google.protobuf.DurationR\ftlsHandshake\x12F\n" +
+	"\x11server_processing\x18\x04 \x01(\v2\x19.google.protobuf.DurationR\x10serverProcessing\x12D\n" +
+	"\x10content_transfer\x18\x05 \x01(\v2\x19.google.protobuf.DurationR\x0fcontentTransfer\x12/\n" +
+	"\x05total\x18\x06 \x01(\v2\x19.google.protobuf.DurationR\x05total\"\xc6\x04\n" +
+	"\rRequestResult\x12\x15\n" +
+	"\x06run_id\x18\x01 \x01(\tR\x05runId\x12\x12\n" +
+	"\x04kind\x18\x02 \x01(\tR\x04kind\x12\x10\n" +
+	"\x03uri\x18\x03 \x01(\tR\x03uri\x12\x14\n" +
+	"\x05range\x18\x04 \x01(\tR\x05range\x12\x1a\n" +
+	"\bduration\x18\x05 \x01(\x01R\bduration\x12\x1c\n" +
+	"\trendition\x18\x06 \x01(\tR\trendition\x12\x14\n" +
+	"\x05class\x18\a \x01(\tR\x05class\x12\x12\n" +
+	"\x04tags\x18\b \x03(\tR\x04tags\x12=\n" +
+	"\frequested_at\x18\t \x01(\v2\x1a.google.protobuf.TimestampR\vrequestedAt\x12=\n" +
+	"\fcompleted_at\x18\n" +
+	" \x01(\v2\x1a.google.protobuf.TimestampR\vcompletedAt\x12\x1f\n" +
+	"\vstatus_code\x18\v \x01(\x05R\n" +
+	"statusCode\x12\x1a\n" +
+	"\bprotocol\x18\f \x01(\tR\bprotocol\x12\x12\n" +
+	"\x04edge\x18\r \x01(\tR\x04edge\x12\x10\n" +
+	"\x03pop\x18\x0e \x01(\tR\x03pop\x12\x14\n" +
+	"\x05bytes\x18\x0f \x01(\x03R\x05bytes\x12\x12\n" +
+	"\x04mbps\x18\x10 \x01(\x01R\x04mbps\x126\n" +
+	"\atimings\x18\x11 \x01(\v2\x1c.hlsbenchmark.daemon.TimingsR\atimings\x12%\n" +
+	"\x0eerror_category\x18\x12 \x01(\tR\rerrorCategory\x12\x14\n" +
+	"\x05error\x18\x13 \x01(\tR\x05error2\x80\x02\n" +
+	"\x06Daemon\x12J\n" +
+	"\bStartRun\x12$.hlsbenchmark.daemon.StartRunRequest\x1a\x18.hlsbenchmark.daemon.Run\x12H\n" +
+	"\aStopRun\x12#.hlsbenchmark.daemon.StopRunRequest\x1a\x18.hlsbenchmark.daemon.Run\x12`\n" +
+	"\rStreamResults\x12).hlsbenchmark.daemon.StreamResultsRequest\x1a\".hlsbenchmark.daemon.RequestResult0\x01B/Z-github.com/Echo360/echo360-benchmark/daemonpbb\x06proto3"
+
+var (
+	file_daemonpb_daemon_proto_rawDescOnce sync.Once
+	file_daemonpb_daemon_proto_rawDescData []byte
+)
+
+func file_daemonpb_daemon_proto_rawDescGZIP() []byte {
+	file_daemonpb_daemon_proto_rawDescOnce.Do(func() {
+		file_daemonpb_daemon_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_daemonpb_daemon_proto_rawDesc), len(file_daemonpb_daemon_proto_rawDesc)))
+	})
+	return file_daemonpb_daemon_proto_rawDescData
+}
+
+var file_daemonpb_daemon_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
+var file_daemonpb_daemon_proto_msgTypes = make([]protoimpl.MessageInfo, 6)
+var file_daemonpb_daemon_proto_goTypes = []any{
+	(Run_State)(0),                // 0: hlsbenchmark.daemon.Run.State
+	(*StartRunRequest)(nil),       // 1: hlsbenchmark.daemon.StartRunRequest
+	(*StopRunRequest)(nil),        // 2: hlsbenchmark.daemon.StopRunRequest
+	(*StreamResultsRequest)(nil),  // 3: hlsbenchmark.daemon.StreamResultsRequest
+	(*Run)(nil),                   // 4: hlsbenchmark.daemon.Run
+	(*Timings)(nil),               // 5: hlsbenchmark.daemon.Timings
+	(*RequestResult)(nil),         // 6: hlsbenchmark.daemon.RequestResult
+	(*durationpb.Duration)(nil),   // 7: google.protobuf.Duration
+	(*timestamppb.Timestamp)(nil), // 8: google.protobuf.Timestamp
+}
+var file_daemonpb_daemon_proto_depIdxs = []int32{
+	7,  // 0: hlsbenchmark.daemon.StartRunRequest.duration:type_name -> google.protobuf.Duration
+	0,  // 1: hlsbenchmark.daemon.Run.state:type_name -> hlsbenchmark.daemon.Run.State
+	8,  // 2: hlsbenchmark.daemon.Run.start:type_name -> google.protobuf.Timestamp
+	8,  // 3: hlsbenchmark.daemon.Run.end:type_name -> google.protobuf.Timestamp
+	7,  // 4: hlsbenchmark.daemon.Timings.dns_lookup:type_name -> google.protobuf.Duration
+	7,  // 5: hlsbenchmark.daemon.Timings.tcp_connection:type_name -> google.protobuf.Duration
+	7,  // 6: hlsbenchmark.daemon.Timings.tls_handshake:type_name -> google.protobuf.Duration
+	7,  // 7: hlsbenchmark.daemon.Timings.server_processing:type_name -> google.protobuf.Duration
+	7,  // 8: hlsbenchmark.daemon.Timings.content_transfer:type_name -> google.protobuf.Duration
+	7,  // 9: hlsbenchmark.daemon.Timings.total:type_name -> google.protobuf.Duration
+	8,  // 10: hlsbenchmark.daemon.RequestResult.requested_at:type_name -> google.protobuf.Timestamp
+	8,  // 11: hlsbenchmark.daemon.RequestResult.completed_at:type_name -> google.protobuf.Timestamp
+	5,  // 12: hlsbenchmark.daemon.RequestResult.timings:type_name -> hlsbenchmark.daemon.Timings
+	1,  // 13: hlsbenchmark.daemon.Daemon.StartRun:input_type -> hlsbenchmark.daemon.StartRunRequest
+	2,  // 14: hlsbenchmark.daemon.Daemon.StopRun:input_type -> hlsbenchmark.daemon.StopRunRequest
+	3,  // 15: hlsbenchmark.daemon.Daemon.StreamResults:input_type -> hlsbenchmark.daemon.StreamResultsRequest
+	4,  // 16: hlsbenchmark.daemon.Daemon.StartRun:output_type -> hlsbenchmark.daemon.Run
+	4,  // 17: hlsbenchmark.daemon.Daemon.StopRun:output_type -> hlsbenchmark.daemon.Run
+	6,  // 18: hlsbenchmark.daemon.Daemon.StreamResults:output_type -> hlsbenchmark.daemon.RequestResult
+	16, // [16:19] is the sub-list for method output_type
+	13, // [13:16] is the sub-list for method input_type
+	13, // [13:13] is the sub-list for extension type_name
+	13, // [13:13] is the sub-list for extension extendee
+	0,  // [0:13] is the sub-list for field type_name
+}
+
+func init() { file_daemonpb_daemon_proto_init() }
+func file_daemonpb_daemon_proto_init() {
+	if File_daemonpb_daemon_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_daemonpb_daemon_proto_rawDesc), len(file_daemonpb_daemon_proto_rawDesc)),
+			NumEnums:      1,
+			NumMessages:   6,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_daemonpb_daemon_proto_goTypes,
+		DependencyIndexes: file_daemonpb_daemon_proto_depIdxs,
+		EnumInfos:         file_daemonpb_daemon_proto_enumTypes,
+		MessageInfos:      file_daemonpb_daemon_proto_msgTypes,
+	}.Build()
+	File_daemonpb_daemon_proto = out.File
+	file_daemonpb_daemon_proto_goTypes = nil
+	file_daemonpb_daemon_proto_depIdxs = nil
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+
+	"github.com/grafov/m3u8"
+	log "github.com/sirupsen/logrus"
+)
+
+// Defect is a rule of the HLS spec a stream broke, how often, and the first
+// time it did.
+type Defect struct {
+	Rule   string `json:"rule"`
+	Count  int    `json:"count"`
+	Detail string `json:"detail"`
+}
+
+// StreamDefects checks every snapshot of a media playlist against the rules
+// of the HLS spec players rely on: segments no longer than the target
+// duration, a target duration that never changes and an EXT-X-VERSION high
+// enough for the tags used.
+type StreamDefects struct {
+	mu      sync.Mutex
+	Defects []*Defect
+
+	byRule  map[string]*Defect
+	target  float64
+	lastSeq uint64
+	started bool
+}
+
+func NewStreamDefects() *StreamDefects {
+	return &StreamDefects{byRule: map[string]*Defect{}}
+}
+
+func (sd *StreamDefects) add(rule, detail string) {
+	d, ok := sd.byRule[rule]
+	if !ok {
+		d = &Defect{Rule: rule, Detail: detail}
+		sd.byRule[rule] = d
+		sd.Defects = append(sd.Defects, d)
+		log.WithField("Detail", detail).Warnf("Stream defect: %v", rule)
+	}
+	d.Count++
+}
+
+// Record adds a defect found by something other than Observe.
+func (sd *StreamDefects) Record(rule, detail string) {
+	sd.mu.Lock()
+	defer sd.mu.Unlock()
+	sd.add(rule, detail)
+}
+
+// versionFeatures returns the features of a playlist that need a minimum
+// EXT-X-VERSION, and those versions. Some are only visible in its text.
+func versionFeatures(body []byte, mpl *m3u8.MediaPlaylist) map[string]uint8 {
+	features := map[string]uint8{}
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case strings.HasPrefix(line, "#EXTINF:"):
+			duration := strings.SplitN(strings.TrimPrefix(line, "#EXTINF:"), ",", 2)[0]
+			if strings.Contains(duration, ".") {
+				features["decimal EXTINF"] = 3
+			}
+		case strings.HasPrefix(line, "#EXT-X-KEY:"):
+			attrs := parseAttributes(strings.TrimPrefix(line, "#EXT-X-KEY:"))
+			if _, ok := attrs["IV"]; ok {
+				features["EXT-X-KEY IV"] = 2
+			}
+			if _, ok := attrs["KEYFORMAT"]; ok {
+				features["EXT-X-KEY KEYFORMAT"] = 5
+			}
+		case strings.HasPrefix(line, "#EXT-X-BYTERANGE:"):
+			features["EXT-X-BYTERANGE"] = 4
+		case line == "#EXT-X-I-FRAMES-ONLY":
+			features["EXT-X-I-FRAMES-ONLY"] = 4
+		case strings.HasPrefix(line, "#EXT-X-MAP:"):
+			if mpl.Iframe {
+				features["EXT-X-MAP"] = 5
+			} else {
+				features["EXT-X-MAP"] = 6
+			}
+		}
+	}
+	return features
+}
+
+// Observe checks a snapshot of a media playlist, whose text is body.
+// Segments are only checked the first time they're seen.
+func (sd *StreamDefects) Observe(body []byte, mpl *m3u8.MediaPlaylist) {
+	sd.mu.Lock()
+	defer sd.mu.Unlock()
+
+	if sd.started && mpl.TargetDuration != sd.target {
+		sd.add("EXT-X-TARGETDURATION changed", fmt.Sprintf("from %v to %v at media sequence %d", sd.target, mpl.TargetDuration, mpl.SeqNo))
+	}
+	sd.target = mpl.TargetDuration
+
+	version := mpl.Version()
+	for feature, needs := range versionFeatures(body, mpl) {
+		if version < needs {
+			rule := "EXT-X-VERSION too low for " + feature
+			if _, ok := sd.byRule[rule]; !ok {
+				sd.add(rule, fmt.Sprintf("%v needs version %d, the playlist has %d", feature, needs, version))
+			}
+		}
+	}
+
+	for i, s := range mpl.Segments {
+		seq := mpl.SeqNo + uint64(i)
+		if s == nil || (sd.started && seq <= sd.lastSeq) {
+			continue
+		}
+		sd.lastSeq = seq
+		if math.Round(s.Duration) > mpl.TargetDuration {
+			sd.add("EXTINF exceeds EXT-X-TARGETDURATION", fmt.Sprintf("segment %d is %.3fs long, the target duration %v", seq, s.Duration, mpl.TargetDuration))
+		}
+	}
+	sd.started = true
+}
+
+// Report returns nil if the stream had no defects.
+func (sd *StreamDefects) Report() []*Defect {
+	if sd == nil {
+		return nil
+	}
+	sd.mu.Lock()
+	defer sd.mu.Unlock()
+	return append([]*Defect(nil), sd.Defects...)
+}
+
+func (sd *StreamDefects) LogSummary() {
+	for _, d := range sd.Report() {
+		log.WithField("Count", d.Count).
+			WithField("First", d.Detail).
+			Warnf("Stream defect: %v", d.Rule)
+	}
+}
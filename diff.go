@@ -0,0 +1,208 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"math"
+	"os"
+	"sort"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// diffMetric is a value of every segment that two result sets are compared
+// on.
+type diffMetric struct {
+	name  string
+	value func(*RequestResult) float64
+}
+
+func timingMetric(name string, timing func(*Timings) time.Duration) diffMetric {
+	return diffMetric{name, func(r *RequestResult) float64 {
+		return timing(r.Timings).Seconds() * 1000
+	}}
+}
+
+// diffMetrics are the metrics compared by diff, timings in milliseconds.
+var diffMetrics = []diffMetric{
+	timingMetric("dns_lookup_ms", func(t *Timings) time.Duration { return t.DNSLookup }),
+	timingMetric("tcp_connection_ms", func(t *Timings) time.Duration { return t.TCPConnection }),
+	timingMetric("tls_handshake_ms", func(t *Timings) time.Duration { return t.TLSHandshake }),
+	timingMetric("server_processing_ms", func(t *Timings) time.Duration { return t.ServerProcessing }),
+	timingMetric("content_transfer_ms", func(t *Timings) time.Duration { return t.ContentTransfer }),
+	timingMetric("total_ms", func(t *Timings) time.Duration { return t.Total }),
+	{"mbps", func(r *RequestResult) float64 { return r.Mbps }},
+}
+
+// MetricDiff compares a metric of the segments of two result sets: their
+// medians, the change from A to B and the probability, going by a
+// Mann-Whitney U test, of a difference at least as large if both came from
+// the same distribution.
+type MetricDiff struct {
+	Metric      string  `json:"metric"`
+	A           int     `json:"a"`
+	B           int     `json:"b"`
+	MedianA     float64 `json:"median_a"`
+	MedianB     float64 `json:"median_b"`
+	Change      float64 `json:"change"`
+	P           float64 `json:"p"`
+	Significant bool    `json:"significant"`
+}
+
+func median(values []float64) float64 {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	n := len(sorted)
+	if n == 0 {
+		return 0
+	}
+	if n%2 == 1 {
+		return sorted[n/2]
+	}
+	return (sorted[n/2-1] + sorted[n/2]) / 2
+}
+
+// mannWhitney returns the two-sided p-value of the Mann-Whitney U test of a
+// and b, by its normal approximation corrected for ties, which holds for
+// the thousands of segments of a benchmark.
+func mannWhitney(a, b []float64) float64 {
+	type sample struct {
+		value float64
+		inA   bool
+	}
+	var all []sample
+	for _, v := range a {
+		all = append(all, sample{v, true})
+	}
+	for _, v := range b {
+		all = append(all, sample{v, false})
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].value < all[j].value })
+	n1, n2 := float64(len(a)), float64(len(b))
+	n := n1 + n2
+	var rankA, ties float64
+	for i := 0; i < len(all); {
+		j := i
+		for j < len(all) && all[j].value == all[i].value {
+			j++
+		}
+		// Tied values share the average of their ranks.
+		rank := float64(i+j+1) / 2
+		for k := i; k < j; k++ {
+			if all[k].inA {
+				rankA += rank
+			}
+		}
+		t := float64(j - i)
+		ties += t*t*t - t
+		i = j
+	}
+	u := rankA - n1*(n1+1)/2
+	sigma := math.Sqrt(n1 * n2 / 12 * ((n + 1) - ties/(n*(n-1))))
+	if sigma == 0 {
+		return 1
+	}
+	z := (math.Abs(u-n1*n2/2) - 0.5) / sigma
+	if z < 0 {
+		z = 0
+	}
+	return math.Erfc(z / math.Sqrt2)
+}
+
+// segmentValues returns the values of every metric of the segments of an
+// archive that succeeded.
+func segmentValues(a *Archive) map[string][]float64 {
+	values := map[string][]float64{}
+	for i := range a.Events {
+		event := &a.Events[i]
+		if event.Kind != KindSegment || event.ErrorCategory != "" || event.Timings == nil {
+			continue
+		}
+		for _, m := range diffMetrics {
+			values[m.name] = append(values[m.name], m.value(event))
+		}
+	}
+	return values
+}
+
+// diffArchives compares every metric of the segments of a and b, holding
+// differences significant below alpha.
+func diffArchives(a, b *Archive, alpha float64) []*MetricDiff {
+	valuesA, valuesB := segmentValues(a), segmentValues(b)
+	var diffs []*MetricDiff
+	for _, m := range diffMetrics {
+		va, vb := valuesA[m.name], valuesB[m.name]
+		if len(va) < 2 || len(vb) < 2 {
+			continue
+		}
+		d := &MetricDiff{
+			Metric:  m.name,
+			A:       len(va),
+			B:       len(vb),
+			MedianA: median(va),
+			MedianB: median(vb),
+			P:       mannWhitney(va, vb),
+		}
+		if d.MedianA != 0 {
+			d.Change = (d.MedianB - d.MedianA) / d.MedianA
+		}
+		d.Significant = d.P < alpha
+		diffs = append(diffs, d)
+	}
+	return diffs
+}
+
+func diffCommand(args []string) {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	alpha := fs.Float64("alpha", 0.05, "significance level below which differences are held to be meaningful")
+	jsonOut := fs.String("json", "", "also write the comparison as JSON to this `file`")
+	fs.Usage = func() {
+		os.Stderr.Write([]byte("Usage: hlsbenchmark diff [flags] a b\n       where a and b are archive-dirs, archive.tar.gzs or events.jsonls\n"))
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, envPrefix+"DIFF_", args)
+	if fs.NArg() < 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	a, err := OpenArchive(fs.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer a.Close()
+	b, err := OpenArchive(fs.Arg(1))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer b.Close()
+
+	diffs := diffArchives(a, b, *alpha)
+	if len(diffs) == 0 {
+		log.Fatal("Both need at least two successful segments to compare")
+	}
+	for _, d := range diffs {
+		entry := log.WithField("A", d.A).
+			WithField("B", d.B).
+			WithField("MedianA", d.MedianA).
+			WithField("MedianB", d.MedianB).
+			WithField("Change", d.Change).
+			WithField("P", d.P)
+		if d.Significant {
+			entry.Warnf("%v differs significantly", d.Metric)
+			continue
+		}
+		entry.Infof("%v doesn't differ significantly", d.Metric)
+	}
+	if *jsonOut != "" {
+		out, err := json.MarshalIndent(diffs, "", "  ")
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := ioutil.WriteFile(*jsonOut, out, 0644); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestMannWhitney(t *testing.T) {
+	sequence := func(from, to float64) []float64 {
+		var values []float64
+		for v := from; v <= to; v++ {
+			values = append(values, v)
+		}
+		return values
+	}
+	tests := []struct {
+		name string
+		a, b []float64
+		want float64
+	}{
+		// U = 0, σ = √5.25, z = (4.5 - 0.5) / σ.
+		{"separate", []float64{1, 2, 3}, []float64{4, 5, 6}, 0.080856},
+		// U = 0, σ = √175, z = (50 - 0.5) / σ.
+		{"separate tens", sequence(1, 10), sequence(11, 20), 0.00018267},
+		// Ranks 1, 3, 3 | 3, 5 give U = 1; the three tied 2s leave
+		// σ = √(6/12 × (6 - 24/20)) = √2.4, z = (2 - 0.5) / σ.
+		{"ties", []float64{1, 2, 2}, []float64{2, 3}, 0.332922},
+		{"same", []float64{1, 2, 3}, []float64{1, 2, 3}, 1},
+		{"all tied", []float64{5, 5, 5}, []float64{5, 5}, 1},
+		// U = 6, σ = √12, z = (2 - 0.5) / σ.
+		{"interleaved", []float64{1, 3, 5, 7}, []float64{2, 4, 6, 8}, 0.665006},
+	}
+	for _, test := range tests {
+		if got := mannWhitney(test.a, test.b); math.Abs(got-test.want) > 1e-5 {
+			t.Errorf("%v: mannWhitney = %v, want %v", test.name, got, test.want)
+		}
+		if got, reversed := mannWhitney(test.a, test.b), mannWhitney(test.b, test.a); math.Abs(got-reversed) > 1e-12 {
+			t.Errorf("%v: mannWhitney isn't symmetric, %v against %v", test.name, got, reversed)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"net"
+	"sync"
+	"time"
+)
+
+var (
+	dnsGoResolver = flag.Bool("dns-go-resolver", false, "look hosts up with Go's own resolver, bypassing any caching by the system's")
+	dnsCacheTTL   = flag.Duration("dns-cache-ttl", 0, "cache host lookups for this long rather than looking hosts up for every new connection")
+	dnsFresh      = flag.Bool("dns-fresh", false, "look hosts up again for every request, which also means a new connection for every request")
+)
+
+type dnsEntry struct {
+	addrs   []net.IPAddr
+	expires time.Time
+}
+
+// dnsCache caches host lookups for -dns-cache-ttl. Go doesn't cache lookups
+// itself, so without it every new connection looks its host up.
+type dnsCache struct {
+	mu       sync.Mutex
+	resolver *net.Resolver
+	entries  map[string]dnsEntry
+}
+
+func newDNSCache(resolver *net.Resolver) *dnsCache {
+	return &dnsCache{resolver: resolver, entries: map[string]dnsEntry{}}
+}
+
+func (dc *dnsCache) lookup(ctx context.Context, host string) ([]net.IPAddr, error) {
+	dc.mu.Lock()
+	entry, ok := dc.entries[host]
+	dc.mu.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return entry.addrs, nil
+	}
+	addrs, err := dc.resolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	dc.mu.Lock()
+	dc.entries[host] = dnsEntry{addrs, time.Now().Add(*dnsCacheTTL)}
+	dc.mu.Unlock()
+	return addrs, nil
+}
+
+// dial connects to addr through the cache, trying the addresses of its host
+// in the order they were looked up.
+func (dc *dnsCache) dial(ctx context.Context, dialer *net.Dialer, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || net.ParseIP(host) != nil {
+		return dialer.DialContext(ctx, network, addr)
+	}
+	addrs, err := dc.lookup(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	err = errors.New("no addresses for " + host)
+	for _, ip := range addrs {
+		var conn net.Conn
+		if conn, err = dialer.DialContext(ctx, network, net.JoinHostPort(ip.String(), port)); err == nil {
+			return conn, nil
+		}
+	}
+	return nil, err
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"flag"
+
+	"github.com/grafov/m3u8"
+	log "github.com/sirupsen/logrus"
+)
+
+var dvrWindow = flag.Duration("dvr-window", 0, "expected length of a live playlist's window; windows more than a target duration longer or shorter are reported")
+
+// WindowStats follows the playable window of a live playlist, the sum of its
+// segment durations in seconds. A window that shrinks, or strays from
+// -dvr-window, points at the packager's retention being misconfigured.
+type WindowStats struct {
+	Min  float64 `json:"min"`
+	Max  float64 `json:"max"`
+	Last float64 `json:"last"`
+	// Shrinks and Growths count anomalies: the window getting more than a
+	// target duration shorter than it was, or leaving -dvr-window.
+	Shrinks int `json:"shrinks"`
+	Growths int `json:"growths"`
+}
+
+func windowLength(mpl *m3u8.MediaPlaylist) float64 {
+	var total float64
+	for _, s := range mpl.Segments {
+		if s != nil {
+			total += s.Duration
+		}
+	}
+	return total
+}
+
+func (ws *WindowStats) observe(mpl *m3u8.MediaPlaylist, first bool) {
+	seconds, target := windowLength(mpl), mpl.TargetDuration
+	last := ws.Last
+	ws.Last = seconds
+	if first {
+		ws.Min, ws.Max = seconds, seconds
+	}
+	if seconds < ws.Min {
+		ws.Min = seconds
+	}
+	if seconds > ws.Max {
+		ws.Max = seconds
+	}
+	if first {
+		return
+	}
+
+	if seconds < last-target {
+		ws.Shrinks++
+		log.WithField("From", last).WithField("To", seconds).Warn("Playlist window shrank")
+		return
+	}
+	if *dvrWindow == 0 {
+		return
+	}
+	expected := dvrWindow.Seconds()
+	switch {
+	case seconds < expected-target && last >= expected-target:
+		ws.Shrinks++
+		log.WithField("Window", seconds).WithField("Expected", expected).Warn("Playlist window is shorter than -dvr-window")
+	case seconds > expected+target && last <= expected+target:
+		ws.Growths++
+		log.WithField("Window", seconds).WithField("Expected", expected).Warn("Playlist window is longer than -dvr-window")
+	}
+}
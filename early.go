@@ -0,0 +1,197 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptrace"
+	"net/textproto"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/digitaljanitors/go-httpstat"
+	log "github.com/sirupsen/logrus"
+)
+
+var preconnectHints = flag.Bool("early-hints-preconnect", false, "act on rel=preconnect Link headers of 103 Early Hints by connecting to their origins before requesting anything from them")
+
+// link is a single entry of a Link header.
+type link struct {
+	URL string
+	Rel string
+}
+
+// parseLinks parses Link headers, resolving their URLs against base.
+func parseLinks(base *url.URL, values []string) []link {
+	var links []link
+	for _, value := range values {
+		for _, entry := range strings.Split(value, ",") {
+			params := strings.Split(entry, ";")
+			target := strings.Trim(strings.TrimSpace(params[0]), "<>")
+			u, err := base.Parse(target)
+			if err != nil {
+				continue
+			}
+			l := link{URL: u.String()}
+			for _, param := range params[1:] {
+				kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+				if len(kv) == 2 && strings.EqualFold(kv[0], "rel") {
+					l.Rel = strings.ToLower(strings.Trim(kv[1], `"`))
+				}
+			}
+			links = append(links, l)
+		}
+	}
+	return links
+}
+
+// origin returns the scheme and host of u.
+func origin(u *url.URL) string {
+	return u.Scheme + "://" + u.Host
+}
+
+// EarlyHintTracker records the 103 Early Hints responses servers send ahead
+// of their final responses and the Link headers they carry. To tell whether
+// acting on preconnect hints pays off, it times the requests made to the
+// origins they name, split by whether a connection had been made ahead.
+type EarlyHintTracker struct {
+	mu        sync.Mutex
+	Responses int
+	Links     map[string]int
+
+	hinted       map[string]bool
+	preconnected map[string]bool
+	times        map[bool][]time.Duration
+	// client is the run's, in whose pool preconnections are left.
+	client *http.Client
+}
+
+func NewEarlyHintTracker(c *http.Client) *EarlyHintTracker {
+	return &EarlyHintTracker{
+		client:       c,
+		Links:        map[string]int{},
+		hinted:       map[string]bool{},
+		preconnected: map[string]bool{},
+		times:        map[bool][]time.Duration{},
+	}
+}
+
+// Trace returns req set up to record the Early Hints sent for it.
+func (et *EarlyHintTracker) Trace(req *http.Request) *http.Request {
+	trace := &httptrace.ClientTrace{
+		Got1xxResponse: func(code int, header textproto.MIMEHeader) error {
+			if code == http.StatusEarlyHints {
+				et.got(req.URL, header["Link"])
+			}
+			return nil
+		},
+	}
+	return req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+}
+
+func (et *EarlyHintTracker) got(base *url.URL, values []string) {
+	links := parseLinks(base, values)
+	log.WithField("Link", values).Debugf("103 Early Hints for %v", base)
+
+	et.mu.Lock()
+	defer et.mu.Unlock()
+	et.Responses++
+	for _, l := range links {
+		et.Links[l.Rel+" "+l.URL]++
+		if l.Rel != "preconnect" {
+			continue
+		}
+		u, err := url.Parse(l.URL)
+		if err != nil {
+			continue
+		}
+		o := origin(u)
+		et.hinted[o] = true
+		if *preconnectHints && !et.preconnected[o] {
+			et.preconnected[o] = true
+			go preconnect(et.client, o)
+		}
+	}
+}
+
+// preconnect leaves a connection to origin in c's pool by making a HEAD
+// request that isn't reported.
+func preconnect(c *http.Client, origin string) {
+	req, err := http.NewRequest("HEAD", origin+"/", nil)
+	if err != nil {
+		log.Warn(err)
+		return
+	}
+	req.Header.Set("User-Agent", USER_AGENT)
+	resp, err := c.Do(req)
+	if err != nil {
+		log.Warnf("Preconnecting to %v: %v", origin, err)
+		return
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	log.Debugf("Preconnected to %v", origin)
+}
+
+// Fetched times a successful request if its origin was hinted for
+// preconnecting.
+func (et *EarlyHintTracker) Fetched(u *url.URL, stats *httpstat.Result) {
+	et.mu.Lock()
+	defer et.mu.Unlock()
+	o := origin(u)
+	if et.hinted[o] {
+		et.times[et.preconnected[o]] = append(et.times[et.preconnected[o]], stats.Total)
+	}
+}
+
+// EarlyHintReport summarizes the Early Hints received. The latencies are of
+// requests to origins hinted for preconnecting, by whether they were.
+type EarlyHintReport struct {
+	Responses    int                      `json:"responses"`
+	Links        map[string]int           `json:"links,omitempty"`
+	Preconnected map[string]time.Duration `json:"preconnected,omitempty"`
+	Cold         map[string]time.Duration `json:"cold,omitempty"`
+}
+
+// Report returns nil if no Early Hints were received.
+func (et *EarlyHintTracker) Report() *EarlyHintReport {
+	if et == nil {
+		return nil
+	}
+	et.mu.Lock()
+	defer et.mu.Unlock()
+	if et.Responses == 0 {
+		return nil
+	}
+	links := map[string]int{}
+	for l, n := range et.Links {
+		links[l] = n
+	}
+	return &EarlyHintReport{
+		Responses:    et.Responses,
+		Links:        links,
+		Preconnected: latencyPercentiles(et.times[true]),
+		Cold:         latencyPercentiles(et.times[false]),
+	}
+}
+
+func (et *EarlyHintTracker) LogSummary() {
+	r := et.Report()
+	if r == nil {
+		return
+	}
+	entry := log.WithField("Responses", r.Responses)
+	for name, d := range r.Preconnected {
+		entry = entry.WithField("Preconnected"+name, d)
+	}
+	for name, d := range r.Cold {
+		entry = entry.WithField("Cold"+name, d)
+	}
+	entry.Info("103 Early Hints")
+	for l, n := range r.Links {
+		log.WithField("Count", n).Infof("Early hint %v", l)
+	}
+}
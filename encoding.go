@@ -0,0 +1,151 @@
+package main
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// encodingsFlag is the -playlist-accept-encoding flag. Only encodings the
+// standard library can decode are accepted, as playlists must be parsed.
+type encodingsFlag string
+
+func (ef *encodingsFlag) String() string {
+	return string(*ef)
+}
+
+func (ef *encodingsFlag) Set(value string) error {
+	for _, e := range strings.Split(value, ",") {
+		switch strings.TrimSpace(e) {
+		case "gzip", "deflate", "identity":
+		default:
+			return fmt.Errorf("can't decode %q playlists, only gzip, deflate and identity ones", strings.TrimSpace(e))
+		}
+	}
+	*ef = encodingsFlag(value)
+	return nil
+}
+
+var playlistEncodings encodingsFlag
+
+func init() {
+	flag.Var(&playlistEncodings, "playlist-accept-encoding", "comma separated `encodings` to accept for playlists out of gzip, deflate and identity, reporting their compressed and decompressed sizes (default gzip, decompressed without reporting sizes)")
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	io.Reader
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.Reader.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
+
+// readCloser reads from one reader and closes another.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// decodeBody swaps resp.Body for its decoded content, going by its
+// Content-Encoding, and returns a count of the bytes read off the wire.
+func decodeBody(resp *http.Response) (*countingReader, error) {
+	wire := &countingReader{Reader: resp.Body}
+	var decoded io.Reader = wire
+	switch encoding := strings.ToLower(resp.Header.Get("Content-Encoding")); encoding {
+	case "", "identity":
+	case "gzip":
+		zr, err := gzip.NewReader(wire)
+		if err != nil {
+			return nil, err
+		}
+		decoded = zr
+	case "deflate":
+		zr, err := zlib.NewReader(wire)
+		if err != nil {
+			return nil, err
+		}
+		decoded = zr
+	default:
+		return nil, fmt.Errorf("can't decode Content-Encoding %v", encoding)
+	}
+	resp.Body = readCloser{decoded, resp.Body}
+	return wire, nil
+}
+
+// CompressionStats are the sizes of the playlists fetched with
+// -playlist-accept-encoding, by the Content-Encoding they came with.
+type CompressionStats struct {
+	mu        sync.Mutex
+	encodings map[string]*CompressionReport
+}
+
+func NewCompressionStats() *CompressionStats {
+	return &CompressionStats{encodings: map[string]*CompressionReport{}}
+}
+
+func (cs *CompressionStats) Add(resp *http.Response, wire, n int64) {
+	encoding := strings.ToLower(resp.Header.Get("Content-Encoding"))
+	if encoding == "" {
+		encoding = "identity"
+	}
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	r, ok := cs.encodings[encoding]
+	if !ok {
+		r = &CompressionReport{}
+		cs.encodings[encoding] = r
+	}
+	r.Playlists++
+	r.WireBytes += wire
+	r.Bytes += n
+}
+
+// CompressionReport is the total size of the playlists of one encoding, as
+// sent and decompressed.
+type CompressionReport struct {
+	Playlists int   `json:"playlists"`
+	WireBytes int64 `json:"wire_bytes"`
+	Bytes     int64 `json:"bytes"`
+}
+
+// Report returns nil without -playlist-accept-encoding.
+func (cs *CompressionStats) Report() map[string]*CompressionReport {
+	if cs == nil {
+		return nil
+	}
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	if len(cs.encodings) == 0 {
+		return nil
+	}
+	reports := map[string]*CompressionReport{}
+	for encoding, r := range cs.encodings {
+		copied := *r
+		reports[encoding] = &copied
+	}
+	return reports
+}
+
+func (cs *CompressionStats) LogSummary() {
+	reports := cs.Report()
+	for encoding, r := range reports {
+		log.WithField("Playlists", r.Playlists).
+			WithField("WireBytes", r.WireBytes).
+			WithField("Bytes", r.Bytes).
+			Infof("%v playlists", encoding)
+	}
+	if _, ok := reports["identity"]; ok && len(reports) == 1 && string(playlistEncodings) != "identity" {
+		log.Warnf("No playlists were compressed despite accepting %v", playlistEncodings)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// envPrefix is prepended to a flag's name to find the environment variable
+// configuring it, so -concurrency is HLSBENCH_CONCURRENCY and, for the
+// serve command, -segment-size is HLSBENCH_SERVE_SEGMENT_SIZE.
+const envPrefix = "HLSBENCH_"
+
+func envName(prefix, name string) string {
+	return prefix + strings.ToUpper(strings.NewReplacer("-", "_", ".", "_").Replace(name))
+}
+
+// applyEnv sets every flag in fs that wasn't given on the command line from
+// its environment variable, if there is one. Flags on the command line take
+// precedence over the environment, which takes precedence over the defaults.
+// Repeatable flags take one value per line.
+func applyEnv(fs *flag.FlagSet, prefix string) error {
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if set[f.Name] || err != nil {
+			return
+		}
+		name := envName(prefix, f.Name)
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			return
+		}
+		for _, v := range strings.Split(value, "\n") {
+			if e := fs.Set(f.Name, v); e != nil {
+				err = fmt.Errorf("invalid value %q for %v: %v", v, name, e)
+				return
+			}
+		}
+	})
+	return err
+}
+
+// parseFlags parses args into fs, then fills in the rest from the
+// environment.
+func parseFlags(fs *flag.FlagSet, prefix string, args []string) {
+	fs.Parse(args)
+	if err := applyEnv(fs, prefix); err != nil {
+		fmt.Fprintln(fs.Output(), err)
+		fs.Usage()
+		os.Exit(2)
+	}
+}
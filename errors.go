@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type ErrorCategory string
+
+const (
+	ErrDNS        ErrorCategory = "DNS"
+	ErrConnect    ErrorCategory = "Connect"
+	ErrTLS        ErrorCategory = "TLS"
+	ErrTimeout    ErrorCategory = "Timeout"
+	Err4xx        ErrorCategory = "4xx"
+	Err5xx        ErrorCategory = "5xx"
+	ErrShortRead  ErrorCategory = "ShortRead"
+	ErrValidation ErrorCategory = "Validation"
+	// ErrIntegrity is a live playlist breaking its media sequence.
+	ErrIntegrity ErrorCategory = "Integrity"
+	ErrOther     ErrorCategory = "Other"
+)
+
+// errorCategories is the order categories are reported in.
+var errorCategories = []ErrorCategory{
+	ErrDNS, ErrConnect, ErrTLS, ErrTimeout, Err4xx, Err5xx, ErrShortRead, ErrValidation, ErrIntegrity, ErrOther,
+}
+
+func categorizeError(err error) ErrorCategory {
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	var opErr *net.OpError
+	var unknownAuthority x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var invalidCert x509.CertificateInvalidError
+	var recordErr tls.RecordHeaderError
+	switch {
+	case errors.As(err, &dnsErr):
+		return ErrDNS
+	case errors.Is(err, context.DeadlineExceeded),
+		errors.As(err, &netErr) && netErr.Timeout():
+		return ErrTimeout
+	case errors.As(err, &unknownAuthority),
+		errors.As(err, &hostnameErr),
+		errors.As(err, &invalidCert),
+		errors.As(err, &recordErr),
+		strings.Contains(err.Error(), "tls: "):
+		return ErrTLS
+	case errors.As(err, &opErr) && opErr.Op == "dial":
+		return ErrConnect
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return ErrShortRead
+	}
+	return ErrOther
+}
+
+func categorizeStatus(code int) ErrorCategory {
+	switch {
+	case code >= 400 && code <= 499:
+		return Err4xx
+	case code >= 500 && code <= 599:
+		return Err5xx
+	}
+	return ErrOther
+}
+
+type ErrorStats struct {
+	Count   int
+	First   time.Time
+	Last    time.Time
+	Reasons map[string]int
+}
+
+// ErrorSummary counts failures by category. It is shared between the
+// playlist and segment goroutines, so access is guarded.
+type ErrorSummary struct {
+	mu         sync.Mutex
+	Categories map[ErrorCategory]*ErrorStats
+}
+
+func NewErrorSummary() *ErrorSummary {
+	return &ErrorSummary{
+		Categories: map[ErrorCategory]*ErrorStats{},
+	}
+}
+
+func (es *ErrorSummary) Record(category ErrorCategory, reason string) {
+	es.RecordAt(time.Now(), category, reason)
+}
+
+// RecordAt records a failure that happened at the given time, which is how
+// recorded runs are replayed.
+func (es *ErrorSummary) RecordAt(at time.Time, category ErrorCategory, reason string) {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+
+	stats, ok := es.Categories[category]
+	if !ok {
+		stats = &ErrorStats{First: at, Reasons: map[string]int{}}
+		es.Categories[category] = stats
+	}
+	stats.Count++
+	stats.Last = at
+	if reason != "" {
+		stats.Reasons[reason]++
+	}
+}
+
+func (es *ErrorSummary) RecordError(err error) {
+	// The URL is in every url.Error and would make each reason unique.
+	reason := err
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		reason = urlErr.Err
+	}
+	es.Record(categorizeError(err), reason.Error())
+}
+
+// Snapshot returns a copy of the failures so far, safe to read while more
+// are recorded.
+func (es *ErrorSummary) Snapshot() *ErrorSummary {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+
+	snapshot := NewErrorSummary()
+	for category, stats := range es.Categories {
+		copied := *stats
+		copied.Reasons = make(map[string]int, len(stats.Reasons))
+		for reason, n := range stats.Reasons {
+			copied.Reasons[reason] = n
+		}
+		snapshot.Categories[category] = &copied
+	}
+	return snapshot
+}
+
+func (es *ErrorSummary) Total() int {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+
+	total := 0
+	for _, stats := range es.Categories {
+		total += stats.Count
+	}
+	return total
+}
+
+func (es *ErrorSummary) LogSummary() {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+
+	if len(es.Categories) == 0 {
+		log.Info("No errors")
+		return
+	}
+	for _, category := range errorCategories {
+		stats, ok := es.Categories[category]
+		if !ok {
+			continue
+		}
+		entry := log.WithField("Count", stats.Count).
+			WithField("First", stats.First.Format(time.RFC3339)).
+			WithField("Last", stats.Last.Format(time.RFC3339))
+		if len(stats.Reasons) > 0 {
+			entry = entry.WithField("Reasons", stats.Reasons)
+		}
+		entry.Warnf("Errors %s", category)
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// addressFamily returns IPv4 or IPv6 for the address addr.
+func addressFamily(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.To4() == nil {
+		return "IPv6"
+	}
+	return "IPv4"
+}
+
+// FamilyStats are the connection attempts of one address family.
+type FamilyStats struct {
+	Attempts  int                      `json:"attempts"`
+	Failures  int                      `json:"failures"`
+	Cancelled int                      `json:"cancelled"`
+	Wins      int                      `json:"wins"`
+	Connect   map[string]time.Duration `json:"connect,omitempty"`
+
+	times []time.Duration
+}
+
+// DialRaceTracker records the connection attempts Go's dialer makes to each
+// address family. Hosts with both A and AAAA records are dialed over IPv6
+// first and IPv4 shortly after, so a broken IPv6 path shows up as IPv6
+// attempts failing or losing the race while still slowing connecting down.
+type DialRaceTracker struct {
+	mu       sync.Mutex
+	Races    int
+	families map[string]*FamilyStats
+}
+
+func NewDialRaceTracker() *DialRaceTracker {
+	return &DialRaceTracker{families: map[string]*FamilyStats{}}
+}
+
+func (dr *DialRaceTracker) family(name string) *FamilyStats {
+	f, ok := dr.families[name]
+	if !ok {
+		f = &FamilyStats{}
+		dr.families[name] = f
+	}
+	return f
+}
+
+func (dr *DialRaceTracker) attempt(family string, d time.Duration, err error) {
+	dr.mu.Lock()
+	defer dr.mu.Unlock()
+	f := dr.family(family)
+	f.Attempts++
+	switch {
+	case err == nil:
+		f.times = append(f.times, d)
+	case errors.Is(err, context.Canceled):
+		f.Cancelled++
+	default:
+		f.Failures++
+	}
+}
+
+func (dr *DialRaceTracker) won(family string) {
+	dr.mu.Lock()
+	defer dr.mu.Unlock()
+	dr.Races++
+	dr.family(family).Wins++
+}
+
+// Trace returns req set up to record the connection attempts it makes.
+func (dr *DialRaceTracker) Trace(req *http.Request) *http.Request {
+	var mu sync.Mutex
+	starts := map[string]time.Time{}
+	tried := map[string]bool{}
+	trace := &httptrace.ClientTrace{
+		ConnectStart: func(network, addr string) {
+			mu.Lock()
+			defer mu.Unlock()
+			starts[addr] = time.Now()
+			tried[addressFamily(addr)] = true
+		},
+		ConnectDone: func(network, addr string, err error) {
+			mu.Lock()
+			start := starts[addr]
+			mu.Unlock()
+			dr.attempt(addressFamily(addr), time.Since(start), err)
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			if info.Reused || info.Conn == nil {
+				return
+			}
+			mu.Lock()
+			raced := len(tried) > 1
+			mu.Unlock()
+			if raced {
+				dr.won(addressFamily(info.Conn.RemoteAddr().String()))
+			}
+		},
+	}
+	return req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+}
+
+// DialRaceReport is the connection attempts of every address family, and
+// how often each won when both were tried for a connection.
+type DialRaceReport struct {
+	Races    int                     `json:"races"`
+	Families map[string]*FamilyStats `json:"families"`
+}
+
+// Report returns nil if no connections were made.
+func (dr *DialRaceTracker) Report() *DialRaceReport {
+	if dr == nil {
+		return nil
+	}
+	dr.mu.Lock()
+	defer dr.mu.Unlock()
+	if len(dr.families) == 0 {
+		return nil
+	}
+	report := &DialRaceReport{Races: dr.Races, Families: map[string]*FamilyStats{}}
+	for name, f := range dr.families {
+		copied := *f
+		copied.Connect = latencyPercentiles(f.times)
+		copied.times = nil
+		report.Families[name] = &copied
+	}
+	return report
+}
+
+func (dr *DialRaceTracker) LogSummary() {
+	r := dr.Report()
+	if r == nil {
+		return
+	}
+	for name, f := range r.Families {
+		entry := log.WithField("Attempts", f.Attempts).
+			WithField("Failures", f.Failures).
+			WithField("Cancelled", f.Cancelled).
+			WithField("Wins", f.Wins).
+			WithField("Races", r.Races)
+		for p, d := range f.Connect {
+			entry = entry.WithField("Connect"+p, d)
+		}
+		entry.Infof("%v connections", name)
+	}
+}
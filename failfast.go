@@ -0,0 +1,41 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	failFast  = flag.Bool("fail-fast", false, "end the run at its first error, with its summary and a non-zero exit status")
+	maxErrors = flag.Int("max-errors", 0, "end the run once it has had `n` errors, with its summary and a non-zero exit status, rather than carrying on with segments bound to fail too")
+)
+
+// errorLimit returns how many errors end a run, 0 if none do.
+func errorLimit() int {
+	if *failFast {
+		return 1
+	}
+	return *maxErrors
+}
+
+// checkErrors ends the run once it has had as many errors as -fail-fast or
+// -max-errors allow. Requests already made finish, those queued are
+// skipped.
+func (run *Run) checkErrors() {
+	limit := errorLimit()
+	if limit <= 0 {
+		return
+	}
+	total := run.Errors.Total()
+	if total < limit {
+		return
+	}
+	run.stopOnce.Do(func() {
+		err := fmt.Errorf("ending the run after %d errors", total)
+		log.Error(err)
+		run.Err = err
+		run.cancel()
+	})
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// failMetric is a value of a run that -fail-if can test.
+type failMetric struct {
+	// parse reads a threshold for the metric.
+	parse func(string) (float64, error)
+	// value returns the metric for summary, or false if it has none.
+	value func(*RunSummary) (float64, bool)
+	// format shows a value of the metric.
+	format func(float64) string
+}
+
+// parseDays reads a duration, allowing a d suffix for days.
+func parseDays(s string) (float64, error) {
+	if strings.HasSuffix(s, "d") {
+		days, err := strconv.ParseFloat(strings.TrimSuffix(s, "d"), 64)
+		return days * 24 * float64(time.Hour), err
+	}
+	d, err := time.ParseDuration(s)
+	return float64(d), err
+}
+
+func formatDays(v float64) string {
+	return fmt.Sprintf("%.1fd", v/float64(24*time.Hour))
+}
+
+var failMetrics = map[string]failMetric{
+	// cert_expiry is the time left until the first certificate seen
+	// expires.
+	"cert_expiry": {
+		parse: parseDays,
+		value: func(s *RunSummary) (float64, bool) {
+			expiry, ok := s.Certs.FirstExpiry()
+			return float64(time.Until(expiry)), ok
+		},
+		format: formatDays,
+	},
+}
+
+type failCondition struct {
+	metric    string
+	op        string
+	threshold float64
+}
+
+// failConditions is the repeatable -fail-if flag, METRIC<VALUE or
+// METRIC>VALUE.
+type failConditions []failCondition
+
+var failConditionPattern = regexp.MustCompile(`^\s*(\w+)\s*(<=|>=|<|>)\s*(\S+)\s*$`)
+
+func (fc *failConditions) String() string {
+	var conditions []string
+	for _, c := range *fc {
+		conditions = append(conditions, c.String())
+	}
+	return strings.Join(conditions, ", ")
+}
+
+func (fc *failConditions) Set(value string) error {
+	m := failConditionPattern.FindStringSubmatch(value)
+	if m == nil {
+		return fmt.Errorf("-fail-if %q is not of the form METRIC<VALUE", value)
+	}
+	metric, ok := failMetrics[m[1]]
+	if !ok {
+		return fmt.Errorf("-fail-if can't test %v", m[1])
+	}
+	threshold, err := metric.parse(m[3])
+	if err != nil {
+		return fmt.Errorf("-fail-if %q: %v", value, err)
+	}
+	*fc = append(*fc, failCondition{m[1], m[2], threshold})
+	return nil
+}
+
+func (c failCondition) String() string {
+	return c.metric + c.op + failMetrics[c.metric].format(c.threshold)
+}
+
+// failed returns why summary meets the condition, or "" if it doesn't.
+func (c failCondition) failed(summary *RunSummary) string {
+	metric := failMetrics[c.metric]
+	v, ok := metric.value(summary)
+	if !ok {
+		return ""
+	}
+	var met bool
+	switch c.op {
+	case "<":
+		met = v < c.threshold
+	case "<=":
+		met = v <= c.threshold
+	case ">":
+		met = v > c.threshold
+	case ">=":
+		met = v >= c.threshold
+	}
+	if !met {
+		return ""
+	}
+	return fmt.Sprintf("%v is %v, failing -fail-if %v", c.metric, metric.format(v), c)
+}
+
+var failIf failConditions
+
+func init() {
+	flag.Var(&failIf, "fail-if", "`METRIC<VALUE` fail the run, exiting with 1, if a metric is past a threshold, e.g. cert_expiry<14d for certificates expiring within two weeks, can be repeated")
+}
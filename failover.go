@@ -0,0 +1,334 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/url"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/grafov/m3u8"
+	log "github.com/sirupsen/logrus"
+)
+
+var failoverAfter = flag.Duration("failover-after", 0, "this far into the run, block the edge segments last came from, or the variant benchmarked with -failover variant, and report how long downloads took to recover and at what BANDWIDTH")
+
+// failoverModeFlag is the -failover flag.
+type failoverModeFlag string
+
+func (fm *failoverModeFlag) String() string {
+	return string(*fm)
+}
+
+func (fm *failoverModeFlag) Set(value string) error {
+	switch value {
+	case "edge", "variant":
+		*fm = failoverModeFlag(value)
+		return nil
+	}
+	return fmt.Errorf("failover %q is not edge or variant", value)
+}
+
+var failoverMode failoverModeFlag = "edge"
+
+func init() {
+	flag.Var(&failoverMode, "failover", "`what` -failover-after blocks: the edge, refusing to connect to its IP address and closing the connections to it, or the variant, refusing to fetch its playlist and segments so that another is picked")
+}
+
+// errBlocked is the error of connections and requests refused after
+// -failover-after.
+var errBlocked = errors.New("blocked to test failover")
+
+// connBlocklist refuses connections to blocked edges and requests for
+// blocked URLs, as if they had gone down.
+type connBlocklist struct {
+	mu    sync.Mutex
+	ips   map[string]bool
+	urls  map[string]bool
+	conns map[*blockableConn]bool
+}
+
+func newConnBlocklist() *connBlocklist {
+	return &connBlocklist{
+		ips:   map[string]bool{},
+		urls:  map[string]bool{},
+		conns: map[*blockableConn]bool{},
+	}
+}
+
+// control is the Control of the dialer of a run's transport, refusing to
+// connect to blocked edges.
+func (bl *connBlocklist) control(network, address string, c syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil
+	}
+	bl.mu.Lock()
+	defer bl.mu.Unlock()
+	if bl.ips[host] {
+		return errBlocked
+	}
+	return nil
+}
+
+// track wraps a newly dialed connection with -failover-after, so that it
+// can be closed if its edge is blocked.
+func (bl *connBlocklist) track(conn net.Conn, err error) (net.Conn, error) {
+	if err != nil || bl == nil {
+		return conn, err
+	}
+	bc := &blockableConn{Conn: conn, blocklist: bl}
+	bl.mu.Lock()
+	defer bl.mu.Unlock()
+	bl.conns[bc] = true
+	return bc, nil
+}
+
+// BlockEdge refuses new connections to ip and closes those open.
+func (bl *connBlocklist) BlockEdge(ip string) {
+	bl.mu.Lock()
+	bl.ips[ip] = true
+	var closing []*blockableConn
+	for bc := range bl.conns {
+		if host, _, err := net.SplitHostPort(bc.RemoteAddr().String()); err == nil && host == ip {
+			closing = append(closing, bc)
+		}
+	}
+	bl.mu.Unlock()
+	for _, bc := range closing {
+		bc.Close()
+	}
+}
+
+// BlockURLs refuses requests for urls.
+func (bl *connBlocklist) BlockURLs(urls []string) {
+	bl.mu.Lock()
+	defer bl.mu.Unlock()
+	for _, u := range urls {
+		bl.urls[u] = true
+	}
+}
+
+// blocksURL reports whether requests for uri are refused.
+func (bl *connBlocklist) blocksURL(uri string) bool {
+	bl.mu.Lock()
+	defer bl.mu.Unlock()
+	return bl.urls[uri]
+}
+
+// refuse returns an error, as a failed dial would, if u is blocked.
+func (bl *connBlocklist) refuse(u *url.URL) error {
+	if bl.blocksURL(u.String()) {
+		return &net.OpError{Op: "dial", Net: "tcp", Err: errBlocked}
+	}
+	return nil
+}
+
+// blockableConn is a connection tracked by a connBlocklist until closed.
+type blockableConn struct {
+	net.Conn
+	blocklist *connBlocklist
+}
+
+func (bc *blockableConn) Close() error {
+	bc.blocklist.mu.Lock()
+	delete(bc.blocklist.conns, bc)
+	bc.blocklist.mu.Unlock()
+	return bc.Conn.Close()
+}
+
+// FailoverTest blocks the edge or variant a run is downloading from with
+// -failover-after and times how long it takes for a segment to be
+// downloaded again.
+type FailoverTest struct {
+	blocklist *connBlocklist
+	mu        sync.Mutex
+	edge      string
+	variant   string
+	bandwidth uint32
+	listed    map[string]bool
+	report    *FailoverReport
+	at        time.Time
+}
+
+// NewFailoverTest makes a test blocking edges and URLs in bl, which the
+// run's requests have to be refused by.
+func NewFailoverTest(bl *connBlocklist) *FailoverTest {
+	return &FailoverTest{blocklist: bl, listed: map[string]bool{}}
+}
+
+// FailoverReport is what was blocked and how downloads recovered.
+type FailoverReport struct {
+	Mode            string        `json:"mode"`
+	Blocked         string        `json:"blocked"`
+	At              time.Duration `json:"at"`
+	Recovered       bool          `json:"recovered"`
+	Recovery        time.Duration `json:"recovery,omitempty"`
+	Failures        int           `json:"failures"`
+	Edge            string        `json:"edge,omitempty"`
+	BandwidthBefore uint32        `json:"bandwidth_before,omitempty"`
+	BandwidthAfter  uint32        `json:"bandwidth_after,omitempty"`
+}
+
+// Variant notes the variant being benchmarked, forgetting the segments of
+// the one before.
+func (ft *FailoverTest) Variant(uri string, bandwidth uint32) {
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+	ft.variant, ft.bandwidth = uri, bandwidth
+	ft.listed = map[string]bool{}
+}
+
+// Listed notes the segments of the variant being benchmarked, to block
+// them along with it with -failover variant.
+func (ft *FailoverTest) Listed(init *SegmentDownload, segments []*SegmentDownload) {
+	if *failoverAfter <= 0 || failoverMode != "variant" {
+		return
+	}
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+	if init != nil {
+		ft.listed[init.URI] = true
+	}
+	for _, segment := range segments {
+		ft.listed[segment.URI] = true
+	}
+}
+
+// Blocked reports whether uri is the variant playlist blocked.
+func (ft *FailoverTest) Blocked(uri string) bool {
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+	return ft.report != nil && ft.report.Mode == "variant" && ft.report.Blocked == uri
+}
+
+// Remaining returns the variants of a master playlist at base that aren't
+// blocked.
+func (ft *FailoverTest) Remaining(base *url.URL, variants []*m3u8.Variant) []*m3u8.Variant {
+	var remaining []*m3u8.Variant
+	for _, v := range variants {
+		if v != nil {
+			if uri, _, err := resolvePlaylist(base, v.URI); err == nil && ft.Blocked(uri) {
+				continue
+			}
+		}
+		remaining = append(remaining, v)
+	}
+	return remaining
+}
+
+// Seen notes where a segment came from, or that downloads recovered if it
+// is the first to succeed since blocking.
+func (ft *FailoverTest) Seen(result *RequestResult) {
+	if result.Kind != KindSegment {
+		return
+	}
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+	if ft.report == nil {
+		ft.edge = result.Edge
+		return
+	}
+	r := ft.report
+	if r.Recovered || result.CompletedAt.Before(ft.at) ||
+		(r.Mode == "edge" && result.Edge == r.Blocked) ||
+		(r.Mode == "variant" && ft.blocklist.blocksURL(result.URI)) {
+		return
+	}
+	r.Recovered = true
+	r.Recovery = result.CompletedAt.Sub(ft.at)
+	r.Edge = result.Edge
+	r.BandwidthAfter = ft.bandwidth
+	log.WithField("Recovery", r.Recovery).
+		WithField("Edge", r.Edge).
+		WithField("Bandwidth", r.BandwidthAfter).
+		Info("Downloads recovered from failover")
+}
+
+// Failed counts the segments that failed since blocking until downloads
+// recovered.
+func (ft *FailoverTest) Failed(result *RequestResult) {
+	if result.Kind != KindSegment {
+		return
+	}
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+	if ft.report != nil && !ft.report.Recovered {
+		ft.report.Failures++
+	}
+}
+
+// Run blocks the edge or variant -failover-after into a run that started
+// at start.
+func (ft *FailoverTest) Run(ctx context.Context, start time.Time) {
+	select {
+	case <-ctx.Done():
+		return
+	case <-time.After(time.Until(start.Add(*failoverAfter))):
+	}
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+	r := &FailoverReport{
+		Mode:            string(failoverMode),
+		At:              time.Since(start),
+		BandwidthBefore: ft.bandwidth,
+	}
+	switch failoverMode {
+	case "edge":
+		if ft.edge == "" {
+			log.Warn("No segments were downloaded to know which edge to block")
+			return
+		}
+		r.Blocked = ft.edge
+		ft.blocklist.BlockEdge(ft.edge)
+	case "variant":
+		if ft.variant == "" {
+			log.Warn("No variant of a master playlist is being benchmarked to block")
+			return
+		}
+		r.Blocked = ft.variant
+		urls := []string{ft.variant}
+		for uri := range ft.listed {
+			urls = append(urls, uri)
+		}
+		ft.blocklist.BlockURLs(urls)
+	}
+	ft.report, ft.at = r, time.Now()
+	log.Warnf("Blocked %v %v to test failover", r.Mode, r.Blocked)
+}
+
+// Report returns nil unless something was blocked.
+func (ft *FailoverTest) Report() *FailoverReport {
+	if ft == nil {
+		return nil
+	}
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+	if ft.report == nil {
+		return nil
+	}
+	copied := *ft.report
+	return &copied
+}
+
+func (ft *FailoverTest) LogSummary() {
+	r := ft.Report()
+	if r == nil {
+		return
+	}
+	entry := log.WithField("At", r.At).
+		WithField("Failures", r.Failures).
+		WithField("BandwidthBefore", r.BandwidthBefore)
+	if !r.Recovered {
+		entry.Warnf("Downloads never recovered from blocking %v %v", r.Mode, r.Blocked)
+		return
+	}
+	entry.WithField("Recovery", r.Recovery).
+		WithField("Edge", r.Edge).
+		WithField("BandwidthAfter", r.BandwidthAfter).
+		Infof("Failover from %v %v", r.Mode, r.Blocked)
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var errorBodyBytes = flag.Int64("error-body-bytes", 0, "number of response body bytes to log when a request fails")
+
+// classifyBodyBytes is how much of an error body is read to work out why the
+// request failed, regardless of how much of it ends up in the log.
+const classifyBodyBytes = 64 * 1024
+
+func isSuccess(resp *http.Response) bool {
+	return resp.StatusCode >= 200 && resp.StatusCode <= 299
+}
+
+// logFailedResponse logs everything we know about a failed response: the
+// full header set, the reason parsed from the error body and, when
+// requested, the start of the body itself. CDN error pages and debug headers
+// are otherwise lost.
+func logFailedResponse(resp *http.Response, format string, args ...interface{}) string {
+	entry := failedResponseEntry(resp)
+	limit := int64(classifyBodyBytes)
+	if *errorBodyBytes > limit {
+		limit = *errorBodyBytes
+	}
+	var reason string
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, limit))
+	if err == nil {
+		reason = classifyErrorBody(body)
+		if reason != "" {
+			entry = entry.WithField("ErrorReason", reason)
+		}
+		if *errorBodyBytes > 0 {
+			if int64(len(body)) > *errorBodyBytes {
+				body = body[:*errorBodyBytes]
+			}
+			entry = entry.WithField("ResponseBody", string(body))
+		}
+	}
+	entry.Warnf(format, args...)
+	return reason
+}
+
+// logFailedHeaders logs a response that failed once its body had already
+// been read, or partly read, so with its headers only.
+func logFailedHeaders(resp *http.Response, format string, args ...interface{}) {
+	failedResponseEntry(resp).Warnf(format, args...)
+}
+
+func failedResponseEntry(resp *http.Response) *log.Entry {
+	return log.WithFields(traceFields(resp.Request)).
+		WithField("StatusCode", resp.StatusCode).
+		WithField("ResponseHeaders", resp.Header)
+}
+
+func logFailedRequest(req *http.Request, err error) {
+	log.WithFields(traceFields(req)).WithError(err).Warnf("Request failed for %v", req.URL)
+}
+
+type s3Error struct {
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+var (
+	cloudFrontReason = regexp.MustCompile(`(?is)<hr[^>]*>(.*?)<br`)
+	htmlTitle        = regexp.MustCompile(`(?is)<title>(.*?)</title>`)
+	htmlTag          = regexp.MustCompile(`<[^>]*>`)
+)
+
+// classifyErrorBody turns the error documents produced by S3, CloudFront and
+// JSON APIs into a short normalized reason such as "s3: AccessDenied: Access
+// Denied". An empty string means the body wasn't recognized.
+func classifyErrorBody(body []byte) string {
+	body = bytes.TrimSpace(body)
+	if len(body) == 0 {
+		return ""
+	}
+	switch {
+	case body[0] == '{':
+		var doc map[string]interface{}
+		if json.Unmarshal(body, &doc) == nil {
+			if reason := jsonErrorReason(doc); reason != "" {
+				return "json: " + reason
+			}
+		}
+	case bytes.HasPrefix(body, []byte("<?xml")) || bytes.HasPrefix(body, []byte("<Error>")):
+		var e s3Error
+		if xml.Unmarshal(body, &e) == nil && e.Code != "" {
+			return fmt.Sprintf("s3: %s: %s", e.Code, e.Message)
+		}
+	case bytes.Contains(body, []byte("cloudfront")):
+		if m := cloudFrontReason.FindSubmatch(body); m != nil {
+			return "cloudfront: " + firstSentence(cleanText(m[1]))
+		}
+		return "cloudfront: " + firstSentence(htmlText(body))
+	case bytes.HasPrefix(bytes.ToLower(body), []byte("<!doctype html")) || bytes.HasPrefix(bytes.ToLower(body), []byte("<html")):
+		return "html: " + htmlText(body)
+	}
+	return ""
+}
+
+func jsonErrorReason(doc map[string]interface{}) string {
+	for _, key := range []string{"message", "error", "reason", "detail", "code"} {
+		switch v := doc[key].(type) {
+		case string:
+			if v != "" {
+				return v
+			}
+		case map[string]interface{}:
+			if reason := jsonErrorReason(v); reason != "" {
+				return reason
+			}
+		}
+	}
+	return ""
+}
+
+func htmlText(body []byte) string {
+	if m := htmlTitle.FindSubmatch(body); m != nil {
+		return cleanText(m[1])
+	}
+	return ""
+}
+
+func cleanText(b []byte) string {
+	return strings.Join(strings.Fields(string(htmlTag.ReplaceAll(b, nil))), " ")
+}
+
+func firstSentence(s string) string {
+	if i := strings.Index(s, ". "); i >= 0 {
+		return s[:i+1]
+	}
+	return s
+}
@@ -0,0 +1,63 @@
+package main
+
+import "testing"
+
+func TestClassifyErrorBody(t *testing.T) {
+	tests := []struct {
+		name, body, want string
+	}{
+		{"empty", "", ""},
+		{"whitespace", " \n\t", ""},
+		{"plain text", "Forbidden", ""},
+		{
+			"s3",
+			`<?xml version="1.0" encoding="UTF-8"?>
+<Error><Code>AccessDenied</Code><Message>Access Denied</Message><RequestId>4442587FB7D0A2F9</RequestId></Error>`,
+			"s3: AccessDenied: Access Denied",
+		},
+		{
+			"s3 without declaration",
+			`<Error><Code>NoSuchKey</Code><Message>The specified key does not exist.</Message><Key>seg1.ts</Key></Error>`,
+			"s3: NoSuchKey: The specified key does not exist.",
+		},
+		{"xml without code", `<?xml version="1.0"?><Error><Message>nope</Message></Error>`, ""},
+		{
+			"cloudfront",
+			`<!DOCTYPE HTML PUBLIC "-//W3C//DTD HTML 4.01 Transitional//EN" "http://www.w3.org/TR/html4/loose.dtd">
+<HTML><HEAD><META HTTP-EQUIV="Content-Type" CONTENT="text/html; charset=iso-8859-1">
+<TITLE>ERROR: The request could not be satisfied</TITLE>
+</HEAD><BODY>
+<H1>403 ERROR</H1>
+<H2>The request could not be satisfied.</H2>
+<HR noshade size="1px">
+Request blocked.
+We can't connect to the server for this app or website at this time.
+<BR clear="all">
+<HR noshade size="1px">
+<PRE>
+Generated by cloudfront (CloudFront)
+Request ID: 3Wt9qiZSyiUcfN0bPr4m1dfIFmB7yBZRfjrmKqBxTS0b2pnmhNkuAw==
+</PRE>
+</BODY></HTML>`,
+			"cloudfront: Request blocked.",
+		},
+		{
+			"cloudfront without reason",
+			`<html><head><title>ERROR: The request could not be satisfied</title></head><body>Generated by cloudfront (CloudFront)</body></html>`,
+			"cloudfront: ERROR: The request could not be satisfied",
+		},
+		{"json message", `{"message": "Token expired"}`, "json: Token expired"},
+		{"json nested", `{"error": {"code": 403, "message": "Forbidden by policy"}}`, "json: Forbidden by policy"},
+		{"json error string", `{"error": "invalid_token", "detail": "ignored"}`, "json: invalid_token"},
+		{"json empty message", `{"message": "", "reason": "geo blocked"}`, "json: geo blocked"},
+		{"json without reason", `{"code": 403}`, ""},
+		{"invalid json", `{oops`, ""},
+		{"html", "<!DOCTYPE html>\n<html><head><title>502 Bad\n  Gateway</title></head><body>nginx</body></html>", "html: 502 Bad Gateway"},
+		{"html tag", `<html><head><title><b>Service</b> Unavailable</title></head></html>`, "html: Service Unavailable"},
+	}
+	for _, test := range tests {
+		if got := classifyErrorBody([]byte(test.body)); got != test.want {
+			t.Errorf("%v: classifyErrorBody = %q, want %q", test.name, got, test.want)
+		}
+	}
+}
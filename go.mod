@@ -1,9 +1,21 @@
 module github.com/Echo360/echo360-benchmark
 
-go 1.13
+go 1.26.0
 
 require (
 	github.com/digitaljanitors/go-httpstat v0.2.1-0.20200331213148-166c91beed46
 	github.com/grafov/m3u8 v0.11.1
 	github.com/sirupsen/logrus v1.4.2
+	golang.org/x/crypto v0.57.0
+	golang.org/x/image v0.46.0
+	google.golang.org/grpc v1.84.0
+	google.golang.org/protobuf v1.36.12
+)
+
+require (
+	github.com/konsorten/go-windows-terminal-sequences v1.0.1 // indirect
+	golang.org/x/net v0.58.0 // indirect
+	golang.org/x/sys v0.48.0 // indirect
+	golang.org/x/text v0.42.0 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20260706201446-f0a921348800 // indirect
 )
@@ -0,0 +1,136 @@
+package main
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative daemonpb/daemon.proto
+
+import (
+	"context"
+	"time"
+
+	"github.com/Echo360/echo360-benchmark/daemonpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// grpcDaemon serves a Daemon's runs over gRPC.
+type grpcDaemon struct {
+	daemonpb.UnimplementedDaemonServer
+	d *Daemon
+}
+
+func newGRPCServer(d *Daemon) *grpc.Server {
+	server := grpc.NewServer()
+	daemonpb.RegisterDaemonServer(server, &grpcDaemon{d: d})
+	return server
+}
+
+// grpcError maps the errors of Daemon to gRPC status codes, leaving those
+// that already have one, such as of sending to a stream, alone.
+func grpcError(err error) error {
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	switch err.(type) {
+	case notFoundError:
+		return status.Error(codes.NotFound, err.Error())
+	case conflictError:
+		return status.Error(codes.FailedPrecondition, err.Error())
+	}
+	return status.Error(codes.Internal, err.Error())
+}
+
+func (gd *grpcDaemon) StartRun(ctx context.Context, req *daemonpb.StartRunRequest) (*daemonpb.Run, error) {
+	if err := checkRemotePlaylist(req.PlaylistUrl); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	var duration time.Duration
+	if req.Duration != nil {
+		if err := req.Duration.CheckValid(); err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		duration = req.Duration.AsDuration()
+	}
+	id, err := gd.d.StartRun(req.PlaylistUrl, duration)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	dr, _ := gd.d.get(id)
+	return runProto(&dr), nil
+}
+
+func (gd *grpcDaemon) StopRun(ctx context.Context, req *daemonpb.StopRunRequest) (*daemonpb.Run, error) {
+	dr, err := gd.d.StopRun(req.Id)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return runProto(dr), nil
+}
+
+func (gd *grpcDaemon) StreamResults(req *daemonpb.StreamResultsRequest, stream daemonpb.Daemon_StreamResultsServer) error {
+	err := gd.d.StreamResults(stream.Context(), req.Id, func(result *RequestResult) error {
+		return stream.Send(resultProto(result))
+	})
+	if err != nil {
+		return grpcError(err)
+	}
+	return nil
+}
+
+var runStates = map[string]daemonpb.Run_State{
+	stateRunning:  daemonpb.Run_STATE_RUNNING,
+	stateStopping: daemonpb.Run_STATE_STOPPING,
+	stateFinished: daemonpb.Run_STATE_FINISHED,
+}
+
+func runProto(dr *daemonRun) *daemonpb.Run {
+	run := &daemonpb.Run{
+		Id:          dr.ID,
+		PlaylistUrl: dr.PlaylistURL,
+		Schedule:    dr.Schedule,
+		State:       runStates[dr.State],
+		Start:       timestamppb.New(dr.Start),
+		Error:       dr.Error,
+	}
+	if dr.End != nil {
+		run.End = timestamppb.New(*dr.End)
+	}
+	return run
+}
+
+func resultProto(rr *RequestResult) *daemonpb.RequestResult {
+	result := &daemonpb.RequestResult{
+		RunId:         rr.RunID,
+		Kind:          rr.Kind,
+		Uri:           rr.URI,
+		Range:         rr.Range,
+		Duration:      rr.Duration,
+		Rendition:     rr.Rendition,
+		Class:         rr.Class,
+		Tags:          rr.Tags,
+		RequestedAt:   timestamppb.New(rr.RequestedAt),
+		StatusCode:    int32(rr.StatusCode),
+		Protocol:      rr.Protocol,
+		Edge:          rr.Edge,
+		Pop:           rr.POP,
+		Bytes:         rr.Bytes,
+		Mbps:          rr.Mbps,
+		ErrorCategory: string(rr.ErrorCategory),
+		Error:         rr.Error,
+	}
+	if !rr.CompletedAt.IsZero() {
+		result.CompletedAt = timestamppb.New(rr.CompletedAt)
+	}
+	if t := rr.Timings; t != nil {
+		result.Timings = &daemonpb.Timings{
+			DnsLookup:        durationpb.New(t.DNSLookup),
+			TcpConnection:    durationpb.New(t.TCPConnection),
+			TlsHandshake:     durationpb.New(t.TLSHandshake),
+			ServerProcessing: durationpb.New(t.ServerProcessing),
+			ContentTransfer:  durationpb.New(t.ContentTransfer),
+			Total:            durationpb.New(t.Total),
+		}
+	}
+	return result
+}
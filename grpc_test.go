@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/Echo360/echo360-benchmark/daemonpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func TestGRPCDaemon(t *testing.T) {
+	l := bufconn.Listen(1 << 16)
+	server := newGRPCServer(NewDaemon())
+	go server.Serve(l)
+	defer server.Stop()
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return l.Dial() }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := daemonpb.NewDaemonClient(conn)
+	ctx := context.Background()
+
+	_, err = client.StartRun(ctx, &daemonpb.StartRunRequest{PlaylistUrl: "file:///etc/passwd"})
+	if code := status.Code(err); code != codes.InvalidArgument {
+		t.Errorf("StartRun of a local file = %v, want %v", err, codes.InvalidArgument)
+	}
+	_, err = client.StopRun(ctx, &daemonpb.StopRunRequest{Id: "01ARZ3NDEKTSV4RRFFQ69G5FAV"})
+	if code := status.Code(err); code != codes.NotFound {
+		t.Errorf("StopRun of an unknown run = %v, want %v", err, codes.NotFound)
+	}
+	stream, err := client.StreamResults(ctx, &daemonpb.StreamResultsRequest{Id: "01ARZ3NDEKTSV4RRFFQ69G5FAV"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stream.Recv(); status.Code(err) != codes.NotFound {
+		t.Errorf("StreamResults of an unknown run = %v, want %v", err, codes.NotFound)
+	}
+}
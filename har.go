@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// HAR 1.2 as written by -output har, of which harFile and harEntry are the
+// parts replay reads back.
+type harLog struct {
+	Log struct {
+		Version string `json:"version"`
+		Creator struct {
+			Name    string `json:"name"`
+			Version string `json:"version"`
+		} `json:"creator"`
+		Entries []*harLogEntry `json:"entries"`
+	} `json:"log"`
+}
+
+type harLogEntry struct {
+	StartedDateTime time.Time      `json:"startedDateTime"`
+	Time            float64        `json:"time"`
+	Request         harLogRequest  `json:"request"`
+	Response        harLogResponse `json:"response"`
+	Cache           struct{}       `json:"cache"`
+	Timings         harLogTimings  `json:"timings"`
+	ServerIPAddress string         `json:"serverIPAddress,omitempty"`
+	Kind            string         `json:"_kind"`
+	RunID           string         `json:"_run_id,omitempty"`
+	Error           string         `json:"_error,omitempty"`
+}
+
+type harLogRequest struct {
+	Method      string      `json:"method"`
+	URL         string      `json:"url"`
+	HTTPVersion string      `json:"httpVersion"`
+	Cookies     []struct{}  `json:"cookies"`
+	Headers     []harHeader `json:"headers"`
+	QueryString []harHeader `json:"queryString"`
+	HeadersSize int         `json:"headersSize"`
+	BodySize    int         `json:"bodySize"`
+}
+
+type harLogResponse struct {
+	Status      int         `json:"status"`
+	StatusText  string      `json:"statusText"`
+	HTTPVersion string      `json:"httpVersion"`
+	Cookies     []struct{}  `json:"cookies"`
+	Headers     []harHeader `json:"headers"`
+	Content     struct {
+		Size     int64  `json:"size"`
+		MimeType string `json:"mimeType"`
+	} `json:"content"`
+	RedirectURL string `json:"redirectURL"`
+	HeadersSize int    `json:"headersSize"`
+	BodySize    int64  `json:"bodySize"`
+}
+
+// harLogTimings are in milliseconds, -1 meaning not applicable.
+type harLogTimings struct {
+	Blocked float64 `json:"blocked"`
+	DNS     float64 `json:"dns"`
+	Connect float64 `json:"connect"`
+	SSL     float64 `json:"ssl"`
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+// harMilliseconds converts d to HAR milliseconds.
+func harMilliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// harHeaders lists h sorted by name, as HAR keeps headers in a list.
+func harHeaders(h http.Header) []harHeader {
+	headers := []harHeader{}
+	for name, values := range h {
+		for _, value := range values {
+			headers = append(headers, harHeader{Name: name, Value: value})
+		}
+	}
+	sort.SliceStable(headers, func(i, j int) bool { return headers[i].Name < headers[j].Name })
+	return headers
+}
+
+// newHARLogEntry describes r as a HAR entry, with the request headers that
+// matter to replaying it. Requests that got no response have a status of 0,
+// as browsers record them.
+func newHARLogEntry(r *RequestResult) *harLogEntry {
+	e := &harLogEntry{
+		StartedDateTime: r.RequestedAt,
+		Kind:            r.Kind,
+		RunID:           r.RunID,
+		Error:           r.Error,
+		ServerIPAddress: r.Edge,
+	}
+	e.Request.Method = "GET"
+	if probeMode == "head" && r.Kind == KindSegment {
+		e.Request.Method = "HEAD"
+	}
+	e.Request.URL = r.URI
+	e.Request.HTTPVersion = r.Protocol
+	e.Request.Cookies = []struct{}{}
+	e.Request.Headers = []harHeader{}
+	if r.Range != "" {
+		e.Request.Headers = append(e.Request.Headers, harHeader{Name: "Range", Value: "bytes=" + r.Range})
+	}
+	if r.TraceParent != "" {
+		e.Request.Headers = append(e.Request.Headers, harHeader{Name: "traceparent", Value: r.TraceParent})
+	}
+	e.Request.QueryString = []harHeader{}
+	e.Request.HeadersSize = -1
+	e.Request.BodySize = 0
+
+	e.Response.Status = r.StatusCode
+	e.Response.StatusText = http.StatusText(r.StatusCode)
+	e.Response.HTTPVersion = r.Protocol
+	e.Response.Cookies = []struct{}{}
+	e.Response.Headers = harHeaders(r.Header)
+	e.Response.Content.Size = r.Bytes
+	e.Response.Content.MimeType = r.Header.Get("Content-Type")
+	e.Response.HeadersSize = -1
+	e.Response.BodySize = r.Bytes
+	if r.WireBytes > 0 {
+		e.Response.BodySize = r.WireBytes
+	}
+
+	e.Timings = harLogTimings{Blocked: -1, DNS: -1, Connect: -1, SSL: -1}
+	if t := r.Timings; t != nil {
+		if t.DNSLookup > 0 {
+			e.Timings.DNS = harMilliseconds(t.DNSLookup)
+		}
+		// HAR counts the TLS handshake into connect as well.
+		if t.TCPConnection > 0 {
+			e.Timings.Connect = harMilliseconds(t.TCPConnection + t.TLSHandshake)
+		}
+		if t.TLSHandshake > 0 {
+			e.Timings.SSL = harMilliseconds(t.TLSHandshake)
+		}
+		e.Timings.Wait = harMilliseconds(t.ServerProcessing)
+		e.Timings.Receive = harMilliseconds(t.ContentTransfer)
+		e.Time = harMilliseconds(t.Total)
+	} else if !r.CompletedAt.IsZero() {
+		e.Time = harMilliseconds(r.CompletedAt.Sub(r.RequestedAt))
+		e.Timings.Wait = e.Time
+	}
+	return e
+}
+
+// harSink writes every request of a run as a HAR file, which replay, browser
+// developer tools and HAR viewers read. HAR is a single JSON document, so it
+// is only written once the run is over.
+type harSink struct {
+	w   io.WriteCloser
+	har harLog
+}
+
+func newHARSink(target string) (OutputSink, error) {
+	w, err := openTarget(target)
+	if err != nil {
+		return nil, err
+	}
+	hs := &harSink{w: w}
+	hs.har.Log.Version = "1.2"
+	hs.har.Log.Creator.Name = "hlsbenchmark"
+	hs.har.Log.Creator.Version = VERSION
+	hs.har.Log.Entries = []*harLogEntry{}
+	return hs, nil
+}
+
+func (hs *harSink) Result(r *RequestResult) {
+	hs.har.Log.Entries = append(hs.har.Log.Entries, newHARLogEntry(r))
+}
+
+func (hs *harSink) Summary(*RunSummary) {}
+
+func (hs *harSink) Close() error {
+	entries := hs.har.Log.Entries
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].StartedDateTime.Before(entries[j].StartedDateTime) })
+	enc := json.NewEncoder(hs.w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(&hs.har); err != nil {
+		hs.w.Close()
+		return err
+	}
+	return hs.w.Close()
+}
+
+func init() {
+	RegisterOutput("har", newHARSink)
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	heartbeatInterval = flag.Duration("heartbeat", 0, "log a heartbeat this often with the runs going on, the benchmark's uptime and when a segment was last fetched, so that monitoring can tell a wedged probe from an idle one")
+	heartbeatWebhook  = flag.String("heartbeat-webhook", "", "also POST every -heartbeat as JSON to this `URL`")
+	heartbeatFile     = flag.String("heartbeat-file", "", "also write every -heartbeat as OpenMetrics to this `file`, e.g. for node_exporter's textfile collector")
+)
+
+// processStart is when the benchmark started, for its uptime.
+var processStart = time.Now()
+
+// heartbeat follows the runs of the benchmark and their segments, for
+// -heartbeat.
+var heartbeat = &Heartbeat{runs: map[string]bool{}}
+
+// Heartbeat reports that the benchmark is alive every -heartbeat, whether
+// or not it is running: a probe whose heartbeat stops is dead, and one
+// whose runs stop fetching segments while going on is wedged, while an idle
+// one carries on beating without runs.
+type Heartbeat struct {
+	mu          sync.Mutex
+	runs        map[string]bool
+	lastSegment time.Time
+}
+
+// HeartbeatEvent is a beat of -heartbeat.
+type HeartbeatEvent struct {
+	Time        time.Time     `json:"time"`
+	Hostname    string        `json:"hostname"`
+	RunIDs      []string      `json:"run_ids"`
+	Uptime      time.Duration `json:"uptime"`
+	LastSegment *time.Time    `json:"last_segment,omitempty"`
+}
+
+// Running notes a run going on until Finished.
+func (hb *Heartbeat) Running(id string) {
+	hb.mu.Lock()
+	defer hb.mu.Unlock()
+	hb.runs[id] = true
+}
+
+func (hb *Heartbeat) Finished(id string) {
+	hb.mu.Lock()
+	defer hb.mu.Unlock()
+	delete(hb.runs, id)
+}
+
+// Segment notes a segment fetched successfully at at.
+func (hb *Heartbeat) Segment(at time.Time) {
+	hb.mu.Lock()
+	defer hb.mu.Unlock()
+	if at.After(hb.lastSegment) {
+		hb.lastSegment = at
+	}
+}
+
+func (hb *Heartbeat) event(now time.Time) *HeartbeatEvent {
+	hostname, _ := os.Hostname()
+	event := &HeartbeatEvent{Time: now, Hostname: hostname, RunIDs: []string{}, Uptime: now.Sub(processStart)}
+	hb.mu.Lock()
+	defer hb.mu.Unlock()
+	for id := range hb.runs {
+		event.RunIDs = append(event.RunIDs, id)
+	}
+	sort.Strings(event.RunIDs)
+	if !hb.lastSegment.IsZero() {
+		last := hb.lastSegment
+		event.LastSegment = &last
+	}
+	return event
+}
+
+// Start beats every -heartbeat for as long as the benchmark runs.
+func (hb *Heartbeat) Start() {
+	go func() {
+		for now := range time.Tick(*heartbeatInterval) {
+			hb.beat(hb.event(now))
+		}
+	}()
+}
+
+func (hb *Heartbeat) beat(event *HeartbeatEvent) {
+	entry := log.WithField("RunIDs", event.RunIDs).
+		WithField("Uptime", event.Uptime.Round(time.Second))
+	if event.LastSegment != nil {
+		entry = entry.WithField("LastSegment", event.LastSegment.Format(time.RFC3339))
+	}
+	entry.Info("Heartbeat")
+	if *heartbeatFile != "" {
+		if err := writeHeartbeatFile(event); err != nil {
+			log.Errorf("Writing heartbeat: %v", err)
+		}
+	}
+	if *heartbeatWebhook != "" {
+		body, err := json.Marshal(event)
+		if err != nil {
+			log.Error(err)
+			return
+		}
+		resp, err := alertClient.Post(*heartbeatWebhook, "application/json", bytes.NewReader(body))
+		if err != nil {
+			log.Errorf("Sending heartbeat: %v", err)
+			return
+		}
+		resp.Body.Close()
+		if !isSuccess(resp) {
+			log.Errorf("Sending heartbeat: webhook returned HTTP %v", resp.StatusCode)
+		}
+	}
+}
+
+// writeHeartbeatFile replaces -heartbeat-file with the metrics of event.
+func writeHeartbeatFile(event *HeartbeatEvent) error {
+	beat := &metric{name: "hlsbenchmark_heartbeat_timestamp_seconds", help: "When the benchmark last reported it was alive.", typ: "gauge"}
+	beat.add(float64(event.Time.UnixNano()) / 1e9)
+	uptime := &metric{name: "hlsbenchmark_uptime_seconds", help: "How long the benchmark has been running.", typ: "gauge"}
+	uptime.add(event.Uptime.Seconds())
+	runs := &metric{name: "hlsbenchmark_runs", help: "Runs going on.", typ: "gauge"}
+	runs.add(float64(len(event.RunIDs)))
+	metrics := []*metric{beat, uptime, runs}
+	if event.LastSegment != nil {
+		last := &metric{name: "hlsbenchmark_last_segment_timestamp_seconds", help: "When a segment was last fetched successfully.", typ: "gauge"}
+		last.add(float64(event.LastSegment.UnixNano()) / 1e9)
+		metrics = append(metrics, last)
+	}
+	var buf bytes.Buffer
+	writeMetrics(&buf, metrics, "instance", event.Hostname)
+	buf.WriteString("# EOF\n")
+	tmp := *heartbeatFile + ".tmp"
+	if err := ioutil.WriteFile(tmp, buf.Bytes(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, *heartbeatFile)
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/grafov/m3u8"
+	log "github.com/sirupsen/logrus"
+)
+
+type segmentSignature struct {
+	URI           string
+	Duration      float64
+	Limit         int64
+	Offset        int64
+	Discontinuity bool
+}
+
+type playlistSnapshot struct {
+	at          time.Time
+	tags        map[string]string
+	segments    map[uint64]segmentSignature
+	first, last uint64
+}
+
+func newPlaylistSnapshot(at time.Time, mpl *m3u8.MediaPlaylist) *playlistSnapshot {
+	ps := &playlistSnapshot{
+		at: at,
+		tags: map[string]string{
+			"EXT-X-TARGETDURATION":         fmt.Sprint(mpl.TargetDuration),
+			"EXT-X-VERSION":                fmt.Sprint(mpl.Version()),
+			"EXT-X-PLAYLIST-TYPE":          fmt.Sprint(mpl.MediaType),
+			"EXT-X-ENDLIST":                fmt.Sprint(mpl.Closed),
+			"EXT-X-DISCONTINUITY-SEQUENCE": fmt.Sprint(mpl.DiscontinuitySeq),
+			"EXT-X-I-FRAMES-ONLY":          fmt.Sprint(mpl.Iframe),
+			"EXT-X-START":                  fmt.Sprint(mpl.StartTime, mpl.StartTimePrecise),
+		},
+		segments: map[uint64]segmentSignature{},
+		first:    mpl.SeqNo,
+		last:     mpl.SeqNo,
+	}
+	if mpl.Map != nil {
+		ps.tags["EXT-X-MAP"] = fmt.Sprint(mpl.Map.URI, mpl.Map.Offset, mpl.Map.Limit)
+	}
+	if mpl.Key != nil {
+		ps.tags["EXT-X-KEY"] = fmt.Sprint(mpl.Key.Method, mpl.Key.URI)
+	}
+	for i, s := range mpl.Segments {
+		if s == nil {
+			continue
+		}
+		ps.last = mpl.SeqNo + uint64(i)
+		ps.segments[ps.last] = segmentSignature{
+			URI:           s.URI,
+			Duration:      s.Duration,
+			Limit:         s.Limit,
+			Offset:        s.Offset,
+			Discontinuity: s.Discontinuity,
+		}
+	}
+	return ps
+}
+
+// PlaylistHistory diffs consecutive snapshots of a live playlist to report
+// how it evolves: tag changes, segment churn and, most importantly, segments
+// that were rewritten after they had been published. Summaries made while
+// the playlist is still being fetched take a Snapshot of it.
+type PlaylistHistory struct {
+	mu sync.Mutex
+
+	Snapshots       int            `json:"snapshots"`
+	Unchanged       int            `json:"unchanged"`
+	SegmentsAdded   int            `json:"segments_added"`
+	SegmentsRemoved int            `json:"segments_removed"`
+	Rewrites        int            `json:"rewrites"`
+	TagChanges      map[string]int `json:"tag_changes"`
+	// The media sequence going back, skipping segments that were never
+	// listed, or renumbering segments are what make players reset.
+	SequenceRegressions int         `json:"sequence_regressions"`
+	SequenceJumps       int         `json:"sequence_jumps"`
+	SequenceDuplicates  int         `json:"sequence_duplicates"`
+	Window              WindowStats `json:"window"`
+
+	last *playlistSnapshot
+}
+
+func NewPlaylistHistory() *PlaylistHistory {
+	return &PlaylistHistory{
+		TagChanges: map[string]int{},
+	}
+}
+
+// Observe diffs a snapshot with the one before it, returning how it broke
+// the continuity of the media sequence, if it did.
+func (ph *PlaylistHistory) Observe(at time.Time, mpl *m3u8.MediaPlaylist) []string {
+	current := newPlaylistSnapshot(at, mpl)
+	ph.mu.Lock()
+	defer ph.mu.Unlock()
+	previous := ph.last
+	ph.last = current
+	ph.Snapshots++
+	ph.Window.observe(mpl, previous == nil)
+	if previous == nil {
+		return nil
+	}
+	integrity := ph.checkSequence(previous, current)
+
+	changed := false
+	for tag, value := range current.tags {
+		if old, ok := previous.tags[tag]; !ok || old != value {
+			ph.TagChanges[tag]++
+			changed = true
+			lvl := log.InfoLevel
+			if tag == "EXT-X-TARGETDURATION" {
+				lvl = log.WarnLevel
+			}
+			log.WithField("Old", old).WithField("New", value).Logf(lvl, "Playlist tag %v changed", tag)
+		}
+	}
+	for tag, old := range previous.tags {
+		if _, ok := current.tags[tag]; !ok {
+			ph.TagChanges[tag]++
+			changed = true
+			log.WithField("Old", old).Infof("Playlist tag %v removed", tag)
+		}
+	}
+
+	added, removed := 0, 0
+	for seq, sig := range current.segments {
+		old, ok := previous.segments[seq]
+		if !ok {
+			added++
+			continue
+		}
+		if old != sig {
+			ph.Rewrites++
+			log.WithField("MediaSequence", seq).
+				WithField("Old", old).
+				WithField("New", sig).
+				Warn("Published segment was rewritten")
+		}
+	}
+	for seq := range previous.segments {
+		if _, ok := current.segments[seq]; !ok {
+			removed++
+		}
+	}
+	ph.SegmentsAdded += added
+	ph.SegmentsRemoved += removed
+
+	if !changed && added == 0 && removed == 0 {
+		ph.Unchanged++
+		return integrity
+	}
+	log.WithField("Added", added).
+		WithField("Removed", removed).
+		WithField("Since", current.at.Sub(previous.at)).
+		Debug("Playlist changed")
+	return integrity
+}
+
+func (ph *PlaylistHistory) checkSequence(previous, current *playlistSnapshot) []string {
+	var problems []string
+	switch {
+	case current.first < previous.first:
+		ph.SequenceRegressions++
+		problems = append(problems, fmt.Sprintf("media sequence went back from %d to %d", previous.first, current.first))
+	case current.first > previous.last+1:
+		ph.SequenceJumps++
+		problems = append(problems, fmt.Sprintf("media sequence jumped from %d to %d, skipping %d segments", previous.last, current.first, current.first-previous.last-1))
+	}
+	sequences := map[string]uint64{}
+	for seq, sig := range previous.segments {
+		sequences[sig.URI] = seq
+	}
+	for seq, sig := range current.segments {
+		if old, ok := sequences[sig.URI]; ok && old != seq {
+			ph.SequenceDuplicates++
+			problems = append(problems, fmt.Sprintf("segment %v was media sequence %d and is now %d", sig.URI, old, seq))
+		}
+	}
+	for _, problem := range problems {
+		log.Warnf("Playlist broke its media sequence: %v", problem)
+	}
+	return problems
+}
+
+// Snapshot returns a copy of the history so far, safe to read while the
+// playlist is still being observed.
+func (ph *PlaylistHistory) Snapshot() *PlaylistHistory {
+	ph.mu.Lock()
+	defer ph.mu.Unlock()
+	snapshot := &PlaylistHistory{
+		Snapshots:           ph.Snapshots,
+		Unchanged:           ph.Unchanged,
+		SegmentsAdded:       ph.SegmentsAdded,
+		SegmentsRemoved:     ph.SegmentsRemoved,
+		Rewrites:            ph.Rewrites,
+		TagChanges:          make(map[string]int, len(ph.TagChanges)),
+		SequenceRegressions: ph.SequenceRegressions,
+		SequenceJumps:       ph.SequenceJumps,
+		SequenceDuplicates:  ph.SequenceDuplicates,
+		Window:              ph.Window,
+	}
+	for tag, n := range ph.TagChanges {
+		snapshot.TagChanges[tag] = n
+	}
+	return snapshot
+}
+
+func (ph *PlaylistHistory) LogSummary() {
+	if ph.Snapshots < 2 {
+		return
+	}
+	lvl := log.InfoLevel
+	integrity := ph.SequenceRegressions + ph.SequenceJumps + ph.SequenceDuplicates
+	anomalies := ph.Window.Shrinks + ph.Window.Growths
+	if ph.Rewrites > 0 || integrity > 0 || anomalies > 0 || ph.TagChanges["EXT-X-TARGETDURATION"] > 0 {
+		lvl = log.WarnLevel
+	}
+	log.WithField("Snapshots", ph.Snapshots).
+		WithField("Unchanged", ph.Unchanged).
+		WithField("SegmentsAdded", ph.SegmentsAdded).
+		WithField("SegmentsRemoved", ph.SegmentsRemoved).
+		WithField("Rewrites", ph.Rewrites).
+		WithField("SequenceRegressions", ph.SequenceRegressions).
+		WithField("SequenceJumps", ph.SequenceJumps).
+		WithField("SequenceDuplicates", ph.SequenceDuplicates).
+		WithField("WindowMin", ph.Window.Min).
+		WithField("WindowMax", ph.Window.Max).
+		WithField("WindowShrinks", ph.Window.Shrinks).
+		WithField("WindowGrowths", ph.Window.Growths).
+		WithField("TagChanges", ph.TagChanges).
+		Log(lvl, "Playlist evolution")
+}
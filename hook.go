@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"runtime"
+	"sort"
+	"sync"
+
+	"github.com/digitaljanitors/go-httpstat"
+	log "github.com/sirupsen/logrus"
+)
+
+var hookCommand = flag.String("hook", "", "shell command started once and consulted for every request and response over JSON lines on its stdin/stdout")
+
+// HookMessage is written to the hook, one JSON object per line. Phase is
+// "request", sent before the request goes out, or "response", sent once the
+// body has been read.
+type HookMessage struct {
+	Phase      string      `json:"phase"`
+	Method     string      `json:"method"`
+	URL        string      `json:"url"`
+	Header     http.Header `json:"header"`
+	StatusCode int         `json:"status_code,omitempty"`
+	Response   http.Header `json:"response_header,omitempty"`
+	Bytes      int64       `json:"bytes,omitempty"`
+	Timings    *Timings    `json:"timings,omitempty"`
+}
+
+// HookReply is the single line the hook must answer every message with; an
+// empty object changes nothing. URL and header changes only apply to the
+// request phase.
+type HookReply struct {
+	URL       string             `json:"url,omitempty"`
+	SetHeader map[string]string  `json:"set_header,omitempty"`
+	DelHeader []string           `json:"del_header,omitempty"`
+	Metrics   map[string]float64 `json:"metrics,omitempty"`
+}
+
+type HookMetric struct {
+	Count int
+	Sum   float64
+	Min   float64
+	Max   float64
+}
+
+// HookMetrics aggregates the metrics the hook replied with for the requests
+// of a run.
+type HookMetrics struct {
+	mu      sync.Mutex
+	metrics map[string]*HookMetric
+}
+
+func NewHookMetrics() *HookMetrics {
+	return &HookMetrics{metrics: map[string]*HookMetric{}}
+}
+
+type hookMetricsKey struct{}
+
+// withHookMetrics makes the metrics of the hook's replies about req, and its
+// response, count towards hm.
+func withHookMetrics(req *http.Request, hm *HookMetrics) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), hookMetricsKey{}, hm))
+}
+
+// add counts the metrics of a reply towards the run's. hm is nil for requests
+// not made by a run, such as those of purge or split.
+func (hm *HookMetrics) add(values map[string]float64) {
+	if hm == nil || len(values) == 0 {
+		return
+	}
+	hm.mu.Lock()
+	defer hm.mu.Unlock()
+
+	for name, value := range values {
+		m, ok := hm.metrics[name]
+		if !ok {
+			m = &HookMetric{Min: value, Max: value}
+			hm.metrics[name] = m
+		}
+		m.Count++
+		m.Sum += value
+		if value < m.Min {
+			m.Min = value
+		}
+		if value > m.Max {
+			m.Max = value
+		}
+	}
+}
+
+func hookMetricsOf(ctx context.Context) *HookMetrics {
+	hm, _ := ctx.Value(hookMetricsKey{}).(*HookMetrics)
+	return hm
+}
+
+type Hook struct {
+	mu     sync.Mutex
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *bufio.Reader
+}
+
+var hook *Hook
+
+func StartHook(command string) (*Hook, error) {
+	shell, arg := "sh", "-c"
+	if runtime.GOOS == "windows" {
+		shell, arg = "cmd", "/C"
+	}
+	cmd := exec.Command(shell, arg, command)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return &Hook{
+		cmd:    cmd,
+		stdin:  stdin,
+		stdout: bufio.NewReader(stdout),
+	}, nil
+}
+
+// call sends msg to the hook and returns its reply, counting its metrics
+// towards those of the run of ctx.
+func (h *Hook) call(ctx context.Context, msg HookMessage) (HookReply, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var reply HookReply
+	line, err := json.Marshal(msg)
+	if err != nil {
+		return reply, err
+	}
+	if _, err := h.stdin.Write(append(line, '\n')); err != nil {
+		return reply, fmt.Errorf("hook failed: %v", err)
+	}
+	answer, err := h.stdout.ReadBytes('\n')
+	if err != nil {
+		return reply, fmt.Errorf("hook failed: %v", err)
+	}
+	if err := json.Unmarshal(answer, &reply); err != nil {
+		return reply, fmt.Errorf("hook replied with invalid JSON: %v", err)
+	}
+	hookMetricsOf(ctx).add(reply.Metrics)
+	return reply, nil
+}
+
+// Request lets the hook change req before it goes out. An error means the
+// hook can't be consulted, and the request is to fail.
+func (h *Hook) Request(req *http.Request) error {
+	if h == nil {
+		return nil
+	}
+	reply, err := h.call(req.Context(), HookMessage{
+		Phase:  "request",
+		Method: req.Method,
+		URL:    req.URL.String(),
+		Header: req.Header,
+	})
+	if err != nil {
+		return err
+	}
+	if reply.URL != "" {
+		u, err := url.Parse(reply.URL)
+		if err != nil {
+			log.Warnf("Hook returned an invalid URL: %v", err)
+		} else {
+			req.URL = u
+			req.Host = ""
+		}
+	}
+	for _, name := range reply.DelHeader {
+		req.Header.Del(name)
+	}
+	for name, value := range reply.SetHeader {
+		req.Header.Set(name, value)
+	}
+	return nil
+}
+
+// Response tells the hook about resp, of which n bytes were read. An error
+// means the hook can't be consulted, and the request is to fail.
+func (h *Hook) Response(resp *http.Response, n int64, stats *httpstat.Result) error {
+	if h == nil {
+		return nil
+	}
+	_, err := h.call(resp.Request.Context(), HookMessage{
+		Phase:      "response",
+		Method:     resp.Request.Method,
+		URL:        resp.Request.URL.String(),
+		Header:     resp.Request.Header,
+		StatusCode: resp.StatusCode,
+		Response:   resp.Header,
+		Bytes:      n,
+		Timings:    NewTimings(stats),
+	})
+	return err
+}
+
+func (hm *HookMetrics) Metrics() map[string]HookMetric {
+	if hm == nil {
+		return nil
+	}
+	hm.mu.Lock()
+	defer hm.mu.Unlock()
+
+	if len(hm.metrics) == 0 {
+		return nil
+	}
+	metrics := map[string]HookMetric{}
+	for name, m := range hm.metrics {
+		metrics[name] = *m
+	}
+	return metrics
+}
+
+func (hm *HookMetrics) LogSummary() {
+	if hm == nil {
+		return
+	}
+	hm.mu.Lock()
+	defer hm.mu.Unlock()
+
+	names := make([]string, 0, len(hm.metrics))
+	for name := range hm.metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		m := hm.metrics[name]
+		log.WithField("Count", m.Count).
+			WithField("Min", m.Min).
+			WithField("Max", m.Max).
+			WithField("Average", m.Sum/float64(m.Count)).
+			Infof("Hook metric %v", name)
+	}
+}
+
+func (h *Hook) Close() error {
+	if h == nil {
+		return nil
+	}
+	h.stdin.Close()
+	return h.cmd.Wait()
+}
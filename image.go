@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"strings"
+)
+
+var thumbnails = flag.Bool("thumbnails", false, "also fetch the thumbnails of the first EXT-X-IMAGE-STREAM-INF of a master playlist, as used for scrubbing previews")
+
+// parseAttributes parses the attribute list of a tag, whose quoted values may
+// contain commas.
+func parseAttributes(list string) map[string]string {
+	attrs := map[string]string{}
+	for list != "" {
+		eq := strings.IndexByte(list, '=')
+		if eq < 0 {
+			break
+		}
+		name := strings.TrimSpace(list[:eq])
+		list = list[eq+1:]
+		var value string
+		if strings.HasPrefix(list, `"`) {
+			end := strings.IndexByte(list[1:], '"')
+			if end < 0 {
+				end = len(list) - 1
+			}
+			value = list[1 : end+1]
+			list = list[end+1:]
+			if strings.HasPrefix(list, `"`) {
+				list = list[1:]
+			}
+		} else {
+			end := strings.IndexByte(list, ',')
+			if end < 0 {
+				end = len(list)
+			}
+			value = list[:end]
+			list = list[end:]
+		}
+		attrs[name] = value
+		list = strings.TrimPrefix(list, ",")
+	}
+	return attrs
+}
+
+// imageStreams returns the attributes of every EXT-X-IMAGE-STREAM-INF of a
+// master playlist. They aren't part of the HLS spec but Roku and several
+// packagers use them for thumbnail playlists, so the decoder drops them.
+func imageStreams(body []byte) []map[string]string {
+	var streams []map[string]string
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "#EXT-X-IMAGE-STREAM-INF:") {
+			streams = append(streams, parseAttributes(strings.TrimPrefix(line, "#EXT-X-IMAGE-STREAM-INF:")))
+		}
+	}
+	return streams
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/digitaljanitors/go-httpstat"
+	"github.com/grafov/m3u8"
+	log "github.com/sirupsen/logrus"
+)
+
+var fetchInterstitials = flag.Bool("interstitials", false, "also fetch the assets of HLS interstitials, reporting them separately as the INTERSTITIAL rendition")
+
+const (
+	// interstitialClass is the CLASS of EXT-X-DATERANGE interstitials.
+	interstitialClass = "com.apple.hls.interstitial"
+
+	// RenditionInterstitial is the Rendition of interstitial assets.
+	RenditionInterstitial = "INTERSTITIAL"
+)
+
+// Interstitial is an EXT-X-DATERANGE scheduling an interstitial, with either
+// an X-ASSET-URI or an X-ASSET-LIST.
+type Interstitial struct {
+	ID        string `json:"id"`
+	StartDate string `json:"start_date"`
+	AssetURI  string `json:"asset_uri,omitempty"`
+	AssetList string `json:"asset_list,omitempty"`
+}
+
+// interstitials returns the interstitials of a media playlist, which the
+// decoder doesn't parse.
+func interstitials(body []byte) []*Interstitial {
+	var found []*Interstitial
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(line, "#EXT-X-DATERANGE:") {
+			continue
+		}
+		attrs := parseAttributes(strings.TrimPrefix(line, "#EXT-X-DATERANGE:"))
+		if attrs["CLASS"] != interstitialClass {
+			continue
+		}
+		found = append(found, &Interstitial{
+			ID:        attrs["ID"],
+			StartDate: attrs["START-DATE"],
+			AssetURI:  attrs["X-ASSET-URI"],
+			AssetList: attrs["X-ASSET-LIST"],
+		})
+	}
+	return found
+}
+
+// InterstitialTracker notes the interstitials seen in a playlist, as a live
+// playlist carries them over several refreshes.
+type InterstitialTracker struct {
+	mu   sync.Mutex
+	Seen []*Interstitial
+	ids  map[string]bool
+}
+
+func NewInterstitialTracker() *InterstitialTracker {
+	return &InterstitialTracker{ids: map[string]bool{}}
+}
+
+// Observe returns the interstitials of body not seen before.
+func (it *InterstitialTracker) Observe(body []byte) []*Interstitial {
+	it.mu.Lock()
+	defer it.mu.Unlock()
+	var added []*Interstitial
+	for _, i := range interstitials(body) {
+		if it.ids[i.ID] {
+			continue
+		}
+		it.ids[i.ID] = true
+		it.Seen = append(it.Seen, i)
+		added = append(added, i)
+	}
+	return added
+}
+
+// Report returns nil if the playlist had no interstitials.
+func (it *InterstitialTracker) Report() []*Interstitial {
+	if it == nil {
+		return nil
+	}
+	it.mu.Lock()
+	defer it.mu.Unlock()
+	return append([]*Interstitial(nil), it.Seen...)
+}
+
+// assetList is the JSON document an X-ASSET-LIST points to.
+type assetList struct {
+	Assets []struct {
+		URI      string  `json:"URI"`
+		Duration float64 `json:"DURATION"`
+	} `json:"ASSETS"`
+}
+
+// fetchAssetList resolves an X-ASSET-LIST to the URIs of its assets.
+func fetchAssetList(run *Run, uri string) []string {
+	stats := &httpstat.Result{}
+	req, err := newRequest("GET", uri, stats)
+	if err != nil {
+		log.Warn(err)
+		return nil
+	}
+	download := &SegmentDownload{URI: uri, Rendition: RenditionInterstitial}
+	requestedAt := time.Now()
+	resp, err := run.do(req)
+	result := NewRequestResult(KindAssetList, download, req, requestedAt)
+	if err != nil {
+		logFailedRequest(req, err)
+		run.failed(result, nil, err)
+		return nil
+	}
+	if !isSuccess(resp) {
+		reason := logFailedResponse(resp, "Recieved HTTP %v for asset list %v\n", resp.StatusCode, uri)
+		resp.Body.Close()
+		run.fail(result, resp, categorizeStatus(resp.StatusCode), reason)
+		return nil
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		logFailedHeaders(resp, "Failed reading asset list %v: %v\n", uri, err)
+		run.failed(result, resp, err)
+		return nil
+	}
+	stats.End(time.Now())
+	if err := hook.Response(resp, int64(len(body)), stats); err != nil {
+		run.failed(result, resp, err)
+		return nil
+	}
+	run.succeeded(result, resp, int64(len(body)), stats)
+	var list assetList
+	if err := json.Unmarshal(body, &list); err != nil {
+		log.Warnf("Asset list %v: %v", uri, err)
+		run.Errors.Record(ErrValidation, "asset list: "+err.Error())
+		return nil
+	}
+	var uris []string
+	for _, asset := range list.Assets {
+		uris = append(uris, asset.URI)
+	}
+	return uris
+}
+
+// fetchAsset queues the segments of an interstitial asset, which is either a
+// media playlist or a master playlist whose first variant is played.
+func fetchAsset(run *Run, base *url.URL, uri string, dlc chan *SegmentDownload) {
+	urlStr, assetBase, err := resolvePlaylist(base, uri)
+	if err != nil {
+		log.Warn(err)
+		return
+	}
+	playlist, err := fetchPlaylist(run, urlStr, RenditionInterstitial)
+	if playlist != nil && playlist.Type == m3u8.MASTER {
+		variant, verr := selectVariant(playlist.Playlist.(*m3u8.MasterPlaylist))
+		if verr != nil {
+			log.Warnf("Interstitial asset %v: %v", urlStr, verr)
+			return
+		}
+		if urlStr, assetBase, err = resolvePlaylist(assetBase, variant.URI); err != nil {
+			log.Warn(err)
+			return
+		}
+		playlist, err = fetchPlaylist(run, urlStr, RenditionInterstitial)
+	}
+	if err != nil || playlist == nil || playlist.Type != m3u8.MEDIA {
+		log.Warnf("Not fetching the segments of interstitial asset %v", urlStr)
+		return
+	}
+	initSegment, segments, err := mediaSegments(assetBase, playlist.Playlist.(*m3u8.MediaPlaylist), RenditionInterstitial, false)
+	if err != nil {
+		log.Warn(err)
+		return
+	}
+	if initSegment != nil {
+		dlc <- initSegment
+	}
+	for _, segment := range segments {
+		dlc <- segment
+	}
+}
+
+// observeInterstitials logs the interstitials newly scheduled in a media
+// playlist and, with -interstitials, queues the segments of their assets.
+func observeInterstitials(run *Run, base *url.URL, body []byte, dlc chan *SegmentDownload) {
+	for _, i := range run.Interstitials.Observe(body) {
+		log.WithField("ID", i.ID).
+			WithField("StartDate", i.StartDate).
+			WithField("AssetURI", i.AssetURI).
+			WithField("AssetList", i.AssetList).
+			Info("Interstitial scheduled")
+		if !*fetchInterstitials {
+			continue
+		}
+		uris := []string{i.AssetURI}
+		if i.AssetList != "" {
+			listURI, err := translateURI(base, i.AssetList)
+			if err != nil {
+				log.Warn(err)
+				continue
+			}
+			uris = fetchAssetList(run, listURI)
+		}
+		for _, uri := range uris {
+			if uri != "" {
+				fetchAsset(run, base, uri, dlc)
+			}
+		}
+	}
+}
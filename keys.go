@@ -0,0 +1,325 @@
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/digitaljanitors/go-httpstat"
+	"github.com/grafov/m3u8"
+	log "github.com/sirupsen/logrus"
+)
+
+// KeyStats is what was seen of one EXT-X-KEY.
+type KeyStats struct {
+	URI       string    `json:"uri"`
+	Method    string    `json:"method"`
+	Format    string    `json:"format,omitempty"`
+	FirstSeen time.Time `json:"first_seen"`
+	// FirstSequence is the media sequence of the first segment it applies to.
+	FirstSequence uint64 `json:"first_sequence"`
+	// Segments and MediaSeconds are how much of the stream it encrypted.
+	Segments     int     `json:"segments"`
+	MediaSeconds float64 `json:"media_seconds"`
+}
+
+// KeyTracker follows the keys a stream is encrypted with, to report how
+// often they rotate and how quickly the key server hands them out. Key
+// fetches and DRM license probes are timed separately from media.
+type KeyTracker struct {
+	mu              sync.Mutex
+	Keys            []*KeyStats
+	Fetches         []time.Duration
+	Failures        int
+	Licenses        []time.Duration
+	LicenseFailures int
+	// Methods counts segments by encryption method.
+	Methods     map[string]int
+	Transitions []EncryptionTransition
+
+	byURI      map[string]*KeyStats
+	lastSeq    uint64
+	lastMethod string
+	started    bool
+}
+
+func NewKeyTracker() *KeyTracker {
+	return &KeyTracker{
+		byURI:   map[string]*KeyStats{},
+		Methods: map[string]int{},
+	}
+}
+
+// EncryptionTransition is a change of encryption method between consecutive
+// segments.
+type EncryptionTransition struct {
+	Sequence uint64    `json:"sequence"`
+	From     string    `json:"from"`
+	To       string    `json:"to"`
+	At       time.Time `json:"at"`
+}
+
+// encryptionMethod is the METHOD of key, NONE if there isn't one.
+func encryptionMethod(key *m3u8.Key) string {
+	if key == nil || key.Method == "" {
+		return "NONE"
+	}
+	return key.Method
+}
+
+// segmentKeys returns the key in effect for every segment of mpl; an
+// EXT-X-KEY applies to every segment after it until the next one.
+func segmentKeys(mpl *m3u8.MediaPlaylist) []*m3u8.Key {
+	keys := make([]*m3u8.Key, len(mpl.Segments))
+	current := mpl.Key
+	for i, s := range mpl.Segments {
+		if s == nil {
+			continue
+		}
+		if s.Key != nil {
+			current = s.Key
+		}
+		keys[i] = current
+	}
+	return keys
+}
+
+// ObservePlaylist notes the keys and encryption methods of segments not seen
+// in earlier snapshots and returns the keys that are new.
+func (kt *KeyTracker) ObservePlaylist(at time.Time, mpl *m3u8.MediaPlaylist) []*KeyStats {
+	kt.mu.Lock()
+	defer kt.mu.Unlock()
+
+	var added []*KeyStats
+	for i, key := range segmentKeys(mpl) {
+		s := mpl.Segments[i]
+		seq := mpl.SeqNo + uint64(i)
+		if s == nil || (kt.started && seq <= kt.lastSeq) {
+			continue
+		}
+		method := encryptionMethod(key)
+		if kt.started && method != kt.lastMethod {
+			kt.Transitions = append(kt.Transitions, EncryptionTransition{seq, kt.lastMethod, method, at})
+			log.WithField("MediaSequence", seq).
+				WithField("From", kt.lastMethod).
+				WithField("To", method).
+				Warn("Encryption method changed")
+		}
+		kt.started = true
+		kt.lastSeq = seq
+		kt.lastMethod = method
+		kt.Methods[method]++
+		if method == "NONE" {
+			continue
+		}
+		ks, ok := kt.byURI[key.URI]
+		if !ok {
+			ks = &KeyStats{URI: key.URI, Method: key.Method, Format: key.Keyformat, FirstSeen: at, FirstSequence: seq}
+			kt.byURI[key.URI] = ks
+			kt.Keys = append(kt.Keys, ks)
+			added = append(added, ks)
+		}
+		ks.Segments++
+		ks.MediaSeconds += s.Duration
+	}
+	return added
+}
+
+func (kt *KeyTracker) Fetched(d time.Duration) {
+	kt.mu.Lock()
+	defer kt.mu.Unlock()
+	kt.Fetches = append(kt.Fetches, d)
+}
+
+func (kt *KeyTracker) Failed() {
+	kt.mu.Lock()
+	defer kt.mu.Unlock()
+	kt.Failures++
+}
+
+func (kt *KeyTracker) Licensed(d time.Duration) {
+	kt.mu.Lock()
+	defer kt.mu.Unlock()
+	kt.Licenses = append(kt.Licenses, d)
+}
+
+func (kt *KeyTracker) LicenseFailed() {
+	kt.mu.Lock()
+	defer kt.mu.Unlock()
+	kt.LicenseFailures++
+}
+
+// KeyReport summarizes a KeyTracker.
+type KeyReport struct {
+	Keys      int `json:"keys"`
+	Rotations int `json:"rotations"`
+	// SegmentsPerKey and MediaSecondsPerKey average over every key but the
+	// last, which may still be in use.
+	SegmentsPerKey     float64 `json:"segments_per_key,omitempty"`
+	MediaSecondsPerKey float64 `json:"media_seconds_per_key,omitempty"`
+	// RotationInterval is the average wall clock time between new keys
+	// appearing in a live playlist.
+	RotationInterval time.Duration            `json:"rotation_interval,omitempty"`
+	Fetches          int                      `json:"fetches"`
+	Failures         int                      `json:"failures"`
+	Latency          map[string]time.Duration `json:"latency,omitempty"`
+	// License* are of the -license-url probes made for DRM keys.
+	Licenses        int                      `json:"licenses,omitempty"`
+	LicenseFailures int                      `json:"license_failures,omitempty"`
+	LicenseLatency  map[string]time.Duration `json:"license_latency,omitempty"`
+
+	Methods     map[string]int         `json:"methods"`
+	Transitions []EncryptionTransition `json:"transitions,omitempty"`
+}
+
+// latencyPercentiles summarizes request times for reports.
+func latencyPercentiles(times []time.Duration) map[string]time.Duration {
+	if len(times) == 0 {
+		return nil
+	}
+	sorted := append([]time.Duration(nil), times...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return map[string]time.Duration{
+		"p50": durationPercentile(sorted, 50),
+		"p90": durationPercentile(sorted, 90),
+		"p99": durationPercentile(sorted, 99),
+		"max": sorted[len(sorted)-1],
+	}
+}
+
+func (kt *KeyTracker) Report() *KeyReport {
+	if kt == nil {
+		return nil
+	}
+	kt.mu.Lock()
+	defer kt.mu.Unlock()
+
+	r := &KeyReport{
+		Keys:            len(kt.Keys),
+		Fetches:         len(kt.Fetches),
+		Failures:        kt.Failures,
+		Latency:         latencyPercentiles(kt.Fetches),
+		Licenses:        len(kt.Licenses),
+		LicenseFailures: kt.LicenseFailures,
+		LicenseLatency:  latencyPercentiles(kt.Licenses),
+		Methods:         map[string]int{},
+		Transitions:     kt.Transitions,
+	}
+	for method, n := range kt.Methods {
+		r.Methods[method] = n
+	}
+	if r.Keys == 0 {
+		return r
+	}
+	r.Rotations = r.Keys - 1
+	if r.Rotations > 0 {
+		segments, seconds := 0, 0.0
+		for _, ks := range kt.Keys[:r.Rotations] {
+			segments += ks.Segments
+			seconds += ks.MediaSeconds
+		}
+		r.SegmentsPerKey = float64(segments) / float64(r.Rotations)
+		r.MediaSecondsPerKey = seconds / float64(r.Rotations)
+		r.RotationInterval = kt.Keys[r.Rotations].FirstSeen.Sub(kt.Keys[0].FirstSeen) / time.Duration(r.Rotations)
+	}
+	return r
+}
+
+func (kt *KeyTracker) LogSummary() {
+	r := kt.Report()
+	if r == nil {
+		return
+	}
+	if len(r.Methods) > 0 {
+		log.WithField("Segments", r.Methods).
+			WithField("Transitions", len(r.Transitions)).
+			Info("Encryption methods")
+	}
+	if r.Keys == 0 {
+		return
+	}
+	entry := log.WithField("Keys", r.Keys).
+		WithField("Rotations", r.Rotations).
+		WithField("Fetches", r.Fetches).
+		WithField("Failures", r.Failures)
+	if r.Rotations > 0 {
+		entry = entry.WithField("SegmentsPerKey", r.SegmentsPerKey).
+			WithField("MediaSecondsPerKey", r.MediaSecondsPerKey).
+			WithField("RotationInterval", r.RotationInterval)
+	}
+	for name, d := range r.Latency {
+		entry = entry.WithField("Latency"+name, d)
+	}
+	if r.Licenses+r.LicenseFailures > 0 {
+		entry = entry.WithField("Licenses", r.Licenses).WithField("LicenseFailures", r.LicenseFailures)
+		for name, d := range r.LicenseLatency {
+			entry = entry.WithField("LicenseLatency"+name, d)
+		}
+	}
+	entry.Info("Encryption keys")
+}
+
+// fetchKey downloads a newly seen key the way a player would before
+// decrypting the segments that use it, or probes the license server of a DRM
+// key.
+func fetchKey(run *Run, playlistURL *url.URL, ks *KeyStats) {
+	uri, err := translateURI(playlistURL, ks.URI)
+	if err != nil {
+		log.Warn(err)
+		return
+	}
+	if isDRMKey(ks) {
+		probeLicense(run, uri, ks)
+		return
+	}
+	if u, err := url.Parse(uri); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		log.WithField("Method", ks.Method).Infof("Not fetching key %v", ks.URI)
+		return
+	}
+	stats := &httpstat.Result{}
+	req, err := newRequest("GET", uri, stats)
+	if err != nil {
+		log.Warn(err)
+		return
+	}
+	setPriority(req, urgencyPlaylist)
+	download := &SegmentDownload{URI: uri}
+	requestedAt := time.Now()
+	resp, err := run.do(req)
+	result := NewRequestResult(KindKey, download, req, requestedAt)
+	if err != nil {
+		logFailedRequest(req, err)
+		run.Keys.Failed()
+		run.failed(result, nil, err)
+		return
+	}
+	if !isSuccess(resp) {
+		reason := logFailedResponse(resp, "Recieved HTTP %v for key %v\n", resp.StatusCode, uri)
+		resp.Body.Close()
+		run.Keys.Failed()
+		run.fail(result, resp, categorizeStatus(resp.StatusCode), reason)
+		return
+	}
+	n, err := io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		logFailedHeaders(resp, "Failed reading key %v: %v\n", uri, err)
+		run.Keys.Failed()
+		run.failed(result, resp, err)
+		return
+	}
+	stats.End(time.Now())
+	if err := hook.Response(resp, n, stats); err != nil {
+		run.Keys.Failed()
+		run.failed(result, resp, err)
+		return
+	}
+	run.Keys.Fetched(stats.Total)
+	run.succeeded(result, resp, n, stats)
+	log.WithFields(stats.Fields()).
+		WithFields(traceFields(resp.Request)).
+		Infof("Downloaded key %v", uri)
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/digitaljanitors/go-httpstat"
+	"github.com/grafov/m3u8"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	wholeLadder  = flag.Bool("whole-ladder", false, "benchmark every variant of the master playlist at once, each in a run of its own, comparing them to characterize the whole bitrate ladder")
+	ladderReport = flag.String("ladder-report", "", "also write the comparison of the variants of -whole-ladder as JSON to this `file`")
+)
+
+// LadderRung is how the run of one variant of -whole-ladder went. Headroom
+// is its throughput over its BANDWIDTH, below 1 when it can't be streamed
+// in real time.
+type LadderRung struct {
+	Bandwidth  uint32                   `json:"bandwidth"`
+	Resolution string                   `json:"resolution,omitempty"`
+	URI        string                   `json:"uri"`
+	RunID      string                   `json:"run_id"`
+	Segments   int                      `json:"segments"`
+	Errors     int                      `json:"errors"`
+	Late       int                      `json:"late"`
+	Latency    map[string]time.Duration `json:"latency,omitempty"`
+	Mbps       float64                  `json:"mbps"`
+	Headroom   float64                  `json:"headroom"`
+	Error      string                   `json:"error,omitempty"`
+}
+
+// LadderReport compares the variants of a master playlist, by bandwidth.
+type LadderReport struct {
+	// ID names the directory the shared -output files are uploaded to.
+	ID          string        `json:"id"`
+	PlaylistURL string        `json:"playlist_url"`
+	Rungs       []*LadderRung `json:"rungs"`
+}
+
+// sharedSink reports several runs to the same sinks, which are closed once
+// all of them are over rather than by each.
+type sharedSink struct {
+	OutputSink
+}
+
+func (sharedSink) Close() error { return nil }
+
+// ladderVariants returns the variants of the master playlist at
+// playlistURL, leaving out I-frame playlists.
+func ladderVariants(playlistURL string) ([]*m3u8.Variant, error) {
+	req, err := newRequest("GET", playlistURL, &httpstat.Result{})
+	if err != nil {
+		return nil, err
+	}
+	resp, err := doRequest(client, randomHex(16), req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if !isSuccess(resp) {
+		return nil, fmt.Errorf("HTTP %v for %v", resp.StatusCode, playlistURL)
+	}
+	playlist, listType, err := m3u8.DecodeFrom(resp.Body, parseMode != "lenient")
+	if err != nil {
+		return nil, err
+	}
+	if listType != m3u8.MASTER {
+		return nil, errors.New("-whole-ladder needs a master playlist")
+	}
+	var variants []*m3u8.Variant
+	seen := map[string]bool{}
+	for _, v := range playlist.(*m3u8.MasterPlaylist).Variants {
+		if v == nil || v.Iframe || seen[v.URI] {
+			continue
+		}
+		seen[v.URI] = true
+		variants = append(variants, v)
+	}
+	if len(variants) == 0 {
+		return nil, errors.New("master playlist has no variants")
+	}
+	return variants, nil
+}
+
+// runLadder benchmarks every variant of the master playlist at playlistURL
+// at once with -whole-ladder, returning nil otherwise. Every run logs its
+// own summary, and they all report to the other -output sinks. The runs stop
+// once ctx is done.
+func runLadder(ctx context.Context, playlistURL string) (*LadderReport, error) {
+	if !*wholeLadder {
+		return nil, nil
+	}
+	// The runs of the variants are made at once, and these are of a single
+	// run.
+	for name, value := range map[string]string{"checkpoint": *checkpointFile, "control": *controlSocket} {
+		if value != "" {
+			return nil, fmt.Errorf("-%v can't be used with -whole-ladder", name)
+		}
+	}
+	variants, err := ladderVariants(playlistURL)
+	if err != nil {
+		return nil, err
+	}
+	var specs []string
+	for _, spec := range outputs {
+		if spec != "console" {
+			specs = append(specs, spec)
+		}
+	}
+	var shared OutputSink
+	var extra []OutputSink
+	if len(specs) > 0 {
+		if shared, err = NewOutputSink(specs); err != nil {
+			return nil, err
+		}
+		extra = append(extra, sharedSink{shared})
+	}
+
+	report := &LadderReport{ID: newULID(time.Now()), PlaylistURL: playlistURL}
+	var wg sync.WaitGroup
+	for _, v := range variants {
+		run, err := newRun(ctx, playlistURL, nil, extra...)
+		if err != nil {
+			return nil, err
+		}
+		run.Control.Select(v.URI)
+		rung := &LadderRung{Bandwidth: v.Bandwidth, Resolution: v.Resolution, URI: v.URI, RunID: run.ID}
+		report.Rungs = append(report.Rungs, rung)
+		log.WithField("RunID", run.ID).Infof("Benchmarking variant %v of the ladder", v.Bandwidth)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			summary := run.Execute()
+			rung.Segments = len(summary.Results.Total)
+			rung.Errors = summary.Errors.Total()
+			rung.Late = summary.Results.Late
+			rung.Latency = latencyPercentiles(summary.Results.Total)
+			if tr := summary.Results.Throughput(); tr != nil {
+				rung.Mbps = tr.Mbps
+			}
+			if rung.Bandwidth > 0 {
+				rung.Headroom = rung.Mbps * 1000000 / float64(rung.Bandwidth)
+			}
+			if run.Err != nil {
+				rung.Error = run.Err.Error()
+			}
+		}()
+	}
+	wg.Wait()
+	if shared != nil {
+		if err := shared.Close(); err != nil {
+			return report, err
+		}
+		if *uploadTo != "" {
+			if err := uploadLadderOutputs(*uploadTo, report.ID, specs); err != nil {
+				return report, err
+			}
+		}
+	}
+	sort.Slice(report.Rungs, func(i, j int) bool { return report.Rungs[i].Bandwidth < report.Rungs[j].Bandwidth })
+	return report, nil
+}
+
+// LogSummary logs every rung of the ladder, from the lowest bandwidth up.
+func (lr *LadderReport) LogSummary() {
+	for _, rung := range lr.Rungs {
+		entry := log.WithField("RunID", rung.RunID).
+			WithField("Resolution", rung.Resolution).
+			WithField("Segments", rung.Segments).
+			WithField("Errors", rung.Errors).
+			WithField("Late", rung.Late).
+			WithField("Mbps", rung.Mbps).
+			WithField("Headroom", rung.Headroom)
+		for name, d := range rung.Latency {
+			entry = entry.WithField("Latency"+name, d)
+		}
+		switch {
+		case rung.Error != "":
+			entry.WithField("Error", rung.Error).Errorf("Variant %v", rung.Bandwidth)
+		case rung.Segments > 0 && rung.Headroom < 1:
+			entry.Warnf("Variant %v can't be streamed in real time", rung.Bandwidth)
+		default:
+			entry.Infof("Variant %v", rung.Bandwidth)
+		}
+	}
+}
+
+// Failed reports whether the run of any variant failed.
+func (lr *LadderReport) Failed() bool {
+	for _, rung := range lr.Rungs {
+		if rung.Error != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// Write writes the report to -ladder-report, if given.
+func (lr *LadderReport) Write() error {
+	if *ladderReport == "" {
+		return nil
+	}
+	b, err := json.MarshalIndent(lr, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(*ladderReport, b, 0644)
+}
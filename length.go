@@ -0,0 +1,30 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// bodyLengthProblem returns why n bytes were the wrong amount to receive in
+// resp, the response to a request for v, or "" if they weren't. Go already
+// fails bodies cut short of their Content-Length, but not ranges answered
+// with more or less than was asked for. short is whether too few arrived.
+func bodyLengthProblem(resp *http.Response, v *SegmentDownload, n int64) (reason string, short bool) {
+	if resp.ContentLength >= 0 && n != resp.ContentLength {
+		return fmt.Sprintf("received %d bytes, Content-Length was %d", n, resp.ContentLength), n < resp.ContentLength
+	}
+	if v.Limit <= 0 {
+		return "", false
+	}
+	if resp.StatusCode != http.StatusPartialContent {
+		return fmt.Sprintf("range %v ignored, received %d bytes instead of %d", v.Range(), n, v.Limit), n < v.Limit
+	}
+	if n != v.Limit {
+		return fmt.Sprintf("received %d bytes of range %v, which is %d", n, v.Range(), v.Limit), n < v.Limit
+	}
+	if contentRange := resp.Header.Get("Content-Range"); !strings.HasPrefix(contentRange, "bytes "+v.Range()+"/") {
+		return fmt.Sprintf("Content-Range %q for range %v", contentRange, v.Range()), false
+	}
+	return "", false
+}
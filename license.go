@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/digitaljanitors/go-httpstat"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	licenseURL    = flag.String("license-url", "", "probe this DRM license endpoint whenever a FairPlay, Widevine or other DRM key is first seen; {uri} is replaced with the escaped key URI and {id} with the key ID of skd:// URIs, and {uri} alone probes the key URI itself")
+	licenseMethod = flag.String("license-method", "POST", "HTTP method of -license-url probes")
+)
+
+// licenseBodyFile is the -license-body flag, read as soon as it is set so
+// that a missing file stops the benchmark before it starts rather than at the
+// first DRM key.
+type licenseBodyFile struct {
+	name string
+	data []byte
+}
+
+func (lb *licenseBodyFile) String() string {
+	return lb.name
+}
+
+func (lb *licenseBodyFile) Set(value string) error {
+	if value == "" {
+		*lb = licenseBodyFile{}
+		return nil
+	}
+	data, err := ioutil.ReadFile(value)
+	if err != nil {
+		return err
+	}
+	if len(data) == 0 {
+		return fmt.Errorf("%v is empty", value)
+	}
+	*lb = licenseBodyFile{name: value, data: data}
+	return nil
+}
+
+var licenseBody = &licenseBodyFile{}
+
+// licenseHeaders is the repeatable -license-header flag.
+type licenseHeaders http.Header
+
+func (lh licenseHeaders) String() string {
+	var headers []string
+	for name, values := range lh {
+		for _, value := range values {
+			headers = append(headers, name+": "+value)
+		}
+	}
+	return strings.Join(headers, ", ")
+}
+
+func (lh licenseHeaders) Set(value string) error {
+	parts := strings.SplitN(value, ":", 2)
+	if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+		return fmt.Errorf("license header %q is not of the form Name: value", value)
+	}
+	http.Header(lh).Add(textproto.TrimString(parts[0]), textproto.TrimString(parts[1]))
+	return nil
+}
+
+var licenseHeader = licenseHeaders{}
+
+func init() {
+	flag.Var(licenseHeader, "license-header", "`Name: value` header sent with -license-url probes, can be repeated")
+	flag.Var(licenseBody, "license-body", "`file` whose contents are sent as the body of -license-url probes, e.g. a captured license challenge")
+}
+
+// identityKeyFormat is the KEYFORMAT of plain AES-128 keys, and the default
+// when a playlist doesn't give one.
+const identityKeyFormat = "identity"
+
+// isDRMKey reports whether a key is handed out by a license server rather
+// than fetched as is.
+func isDRMKey(ks *KeyStats) bool {
+	return strings.HasPrefix(ks.URI, "skd://") || (ks.Format != "" && ks.Format != identityKeyFormat)
+}
+
+// licenseTarget fills in the -license-url template for a key.
+func licenseTarget(keyURI string) string {
+	id := ""
+	if u, err := url.Parse(keyURI); err == nil && u.Scheme == "skd" {
+		id = strings.TrimPrefix(u.Host+u.Path, "/")
+	}
+	if *licenseURL == "{uri}" {
+		return keyURI
+	}
+	return strings.NewReplacer("{uri}", url.QueryEscape(keyURI), "{id}", url.QueryEscape(id)).Replace(*licenseURL)
+}
+
+// probeLicense times a request to the license endpoint for a newly seen DRM
+// key, as a player has to make one before it can start playing.
+func probeLicense(run *Run, keyURI string, ks *KeyStats) {
+	if *licenseURL == "" {
+		log.WithField("Method", ks.Method).WithField("KeyFormat", ks.Format).Infof("Not probing the license server of key %v without -license-url", ks.URI)
+		return
+	}
+	target := licenseTarget(keyURI)
+	if u, err := url.Parse(target); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		log.Warnf("Can't probe license endpoint %v", target)
+		return
+	}
+	stats := &httpstat.Result{}
+	ctx := httpstat.WithHTTPStat(run.ctx, stats)
+	req, err := http.NewRequestWithContext(ctx, *licenseMethod, target, bytes.NewReader(licenseBody.data))
+	if err != nil {
+		log.Warn(err)
+		return
+	}
+	for name, values := range licenseHeader {
+		req.Header[name] = values
+	}
+	setPriority(req, urgencyPlaylist)
+	download := &SegmentDownload{URI: target}
+	requestedAt := time.Now()
+	resp, err := run.do(req)
+	result := NewRequestResult(KindLicense, download, req, requestedAt)
+	if err != nil {
+		logFailedRequest(req, err)
+		run.Keys.LicenseFailed()
+		run.failed(result, nil, err)
+		return
+	}
+	if !isSuccess(resp) {
+		reason := logFailedResponse(resp, "Recieved HTTP %v from license endpoint %v\n", resp.StatusCode, target)
+		resp.Body.Close()
+		run.Keys.LicenseFailed()
+		run.fail(result, resp, categorizeStatus(resp.StatusCode), reason)
+		return
+	}
+	n, err := io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		logFailedHeaders(resp, "Failed reading license from %v: %v\n", target, err)
+		run.Keys.LicenseFailed()
+		run.failed(result, resp, err)
+		return
+	}
+	stats.End(time.Now())
+	if err := hook.Response(resp, n, stats); err != nil {
+		run.Keys.LicenseFailed()
+		run.failed(result, resp, err)
+		return
+	}
+	run.Keys.Licensed(stats.Total)
+	run.succeeded(result, resp, n, stats)
+	log.WithFields(stats.Fields()).
+		WithFields(traceFields(resp.Request)).
+		Infof("Probed license endpoint %v for key %v", target, ks.URI)
+}
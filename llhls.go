@@ -0,0 +1,356 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"math"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/digitaljanitors/go-httpstat"
+	"github.com/grafov/m3u8"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	fetchParts = flag.Bool("parts", false, "also fetch the EXT-X-PART parts of low-latency playlists as they are published, reporting them separately from segments")
+	fetchHints = flag.Bool("preload-hints", false, "request the EXT-X-PRELOAD-HINT parts of low-latency playlists as soon as they are hinted, timing how long the server holds them until they are available")
+)
+
+// llPart is an EXT-X-PART of a low-latency playlist. Sequence is the media
+// sequence number of the segment it's part of, and Index its place in it.
+type llPart struct {
+	Sequence    uint64
+	Index       int
+	URI         string
+	Duration    float64
+	Independent bool
+	Limit       int64
+	Offset      int64
+}
+
+// lowLatency is what a low-latency media playlist says beyond what the
+// decoder reads: its EXT-X-PART-INF, EXT-X-SERVER-CONTROL and parts.
+type lowLatency struct {
+	TargetDuration float64
+	PartTarget     float64
+	HoldBack       float64
+	PartHoldBack   float64
+	CanBlockReload bool
+	Parts          []*llPart
+	Hints          []*preloadHint
+}
+
+// preloadHint is an EXT-X-PRELOAD-HINT: a part or map the server will hold
+// requests for until it is available. Length is 0 if it runs to the end of
+// the resource.
+type preloadHint struct {
+	Type   string
+	URI    string
+	Start  int64
+	Length int64
+}
+
+// parseByteRange parses the "length[@offset]" of a BYTERANGE attribute.
+func parseByteRange(value string) (limit, offset int64) {
+	fields := strings.SplitN(value, "@", 2)
+	limit, _ = strconv.ParseInt(fields[0], 10, 64)
+	if len(fields) == 2 {
+		offset, _ = strconv.ParseInt(fields[1], 10, 64)
+	}
+	return limit, offset
+}
+
+// parseLowLatency returns the low-latency tags of mpl, whose text is body,
+// or nil if it has no usable EXT-X-PART-INF.
+func parseLowLatency(body []byte, mpl *m3u8.MediaPlaylist) *lowLatency {
+	ll := &lowLatency{TargetDuration: mpl.TargetDuration}
+	partInf := false
+	seq, index := mpl.SeqNo, 0
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "#EXT-X-PART-INF:"):
+			attrs := parseAttributes(strings.TrimPrefix(line, "#EXT-X-PART-INF:"))
+			ll.PartTarget, _ = strconv.ParseFloat(attrs["PART-TARGET"], 64)
+			partInf = true
+		case strings.HasPrefix(line, "#EXT-X-SERVER-CONTROL:"):
+			attrs := parseAttributes(strings.TrimPrefix(line, "#EXT-X-SERVER-CONTROL:"))
+			ll.HoldBack, _ = strconv.ParseFloat(attrs["HOLD-BACK"], 64)
+			ll.PartHoldBack, _ = strconv.ParseFloat(attrs["PART-HOLD-BACK"], 64)
+			ll.CanBlockReload = attrs["CAN-BLOCK-RELOAD"] == "YES"
+		case strings.HasPrefix(line, "#EXT-X-PART:"):
+			attrs := parseAttributes(strings.TrimPrefix(line, "#EXT-X-PART:"))
+			part := &llPart{
+				Sequence:    seq,
+				Index:       index,
+				URI:         attrs["URI"],
+				Independent: attrs["INDEPENDENT"] == "YES",
+			}
+			part.Duration, _ = strconv.ParseFloat(attrs["DURATION"], 64)
+			if r, ok := attrs["BYTERANGE"]; ok {
+				part.Limit, part.Offset = parseByteRange(r)
+			}
+			ll.Parts = append(ll.Parts, part)
+			index++
+		case strings.HasPrefix(line, "#EXT-X-PRELOAD-HINT:"):
+			attrs := parseAttributes(strings.TrimPrefix(line, "#EXT-X-PRELOAD-HINT:"))
+			hint := &preloadHint{Type: attrs["TYPE"], URI: attrs["URI"]}
+			hint.Start, _ = strconv.ParseInt(attrs["BYTERANGE-START"], 10, 64)
+			hint.Length, _ = strconv.ParseInt(attrs["BYTERANGE-LENGTH"], 10, 64)
+			ll.Hints = append(ll.Hints, hint)
+		case strings.HasPrefix(line, "#"):
+		default:
+			// A segment's URI follows its parts.
+			seq, index = seq+1, 0
+		}
+	}
+	if !partInf || ll.PartTarget <= 0 {
+		return nil
+	}
+	return ll
+}
+
+// partKey identifies a part across refreshes of a playlist.
+type partKey struct {
+	seq   uint64
+	index int
+}
+
+// LowLatencyTracker checks the hold-backs of a low-latency playlist against
+// the minimums of the HLS spec, and against how far apart its parts are
+// published: a player holding back PART-HOLD-BACK from the live edge stalls
+// if no new part turns up for longer than that.
+type LowLatencyTracker struct {
+	mu           sync.Mutex
+	PartTarget   float64
+	HoldBack     float64
+	PartHoldBack float64
+	Parts        int
+
+	seen    map[partKey]bool
+	gaps    []time.Duration
+	lastNew time.Time
+	checked bool
+}
+
+func NewLowLatencyTracker() *LowLatencyTracker {
+	return &LowLatencyTracker{seen: map[partKey]bool{}}
+}
+
+// checkHoldBacks records the hold-backs of ll that are below the minimums
+// of the spec as defects.
+func checkHoldBacks(ll *lowLatency, defects *StreamDefects) {
+	if ll.HoldBack > 0 && ll.HoldBack < 3*ll.TargetDuration {
+		defects.Record("HOLD-BACK below three target durations", fmt.Sprintf("HOLD-BACK is %vs, the target duration %vs", ll.HoldBack, ll.TargetDuration))
+	}
+	switch {
+	case ll.PartHoldBack == 0:
+		defects.Record("EXT-X-PART-INF without PART-HOLD-BACK", fmt.Sprintf("PART-TARGET is %vs", ll.PartTarget))
+	case ll.PartHoldBack < 2*ll.PartTarget:
+		defects.Record("PART-HOLD-BACK below two part targets", fmt.Sprintf("PART-HOLD-BACK is %vs, PART-TARGET %vs", ll.PartHoldBack, ll.PartTarget))
+	case ll.PartHoldBack < 3*ll.PartTarget:
+		log.WithField("PartHoldBack", ll.PartHoldBack).
+			WithField("PartTarget", ll.PartTarget).
+			Warn("PART-HOLD-BACK is below the three part targets the spec recommends")
+	}
+}
+
+// Observe notes the parts of a snapshot of a low-latency playlist fetched at
+// at, recording what breaks the spec in defects, and returns the parts not
+// seen before.
+func (lt *LowLatencyTracker) Observe(at time.Time, ll *lowLatency, defects *StreamDefects) []*llPart {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+	if !lt.checked {
+		checkHoldBacks(ll, defects)
+	}
+	lt.PartTarget, lt.HoldBack, lt.PartHoldBack = ll.PartTarget, ll.HoldBack, ll.PartHoldBack
+
+	var added []*llPart
+	for _, part := range ll.Parts {
+		key := partKey{part.Sequence, part.Index}
+		if lt.seen[key] {
+			continue
+		}
+		lt.seen[key] = true
+		added = append(added, part)
+		if part.Duration > ll.PartTarget {
+			defects.Record("EXT-X-PART exceeds PART-TARGET", fmt.Sprintf("part %d.%d is %.3fs long, the part target %v", part.Sequence, part.Index, part.Duration, ll.PartTarget))
+		}
+	}
+	lt.Parts += len(added)
+	// Parts already in the first snapshot say nothing about when they were
+	// published.
+	if len(added) > 0 && lt.checked {
+		gap := at.Sub(lt.lastNew)
+		lt.gaps = append(lt.gaps, gap)
+		if ll.PartHoldBack > 0 && gap.Seconds() > ll.PartHoldBack {
+			defects.Record("parts published further apart than PART-HOLD-BACK", fmt.Sprintf("no new part for %v before part %d.%d, PART-HOLD-BACK is %vs", gap, added[0].Sequence, added[0].Index, ll.PartHoldBack))
+		}
+	}
+	if len(added) > 0 {
+		lt.lastNew = at
+	}
+	lt.checked = true
+	return added
+}
+
+// LowLatencyReport is the hold-backs of a low-latency playlist and how far
+// apart new parts were seen in it.
+type LowLatencyReport struct {
+	PartTarget     float64                  `json:"part_target"`
+	HoldBack       float64                  `json:"hold_back,omitempty"`
+	PartHoldBack   float64                  `json:"part_hold_back"`
+	Parts          int                      `json:"parts"`
+	PublicationGap map[string]time.Duration `json:"publication_gap,omitempty"`
+}
+
+// Report returns nil if the playlist wasn't a low-latency one.
+func (lt *LowLatencyTracker) Report() *LowLatencyReport {
+	if lt == nil {
+		return nil
+	}
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+	if !lt.checked {
+		return nil
+	}
+	return &LowLatencyReport{
+		PartTarget:     lt.PartTarget,
+		HoldBack:       lt.HoldBack,
+		PartHoldBack:   lt.PartHoldBack,
+		Parts:          lt.Parts,
+		PublicationGap: latencyPercentiles(lt.gaps),
+	}
+}
+
+func (lt *LowLatencyTracker) LogSummary() {
+	report := lt.Report()
+	if report == nil {
+		return
+	}
+	log.WithField("PartTarget", report.PartTarget).
+		WithField("HoldBack", report.HoldBack).
+		WithField("PartHoldBack", report.PartHoldBack).
+		WithField("Parts", report.Parts).
+		WithField("PublicationGap", report.PublicationGap).
+		Info("Low-latency playlist")
+}
+
+// partDownload returns the download of part, listed in a playlist at base
+// requested at advertisedAt.
+func partDownload(base *url.URL, part *llPart, advertisedAt time.Time) (*SegmentDownload, error) {
+	uri, err := translateURI(base, part.URI)
+	if err != nil {
+		return nil, err
+	}
+	download := NewSegmentDownload(uri, part.Duration, part.Limit, part.Offset)
+	download.Sequence = part.Sequence
+	download.Part = true
+	download.AdvertisedAt = advertisedAt
+	return download, nil
+}
+
+// ratePercentiles returns the nearest-rank p50, p90 and p99 and the minimum
+// of rates, the minimum being the worst.
+func ratePercentiles(rates []float64) map[string]float64 {
+	if len(rates) == 0 {
+		return nil
+	}
+	sorted := append([]float64(nil), rates...)
+	sort.Float64s(sorted)
+	percentile := func(p float64) float64 {
+		i := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+		if i < 0 {
+			i = 0
+		}
+		return sorted[i]
+	}
+	return map[string]float64{
+		"p50": percentile(50),
+		"p90": percentile(90),
+		"p99": percentile(99),
+		"min": sorted[0],
+	}
+}
+
+// PartStats are the timings of the parts fetched with -parts. Availability
+// is how long after the playlist listing a part was requested its first
+// byte arrived.
+type PartStats struct {
+	mu           sync.Mutex
+	bytes        int64
+	latency      []time.Duration
+	throughput   []float64
+	availability []time.Duration
+}
+
+func NewPartStats() *PartStats {
+	return &PartStats{}
+}
+
+func (ps *PartStats) Add(v *SegmentDownload, n int64, stats *httpstat.Result, fetchedAt time.Time) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	ps.bytes += n
+	ps.latency = append(ps.latency, stats.Total)
+	if stats.ContentTransfer > 0 {
+		ps.throughput = append(ps.throughput, float64(n)*8/1e6/stats.ContentTransfer.Seconds())
+	}
+	ps.availability = append(ps.availability, fetchedAt.Add(stats.StartTransfer).Sub(v.AdvertisedAt))
+}
+
+// PartReport summarizes the parts fetched; throughput is in Mb/s.
+type PartReport struct {
+	Parts        int                      `json:"parts"`
+	Bytes        int64                    `json:"bytes"`
+	Latency      map[string]time.Duration `json:"latency"`
+	Throughput   map[string]float64       `json:"throughput_mbps,omitempty"`
+	Availability map[string]time.Duration `json:"availability"`
+}
+
+// Report returns nil if no parts were fetched.
+func (ps *PartStats) Report() *PartReport {
+	if ps == nil {
+		return nil
+	}
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	if len(ps.latency) == 0 {
+		return nil
+	}
+	return &PartReport{
+		Parts:        len(ps.latency),
+		Bytes:        ps.bytes,
+		Latency:      latencyPercentiles(ps.latency),
+		Throughput:   ratePercentiles(ps.throughput),
+		Availability: latencyPercentiles(ps.availability),
+	}
+}
+
+func (ps *PartStats) LogSummary() {
+	r := ps.Report()
+	if r == nil {
+		return
+	}
+	entry := log.WithField("Parts", r.Parts).WithField("Bytes", r.Bytes)
+	for name, d := range r.Latency {
+		entry = entry.WithField("Latency"+name, d)
+	}
+	for name, rate := range r.Throughput {
+		entry = entry.WithField("Throughput"+name, fmt.Sprintf("%.2f Mb/s", rate))
+	}
+	for name, d := range r.Availability {
+		entry = entry.WithField("Availability"+name, d)
+	}
+	entry.Info("Parts")
+}
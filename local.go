@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// localFiles is whether the playlist argument is a local file, the only
+// case in which file:// URLs are read at all. Otherwise a playlist or a
+// redirect could have the benchmark read any file of the host it runs on.
+var localFiles bool
+
+// localPlaylist returns the URL to benchmark for the playlist argument: a
+// URL as is, a local file as a file:// URL, or for - the playlist read from
+// stdin, saved to a temporary file as it is refreshed like any other. The
+// URIs in a local playlist are resolved against -base-url, so that a
+// pre-generated or hand-edited playlist can drive a benchmark of remote
+// segments. The function returned removes the temporary file, if any.
+func localPlaylist(arg string) (string, func(), error) {
+	cleanup := func() {}
+	if strings.Contains(arg, "://") {
+		return arg, cleanup, nil
+	}
+	path := arg
+	if arg == "-" {
+		f, err := ioutil.TempFile("", "hlsbenchmark-*.m3u8")
+		if err != nil {
+			return "", cleanup, err
+		}
+		cleanup = func() { os.Remove(f.Name()) }
+		_, err = io.Copy(f, os.Stdin)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			cleanup()
+			return "", func() {}, err
+		}
+		path = f.Name()
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		cleanup()
+		return "", func() {}, err
+	}
+	if _, err := os.Stat(abs); err != nil {
+		cleanup()
+		return "", func() {}, err
+	}
+	if *baseURL == "" {
+		log.Warnf("%v is a local playlist, and without -base-url its segments are read from local files too", arg)
+	}
+	localFiles = true
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(abs)}).String(), cleanup, nil
+}
+
+// checkLocalURI refuses uri, referred to by the playlist at base, if it is
+// a local file but the playlist isn't.
+func checkLocalURI(base, uri *url.URL) error {
+	if uri.Scheme == "file" && base.Scheme != "file" {
+		return fmt.Errorf("refusing to read %v, a local file referred to by %v", uri, base)
+	}
+	return nil
+}
+
+// checkRemotePlaylist refuses to benchmark playlistURL unless it is HTTP or
+// HTTPS, as the daemon does for the URLs it is given.
+func checkRemotePlaylist(playlistURL string) error {
+	u, err := url.Parse(playlistURL)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("playlist URL %v is not HTTP or HTTPS", playlistURL)
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"flag"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var lookahead = flag.Int("lookahead", 0, "fetch at most `n` segments ahead of the playback point of a simulated player, which starts playing once the first segment is fetched and stalls when the next isn't, rather than as fast as possible or, with -realtime, each segment no faster than it plays; models the buffer targets of players and the requests they make of CDNs")
+
+// Playhead simulates playing the segments of the variant as they are
+// fetched, to hold segments back until they are at most -lookahead ahead of
+// the segment playing. Segments play in media sequence order, from the
+// first one fetched, and playback stalls while the next one is being
+// fetched. Segments that never are, such as those that dropped out of a live
+// playlist between refreshes, are skipped.
+type Playhead struct {
+	mu      sync.Mutex
+	started bool
+	// queued are the segments being fetched, and done those fetched and not
+	// yet played, by media sequence.
+	queued map[uint64]bool
+	done   map[uint64]*SegmentDownload
+	// playSeq is the segment playing, position how far into it playback is
+	// in seconds as of updated.
+	playSeq  uint64
+	position float64
+	updated  time.Time
+}
+
+func NewPlayhead() *Playhead {
+	return &Playhead{queued: map[uint64]bool{}, done: map[uint64]*SegmentDownload{}}
+}
+
+// paced reports whether v is held back by -lookahead: segments of the
+// variant other than init segments and parts.
+func paced(v *SegmentDownload) bool {
+	return *lookahead > 0 && !v.Init && !v.Part && v.Rendition == ""
+}
+
+// span returns how many media sequence numbers v covers.
+func span(v *SegmentDownload) uint64 {
+	if v.Coalesced > 1 {
+		return uint64(v.Coalesced)
+	}
+	return 1
+}
+
+// advance plays the segments fetched up to now.
+func (ph *Playhead) advance(now time.Time) {
+	if ph.updated.IsZero() {
+		return
+	}
+	elapsed := now.Sub(ph.updated).Seconds()
+	ph.updated = now
+	for elapsed > 0 {
+		v, ok := ph.done[ph.playSeq]
+		if !ok {
+			if ph.queued[ph.playSeq] || !ph.skip() {
+				// Stalled waiting for the segment.
+				return
+			}
+			continue
+		}
+		left := v.Duration - ph.position
+		if elapsed < left {
+			ph.position += elapsed
+			return
+		}
+		elapsed -= left
+		delete(ph.done, ph.playSeq)
+		ph.playSeq += span(v)
+		ph.position = 0
+	}
+}
+
+// skip moves playback past a gap of segments that were never queued, to the
+// next one that was, reporting whether there was one.
+func (ph *Playhead) skip() bool {
+	next, found := uint64(0), false
+	for seq := range ph.queued {
+		if seq > ph.playSeq && (!found || seq < next) {
+			next, found = seq, true
+		}
+	}
+	for seq := range ph.done {
+		if seq > ph.playSeq && (!found || seq < next) {
+			next, found = seq, true
+		}
+	}
+	if !found {
+		return false
+	}
+	log.Debugf("Playback skipping segments %d to %d, which were never fetched", ph.playSeq, next-1)
+	ph.playSeq = next
+	ph.position = 0
+	return true
+}
+
+// Wait waits until v is at most -lookahead segments ahead of the one
+// playing, and reports whether the run should carry on.
+func (ph *Playhead) Wait(run *Run, v *SegmentDownload) bool {
+	if !paced(v) {
+		return true
+	}
+	for {
+		ph.mu.Lock()
+		if !ph.started {
+			ph.started = true
+			ph.playSeq = v.Sequence
+		}
+		ph.queued[v.Sequence] = true
+		ph.advance(time.Now())
+		if v.Sequence <= ph.playSeq+uint64(*lookahead) {
+			ph.mu.Unlock()
+			return true
+		}
+		// Wait for the segment playing to end, or for a while if playback
+		// is stalled.
+		wait := 100 * time.Millisecond
+		if current, ok := ph.done[ph.playSeq]; ok {
+			wait = time.Duration((current.Duration - ph.position) * float64(time.Second))
+		}
+		ph.mu.Unlock()
+		if !run.sleep(wait) {
+			return false
+		}
+	}
+}
+
+// Done notes v as fetched, whether it succeeded or not, so that it plays
+// once playback reaches it.
+func (ph *Playhead) Done(v *SegmentDownload) {
+	if !paced(v) {
+		return
+	}
+	ph.mu.Lock()
+	defer ph.mu.Unlock()
+	now := time.Now()
+	delete(ph.queued, v.Sequence)
+	ph.advance(now)
+	if v.Sequence < ph.playSeq {
+		return
+	}
+	ph.done[v.Sequence] = v
+	// Playback starts with the first segment.
+	if ph.updated.IsZero() && v.Sequence == ph.playSeq {
+		ph.updated = now
+	}
+}
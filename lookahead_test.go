@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+)
+
+// playheadAt returns the segment playing d after playback started, and how
+// far into it playback is.
+func playheadAt(ph *Playhead, d time.Duration) (uint64, float64) {
+	ph.mu.Lock()
+	defer ph.mu.Unlock()
+	ph.advance(ph.updated.Add(d))
+	return ph.playSeq, ph.position
+}
+
+func TestPlayhead(t *testing.T) {
+	defer func(n int) { *lookahead = n }(*lookahead)
+	*lookahead = 1
+	run := &Run{ctx: context.Background()}
+	segment := func(seq uint64) *SegmentDownload {
+		return &SegmentDownload{Sequence: seq, Duration: 2}
+	}
+
+	t.Run("stalls on a segment being fetched", func(t *testing.T) {
+		ph := NewPlayhead()
+		ph.Wait(run, segment(10))
+		ph.Wait(run, segment(11))
+		ph.Done(segment(10))
+		if seq, _ := playheadAt(ph, 3*time.Second); seq != 11 {
+			t.Errorf("after 3s playing %d, want 11", seq)
+		}
+	})
+	t.Run("skips segments never fetched", func(t *testing.T) {
+		ph := NewPlayhead()
+		ph.Wait(run, segment(10))
+		ph.Done(segment(10))
+		// 11 and 12 dropped out of the playlist, 13 is held back.
+		ph.queued[13] = true
+		if seq, position := playheadAt(ph, 3*time.Second); seq != 13 || position != 0 {
+			t.Errorf("after 3s playing %d at %vs, want 13 at 0s", seq, position)
+		}
+	})
+	t.Run("plays on after a gap", func(t *testing.T) {
+		ph := NewPlayhead()
+		ph.Wait(run, segment(10))
+		ph.Done(segment(10))
+		ph.queued[13] = true
+		ph.Done(segment(13))
+		// Done(13) advanced playback a little already.
+		if seq, position := playheadAt(ph, 3*time.Second); seq != 13 || math.Abs(position-1) > 0.1 {
+			t.Errorf("after 3s playing %d at %vs, want 13 at 1s", seq, position)
+		}
+	})
+}
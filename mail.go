@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	htmltemplate "html/template"
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+)
+
+var (
+	smtpAddr   = flag.String("smtp-addr", "localhost:25", "SMTP server `host:port` for -output mail; SMTP_USERNAME and SMTP_PASSWORD are used to log in if set")
+	mailFrom   = flag.String("mail-from", "hlsbenchmark@localhost", "sender of -output mail reports")
+	mailFormat = flag.String("mail-format", "text", "format of -output mail reports, text or html")
+)
+
+// reportPhases are the timings shown in reports, in request order.
+var reportPhases = []string{"DNSLookup", "TCPConnection", "TLSHandshake", "ServerProcessing", "ContentTransfer", "Total"}
+
+type reportPhase struct {
+	Name                      string
+	Minimum, Maximum, Average interface{}
+}
+
+type reportError struct {
+	Category ErrorCategory
+	*ErrorStats
+}
+
+// report is what the mail templates are rendered with.
+type report struct {
+	*RunSummary
+	Duration time.Duration
+	Requests int
+	Phases   []reportPhase
+	Failures []reportError
+}
+
+func newReport(s *RunSummary) *report {
+	r := &report{
+		RunSummary: s,
+		Duration:   s.End.Sub(s.Start).Round(time.Second),
+		Requests:   len(s.Results.Total),
+	}
+	mins, maxs, avgs := s.Results.Minimums(), s.Results.Maximums(), s.Results.Averages()
+	for _, phase := range reportPhases {
+		r.Phases = append(r.Phases, reportPhase{phase, mins[phase], maxs[phase], avgs[phase]})
+	}
+	for _, category := range errorCategories {
+		if stats, ok := s.Errors.Categories[category]; ok {
+			r.Failures = append(r.Failures, reportError{category, stats})
+		}
+	}
+	return r
+}
+
+var textReport = template.Must(template.New("text").Parse(`Benchmark of {{.PlaylistURL}}
+{{.Start.Format "2006-01-02 15:04:05 MST"}} for {{.Duration}}, {{.Requests}} segments downloaded
+
+{{range .Phases}}{{printf "%-18s" .Name}} min {{.Minimum}}  max {{.Maximum}}  avg {{.Average}}
+{{end}}
+{{if .Failures}}Errors:
+{{range .Failures}}  {{.Category}}: {{.Count}}
+{{range $reason, $count := .Reasons}}    {{$count}} x {{$reason}}
+{{end}}{{end}}{{else}}No errors
+{{end}}`))
+
+var htmlReport = htmltemplate.Must(htmltemplate.New("html").Parse(`<html><body>
+<h2>Benchmark of {{.PlaylistURL}}</h2>
+<p>{{.Start.Format "2006-01-02 15:04:05 MST"}} for {{.Duration}}, {{.Requests}} segments downloaded</p>
+<table border="1" cellpadding="4" cellspacing="0">
+<tr><th></th><th>Min</th><th>Max</th><th>Avg</th></tr>
+{{range .Phases}}<tr><td>{{.Name}}</td><td>{{.Minimum}}</td><td>{{.Maximum}}</td><td>{{.Average}}</td></tr>
+{{end}}</table>
+{{if .Failures}}<h3>Errors</h3>
+<ul>
+{{range .Failures}}<li>{{.Category}}: {{.Count}}<ul>
+{{range $reason, $count := .Reasons}}<li>{{$count}} &times; {{$reason}}</li>
+{{end}}</ul></li>
+{{end}}</ul>
+{{else}}<p>No errors</p>
+{{end}}</body></html>
+`))
+
+// mailSink emails the summary to the comma separated addresses in its target
+// once the run is over.
+type mailSink struct {
+	to      []string
+	message []byte
+}
+
+func newMailSink(target string) (OutputSink, error) {
+	var to []string
+	for _, addr := range strings.Split(target, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			to = append(to, addr)
+		}
+	}
+	if len(to) == 0 {
+		return nil, fmt.Errorf("-output mail needs recipients, e.g. mail:ops@example.com,team@example.com")
+	}
+	if *mailFormat != "text" && *mailFormat != "html" {
+		return nil, fmt.Errorf("unknown -mail-format %q, expected text or html", *mailFormat)
+	}
+	return &mailSink{to: to}, nil
+}
+
+func (ms *mailSink) Result(*RequestResult) {}
+
+func (ms *mailSink) Summary(s *RunSummary) {
+	var body bytes.Buffer
+	contentType := "text/plain"
+	if *mailFormat == "html" {
+		contentType = "text/html"
+		htmlReport.Execute(&body, newReport(s))
+	} else {
+		textReport.Execute(&body, newReport(s))
+	}
+
+	subject := fmt.Sprintf("Benchmark of %v: %d errors", s.PlaylistURL, s.Errors.Total())
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %v\r\n", *mailFrom)
+	fmt.Fprintf(&msg, "To: %v\r\n", strings.Join(ms.to, ", "))
+	fmt.Fprintf(&msg, "Subject: %v\r\n", subject)
+	fmt.Fprintf(&msg, "Date: %v\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&msg, "Content-Type: %v; charset=UTF-8\r\n\r\n", contentType)
+	msg.Write(bytes.Replace(body.Bytes(), []byte("\n"), []byte("\r\n"), -1))
+	ms.message = msg.Bytes()
+}
+
+func (ms *mailSink) Close() error {
+	if ms.message == nil {
+		return nil
+	}
+	var auth smtp.Auth
+	if username := os.Getenv("SMTP_USERNAME"); username != "" {
+		host, _, err := net.SplitHostPort(*smtpAddr)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", username, os.Getenv("SMTP_PASSWORD"), host)
+	}
+	if err := smtp.SendMail(*smtpAddr, auth, *mailFrom, ms.to, ms.message); err != nil {
+		return fmt.Errorf("mailing report: %v", err)
+	}
+	return nil
+}
+
+func init() {
+	RegisterOutput("mail", newMailSink)
+}
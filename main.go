@@ -1,13 +1,23 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"math"
 	"net/http"
 	"net/url"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/digitaljanitors/go-httpstat"
@@ -22,12 +32,39 @@ var USER_AGENT = fmt.Sprintf("HLS-Benchmark-tool/%s", VERSION)
 
 var client = &http.Client{}
 
-func doRequest(c *http.Client, req *http.Request) (*http.Response, error) {
+var (
+	baseURL     = flag.String("base-url", "", "resolve relative URIs in playlists against this URL instead of the playlist's own")
+	concurrency = flag.Int("concurrency", 1, "number of segments downloaded at once")
+	realtime    = flag.Bool("realtime", false, "download segments no faster than they play back, like a player would")
+	repeat      = flag.Int("repeat", 1, "number of times every segment is downloaded, e.g. 2 to check the second request is a cache hit")
+)
+
+func doRequest(c *http.Client, traceID string, req *http.Request) (*http.Response, error) {
 	req.Header.Set("User-Agent", USER_AGENT)
-	resp, err := c.Do(req)
+	setTraceHeaders(req, traceID)
+	setOrigin(req)
+	extraParams.Apply(req.URL)
+	token := tokens.Apply(req)
+	if err := hook.Request(req); err != nil {
+		return nil, err
+	}
+	resp, err := c.Do(withRedirects(req))
+	if err == nil {
+		tokens.Rejected(resp, token)
+	}
 	return resp, err
 }
 
+// do makes a request of the run with its client, tracing it into the run's
+// trackers of the connections made.
+func (run *Run) do(req *http.Request) (*http.Response, error) {
+	if err := run.blocklist.refuse(req.URL); err != nil {
+		return nil, err
+	}
+	req = withHookMetrics(req, run.Hook)
+	return doRequest(run.client, run.TraceID, run.DialRaces.Trace(run.Protocols.Trace(run.EarlyHints.Trace(req))))
+}
+
 func newRequest(method, url string, stats *httpstat.Result) (*http.Request, error) {
 	ctx := httpstat.WithHTTPStat(context.Background(), stats)
 	return http.NewRequestWithContext(ctx, method, url, nil)
@@ -38,6 +75,33 @@ type SegmentDownload struct {
 	Duration float64
 	Limit    int64
 	Offset   int64
+	// Encryption is the METHOD of the segment's EXT-X-KEY.
+	Encryption string
+	// Rendition is the TYPE of the EXT-X-MEDIA rendition it belongs to,
+	// I-FRAMES or IMAGES for I-frame and image playlists, or empty for the
+	// variant's own segments.
+	Rendition string
+	// Sequence is the media sequence number of the segment.
+	Sequence uint64
+	// MediaTime is when the segment starts, in seconds, see segmentTimes.
+	MediaTime float64
+	// Class is whether the segment is an ad or content, see classifyAds.
+	Class string
+	// Tags are the unknown tags before the segment, see captureTags.
+	Tags []string
+	// Coalesced is the number of byte range segments fetched together as
+	// this one with -coalesce-ranges.
+	Coalesced int
+	// Init is whether this is the EXT-X-MAP of a playlist.
+	Init bool
+	// Part is whether this is an EXT-X-PART of a low-latency playlist
+	// rather than a whole segment, and AdvertisedAt when the playlist that
+	// first listed it was requested.
+	Part         bool
+	AdvertisedAt time.Time
+	// Paired are the segments of other renditions for the same media time,
+	// which are fetched along with it.
+	Paired []*SegmentDownload
 }
 
 func (sd SegmentDownload) SegmentStart() int64 {
@@ -51,6 +115,14 @@ func (sd SegmentDownload) SegmentEnd() int64 {
 	return sd.Offset + sd.Limit - 1
 }
 
+// Range is the byte range requested, or empty for a whole segment.
+func (sd SegmentDownload) Range() string {
+	if sd.Limit <= 0 {
+		return ""
+	}
+	return fmt.Sprintf("%d-%d", sd.SegmentStart(), sd.SegmentEnd())
+}
+
 func NewSegmentDownload(uri string, duration float64, limit, offset int64) *SegmentDownload {
 	return &SegmentDownload{
 		URI:      uri,
@@ -74,29 +146,45 @@ type ResultSummary struct {
 	Pretransfer   []time.Duration
 	StartTransfer []time.Duration
 	Total         []time.Duration
-}
 
-func (rs *ResultSummary) Add(result *httpstat.Result) {
-	rs.DNSLookup = append(rs.DNSLookup, result.DNSLookup)
-	rs.TCPConnection = append(rs.TCPConnection, result.TCPConnection)
-	rs.TLSHandshake = append(rs.TLSHandshake, result.TLSHandshake)
-	rs.ServerProcessing = append(rs.ServerProcessing, result.ServerProcessing)
-	rs.ContentTransfer = append(rs.ContentTransfer, result.ContentTransfer)
-	rs.NameLookup = append(rs.NameLookup, result.NameLookup)
-	rs.Connect = append(rs.Connect, result.Connect)
-	rs.Pretransfer = append(rs.Pretransfer, result.Pretransfer)
-	rs.StartTransfer = append(rs.StartTransfer, result.StartTransfer)
-	rs.Total = append(rs.Total, result.Total)
+	// Bytes and Groups are the size of every result and the rendition it
+	// belongs to, see Throughput.
+	Bytes  []int64
+	Groups []string
+
+	// Late counts the results that took longer to download than they play.
+	Late int
 }
 
-func (rs *ResultSummary) Averages() map[string]interface{} {
-	var f = func(d []time.Duration) time.Duration {
-		var total time.Duration
-		for _, value := range d {
-			total += value
-		}
-		return time.Duration(int64(total) / int64(len(d)))
+// Add records the timings and size of a successful request.
+func (rs *ResultSummary) Add(result *RequestResult) {
+	t := result.Timings
+	if t == nil {
+		return
+	}
+	rs.DNSLookup = append(rs.DNSLookup, t.DNSLookup)
+	rs.TCPConnection = append(rs.TCPConnection, t.TCPConnection)
+	rs.TLSHandshake = append(rs.TLSHandshake, t.TLSHandshake)
+	rs.ServerProcessing = append(rs.ServerProcessing, t.ServerProcessing)
+	rs.ContentTransfer = append(rs.ContentTransfer, t.ContentTransfer)
+	rs.NameLookup = append(rs.NameLookup, t.NameLookup)
+	rs.Connect = append(rs.Connect, t.Connect)
+	rs.Pretransfer = append(rs.Pretransfer, t.Pretransfer)
+	rs.StartTransfer = append(rs.StartTransfer, t.StartTransfer)
+	rs.Total = append(rs.Total, t.Total)
+	group := result.Rendition
+	if group == "" {
+		group = "variant"
+	}
+	rs.Bytes = append(rs.Bytes, result.Bytes)
+	rs.Groups = append(rs.Groups, group)
+	if result.Duration > 0 && t.Total >= time.Duration(result.Duration*float64(time.Second)) {
+		rs.Late++
 	}
+}
+
+// aggregate applies f to the samples of every timing.
+func (rs *ResultSummary) aggregate(f func([]time.Duration) time.Duration) map[string]interface{} {
 	return map[string]interface{}{
 		"DNSLookup":        f(rs.DNSLookup),
 		"TCPConnection":    f(rs.TCPConnection),
@@ -106,14 +194,27 @@ func (rs *ResultSummary) Averages() map[string]interface{} {
 
 		"NameLookup":    f(rs.NameLookup),
 		"Connect":       f(rs.Connect),
-		"Pretransfer":   f(rs.Connect),
+		"Pretransfer":   f(rs.Pretransfer),
 		"StartTransfer": f(rs.StartTransfer),
 		"Total":         f(rs.Total),
 	}
 }
 
+func (rs *ResultSummary) Averages() map[string]interface{} {
+	return rs.aggregate(func(d []time.Duration) time.Duration {
+		var total time.Duration
+		if len(d) == 0 {
+			return 0
+		}
+		for _, value := range d {
+			total += value
+		}
+		return time.Duration(int64(total) / int64(len(d)))
+	})
+}
+
 func (rs *ResultSummary) Maximums() map[string]interface{} {
-	var f = func(d []time.Duration) time.Duration {
+	return rs.aggregate(func(d []time.Duration) time.Duration {
 		var max time.Duration
 		for _, value := range d {
 			if value > max {
@@ -121,51 +222,141 @@ func (rs *ResultSummary) Maximums() map[string]interface{} {
 			}
 		}
 		return max
-	}
-	return map[string]interface{}{
-		"DNSLookup":        f(rs.DNSLookup),
-		"TCPConnection":    f(rs.TCPConnection),
-		"TLSHandshake":     f(rs.TLSHandshake),
-		"ServerProcessing": f(rs.ServerProcessing),
-		"ContentTransfer":  f(rs.ContentTransfer),
-
-		"NameLookup":    f(rs.NameLookup),
-		"Connect":       f(rs.Connect),
-		"Pretransfer":   f(rs.Connect),
-		"StartTransfer": f(rs.StartTransfer),
-		"Total":         f(rs.Total),
-	}
+	})
 }
 
 func (rs *ResultSummary) Minimums() map[string]interface{} {
-	var f = func(d []time.Duration) time.Duration {
+	return rs.aggregate(func(d []time.Duration) time.Duration {
 		var min time.Duration
-		for _, value := range d {
-			if value < min {
+		for i, value := range d {
+			if i == 0 || value < min {
 				min = value
 			}
 		}
 		return min
+	})
+}
+
+// throughputBuckets are the upper bounds in Mb/s of the buckets of
+// ThroughputReport.Histogram.
+var throughputBuckets = []float64{1, 2, 5, 10, 20, 50, 100, 200, 500}
+
+// mbps returns the throughput of n bytes sent over d in Mb/s.
+func mbps(n int64, d time.Duration) float64 {
+	return float64(n) * 8 / 1000000 / d.Seconds()
+}
+
+// ThroughputReport is the throughput of a set of results in Mb/s: Mbps
+// weighted by their size, total bytes over total transfer time, and
+// MeanMbps the average of their own throughputs, which small ones such as
+// init segments skew. Rates, Variance and Histogram are the distribution of
+// their own throughputs, the histogram counting them by the bucket of
+// throughputBuckets they fall in, keyed by its upper bound.
+type ThroughputReport struct {
+	Requests  int                          `json:"requests"`
+	Bytes     int64                        `json:"bytes"`
+	Transfer  time.Duration                `json:"transfer"`
+	Mbps      float64                      `json:"mbps"`
+	MeanMbps  float64                      `json:"mean_mbps"`
+	Rates     map[string]float64           `json:"rates,omitempty"`
+	Variance  float64                      `json:"variance"`
+	Histogram map[string]int               `json:"histogram,omitempty"`
+	Groups    map[string]*ThroughputReport `json:"groups,omitempty"`
+
+	rates []float64
+}
+
+func (tr *ThroughputReport) add(n int64, transfer time.Duration) {
+	tr.Requests++
+	tr.Bytes += n
+	tr.Transfer += transfer
+	if transfer > 0 {
+		tr.rates = append(tr.rates, mbps(n, transfer))
 	}
-	return map[string]interface{}{
-		"DNSLookup":        f(rs.DNSLookup),
-		"TCPConnection":    f(rs.TCPConnection),
-		"TLSHandshake":     f(rs.TLSHandshake),
-		"ServerProcessing": f(rs.ServerProcessing),
-		"ContentTransfer":  f(rs.ContentTransfer),
+}
 
-		"NameLookup":    f(rs.NameLookup),
-		"Connect":       f(rs.Connect),
-		"Pretransfer":   f(rs.Connect),
-		"StartTransfer": f(rs.StartTransfer),
-		"Total":         f(rs.Total),
+func (tr *ThroughputReport) finish() {
+	if tr.Transfer > 0 {
+		tr.Mbps = mbps(tr.Bytes, tr.Transfer)
+	}
+	if len(tr.rates) == 0 {
+		return
 	}
+	for _, rate := range tr.rates {
+		tr.MeanMbps += rate
+	}
+	tr.MeanMbps /= float64(len(tr.rates))
+	tr.Histogram = map[string]int{}
+	for _, rate := range tr.rates {
+		tr.Variance += (rate - tr.MeanMbps) * (rate - tr.MeanMbps)
+		bucket := "+Inf"
+		for _, le := range throughputBuckets {
+			if rate <= le {
+				bucket = strconv.FormatFloat(le, 'g', -1, 64)
+				break
+			}
+		}
+		tr.Histogram[bucket]++
+	}
+	tr.Variance /= float64(len(tr.rates))
+	tr.Rates = ratePercentiles(tr.rates)
+}
+
+// Throughput returns the throughput of all results and of every rendition's,
+// or nil if there were none.
+func (rs *ResultSummary) Throughput() *ThroughputReport {
+	if len(rs.Bytes) == 0 {
+		return nil
+	}
+	report := &ThroughputReport{Groups: map[string]*ThroughputReport{}}
+	for i, n := range rs.Bytes {
+		report.add(n, rs.ContentTransfer[i])
+		group, ok := report.Groups[rs.Groups[i]]
+		if !ok {
+			group = &ThroughputReport{}
+			report.Groups[rs.Groups[i]] = group
+		}
+		group.add(n, rs.ContentTransfer[i])
+	}
+	report.finish()
+	for _, group := range report.Groups {
+		group.finish()
+	}
+	return report
+}
+
+// durationPercentile returns the nearest-rank percentile p of sorted.
+func durationPercentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	return sorted[i]
 }
 
 func (rs *ResultSummary) LogSummary() {
 	log.WithFields(rs.Minimums()).Info("Results Minimums")
 	log.WithFields(rs.Maximums()).Info("Results Maximums")
 	log.WithFields(rs.Averages()).Info("Results Averages")
+	if tr := rs.Throughput(); tr != nil {
+		entry := log.WithField("Mbps", tr.Mbps).
+			WithField("MeanMbps", tr.MeanMbps).
+			WithField("Variance", tr.Variance).
+			WithField("Bytes", tr.Bytes)
+		for name, rate := range tr.Rates {
+			entry = entry.WithField("Mbps"+name, rate)
+		}
+		entry.Info("Results Throughput")
+		for group, gr := range tr.Groups {
+			log.WithField("Mbps", gr.Mbps).
+				WithField("MeanMbps", gr.MeanMbps).
+				WithField("Bytes", gr.Bytes).
+				Infof("Results Throughput of %v", group)
+		}
+	}
 }
 
 func translateURI(playlistURL *url.URL, segmentURI string) (string, error) {
@@ -173,11 +364,14 @@ func translateURI(playlistURL *url.URL, segmentURI string) (string, error) {
 	if err != nil {
 		return "", err
 	}
+	if err := checkLocalURI(playlistURL, msUrl); err != nil {
+		return "", err
+	}
 	msURI, err := url.QueryUnescape(msUrl.String())
 	if err != nil {
 		return "", err
 	}
-	return msURI, nil
+	return uriRewrites.Apply(msURI), nil
 }
 
 func calculateTransfer(bytesDownloaded int64, overTime time.Duration) string {
@@ -195,111 +389,1160 @@ func logSegmentDownload(resp *http.Response, stats *httpstat.Result, segment *Se
 		lvl = logrus.WarnLevel
 	}
 	log.WithFields(stats.Fields()).
-		WithField("X-Cache", resp.Header["X-Cache"][0]).
+		WithFields(traceFields(resp.Request)).
+		WithField("X-Cache", resp.Header.Get("X-Cache")).
 		WithField("TransferRate", calculateTransfer(resp.ContentLength, stats.ContentTransfer)).
 		WithField("ConnectedTo", stats.ConnectedTo).
 		Logf(lvl, "Downloaded %d bytes of %v @%d-%d\n", resp.ContentLength, segment.URI, segment.SegmentStart(), segment.SegmentEnd())
 }
 
-func downloadSegments(dlc chan *SegmentDownload) ResultSummary {
-	results := ResultSummary{}
+// Run is the state shared by the playlist and segment goroutines of a
+// benchmark. Cancelling its context stops it at the next playlist refresh.
+type Run struct {
+	ID            string
+	PlaylistURL   string
+	TraceID       string
+	Start         time.Time
+	Errors        *ErrorSummary
+	History       *PlaylistHistory
+	Defects       *StreamDefects
+	LowLatency    *LowLatencyTracker
+	Parts         *PartStats
+	Hints         *HintTracker
+	Priorities    *PriorityTracker
+	Compression   *CompressionStats
+	Chunks        *ChunkStats
+	Coalescing    *SegmentBreakdown
+	Splits        *SplitStats
+	Redirects     *RedirectStats
+	FirstSegment  *FirstSegmentTracker
+	RTT           *RTTProber
+	LiveLatency   *LiveLatencyTracker
+	Stalls        *StallDetector
+	Purges        *PurgeChecker
+	Alignment     *AlignmentChecker
+	ClockSkew     *ClockSkewTracker
+	Certs         *CertTracker
+	Security      *SecurityHeaderTracker
+	CMSD          *CMSDStats
+	Via           *ViaStats
+	Bandwidth     *BandwidthEstimator
+	Resources     *ResourceMonitor
+	Failover      *FailoverTest
+	Parsing       *ParseStats
+	Keys          *KeyTracker
+	Pairs         *PairTracker
+	TrickPlay     []*TrickPlayResult
+	Renditions    *SegmentBreakdown
+	Classes       *SegmentBreakdown
+	POPs          *SegmentBreakdown
+	CacheStatuses *CacheStatusBreakdown
+	Interstitials *InterstitialTracker
+	Recorder      *Recorder
+	Checkpoint    *Checkpoint
+	Progress      *Progress
+	Playhead      *Playhead
+	Control       *Control
+	EarlyHints    *EarlyHintTracker
+	Protocols     *ProtocolTracker
+	DialRaces     *DialRaceTracker
+	Paths         *PathTracer
+	Hook          *HookMetrics
+	Output        OutputSink
+	// Dir is the run's directory within -run-dir, if there is one.
+	Dir string
+	// Files are where the run writes the files of -record, -bundle,
+	// -metrics-file and -charts.
+	Files RunFiles
+
+	// Err is why the run was aborted, if it was.
+	Err error
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	// outputSpecs are the -output sinks the run reports to itself, rather
+	// than through extra ones shared with other runs.
+	outputSpecs []string
+	// client makes the run's requests, see runClient.
+	client *http.Client
+	// blocklist refuses the run's requests and connections to what
+	// -failover-after blocked.
+	blocklist *connBlocklist
+	// stopOnce ends the run once it has had too many errors.
+	stopOnce sync.Once
+}
+
+// RunFiles are the names of the files and directories a run writes.
+type RunFiles struct {
+	Record  string
+	Bundle  string
+	Metrics string
+	Charts  string
+}
+
+// newRunFiles returns the files of the run id given by the flags. The runs
+// of -whole-ladder are made at once, so each of them writes files of its
+// own, named after the flags with the run's ID added.
+func newRunFiles(id string) RunFiles {
+	name := func(name string) string {
+		if name == "" || !*wholeLadder {
+			return name
+		}
+		ext := filepath.Ext(name)
+		if strings.HasSuffix(name, ".tar.gz") {
+			ext = ".tar.gz"
+		}
+		return strings.TrimSuffix(name, ext) + "-" + id + ext
+	}
+	return RunFiles{
+		Record:  name(*recordTo),
+		Bundle:  name(*bundleTo),
+		Metrics: name(*metricsFile),
+		Charts:  name(*chartsDir),
+	}
+}
+
+func NewRun(ctx context.Context, playlistURL string, extra ...OutputSink) (*Run, error) {
+	return newRun(ctx, playlistURL, outputs, extra...)
+}
 
-	for v := range dlc {
-		stats := &httpstat.Result{}
-		req, err := newRequest("GET", v.URI, stats)
+// newRun makes a run reporting to the -output sinks of specs and to extra.
+func newRun(ctx context.Context, playlistURL string, specs []string, extra ...OutputSink) (*Run, error) {
+	start := time.Now()
+	ctx, cancel := context.WithCancel(ctx)
+	blocklist := newConnBlocklist()
+	c := runClient(blocklist)
+	run := &Run{
+		ID:            newULID(start),
+		PlaylistURL:   playlistURL,
+		TraceID:       randomHex(16),
+		Start:         start,
+		Errors:        NewErrorSummary(),
+		History:       NewPlaylistHistory(),
+		Defects:       NewStreamDefects(),
+		LowLatency:    NewLowLatencyTracker(),
+		Parts:         NewPartStats(),
+		Hints:         NewHintTracker(),
+		Priorities:    NewPriorityTracker(),
+		Compression:   NewCompressionStats(),
+		Chunks:        NewChunkStats(),
+		Coalescing:    NewSegmentBreakdown(),
+		Splits:        NewSplitStats(),
+		Redirects:     NewRedirectStats(),
+		FirstSegment:  NewFirstSegmentTracker(),
+		RTT:           NewRTTProber(),
+		LiveLatency:   NewLiveLatencyTracker(),
+		Stalls:        NewStallDetector(),
+		Purges:        NewPurgeChecker(),
+		Alignment:     NewAlignmentChecker(),
+		ClockSkew:     NewClockSkewTracker(),
+		Certs:         NewCertTracker(),
+		Security:      NewSecurityHeaderTracker(),
+		CMSD:          NewCMSDStats(),
+		Via:           NewViaStats(),
+		Bandwidth:     NewBandwidthEstimator(),
+		Resources:     NewResourceMonitor(),
+		Failover:      NewFailoverTest(blocklist),
+		Parsing:       NewParseStats(),
+		Keys:          NewKeyTracker(),
+		Pairs:         NewPairTracker(),
+		Renditions:    NewSegmentBreakdown(),
+		Classes:       NewSegmentBreakdown(),
+		POPs:          NewSegmentBreakdown(),
+		CacheStatuses: NewCacheStatusBreakdown(),
+		Interstitials: NewInterstitialTracker(),
+		Checkpoint:    NewCheckpoint(),
+		Progress:      NewProgress(),
+		Playhead:      NewPlayhead(),
+		Control:       NewControl(),
+		EarlyHints:    NewEarlyHintTracker(c),
+		Protocols:     NewProtocolTracker(),
+		DialRaces:     NewDialRaceTracker(),
+		Paths:         NewPathTracer(),
+		Hook:          NewHookMetrics(),
+		ctx:           ctx,
+		cancel:        cancel,
+		outputSpecs:   specs,
+		client:        c,
+		blocklist:     blocklist,
+	}
+	run.Files = newRunFiles(run.ID)
+	run.Checkpoint.resume(run)
+	if *runDir != "" {
+		run.Dir = filepath.Join(*runDir, run.ID)
+		sink, err := newRunDirSink(run.Dir)
 		if err != nil {
-			log.Fatal(err)
+			return nil, err
 		}
-		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", v.SegmentStart(), v.SegmentEnd()))
-		resp, err := doRequest(client, req)
+		extra = append(extra, sink)
+	}
+	if run.Files.Record != "" {
+		var err error
+		run.Recorder, err = NewRecorder(run.Files.Record, run.Info())
 		if err != nil {
-			log.Print(err)
-			continue
+			return nil, err
 		}
-		if !(resp.StatusCode >= 200 && resp.StatusCode <= 299) {
-			log.Warnf("Recieved HTTP %v for %v @%d-%d\n", resp.StatusCode, v.URI, v.SegmentStart(), v.SegmentEnd())
-			continue
+		extra = append(extra, run.Recorder)
+	}
+	if run.Files.Bundle != "" {
+		bundle, err := newBundleSink(run.Files.Bundle, run.Info())
+		if err != nil {
+			return nil, err
+		}
+		extra = append(extra, bundle)
+	}
+	if run.Files.Metrics != "" {
+		extra = append(extra, &metricsFileSink{target: run.Files.Metrics})
+	}
+	if run.Files.Charts != "" {
+		extra = append(extra, &chartSink{dir: run.Files.Charts})
+	}
+	var err error
+	run.Output, err = NewOutputSink(specs, extra...)
+	if err != nil {
+		return nil, err
+	}
+	return run, nil
+}
+
+func (run *Run) Info() RunInfo {
+	env := currentEnvironment()
+	return RunInfo{
+		RunID:       run.ID,
+		PlaylistURL: run.PlaylistURL,
+		Version:     VERSION,
+		TraceID:     run.TraceID,
+		Start:       run.Start,
+		Environment: &env,
+	}
+}
+
+// sleep waits for d and reports whether the run should carry on.
+func (run *Run) sleep(d time.Duration) bool {
+	select {
+	case <-run.ctx.Done():
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
+
+// abort stops the playlist loop because of err, letting the segments already
+// queued finish so the run still gets its summary.
+func (run *Run) abort(dlc chan *SegmentDownload, err error) {
+	log.Error(err)
+	run.Err = err
+	close(dlc)
+}
+
+func (run *Run) report(result *RequestResult) {
+	result.RunID = run.ID
+	run.Output.Result(result)
+}
+
+func (run *Run) fail(result *RequestResult, resp *http.Response, category ErrorCategory, reason string) {
+	run.Errors.Record(category, reason)
+	run.Failover.Failed(result)
+	result.SetError(resp, category, reason)
+	run.report(result)
+	run.checkErrors()
+}
+
+func (run *Run) failed(result *RequestResult, resp *http.Response, err error) {
+	run.Errors.RecordError(err)
+	run.Failover.Failed(result)
+	result.SetError(resp, categorizeError(err), err.Error())
+	run.report(result)
+	run.checkErrors()
+}
+
+func (run *Run) succeeded(result *RequestResult, resp *http.Response, n int64, stats *httpstat.Result) {
+	run.observe(result, resp, n, stats)
+	run.report(result)
+}
+
+// observe notes a successful request in result and the run's trackers,
+// without reporting it.
+func (run *Run) observe(result *RequestResult, resp *http.Response, n int64, stats *httpstat.Result) {
+	result.SetResponse(resp, n, stats)
+	if result.Kind == KindSegment {
+		heartbeat.Segment(time.Now())
+	}
+	if *rttInterval > 0 {
+		addr := edgeAddrOf(resp)
+		run.RTT.Seen(addr)
+		result.BaselineRTT = run.RTT.Latest(addr)
+	}
+	run.ClockSkew.Add(resp, result.RequestedAt.Add(stats.StartTransfer))
+	run.Certs.Observe(resp)
+	run.Security.Add(result.Kind, resp)
+	run.CMSD.Add(result)
+	run.Via.Add(result.Via)
+	run.Failover.Seen(result)
+	if len(result.Redirects) > 0 {
+		run.Redirects.Add(result)
+	}
+	run.EarlyHints.Fetched(resp.Request.URL, stats)
+}
+
+// summarize returns the summary of the run given the results of its
+// segments so far.
+func (run *Run) summarize(results ResultSummary) *RunSummary {
+	env := currentEnvironment()
+	return &RunSummary{
+		RunID:         run.ID,
+		PlaylistURL:   run.PlaylistURL,
+		Start:         run.Start,
+		End:           time.Now(),
+		Results:       results,
+		Errors:        run.Errors.Snapshot(),
+		History:       run.History.Snapshot(),
+		Defects:       run.Defects,
+		LowLatency:    run.LowLatency,
+		Parts:         run.Parts,
+		Hints:         run.Hints,
+		Priorities:    run.Priorities,
+		Compression:   run.Compression,
+		Chunks:        run.Chunks,
+		Coalescing:    run.Coalescing,
+		Splits:        run.Splits,
+		Redirects:     run.Redirects,
+		FirstSegment:  run.FirstSegment,
+		RTT:           run.RTT,
+		LiveLatency:   run.LiveLatency,
+		Stalls:        run.Stalls,
+		Purges:        run.Purges,
+		Alignment:     run.Alignment,
+		ClockSkew:     run.ClockSkew,
+		Certs:         run.Certs,
+		Security:      run.Security,
+		CMSD:          run.CMSD,
+		Via:           run.Via,
+		Bandwidth:     run.Bandwidth,
+		Resources:     run.Resources,
+		Failover:      run.Failover,
+		Parsing:       run.Parsing,
+		Keys:          run.Keys,
+		Pairs:         run.Pairs,
+		TrickPlay:     run.TrickPlay,
+		Renditions:    run.Renditions,
+		Classes:       run.Classes,
+		POPs:          run.POPs,
+		CacheStatuses: run.CacheStatuses,
+		Interstitials: run.Interstitials,
+		Protocols:     run.Protocols,
+		DialRaces:     run.DialRaces,
+		EarlyHints:    run.EarlyHints,
+		Hook:          run.Hook,
+		Environment:   &env,
+	}
+}
+
+func (run *Run) Finish(results ResultSummary) *RunSummary {
+	summary := run.summarize(results)
+	for _, c := range failIf {
+		if reason := c.failed(summary); reason != "" {
+			log.Error(reason)
+			if run.Err == nil {
+				run.Err = errors.New(reason)
+			}
+		}
+	}
+	run.Output.Summary(summary)
+	if err := run.Output.Close(); err != nil {
+		log.Error(err)
+		if run.Err == nil {
+			run.Err = err
+		}
+	}
+	if *uploadTo != "" {
+		if err := uploadArtifacts(*uploadTo, run, summary); err != nil {
+			log.Error(err)
+			if run.Err == nil {
+				run.Err = err
+			}
 		}
-		err = resp.Write(ioutil.Discard)
+	}
+	return summary
+}
+
+// Execute benchmarks the playlist until it ends or the run is stopped.
+func (run *Run) Execute() *RunSummary {
+	log.WithField("RunID", run.ID).Infof("Starting run of %v", run.PlaylistURL)
+	defer run.cancel()
+	heartbeat.Running(run.ID)
+	defer heartbeat.Finished(run.ID)
+	if run.client != client {
+		defer run.client.CloseIdleConnections()
+	}
+	shaper.Start(time.Now())
+	if len(trickPlay) > 0 {
+		results := executeTrickPlay(run)
+		run.Paths.Wait()
+		return run.Finish(results)
+	}
+	ctx, cancel := context.WithCancel(run.ctx)
+	defer cancel()
+	go run.Resources.Run(ctx)
+	if *rttInterval > 0 {
+		go run.RTT.Run(ctx)
+	}
+	if *failoverAfter > 0 {
+		go run.Failover.Run(ctx, run.Start)
+	}
+	if *purgeCheck > 0 {
+		go run.Purges.Run(ctx, run.client, run.TraceID)
+	}
+	if *checkpointFile != "" {
+		go run.Checkpoint.Run(ctx, run)
+	}
+	if *progress {
+		run.Progress.Start(run.Start)
+	}
+	if *controlSocket != "" {
+		go run.Control.Serve(ctx, run)
+	}
+	dlc := make(chan *SegmentDownload, 1024)
+	go getPlaylist(run, dlc)
+	results := downloadSegments(run, dlc)
+	run.Progress.Stop()
+	run.Hints.Wait()
+	run.Paths.Wait()
+	run.Checkpoint.Close(run)
+	return run.Finish(results)
+}
+
+func downloadSegments(run *Run, dlc chan *SegmentDownload) ResultSummary {
+	results := run.Checkpoint.Results()
+
+	var store *SegmentStore
+	if *saveDir != "" {
+		var err error
+		store, err = NewSegmentStore(*saveDir)
 		if err != nil {
-			log.Fatal(err)
+			log.Errorf("Not saving segments: %v", err)
+		} else {
+			defer store.Close()
 		}
-		resp.Body.Close()
-		stats.End(time.Now())
-		logSegmentDownload(resp, stats, v)
-		results.Add(stats)
 	}
 
+	var mu sync.Mutex
+	run.Checkpoint.Track(&results, &mu)
+	run.Control.Track(&results, &mu)
+	var wg sync.WaitGroup
+	worker := func() {
+		defer wg.Done()
+		for !run.Control.Retire() {
+			v, ok := <-dlc
+			if !ok {
+				return
+			}
+			run.Progress.Take()
+			if run.ctx.Err() != nil {
+				run.Progress.Done(0)
+				continue
+			}
+			// Parts are reported on their own rather than with the
+			// segments they make up.
+			if v.Part {
+				var n int64
+				if result := downloadSegment(run, store, v); result != nil {
+					n = result.Bytes
+				}
+				run.Progress.Done(n)
+				continue
+			}
+			if !run.Playhead.Wait(run, v) {
+				run.Progress.Done(0)
+				continue
+			}
+			started := time.Now()
+			var n int64
+			for i := 0; i < *repeat || i == 0; i++ {
+				for _, result := range downloadPaired(run, store, v) {
+					n += result.Bytes
+					mu.Lock()
+					results.Add(result)
+					mu.Unlock()
+				}
+			}
+			run.Checkpoint.Done(v)
+			run.Progress.Done(n)
+			run.Playhead.Done(v)
+			if *realtime && !paced(v) {
+				run.sleep(time.Until(started.Add(time.Duration(v.Duration * float64(time.Second)))))
+			}
+		}
+	}
+	workers := *concurrency
+	if workers < 1 {
+		workers = 1
+	}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go worker()
+	}
+	run.Control.Workers(workers, func() {
+		wg.Add(1)
+		go worker()
+	})
+	wg.Wait()
+	run.Control.Workers(0, nil)
+
 	return results
 }
 
-func getPlaylist(urlStr string, dlc chan *SegmentDownload) {
-	playlistUrl, err := url.Parse(urlStr)
+// downloadSegment fetches v once, returning its result if it succeeded.
+func downloadSegment(run *Run, store *SegmentStore, v *SegmentDownload) *RequestResult {
+	stats := &httpstat.Result{}
+	req, err := newRequest("GET", v.URI, stats)
 	if err != nil {
 		log.Fatal(err)
 	}
-	for {
-		stats := &httpstat.Result{}
-		req, err := newRequest("GET", urlStr, stats)
+	if r := v.Range(); r != "" {
+		req.Header.Set("Range", "bytes="+r)
+	}
+	probeRequest(req, v)
+	urgency := run.Priorities.Start(v)
+	defer run.Priorities.Done(v)
+	setPriority(req, urgency)
+	kind := KindSegment
+	if v.Part {
+		kind = KindPart
+	}
+	fetchedAt := time.Now()
+	resp, err := run.do(req)
+	result := NewRequestResult(kind, v, req, fetchedAt)
+	if err != nil {
+		logFailedRequest(req, err)
+		run.failed(result, nil, err)
+		return nil
+	}
+	if !isSuccess(resp) {
+		reason := logFailedResponse(resp, "Recieved HTTP %v for %v @%d-%d\n", resp.StatusCode, v.URI, v.SegmentStart(), v.SegmentEnd())
+		resp.Body.Close()
+		run.fail(result, resp, categorizeStatus(resp.StatusCode), reason)
+		return nil
+	}
+	var chunks *chunkTimer
+	if *chunkTiming && resp.ContentLength < 0 {
+		chunks = newChunkTimer(resp.Body)
+		resp.Body = chunks
+	}
+	result.File = run.Recorder.CaptureBody(kind, v, resp)
+	var vtt *bytes.Buffer
+	if isWebVTT(resp) && probeMode == "" {
+		vtt = &bytes.Buffer{}
+		resp.Body = teeBody{Reader: io.TeeReader(resp.Body, vtt), body: resp.Body, file: nopCloser{vtt}}
+	}
+	var n int64
+	if store != nil {
+		n, err = store.Save(v, resp, fetchedAt)
+	} else {
+		n, err = io.Copy(ioutil.Discard, resp.Body)
+	}
+	resp.Body.Close()
+	if err != nil {
+		logFailedHeaders(resp, "Failed reading %v @%d-%d: %v\n", v.URI, v.SegmentStart(), v.SegmentEnd(), err)
+		run.failed(result, resp, err)
+		return nil
+	}
+	if probeMode != "" {
+		if reason := probeProblem(resp, v); reason != "" {
+			log.WithField("URI", v.URI).Warn(reason)
+			run.Errors.Record(ErrValidation, reason)
+		}
+	} else if reason, short := bodyLengthProblem(resp, v, n); short {
+		logFailedHeaders(resp, "Truncated %v @%d-%d: %v\n", v.URI, v.SegmentStart(), v.SegmentEnd(), reason)
+		run.fail(result, resp, ErrShortRead, reason)
+		return nil
+	} else if reason != "" {
+		log.WithField("URI", v.URI).Warn(reason)
+		run.Errors.Record(ErrValidation, reason)
+	}
+	stats.End(time.Now())
+	if chunks != nil {
+		result.Chunks = len(chunks.arrivals)
+		result.MaxChunkGap = run.Chunks.Add(chunks)
+	}
+	if err := hook.Response(resp, n, stats); err != nil {
+		run.failed(result, resp, err)
+		return nil
+	}
+	if edge := edgeOf(resp); *tracerouteOver > 0 && stats.Total > *tracerouteOver && edge != "" {
+		run.observe(result, resp, n, stats)
+		run.Paths.Report(run, result, edge)
+	} else {
+		run.succeeded(result, resp, n, stats)
+	}
+	logSegmentDownload(resp, stats, v)
+	run.Bandwidth.Add(result.CompletedAt, n, stats.Total)
+	if v.Part {
+		run.Parts.Add(v, n, stats, fetchedAt)
+	} else if !v.Init {
+		run.FirstSegment.Add(v, stats)
+	}
+	if *coalesceRanges > 1 && v.Limit > 0 {
+		group := "single"
+		if v.Coalesced > 1 {
+			group = "coalesced"
+		}
+		run.Coalescing.Add(group, resp, n, stats.Total)
+	}
+	if *splitRanges > 1 && !v.Part && !v.Init {
+		run.Splits.Fetch(v, n, stats.Total, run.TraceID)
+	}
+	if *sendPriority {
+		run.Priorities.Add(fmt.Sprintf("u=%d", urgency), resp, n, stats.Total)
+	}
+	if v.Rendition != "" {
+		run.Renditions.Add(v.Rendition, resp, n, stats.Total)
+	}
+	if v.Class != "" {
+		run.Classes.Add(v.Class, resp, n, stats.Total)
+	}
+	if result.POP != "" {
+		run.POPs.Add(result.POP, resp, n, stats.Total)
+	}
+	if status := cacheStatus(resp); status != "" {
+		run.CacheStatuses.Add(status, resp, n, stats.Total)
+	}
+	if vtt != nil {
+		for _, problem := range validateWebVTT(vtt.Bytes()) {
+			log.WithField("URI", v.URI).Warnf("Invalid WebVTT segment: %v", problem)
+			run.Errors.Record(ErrValidation, "WebVTT: "+problem)
+		}
+	}
+	return result
+}
+
+// fetchedPlaylist is a playlist as it was downloaded.
+type fetchedPlaylist struct {
+	m3u8.Playlist
+	Type        m3u8.ListType
+	RequestedAt time.Time
+	Body        []byte
+}
+
+// fetchPlaylist downloads and decodes the playlist at urlStr. Failed requests
+// are reported and return nil to be retried later; only playlists that can't
+// be decoded are errors.
+func fetchPlaylist(run *Run, urlStr, rendition string) (*fetchedPlaylist, error) {
+	stats := &httpstat.Result{}
+	req, err := newRequest("GET", urlStr, stats)
+	if err != nil {
+		return nil, err
+	}
+	setPriority(req, urgencyPlaylist)
+	if playlistEncodings != "" {
+		req.Header.Set("Accept-Encoding", string(playlistEncodings))
+	}
+	playlistDownload := NewSegmentDownload(urlStr, 1, 0, 1)
+	playlistDownload.Rendition = rendition
+	requestedAt := time.Now()
+	resp, err := run.do(req)
+	result := NewRequestResult(KindPlaylist, playlistDownload, req, requestedAt)
+	if err != nil {
+		logFailedRequest(req, err)
+		run.failed(result, nil, err)
+		return nil, nil
+	}
+	if !isSuccess(resp) {
+		reason := logFailedResponse(resp, "Recieved HTTP %v for %v\n", resp.StatusCode, urlStr)
+		resp.Body.Close()
+		run.fail(result, resp, categorizeStatus(resp.StatusCode), reason)
+		return nil, nil
+	}
+	var wire *countingReader
+	if playlistEncodings != "" {
+		if wire, err = decodeBody(resp); err != nil {
+			logFailedHeaders(resp, "Failed decoding %v: %v\n", urlStr, err)
+			resp.Body.Close()
+			run.fail(result, resp, ErrValidation, err.Error())
+			return nil, nil
+		}
+	}
+	result.File = run.Recorder.CaptureBody(KindPlaylist, playlistDownload, resp)
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		logFailedHeaders(resp, "Failed reading %v: %v\n", urlStr, err)
+		run.failed(result, resp, err)
+		return nil, nil
+	}
+	if wire != nil {
+		result.WireBytes = wire.n
+		run.Compression.Add(resp, wire.n, int64(len(body)))
+	}
+	received := int64(len(body))
+	if wire != nil {
+		received = wire.n
+	}
+	if reason, short := bodyLengthProblem(resp, playlistDownload, received); short {
+		logFailedHeaders(resp, "Truncated %v: %v\n", urlStr, reason)
+		run.fail(result, resp, ErrShortRead, reason)
+		return nil, nil
+	} else if reason != "" {
+		log.WithField("URI", urlStr).Warn(reason)
+		run.Errors.Record(ErrValidation, reason)
+	}
+	stats.End(time.Now())
+	if err := hook.Response(resp, int64(len(body)), stats); err != nil {
+		run.failed(result, resp, err)
+		return nil, nil
+	}
+	parseStart := time.Now()
+	playlist, listType, err := m3u8.DecodeFrom(bytes.NewReader(body), parseMode != "lenient")
+	if err == nil && parseMode == "strict" {
+		err = checkTags(body)
+	}
+	result.ParseTime = time.Since(parseStart)
+	run.Parsing.Add(result.ParseTime, int64(len(body)))
+	if mpl, ok := playlist.(*m3u8.MediaPlaylist); ok && err == nil {
+		result.Segments = int(mpl.Count())
+	}
+	run.succeeded(result, resp, int64(len(body)), stats)
+	if err != nil {
+		run.Errors.Record(ErrValidation, err.Error())
+		return nil, err
+	}
+	logSegmentDownload(resp, stats, playlistDownload)
+	if reason := playlistTooLarge(len(body), result.Segments); reason != "" {
+		log.WithField("URI", urlStr).Warn(reason)
+	}
+	return &fetchedPlaylist{playlist, listType, requestedAt, body}, nil
+}
+
+// resolvePlaylist resolves the URI of a playlist referred to by another,
+// returning it and the URL the URIs within it are resolved against.
+func resolvePlaylist(base *url.URL, uri string) (string, *url.URL, error) {
+	resolved, err := translateURI(base, uri)
+	if err != nil {
+		return "", nil, err
+	}
+	if *baseURL != "" {
+		return resolved, base, nil
+	}
+	u, err := url.Parse(resolved)
+	return resolved, u, err
+}
+
+// mediaSegments returns the downloads of mpl's EXT-X-MAP, if it has one, and
+// of its segments.
+func mediaSegments(base *url.URL, mpl *m3u8.MediaPlaylist, rendition string, dated bool) (*SegmentDownload, []*SegmentDownload, error) {
+	var initSegment *SegmentDownload
+	if mpl.Map != nil {
+		uri, err := translateURI(base, mpl.Map.URI)
 		if err != nil {
-			log.Fatal(err)
+			return nil, nil, err
 		}
-		resp, err := doRequest(client, req)
+		initSegment = NewSegmentDownload(uri, mpl.TargetDuration, mpl.Map.Limit, mpl.Map.Offset)
+		initSegment.Rendition = rendition
+		initSegment.Init = true
+	}
+	keys := segmentKeys(mpl)
+	times := segmentTimes(mpl, dated)
+	var segments []*SegmentDownload
+	for i, v := range mpl.Segments {
+		if v != nil {
+			uri, err := translateURI(base, v.URI)
+			if err != nil {
+				log.Print(err)
+				continue
+			}
+			segment := NewSegmentDownload(uri, v.Duration, v.Limit, v.Offset)
+			segment.Encryption = encryptionMethod(keys[i])
+			segment.Rendition = rendition
+			segment.Sequence = mpl.SeqNo + uint64(i)
+			segment.MediaTime = times[i]
+			segments = append(segments, segment)
+		}
+	}
+	return initSegment, segments, nil
+}
+
+// fetchRendition fetches the media playlist of a rendition, returning nil if
+// it can't be had this time round.
+func fetchRendition(run *Run, r *renditionPlaylist) *m3u8.MediaPlaylist {
+	playlist, err := fetchPlaylist(run, r.URL, r.Type)
+	if err != nil {
+		log.Warnf("Not fetching %v segments: %v", r.Type, err)
+		return nil
+	}
+	if playlist == nil {
+		return nil
+	}
+	if playlist.Type != m3u8.MEDIA {
+		log.Warnf("Not fetching %v segments: %v is not a media playlist", r.Type, r.URL)
+		return nil
+	}
+	return playlist.Playlist.(*m3u8.MediaPlaylist)
+}
+
+func getPlaylist(run *Run, dlc chan *SegmentDownload) {
+	urlStr := run.PlaylistURL
+	playlistUrl, err := url.Parse(urlStr)
+	if err != nil {
+		run.abort(dlc, err)
+		return
+	}
+	if *baseURL != "" {
+		playlistUrl, err = url.Parse(*baseURL)
 		if err != nil {
-			log.Print(err)
-			time.Sleep(time.Duration(3) * time.Second)
+			run.abort(dlc, err)
+			return
 		}
-		playlist, listType, err := m3u8.DecodeFrom(resp.Body, true)
+	}
+	masterUrl := playlistUrl
+	var renditions []*renditionPlaylist
+	// joinSeq is the first segment to fetch, see startSequence.
+	var joinSeq uint64
+	joined := false
+	// segmentsDue is when the segments of a low-latency playlist are next
+	// fetched.
+	var segmentsDue time.Time
+	for run.ctx.Err() == nil {
+		if run.Failover.Blocked(urlStr) {
+			log.Warnf("Variant %v is blocked, failing over to another", urlStr)
+			urlStr, playlistUrl, renditions = run.PlaylistURL, masterUrl, nil
+		}
+		if run.Control.Switched() {
+			urlStr, playlistUrl, renditions = run.PlaylistURL, masterUrl, nil
+		}
+		playlist, err := fetchPlaylist(run, urlStr, "")
 		if err != nil {
-			log.Fatal(err)
+			run.abort(dlc, err)
+			return
 		}
-		resp.Body.Close()
-		stats.End(time.Now())
-		logSegmentDownload(resp, stats, &SegmentDownload{urlStr, 1, 0, 1})
-		if listType == m3u8.MEDIA {
-			mpl := playlist.(*m3u8.MediaPlaylist)
-			if mpl.Map != nil {
-				uri, err := translateURI(playlistUrl, mpl.Map.URI)
-				if err != nil {
-					log.Fatal(err)
+		if playlist == nil {
+			run.sleep(time.Duration(3) * time.Second)
+			continue
+		}
+		if playlist.Type == m3u8.MASTER && urlStr == run.PlaylistURL {
+			master := playlist.Playlist.(*m3u8.MasterPlaylist)
+			master.Variants = run.Failover.Remaining(playlistUrl, master.Variants)
+			variant, err := selectVariant(master)
+			if err != nil {
+				run.abort(dlc, err)
+				return
+			}
+			if v := run.Control.Variant(master); v != nil {
+				variant = v
+			}
+			alts, err := selectRenditions(master, variant)
+			if err != nil {
+				run.abort(dlc, err)
+				return
+			}
+			for _, alt := range alts {
+				r := &renditionPlaylist{Type: alt.Type, Language: alt.Language}
+				if r.URL, r.base, err = resolvePlaylist(playlistUrl, alt.URI); err != nil {
+					run.abort(dlc, err)
+					return
+				}
+				log.WithField("Language", r.Language).Infof("Benchmarking %v rendition %v", r.Type, r.URL)
+				renditions = append(renditions, r)
+			}
+			if *thumbnails {
+				streams := imageStreams(playlist.Body)
+				if len(streams) == 0 {
+					log.Warn("Master playlist has no EXT-X-IMAGE-STREAM-INF to fetch thumbnails of")
+				} else {
+					r := &renditionPlaylist{Type: RenditionImages}
+					if r.URL, r.base, err = resolvePlaylist(playlistUrl, streams[0]["URI"]); err != nil {
+						run.abort(dlc, err)
+						return
+					}
+					log.WithField("Resolution", streams[0]["RESOLUTION"]).Infof("Benchmarking thumbnails %v", r.URL)
+					renditions = append(renditions, r)
 				}
-				dlc <- NewSegmentDownload(uri, mpl.TargetDuration, mpl.Map.Limit, mpl.Map.Offset)
 			}
-			for _, v := range mpl.Segments {
-				if v != nil {
-					uri, err := translateURI(playlistUrl, v.URI)
+			if *checkAlignment {
+				run.Alignment.SetVariants(playlistUrl, master, variant)
+			}
+			if urlStr, playlistUrl, err = resolvePlaylist(playlistUrl, variant.URI); err != nil {
+				run.abort(dlc, err)
+				return
+			}
+			run.Failover.Variant(urlStr, variant.Bandwidth)
+			log.WithField("Bandwidth", variant.Bandwidth).
+				Infof("Benchmarking variant %v", urlStr)
+			continue
+		}
+		if playlist.Type != m3u8.MEDIA {
+			run.abort(dlc, errors.New("not a valid media playlist"))
+			return
+		}
+		mpl := playlist.Playlist.(*m3u8.MediaPlaylist)
+		for _, problem := range run.History.Observe(playlist.RequestedAt, mpl) {
+			run.Errors.Record(ErrIntegrity, problem)
+		}
+		run.Defects.Observe(playlist.Body, mpl)
+		run.LiveLatency.Observe(playlist.RequestedAt, mpl)
+		run.Stalls.Observe(run, playlist.RequestedAt, urlStr, playlist.Body, mpl)
+		ll := parseLowLatency(playlist.Body, mpl)
+		if ll != nil {
+			parts := run.LowLatency.Observe(playlist.RequestedAt, ll, run.Defects)
+			// The parts of the first snapshot were published before it was
+			// fetched, so say nothing about when they became available.
+			if *fetchParts && joined {
+				for _, part := range parts {
+					download, err := partDownload(playlistUrl, part, playlist.RequestedAt)
 					if err != nil {
-						log.Print(err)
+						log.Warn(err)
 						continue
 					}
-					dlc <- NewSegmentDownload(uri, v.Duration, v.Limit, v.Offset)
+					dlc <- download
+				}
+			}
+			for _, hint := range run.Hints.Observe(ll, run.Defects) {
+				if *fetchHints {
+					// Players give up on a part long before three target
+					// durations.
+					run.Hints.Fetch(run, playlistUrl, hint, time.Duration(int64(3*mpl.TargetDuration*1000000000)))
 				}
 			}
-			if mpl.Closed {
-				close(dlc)
+			// Low-latency playlists are refreshed every part target to see
+			// when parts are published, but their segments are only
+			// fetched every target duration, like any other playlist's.
+			if playlist.RequestedAt.Before(segmentsDue) {
+				run.sleep(time.Duration(int64(ll.PartTarget * 1000000000)))
+				continue
+			}
+			segmentsDue = playlist.RequestedAt.Add(time.Duration(int64(mpl.TargetDuration * 1000000000)))
+		}
+		if *checkAlignment {
+			run.Alignment.Check(run, mpl)
+		}
+		for _, key := range run.Keys.ObservePlaylist(playlist.RequestedAt, mpl) {
+			fetchKey(run, playlistUrl, key)
+		}
+		observeInterstitials(run, playlistUrl, playlist.Body, dlc)
+		dated := hasProgramDateTime(mpl)
+		media := make([]*m3u8.MediaPlaylist, len(renditions))
+		for i, r := range renditions {
+			media[i] = fetchRendition(run, r)
+			dated = dated && (media[i] == nil || hasProgramDateTime(media[i]))
+		}
+		initSegment, segments, err := mediaSegments(playlistUrl, mpl, "", dated)
+		if err != nil {
+			run.abort(dlc, err)
+			return
+		}
+		run.Failover.Listed(initSegment, segments)
+		run.Purges.Observe(playlist.RequestedAt, segments)
+		classifyAds(playlist.Body, mpl, segments)
+		if parseMode == "lenient" {
+			captureTags(playlist.Body, mpl, segments)
+		}
+		first := !joined
+		if !joined {
+			if seq, pending, ok := run.Checkpoint.Resumed(); ok {
+				joinSeq = seq
+				for _, segment := range pending {
+					run.Checkpoint.Queued(segment)
+					dlc <- segment
+				}
+			} else if joinSeq, err = startSequence(mpl); err != nil {
+				run.abort(dlc, err)
 				return
-			} else {
-				log.Print("Sleeping.")
-				time.Sleep(time.Duration(int64(mpl.TargetDuration * 1000000000)))
 			}
+			run.Checkpoint.Joined(joinSeq)
+			joined = true
+		}
+		segments = segmentsFrom(segments, joinSeq)
+		if *prepareHosts && first {
+			prepared := segments
+			if initSegment != nil {
+				prepared = append([]*SegmentDownload{initSegment}, segments...)
+			}
+			prepare(run.client, prepared)
+		}
+		if initSegment != nil {
+			dlc <- initSegment
+		}
+		for i, r := range renditions {
+			if media[i] == nil {
+				continue
+			}
+			renditionInit, renditionSegments, err := mediaSegments(r.base, media[i], r.Type, dated)
+			if err != nil {
+				log.Warnf("Not fetching %v segments: %v", r.Type, err)
+				continue
+			}
+			if len(segments) > 0 {
+				renditionSegments = segmentsAfter(renditionSegments, segments[0].MediaTime)
+			}
+			if renditionInit != nil {
+				dlc <- renditionInit
+			}
+			// Thumbnails are fetched while scrubbing rather than to
+			// play, so aren't paired.
+			if r.Type == RenditionImages {
+				for _, segment := range renditionSegments {
+					dlc <- segment
+				}
+				continue
+			}
+			for _, segment := range pairSegments(segments, renditionSegments) {
+				dlc <- segment
+			}
+		}
+		for _, segment := range coalesceSegments(segments) {
+			run.Checkpoint.Queued(segment)
+			dlc <- segment
+		}
+		if mpl.Closed {
+			run.Progress.Closed(dlc)
+			break
+		}
+		log.Print("Sleeping.")
+		if ll != nil {
+			run.sleep(time.Duration(int64(ll.PartTarget * 1000000000)))
 		} else {
-			log.Fatal("Not a valid media playlist")
+			run.sleep(time.Duration(int64(mpl.TargetDuration * 1000000000)))
 		}
 	}
-
+	close(dlc)
 }
 
 func main() {
-	flag.Parse()
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "analyze":
+			analyzeCommand(os.Args[2:])
+			return
+		case "serve":
+			serveCommand(os.Args[2:])
+			return
+		case "replay":
+			replayCommand(os.Args[2:])
+			return
+		case "abr":
+			abrCommand(os.Args[2:])
+			return
+		case "diff":
+			diffCommand(os.Args[2:])
+			return
+		}
+	}
+
+	parseFlags(flag.CommandLine, envPrefix, os.Args[1:])
+	if err := applyConfig(flag.CommandLine); err != nil {
+		log.Fatal(err)
+	}
+	if *bandwidthSchedule != "" {
+		var err error
+		if shaper, err = loadBandwidthSchedule(*bandwidthSchedule); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *injectLatency > 0 || *injectLoss > 0 || *latencySweep != "" || *lossSweep != "" {
+		if shaper == nil {
+			shaper = newBandwidthShaper()
+		}
+		shaper.Emulate(*injectLatency, *injectLoss)
+	}
+	// Whether the playlist is local decides whether transports read files.
+	var playlistURL string
+	if *daemonAddr == "" {
+		if flag.NArg() < 1 {
+			os.Stderr.Write([]byte("Usage: hlsbenchmark [flags] playlist-url|playlist-file|-\n       hlsbenchmark -daemon addr [flags]\n       hlsbenchmark analyze archive\n       hlsbenchmark serve [flags]\n       hlsbenchmark replay [flags] capture.har\n       hlsbenchmark abr [flags] archive\n       hlsbenchmark diff [flags] archive archive\n"))
+			flag.PrintDefaults()
+			os.Exit(2)
+		}
+		var cleanup func()
+		var err error
+		if playlistURL, cleanup, err = localPlaylist(flag.Arg(0)); err != nil {
+			log.Fatal(err)
+		}
+		// log.Fatal and log.Exit run exit handlers, unlike os.Exit.
+		log.RegisterExitHandler(cleanup)
+		defer cleanup()
+	}
+	client.Transport = newTransport(nil)
+	client.CheckRedirect = checkRedirect
+	if *splitRanges > 1 {
+		splitClient = newSplitClient()
+	}
+	startPprof()
+	tokens.Start(*tokenRefresh)
+	syncClock()
+	if *hookCommand != "" {
+		var err error
+		hook, err = StartHook(*hookCommand)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
 
-	if flag.NArg() < 1 {
-		os.Stderr.Write([]byte("Usage: hlsbenchmark media-playlist-url\n"))
-		flag.PrintDefaults()
-		os.Exit(2)
+	if *heartbeatInterval > 0 {
+		heartbeat.Start()
 	}
 
-	dlChan := make(chan *SegmentDownload, 1024)
-	go getPlaylist(flag.Arg(0), dlChan)
-	results := downloadSegments(dlChan)
-	results.LogSummary()
+	// Interrupting or terminating the benchmark stops its runs, which end
+	// with their summaries and outputs as if they had run their course.
+	// Doing so again exits at once.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
+	if *daemonAddr != "" {
+		if err := NewDaemon().ListenAndServe(ctx, *daemonAddr); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if *resume {
+		if err := loadCheckpoint(playlistURL); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if report, err := runSweeps(ctx, playlistURL); err != nil {
+		log.Fatal(err)
+	} else if report != nil {
+		report.LogSummary()
+		if err := report.Write(); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if report, err := runLadder(ctx, playlistURL); err != nil {
+		log.Fatal(err)
+	} else if report != nil {
+		report.LogSummary()
+		if err := report.Write(); err != nil {
+			log.Fatal(err)
+		}
+		if report.Failed() {
+			log.Exit(1)
+		}
+		return
+	}
+
+	run, err := NewRun(ctx, playlistURL)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if run.Dir != "" {
+		runLog, err := os.Create(filepath.Join(run.Dir, "run.log"))
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer runLog.Close()
+		log.SetOutput(io.MultiWriter(os.Stderr, runLog))
+	}
+	run.Execute()
+	if err := hook.Close(); err != nil {
+		log.Warnf("Hook exited: %v", err)
+	}
+	if run.Err != nil {
+		log.Exit(1)
+	}
 }
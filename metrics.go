@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var metricsFile = flag.String("metrics-file", "", "write the run's final metrics to this OpenMetrics file, e.g. for node_exporter's textfile collector")
+
+type metricSample struct {
+	labels [][2]string
+	value  float64
+}
+
+// metric is a gauge or counter in the Prometheus text format.
+type metric struct {
+	name    string
+	help    string
+	typ     string
+	samples []metricSample
+}
+
+func (m *metric) add(value float64, labels ...string) {
+	s := metricSample{value: value}
+	for i := 0; i+1 < len(labels); i += 2 {
+		s.labels = append(s.labels, [2]string{labels[i], labels[i+1]})
+	}
+	m.samples = append(m.samples, s)
+}
+
+func seconds(d interface{}) float64 {
+	if d, ok := d.(time.Duration); ok {
+		return d.Seconds()
+	}
+	return 0
+}
+
+// summaryMetrics returns the final aggregates of a run as metrics.
+func summaryMetrics(s *RunSummary) []*metric {
+	start := &metric{name: "hlsbenchmark_run_start_timestamp_seconds", help: "When the run started.", typ: "gauge"}
+	start.add(float64(s.Start.UnixNano()) / 1e9)
+	end := &metric{name: "hlsbenchmark_run_end_timestamp_seconds", help: "When the run ended.", typ: "gauge"}
+	end.add(float64(s.End.UnixNano()) / 1e9)
+	segments := &metric{name: "hlsbenchmark_segments_downloaded", help: "Segments successfully downloaded.", typ: "gauge"}
+	segments.add(float64(len(s.Results.Total)))
+
+	errs := &metric{name: "hlsbenchmark_errors", help: "Failed requests by category.", typ: "gauge"}
+	for _, category := range errorCategories {
+		count := 0
+		if stats, ok := s.Errors.Categories[category]; ok {
+			count = stats.Count
+		}
+		errs.add(float64(count), "category", string(category))
+	}
+
+	phases := &metric{name: "hlsbenchmark_segment_phase_seconds", help: "Minimum, maximum and average time spent in each phase of segment downloads.", typ: "gauge"}
+	mins, maxs, avgs := s.Results.Minimums(), s.Results.Maximums(), s.Results.Averages()
+	for _, phase := range reportPhases {
+		phases.add(seconds(mins[phase]), "phase", phase, "stat", "min")
+		phases.add(seconds(maxs[phase]), "phase", phase, "stat", "max")
+		phases.add(seconds(avgs[phase]), "phase", phase, "stat", "avg")
+	}
+
+	metrics := []*metric{start, end, segments, errs, phases}
+	if tr := s.Results.Throughput(); tr != nil {
+		throughput := &metric{name: "hlsbenchmark_segment_throughput_mbps", help: "Segment throughput in Mb/s, weighted by size, averaged and by percentile of every segment's own.", typ: "gauge"}
+		throughput.add(tr.Mbps, "stat", "weighted")
+		throughput.add(tr.MeanMbps, "stat", "avg")
+		for _, name := range []string{"min", "p50", "p90", "p99"} {
+			throughput.add(tr.Rates[name], "stat", name)
+		}
+		variance := &metric{name: "hlsbenchmark_segment_throughput_variance", help: "Variance of every segment's own throughput in Mb/s.", typ: "gauge"}
+		variance.add(tr.Variance)
+		histogram := &metric{name: "hlsbenchmark_segment_throughput_segments", help: "Segments by the bucket of their throughput, up to so many Mb/s.", typ: "gauge"}
+		for _, le := range throughputBuckets {
+			bucket := strconv.FormatFloat(le, 'g', -1, 64)
+			histogram.add(float64(tr.Histogram[bucket]), "up_to", bucket)
+		}
+		histogram.add(float64(tr.Histogram["+Inf"]), "up_to", "+Inf")
+		metrics = append(metrics, throughput, variance, histogram)
+	}
+	if s.History != nil {
+		playlist := &metric{name: "hlsbenchmark_playlist_changes", help: "How the playlist changed between refreshes.", typ: "gauge"}
+		playlist.add(float64(s.History.Snapshots), "change", "snapshots")
+		playlist.add(float64(s.History.Unchanged), "change", "unchanged")
+		playlist.add(float64(s.History.SegmentsAdded), "change", "segments_added")
+		playlist.add(float64(s.History.SegmentsRemoved), "change", "segments_removed")
+		playlist.add(float64(s.History.Rewrites), "change", "rewrites")
+		playlist.add(float64(s.History.SequenceRegressions), "change", "sequence_regressions")
+		playlist.add(float64(s.History.SequenceJumps), "change", "sequence_jumps")
+		playlist.add(float64(s.History.SequenceDuplicates), "change", "sequence_duplicates")
+		window := &metric{name: "hlsbenchmark_playlist_window_seconds", help: "Length of the playlist's window.", typ: "gauge"}
+		window.add(s.History.Window.Min, "stat", "min")
+		window.add(s.History.Window.Max, "stat", "max")
+		window.add(s.History.Window.Last, "stat", "last")
+		playlist.add(float64(s.History.Window.Shrinks), "change", "window_shrinks")
+		playlist.add(float64(s.History.Window.Growths), "change", "window_growths")
+		metrics = append(metrics, playlist, window)
+	}
+	if r := s.Stalls.Report(); r != nil {
+		stalls := &metric{name: "hlsbenchmark_playlist_stalls", help: "Times the playlist came back identical for longer than -stall-after target durations.", typ: "gauge"}
+		stalls.add(float64(len(r.Stalls)))
+		longest := &metric{name: "hlsbenchmark_playlist_stall_longest_seconds", help: "Longest the playlist came back identical for.", typ: "gauge"}
+		longest.add(r.Longest.Seconds())
+		metrics = append(metrics, stalls, longest)
+	}
+	return metrics
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// writeMetrics writes metrics in the Prometheus text format, with labels
+// added to every sample.
+func writeMetrics(w io.Writer, metrics []*metric, labels ...string) error {
+	bw := bufio.NewWriter(w)
+	for _, m := range metrics {
+		fmt.Fprintf(bw, "# HELP %v %v\n# TYPE %v %v\n", m.name, m.help, m.name, m.typ)
+		for _, s := range m.samples {
+			bw.WriteString(m.name)
+			all := s.labels
+			for i := 0; i+1 < len(labels); i += 2 {
+				all = append(all, [2]string{labels[i], labels[i+1]})
+			}
+			for i, l := range all {
+				sep := ","
+				if i == 0 {
+					sep = "{"
+				}
+				fmt.Fprintf(bw, `%v%v="%v"`, sep, l[0], labelEscaper.Replace(l[1]))
+			}
+			if len(all) > 0 {
+				bw.WriteString("}")
+			}
+			fmt.Fprintf(bw, " %v\n", strconv.FormatFloat(s.value, 'g', -1, 64))
+		}
+	}
+	return bw.Flush()
+}
+
+// metricsFileSink writes the final metrics to a file once the run is over.
+// The file is replaced in one go so a collector never reads half of it.
+type metricsFileSink struct {
+	target string
+	body   []byte
+}
+
+func (mf *metricsFileSink) Result(*RequestResult) {}
+
+func (mf *metricsFileSink) Summary(s *RunSummary) {
+	var buf bytes.Buffer
+	writeMetrics(&buf, summaryMetrics(s), "label", runLabel(s.PlaylistURL), "playlist_url", s.PlaylistURL)
+	buf.WriteString("# EOF\n")
+	mf.body = buf.Bytes()
+}
+
+func (mf *metricsFileSink) Close() error {
+	if mf.body == nil {
+		return nil
+	}
+	tmp := mf.target + ".tmp"
+	if err := ioutil.WriteFile(tmp, mf.body, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, mf.target)
+}
+
+// pushgatewaySink pushes the final metrics of a run to the Prometheus
+// Pushgateway at its target, grouped by job, -label and host so that runs
+// of other streams or from other probes don't replace each other's.
+type pushgatewaySink struct {
+	url  string
+	body []byte
+}
+
+func newPushgatewaySink(target string) (OutputSink, error) {
+	if !strings.HasPrefix(target, "http://") && !strings.HasPrefix(target, "https://") {
+		return nil, fmt.Errorf("-output pushgateway needs the Pushgateway's URL, e.g. pushgateway:http://localhost:9091")
+	}
+	return &pushgatewaySink{url: strings.TrimRight(target, "/")}, nil
+}
+
+func (ps *pushgatewaySink) Result(*RequestResult) {}
+
+func (ps *pushgatewaySink) Summary(s *RunSummary) {
+	hostname, _ := os.Hostname()
+	var buf bytes.Buffer
+	writeMetrics(&buf, summaryMetrics(s), "playlist_url", s.PlaylistURL)
+	ps.body = buf.Bytes()
+	ps.url += "/metrics/job/hlsbenchmark" +
+		"/label@base64/" + base64.RawURLEncoding.EncodeToString([]byte(runLabel(s.PlaylistURL))) +
+		"/instance@base64/" + base64.RawURLEncoding.EncodeToString([]byte(hostname))
+}
+
+func (ps *pushgatewaySink) Close() error {
+	if ps.body == nil {
+		return nil
+	}
+	req, err := http.NewRequest("PUT", ps.url, bytes.NewReader(ps.body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+	resp, err := uploadClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("pushing metrics: %v", err)
+	}
+	resp.Body.Close()
+	if !isSuccess(resp) {
+		return fmt.Errorf("pushing metrics: Pushgateway returned HTTP %v", resp.StatusCode)
+	}
+	return nil
+}
+
+func init() {
+	RegisterOutput("pushgateway", newPushgatewaySink)
+}
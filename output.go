@@ -0,0 +1,594 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/digitaljanitors/go-httpstat"
+)
+
+// Timings is the serializable form of an httpstat.Result.
+type Timings struct {
+	DNSLookup        time.Duration `json:"dns_lookup"`
+	TCPConnection    time.Duration `json:"tcp_connection"`
+	TLSHandshake     time.Duration `json:"tls_handshake"`
+	ServerProcessing time.Duration `json:"server_processing"`
+	ContentTransfer  time.Duration `json:"content_transfer"`
+
+	NameLookup    time.Duration `json:"name_lookup"`
+	Connect       time.Duration `json:"connect"`
+	Pretransfer   time.Duration `json:"pretransfer"`
+	StartTransfer time.Duration `json:"start_transfer"`
+	Total         time.Duration `json:"total"`
+}
+
+func NewTimings(stats *httpstat.Result) *Timings {
+	return &Timings{
+		DNSLookup:        stats.DNSLookup,
+		TCPConnection:    stats.TCPConnection,
+		TLSHandshake:     stats.TLSHandshake,
+		ServerProcessing: stats.ServerProcessing,
+		ContentTransfer:  stats.ContentTransfer,
+		NameLookup:       stats.NameLookup,
+		Connect:          stats.Connect,
+		Pretransfer:      stats.Pretransfer,
+		StartTransfer:    stats.StartTransfer,
+		Total:            stats.Total,
+	}
+}
+
+const (
+	KindPlaylist = "playlist"
+	KindSegment  = "segment"
+	KindKey      = "key"
+	KindLicense  = "license"
+	// KindAssetList is an X-ASSET-LIST of an interstitial.
+	KindAssetList = "asset-list"
+	// KindPart is an EXT-X-PART of a low-latency playlist.
+	KindPart = "part"
+	// KindPreloadHint is a part requested from its EXT-X-PRELOAD-HINT.
+	KindPreloadHint = "preload-hint"
+)
+
+// RequestResult is what every output sink receives for each request made,
+// including failed ones, which have an ErrorCategory and no Timings.
+// Playlists also have the number of Segments they list and the time taken
+// to decode them, and with -playlist-accept-encoding the WireBytes they
+// took compressed.
+type RequestResult struct {
+	RunID         string        `json:"run_id"`
+	Kind          string        `json:"kind"`
+	URI           string        `json:"uri"`
+	Range         string        `json:"range,omitempty"`
+	Duration      float64       `json:"duration,omitempty"`
+	Encryption    string        `json:"encryption,omitempty"`
+	Rendition     string        `json:"rendition,omitempty"`
+	Class         string        `json:"class,omitempty"`
+	Tags          []string      `json:"tags,omitempty"`
+	File          string        `json:"file,omitempty"`
+	RequestID     string        `json:"request_id,omitempty"`
+	TraceParent   string        `json:"traceparent,omitempty"`
+	RequestedAt   time.Time     `json:"requested_at"`
+	CompletedAt   time.Time     `json:"completed_at"`
+	StatusCode    int           `json:"status_code,omitempty"`
+	Protocol      string        `json:"protocol,omitempty"`
+	Redirects     []string      `json:"redirects,omitempty"`
+	RedirectTime  time.Duration `json:"redirect_time,omitempty"`
+	Hops          []*Hop        `json:"hops,omitempty"`
+	Edge          string        `json:"edge,omitempty"`
+	POP           string        `json:"pop,omitempty"`
+	Via           []string      `json:"via,omitempty"`
+	Path          string        `json:"path,omitempty"`
+	BaselineRTT   time.Duration `json:"baseline_rtt,omitempty"`
+	CMSD          *CMSD         `json:"cmsd,omitempty"`
+	Header        http.Header   `json:"header,omitempty"`
+	Fields        HeaderFields  `json:"fields,omitempty"`
+	Bytes         int64         `json:"bytes"`
+	Mbps          float64       `json:"mbps,omitempty"`
+	WireBytes     int64         `json:"wire_bytes,omitempty"`
+	Segments      int           `json:"segments,omitempty"`
+	ParseTime     time.Duration `json:"parse_time,omitempty"`
+	Chunks        int           `json:"chunks,omitempty"`
+	MaxChunkGap   time.Duration `json:"max_chunk_gap,omitempty"`
+	Timings       *Timings      `json:"timings,omitempty"`
+	ErrorCategory ErrorCategory `json:"error_category,omitempty"`
+	Error         string        `json:"error,omitempty"`
+}
+
+func NewRequestResult(kind string, segment *SegmentDownload, req *http.Request, requestedAt time.Time) *RequestResult {
+	result := &RequestResult{
+		Kind:        kind,
+		URI:         segment.URI,
+		Range:       segment.Range(),
+		Duration:    segment.Duration,
+		Encryption:  segment.Encryption,
+		Rendition:   segment.Rendition,
+		Class:       segment.Class,
+		Tags:        segment.Tags,
+		TraceParent: req.Header.Get("traceparent"),
+		RequestedAt: requestedAt,
+	}
+	if *requestIDHeader != "" {
+		result.RequestID = req.Header.Get(*requestIDHeader)
+	}
+	return result
+}
+
+func (rr *RequestResult) SetResponse(resp *http.Response, n int64, stats *httpstat.Result) {
+	rr.CompletedAt = time.Now()
+	rr.StatusCode = resp.StatusCode
+	rr.Protocol = resp.Proto
+	rr.setRedirects(resp)
+	rr.Edge = edgeOf(resp)
+	rr.POP = popOf(resp)
+	rr.Via = viaChain(resp.Header)
+	rr.Header = resp.Header
+	rr.Fields = capturedHeaders.fields(resp.Header)
+	rr.CMSD = parseCMSD(resp.Header)
+	rr.Bytes = n
+	rr.Timings = NewTimings(stats)
+	if stats.ContentTransfer > 0 {
+		rr.Mbps = mbps(n, stats.ContentTransfer)
+	}
+}
+
+func (rr *RequestResult) SetError(resp *http.Response, category ErrorCategory, reason string) {
+	rr.CompletedAt = time.Now()
+	if resp != nil {
+		rr.StatusCode = resp.StatusCode
+		rr.Header = resp.Header
+		rr.Fields = capturedHeaders.fields(resp.Header)
+		rr.setRedirects(resp)
+		rr.Edge = edgeOf(resp)
+		rr.POP = popOf(resp)
+		rr.Via = viaChain(resp.Header)
+	}
+	rr.ErrorCategory = category
+	rr.Error = reason
+}
+
+// setRedirects notes the redirects followed on the way to resp.
+func (rr *RequestResult) setRedirects(resp *http.Response) {
+	if chain := redirectsOf(resp.Request); chain != nil {
+		chain.mu.Lock()
+		defer chain.mu.Unlock()
+		if len(chain.URLs) == 0 {
+			return
+		}
+		rr.Redirects = chain.URLs
+		rr.RedirectTime = chain.Elapsed()
+		rr.Hops = chain.Hops
+	}
+}
+
+// RunSummary is handed to every output sink once a run is over.
+type RunSummary struct {
+	RunID         string
+	PlaylistURL   string
+	Start         time.Time
+	End           time.Time
+	Results       ResultSummary
+	Errors        *ErrorSummary
+	History       *PlaylistHistory
+	Defects       *StreamDefects
+	LowLatency    *LowLatencyTracker
+	Parts         *PartStats
+	Hints         *HintTracker
+	Priorities    *PriorityTracker
+	Compression   *CompressionStats
+	Chunks        *ChunkStats
+	Coalescing    *SegmentBreakdown
+	Splits        *SplitStats
+	Redirects     *RedirectStats
+	FirstSegment  *FirstSegmentTracker
+	RTT           *RTTProber
+	LiveLatency   *LiveLatencyTracker
+	Stalls        *StallDetector
+	Purges        *PurgeChecker
+	Alignment     *AlignmentChecker
+	ClockSkew     *ClockSkewTracker
+	Certs         *CertTracker
+	Security      *SecurityHeaderTracker
+	CMSD          *CMSDStats
+	Via           *ViaStats
+	Bandwidth     *BandwidthEstimator
+	Resources     *ResourceMonitor
+	Failover      *FailoverTest
+	Parsing       *ParseStats
+	Keys          *KeyTracker
+	Pairs         *PairTracker
+	TrickPlay     []*TrickPlayResult
+	Renditions    *SegmentBreakdown
+	Classes       *SegmentBreakdown
+	POPs          *SegmentBreakdown
+	CacheStatuses *CacheStatusBreakdown
+	Interstitials *InterstitialTracker
+	Protocols     *ProtocolTracker
+	DialRaces     *DialRaceTracker
+	EarlyHints    *EarlyHintTracker
+	Hook          *HookMetrics
+	// Environment is nil if the run was analyzed from an archive that
+	// didn't record it.
+	Environment *Environment
+}
+
+// OutputSink is implemented by every way of reporting a run. Sinks are only
+// ever called from one goroutine at a time.
+type OutputSink interface {
+	Result(result *RequestResult)
+	Summary(summary *RunSummary)
+	Close() error
+}
+
+type outputFactory func(target string) (OutputSink, error)
+
+var outputFactories = map[string]outputFactory{}
+
+// RegisterOutput makes a sink available to -output as name[:target].
+func RegisterOutput(name string, factory outputFactory) {
+	outputFactories[name] = factory
+}
+
+// outputSpecs is the repeatable -output flag.
+type outputSpecs []string
+
+func (specs *outputSpecs) String() string {
+	return strings.Join(*specs, ", ")
+}
+
+func (specs *outputSpecs) Set(value string) error {
+	name := strings.SplitN(value, ":", 2)[0]
+	if _, ok := outputFactories[name]; !ok {
+		return fmt.Errorf("unknown output %q, choose from %v", name, outputNames())
+	}
+	*specs = append(*specs, value)
+	return nil
+}
+
+func outputNames() []string {
+	var names []string
+	for name := range outputFactories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+var outputs outputSpecs
+
+func init() {
+	flag.Var(&outputs, "output", "`type[:target]` to report results to, can be repeated (default console); types are console, json, csv, parquet and har, whose target is a file or - for stdout, and mail, whose target is a comma separated list of recipients")
+}
+
+// multiSink fans out to several sinks and serializes calls to them.
+type multiSink struct {
+	mu    sync.Mutex
+	sinks []OutputSink
+}
+
+func NewOutputSink(specs []string, extra ...OutputSink) (OutputSink, error) {
+	if len(specs) == 0 {
+		specs = []string{"console"}
+	}
+	ms := &multiSink{}
+	for _, spec := range specs {
+		parts := strings.SplitN(spec, ":", 2)
+		target := ""
+		if len(parts) == 2 {
+			target = parts[1]
+		}
+		sink, err := outputFactories[parts[0]](target)
+		if err != nil {
+			ms.Close()
+			return nil, err
+		}
+		ms.sinks = append(ms.sinks, sink)
+	}
+	ms.sinks = append(ms.sinks, extra...)
+	return ms, nil
+}
+
+func (ms *multiSink) Result(result *RequestResult) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	for _, sink := range ms.sinks {
+		sink.Result(result)
+	}
+}
+
+func (ms *multiSink) Summary(summary *RunSummary) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	for _, sink := range ms.sinks {
+		sink.Summary(summary)
+	}
+}
+
+func (ms *multiSink) Close() error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	var first error
+	for _, sink := range ms.sinks {
+		if err := sink.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// openTarget opens a sink's output file, with "" and "-" meaning stdout.
+func openTarget(target string) (io.WriteCloser, error) {
+	if target == "" || target == "-" {
+		return nopCloser{os.Stdout}, nil
+	}
+	return os.Create(target)
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
+
+// consoleSink logs the summaries. Individual requests are already logged as
+// they happen.
+type consoleSink struct{}
+
+func (consoleSink) Result(*RequestResult) {}
+
+func (consoleSink) Summary(s *RunSummary) {
+	s.Environment.LogSummary()
+	s.Results.LogSummary()
+	s.History.LogSummary()
+	s.Defects.LogSummary()
+	s.LowLatency.LogSummary()
+	s.Parts.LogSummary()
+	s.Hints.LogSummary()
+	s.Priorities.LogSummary()
+	s.Compression.LogSummary()
+	s.Chunks.LogSummary()
+	s.Coalescing.LogSummary()
+	s.Splits.LogSummary()
+	s.Redirects.LogSummary()
+	s.FirstSegment.LogSummary()
+	s.RTT.LogSummary()
+	s.LiveLatency.LogSummary()
+	s.Stalls.LogSummary()
+	s.Purges.LogSummary()
+	s.Alignment.LogSummary()
+	s.ClockSkew.LogSummary()
+	s.Certs.LogSummary()
+	s.Security.LogSummary()
+	s.CMSD.LogSummary()
+	s.Via.LogSummary()
+	s.Bandwidth.LogSummary()
+	s.Resources.LogSummary()
+	s.Failover.LogSummary()
+	s.Parsing.LogSummary()
+	s.Keys.LogSummary()
+	s.Renditions.LogSummary()
+	s.Classes.LogSummary()
+	s.POPs.LogSummary()
+	s.CacheStatuses.LogSummary()
+	s.Pairs.LogSummary()
+	for _, tp := range s.TrickPlay {
+		tp.LogSummary()
+	}
+	s.Protocols.LogSummary()
+	s.DialRaces.LogSummary()
+	s.EarlyHints.LogSummary()
+	s.Hook.LogSummary()
+	s.Errors.LogSummary()
+}
+
+func (consoleSink) Close() error { return nil }
+
+// jsonSink writes a JSON line per request followed by a summary line.
+type jsonSink struct {
+	w   io.WriteCloser
+	enc *json.Encoder
+}
+
+type jsonSummary struct {
+	Kind          string                        `json:"kind"`
+	RunID         string                        `json:"run_id"`
+	PlaylistURL   string                        `json:"playlist_url"`
+	Start         time.Time                     `json:"start"`
+	End           time.Time                     `json:"end"`
+	Requests      int                           `json:"requests"`
+	Minimums      map[string]interface{}        `json:"minimums"`
+	Maximums      map[string]interface{}        `json:"maximums"`
+	Averages      map[string]interface{}        `json:"averages"`
+	Throughput    *ThroughputReport             `json:"throughput,omitempty"`
+	Errors        map[ErrorCategory]*ErrorStats `json:"errors"`
+	Playlist      *PlaylistHistory              `json:"playlist,omitempty"`
+	Defects       []*Defect                     `json:"defects,omitempty"`
+	LowLatency    *LowLatencyReport             `json:"low_latency,omitempty"`
+	Parts         *PartReport                   `json:"parts,omitempty"`
+	PreloadHints  *HintReport                   `json:"preload_hints,omitempty"`
+	Priorities    map[string]*BreakdownReport   `json:"priorities,omitempty"`
+	Compression   map[string]*CompressionReport `json:"compression,omitempty"`
+	Chunks        *ChunkReport                  `json:"chunks,omitempty"`
+	Coalescing    map[string]*BreakdownReport   `json:"coalescing,omitempty"`
+	Splits        *SplitReport                  `json:"range_splits,omitempty"`
+	Redirects     *RedirectReport               `json:"redirects,omitempty"`
+	FirstSegment  *FirstSegment                 `json:"first_segment,omitempty"`
+	RTT           map[string]*RTTReport         `json:"rtt,omitempty"`
+	LiveLatency   *LiveLatencyReport            `json:"live_latency,omitempty"`
+	Stalls        *StallReport                  `json:"stalls,omitempty"`
+	Purges        *PurgeReport                  `json:"expired_segments,omitempty"`
+	Alignment     *AlignmentReport              `json:"alignment,omitempty"`
+	ClockSkew     map[string]*ClockSkewReport   `json:"clock_skew,omitempty"`
+	Certificates  map[string]*ChainReport       `json:"certificates,omitempty"`
+	Security      map[string]HeaderChecks       `json:"security_headers,omitempty"`
+	CMSD          *CMSDReport                   `json:"cmsd,omitempty"`
+	Via           *ViaReport                    `json:"via,omitempty"`
+	Bandwidth     *BandwidthReport              `json:"bandwidth_estimate,omitempty"`
+	Resources     *ResourceReport               `json:"resources,omitempty"`
+	Failover      *FailoverReport               `json:"failover,omitempty"`
+	Parsing       *ParseReport                  `json:"parsing,omitempty"`
+	Keys          *KeyReport                    `json:"keys,omitempty"`
+	Pairs         *PairReport                   `json:"pairs,omitempty"`
+	TrickPlay     []*TrickPlayResult            `json:"trick_play,omitempty"`
+	Renditions    map[string]*BreakdownReport   `json:"renditions,omitempty"`
+	Classes       map[string]*BreakdownReport   `json:"classes,omitempty"`
+	POPs          map[string]*BreakdownReport   `json:"pops,omitempty"`
+	CacheStatuses map[string]*BreakdownReport   `json:"cache_statuses,omitempty"`
+	Interstitials []*Interstitial               `json:"interstitials,omitempty"`
+	Protocols     *ProtocolReport               `json:"protocols,omitempty"`
+	DialRaces     *DialRaceReport               `json:"dial_races,omitempty"`
+	EarlyHints    *EarlyHintReport              `json:"early_hints,omitempty"`
+	HookMetrics   map[string]HookMetric         `json:"hook_metrics,omitempty"`
+	Environment   *Environment                  `json:"environment,omitempty"`
+}
+
+func newJSONSink(target string) (OutputSink, error) {
+	w, err := openTarget(target)
+	if err != nil {
+		return nil, err
+	}
+	return &jsonSink{w: w, enc: json.NewEncoder(w)}, nil
+}
+
+func (js *jsonSink) Result(result *RequestResult) {
+	js.enc.Encode(result)
+}
+
+func newJSONSummary(s *RunSummary) *jsonSummary {
+	return &jsonSummary{
+		Kind:          "summary",
+		RunID:         s.RunID,
+		PlaylistURL:   s.PlaylistURL,
+		Start:         s.Start,
+		End:           s.End,
+		Requests:      len(s.Results.Total),
+		Minimums:      s.Results.Minimums(),
+		Maximums:      s.Results.Maximums(),
+		Averages:      s.Results.Averages(),
+		Throughput:    s.Results.Throughput(),
+		Errors:        s.Errors.Categories,
+		Playlist:      s.History,
+		Defects:       s.Defects.Report(),
+		LowLatency:    s.LowLatency.Report(),
+		Parts:         s.Parts.Report(),
+		PreloadHints:  s.Hints.Report(),
+		Priorities:    s.Priorities.Report(),
+		Compression:   s.Compression.Report(),
+		Chunks:        s.Chunks.Report(),
+		Coalescing:    s.Coalescing.Report(),
+		Splits:        s.Splits.Report(),
+		Redirects:     s.Redirects.Report(),
+		FirstSegment:  s.FirstSegment.Report(),
+		RTT:           s.RTT.Report(),
+		LiveLatency:   s.LiveLatency.Report(),
+		Stalls:        s.Stalls.Report(),
+		Purges:        s.Purges.Report(),
+		Alignment:     s.Alignment.Report(),
+		ClockSkew:     s.ClockSkew.Report(),
+		Certificates:  s.Certs.Report(),
+		Security:      s.Security.Report(),
+		CMSD:          s.CMSD.Report(),
+		Via:           s.Via.Report(),
+		Bandwidth:     s.Bandwidth.Report(),
+		Resources:     s.Resources.Report(),
+		Failover:      s.Failover.Report(),
+		Parsing:       s.Parsing.Report(),
+		Keys:          s.Keys.Report(),
+		Pairs:         s.Pairs.Report(),
+		TrickPlay:     s.TrickPlay,
+		Renditions:    s.Renditions.Report(),
+		Classes:       s.Classes.Report(),
+		POPs:          s.POPs.Report(),
+		CacheStatuses: s.CacheStatuses.Report(),
+		Interstitials: s.Interstitials.Report(),
+		Protocols:     s.Protocols.Report(),
+		DialRaces:     s.DialRaces.Report(),
+		EarlyHints:    s.EarlyHints.Report(),
+		HookMetrics:   s.Hook.Metrics(),
+		Environment:   s.Environment,
+	}
+}
+
+func (js *jsonSink) Summary(s *RunSummary) {
+	js.enc.Encode(newJSONSummary(s))
+}
+
+func (js *jsonSink) Close() error {
+	return js.w.Close()
+}
+
+// csvSink writes a row per request; durations are in milliseconds.
+type csvSink struct {
+	w   io.WriteCloser
+	csv *csv.Writer
+}
+
+var csvHeader = []string{
+	"kind", "uri", "range", "requested_at", "completed_at", "status_code", "bytes",
+	"error_category", "error", "request_id",
+	"dns_lookup_ms", "tcp_connection_ms", "tls_handshake_ms", "server_processing_ms", "content_transfer_ms", "total_ms",
+	"encryption", "rendition", "class", "segments", "parse_ms", "protocol", "pop", "mbps",
+}
+
+func newCSVSink(target string) (OutputSink, error) {
+	w, err := openTarget(target)
+	if err != nil {
+		return nil, err
+	}
+	cs := &csvSink{w: w, csv: csv.NewWriter(w)}
+	cs.csv.Write(append(append([]string(nil), csvHeader...), capturedHeaders.names()...))
+	return cs, nil
+}
+
+func milliseconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds()*1000, 'f', 3, 64)
+}
+
+func (cs *csvSink) Result(r *RequestResult) {
+	row := []string{
+		r.Kind, r.URI, r.Range,
+		r.RequestedAt.Format(time.RFC3339Nano), r.CompletedAt.Format(time.RFC3339Nano),
+		strconv.Itoa(r.StatusCode), strconv.FormatInt(r.Bytes, 10),
+		string(r.ErrorCategory), r.Error, r.RequestID,
+	}
+	t := r.Timings
+	if t == nil {
+		t = &Timings{}
+	}
+	row = append(row,
+		milliseconds(t.DNSLookup), milliseconds(t.TCPConnection), milliseconds(t.TLSHandshake),
+		milliseconds(t.ServerProcessing), milliseconds(t.ContentTransfer), milliseconds(t.Total),
+		r.Encryption, r.Rendition, r.Class, strconv.Itoa(r.Segments), milliseconds(r.ParseTime), r.Protocol, r.POP,
+		strconv.FormatFloat(r.Mbps, 'f', 3, 64))
+	for _, field := range capturedHeaders.names() {
+		row = append(row, r.Fields[field])
+	}
+	cs.csv.Write(row)
+}
+
+func (cs *csvSink) Summary(*RunSummary) {}
+
+func (cs *csvSink) Close() error {
+	cs.csv.Flush()
+	if err := cs.csv.Error(); err != nil {
+		cs.w.Close()
+		return err
+	}
+	return cs.w.Close()
+}
+
+func init() {
+	RegisterOutput("console", func(string) (OutputSink, error) { return consoleSink{}, nil })
+	RegisterOutput("json", newJSONSink)
+	RegisterOutput("csv", newCSVSink)
+}
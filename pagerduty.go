@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const pagerDutyEventsURL = "https://events.pagerduty.com/v2/enqueue"
+
+// alertClient sends alerts outside of the benchmark's own client.
+var alertClient = &http.Client{Timeout: 30 * time.Second}
+
+type pagerDutyPayload struct {
+	Summary       string                 `json:"summary"`
+	Source        string                 `json:"source"`
+	Severity      string                 `json:"severity"`
+	Timestamp     time.Time              `json:"timestamp"`
+	Component     string                 `json:"component,omitempty"`
+	CustomDetails map[string]interface{} `json:"custom_details,omitempty"`
+}
+
+type pagerDutyEvent struct {
+	RoutingKey  string            `json:"routing_key"`
+	EventAction string            `json:"event_action"`
+	DedupKey    string            `json:"dedup_key"`
+	Payload     *pagerDutyPayload `json:"payload,omitempty"`
+}
+
+// pagerDutyRetry is how long to wait before sending an alert again after
+// PagerDuty couldn't be reached.
+const pagerDutyRetry = time.Minute
+
+// pagerDutySink triggers a PagerDuty alert the first time the SLA is
+// breached during a run and resolves it at the end of a run that stayed
+// within the SLA. Alerts are deduplicated on the -label, so a canary
+// breaching on every scheduled run keeps a single incident open. Its target
+// is the integration's routing key, or empty to use PAGERDUTY_ROUTING_KEY.
+type pagerDutySink struct {
+	routingKey string
+	label      string
+	sla        slaMonitor
+	// breach is the first SLA breach of the run, and triggered whether
+	// PagerDuty was alerted of it, which is tried again from retryAt on if
+	// it couldn't be.
+	breach    string
+	triggered bool
+	retryAt   time.Time
+	// traceID is the run's, from the traceparent of its results.
+	traceID string
+}
+
+func newPagerDutySink(target string) (OutputSink, error) {
+	if target == "" {
+		target = os.Getenv("PAGERDUTY_ROUTING_KEY")
+	}
+	if target == "" {
+		return nil, fmt.Errorf("-output pagerduty needs a routing key, e.g. pagerduty:KEY or PAGERDUTY_ROUTING_KEY")
+	}
+	if !slaEnabled() {
+		return nil, fmt.Errorf("-output pagerduty needs -sla-error-rate, -sla-segment-time, -sla-playlist-bytes or -sla-playlist-segments")
+	}
+	return &pagerDutySink{routingKey: target}, nil
+}
+
+func (pd *pagerDutySink) send(action string, payload *pagerDutyPayload) error {
+	body, err := json.Marshal(&pagerDutyEvent{
+		RoutingKey:  pd.routingKey,
+		EventAction: action,
+		DedupKey:    "hlsbenchmark/" + pd.label,
+		Payload:     payload,
+	})
+	if err != nil {
+		return err
+	}
+	resp, err := alertClient.Post(pagerDutyEventsURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if !isSuccess(resp) {
+		return fmt.Errorf("PagerDuty returned HTTP %v", resp.StatusCode)
+	}
+	return nil
+}
+
+// trigger alerts PagerDuty of the breach, unless it already was or the last
+// attempt failed too recently.
+func (pd *pagerDutySink) trigger(runID, lastURI string) {
+	if pd.triggered || time.Now().Before(pd.retryAt) {
+		return
+	}
+	hostname, _ := os.Hostname()
+	err := pd.send("trigger", &pagerDutyPayload{
+		Summary:   fmt.Sprintf("%v: %v", pd.label, pd.breach),
+		Source:    hostname,
+		Severity:  "error",
+		Timestamp: time.Now(),
+		Component: pd.label,
+		CustomDetails: map[string]interface{}{
+			"requests": pd.sla.requests,
+			"failures": pd.sla.failures,
+			"last_uri": lastURI,
+			"run_id":   runID,
+			"trace_id": pd.traceID,
+		},
+	})
+	if err != nil {
+		pd.retryAt = time.Now().Add(pagerDutyRetry)
+		log.Errorf("Sending PagerDuty alert: %v", err)
+		return
+	}
+	pd.triggered = true
+	log.WithField("Label", pd.label).Warnf("SLA breached, PagerDuty alert sent: %v", pd.breach)
+}
+
+func (pd *pagerDutySink) Result(result *RequestResult) {
+	// The first result is always of the playlist itself.
+	if pd.label == "" {
+		pd.label = runLabel(result.URI)
+	}
+	if pd.traceID == "" {
+		pd.traceID = traceIDOf(result.TraceParent)
+	}
+	if breach := pd.sla.Observe(result); breach != "" && pd.breach == "" {
+		pd.breach = breach
+	}
+	if pd.breach != "" {
+		pd.trigger(result.RunID, result.URI)
+	}
+}
+
+// Summary resolves the alert if the run stayed within the SLA, and otherwise
+// makes a last attempt at alerting if that failed so far.
+func (pd *pagerDutySink) Summary(s *RunSummary) {
+	pd.label = runLabel(s.PlaylistURL)
+	if pd.breach != "" {
+		pd.retryAt = time.Time{}
+		pd.trigger(s.RunID, "")
+		return
+	}
+	if pd.sla.requests < *slaMinimum {
+		return
+	}
+	if err := pd.send("resolve", nil); err != nil {
+		log.Errorf("Resolving PagerDuty alert: %v", err)
+	}
+}
+
+func (pd *pagerDutySink) Close() error { return nil }
+
+func init() {
+	RegisterOutput("pagerduty", newPagerDutySink)
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/grafov/m3u8"
+	"github.com/sirupsen/logrus"
+	log "github.com/sirupsen/logrus"
+)
+
+// pairTolerance absorbs rounding in EXTINF durations when lining up the
+// segments of different renditions.
+const pairTolerance = 0.001
+
+// hasProgramDateTime reports whether mpl dates its segments.
+func hasProgramDateTime(mpl *m3u8.MediaPlaylist) bool {
+	for _, s := range mpl.Segments {
+		if s != nil {
+			return !s.ProgramDateTime.IsZero()
+		}
+	}
+	return false
+}
+
+// segmentTimes returns when every segment of mpl starts, in seconds: since
+// the epoch going by EXT-X-PROGRAM-DATE-TIME if dated, or else since the
+// start of the playlist, which only lines up with other renditions in VOD
+// playlists.
+func segmentTimes(mpl *m3u8.MediaPlaylist, dated bool) []float64 {
+	times := make([]float64, len(mpl.Segments))
+	var t float64
+	for i, s := range mpl.Segments {
+		if s == nil {
+			continue
+		}
+		if dated && !s.ProgramDateTime.IsZero() {
+			t = float64(s.ProgramDateTime.UnixNano()) / 1e9
+		}
+		times[i] = t
+		t += s.Duration
+	}
+	return times
+}
+
+// pairSegments attaches every segment of a rendition to the video segment
+// during which it starts, and returns those starting outside all of them.
+func pairSegments(video, rendition []*SegmentDownload) []*SegmentDownload {
+	var unpaired []*SegmentDownload
+	i := 0
+	for _, a := range rendition {
+		for i < len(video) && video[i].MediaTime+video[i].Duration <= a.MediaTime+pairTolerance {
+			i++
+		}
+		if i == len(video) || a.MediaTime+pairTolerance < video[i].MediaTime {
+			unpaired = append(unpaired, a)
+			continue
+		}
+		video[i].Paired = append(video[i].Paired, a)
+	}
+	return unpaired
+}
+
+// downloadPaired fetches v together with the segments paired with it, as a
+// player of a demuxed stream needs all of them before it can render, and
+// returns the timings of those that succeeded.
+func downloadPaired(run *Run, store *SegmentStore, v *SegmentDownload) []*RequestResult {
+	if len(v.Paired) == 0 {
+		if result := downloadSegment(run, store, v); result != nil {
+			return []*RequestResult{result}
+		}
+		return nil
+	}
+
+	group := append([]*SegmentDownload{v}, v.Paired...)
+	all := make([]*RequestResult, len(group))
+	started := time.Now()
+	var wg sync.WaitGroup
+	for i, s := range group {
+		wg.Add(1)
+		go func(i int, s *SegmentDownload) {
+			defer wg.Done()
+			all[i] = downloadSegment(run, store, s)
+		}(i, s)
+	}
+	wg.Wait()
+	elapsed := time.Since(started)
+
+	var done []*RequestResult
+	for _, result := range all {
+		if result != nil {
+			done = append(done, result)
+		}
+	}
+	if len(done) < len(group) {
+		run.Pairs.Failed()
+		return done
+	}
+	late := run.Pairs.Completed(elapsed, v.Duration)
+	lvl := logrus.InfoLevel
+	if late {
+		lvl = logrus.WarnLevel
+	}
+	log.WithField("Segments", len(group)).
+		WithField("Total", elapsed).
+		Logf(lvl, "Downloaded %v and the %d segments paired with it", v.URI, len(v.Paired))
+	return done
+}
+
+// PairTracker times how long it takes to have every rendition of a stretch
+// of media downloaded.
+type PairTracker struct {
+	mu       sync.Mutex
+	Times    []time.Duration
+	Failures int
+	// Late counts pairs that took longer than the media they hold.
+	Late int
+}
+
+func NewPairTracker() *PairTracker {
+	return &PairTracker{}
+}
+
+// Completed records a pair downloaded in d and reports whether that was
+// slower than real time.
+func (pt *PairTracker) Completed(d time.Duration, mediaDuration float64) bool {
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+	pt.Times = append(pt.Times, d)
+	late := d >= time.Duration(mediaDuration*float64(time.Second))
+	if late {
+		pt.Late++
+	}
+	return late
+}
+
+func (pt *PairTracker) Failed() {
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+	pt.Failures++
+}
+
+// PairReport summarizes a PairTracker.
+type PairReport struct {
+	Pairs    int                      `json:"pairs"`
+	Failures int                      `json:"failures"`
+	Late     int                      `json:"late"`
+	Latency  map[string]time.Duration `json:"latency,omitempty"`
+}
+
+// Report returns nil if no segments were paired, as in muxed streams.
+func (pt *PairTracker) Report() *PairReport {
+	if pt == nil {
+		return nil
+	}
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+	if len(pt.Times)+pt.Failures == 0 {
+		return nil
+	}
+	return &PairReport{
+		Pairs:    len(pt.Times),
+		Failures: pt.Failures,
+		Late:     pt.Late,
+		Latency:  latencyPercentiles(pt.Times),
+	}
+}
+
+func (pt *PairTracker) LogSummary() {
+	r := pt.Report()
+	if r == nil {
+		return
+	}
+	entry := log.WithField("Pairs", r.Pairs).
+		WithField("Failures", r.Failures).
+		WithField("Late", r.Late)
+	for name, d := range r.Latency {
+		entry = entry.WithField("Latency"+name, d)
+	}
+	entry.Info("Paired segments")
+}
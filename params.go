@@ -0,0 +1,63 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type queryParam struct {
+	key, value string
+}
+
+// queryParams is a repeatable flag of key=value pairs appended to the query
+// string of every request. Values may use {timestamp} and {timestamp_ms},
+// which are replaced with the current Unix time when the request is sent.
+type queryParams []queryParam
+
+func (qp *queryParams) String() string {
+	var params []string
+	for _, p := range *qp {
+		params = append(params, p.key+"="+p.value)
+	}
+	return strings.Join(params, ", ")
+}
+
+func (qp *queryParams) Set(value string) error {
+	parts := strings.SplitN(value, "=", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return fmt.Errorf("param %q is not of the form key=value", value)
+	}
+	*qp = append(*qp, queryParam{parts[0], parts[1]})
+	return nil
+}
+
+// Apply appends the parameters to u without re-encoding the existing query,
+// which could break signed URLs.
+func (qp queryParams) Apply(u *url.URL) {
+	if len(qp) == 0 {
+		return
+	}
+	now := time.Now()
+	template := strings.NewReplacer(
+		"{timestamp}", strconv.FormatInt(now.Unix(), 10),
+		"{timestamp_ms}", strconv.FormatInt(now.UnixNano()/int64(time.Millisecond), 10),
+	)
+	query := u.RawQuery
+	for _, p := range qp {
+		if query != "" {
+			query += "&"
+		}
+		query += url.QueryEscape(p.key) + "=" + url.QueryEscape(template.Replace(p.value))
+	}
+	u.RawQuery = query
+}
+
+var extraParams queryParams
+
+func init() {
+	flag.Var(&extraParams, "param", "`key=value` appended to the query of every playlist and segment URL, can be repeated; {timestamp} and {timestamp_ms} in the value are replaced with the current time")
+}
@@ -0,0 +1,371 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"io"
+	"math"
+	"time"
+)
+
+// parquetRowGroupRows is how many requests are buffered into every row
+// group of a Parquet file.
+const parquetRowGroupRows = 100000
+
+// The parts of the Parquet format used: its physical types, the converted
+// types of strings and timestamps, and the PLAIN, RLE and GZIP codes.
+const (
+	parquetInt32     = 1
+	parquetInt64     = 2
+	parquetDouble    = 5
+	parquetByteArray = 6
+
+	parquetUTF8            = 0
+	parquetTimestampMicros = 10
+
+	parquetPlain = 0
+	parquetRLE   = 3
+	parquetGzip  = 2
+)
+
+// The types of the Thrift compact protocol Parquet's metadata is written
+// in.
+const (
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// thriftWriter writes structs in the Thrift compact protocol.
+type thriftWriter struct {
+	bytes.Buffer
+	last []int16
+}
+
+func newThriftWriter() *thriftWriter {
+	return &thriftWriter{last: []int16{0}}
+}
+
+func (tw *thriftWriter) varint(v uint64) {
+	var b [binary.MaxVarintLen64]byte
+	tw.Write(b[:binary.PutUvarint(b[:], v)])
+}
+
+func (tw *thriftWriter) zigzag(v int64) {
+	tw.varint(uint64((v << 1) ^ (v >> 63)))
+}
+
+func (tw *thriftWriter) field(id int16, typ byte) {
+	top := len(tw.last) - 1
+	if delta := id - tw.last[top]; delta > 0 && delta <= 15 {
+		tw.WriteByte(byte(delta)<<4 | typ)
+	} else {
+		tw.WriteByte(typ)
+		tw.zigzag(int64(id))
+	}
+	tw.last[top] = id
+}
+
+func (tw *thriftWriter) i32(id int16, v int32) {
+	tw.field(id, thriftI32)
+	tw.zigzag(int64(v))
+}
+
+func (tw *thriftWriter) i64(id int16, v int64) {
+	tw.field(id, thriftI64)
+	tw.zigzag(v)
+}
+
+func (tw *thriftWriter) str(s string) {
+	tw.varint(uint64(len(s)))
+	tw.WriteString(s)
+}
+
+func (tw *thriftWriter) binary(id int16, s string) {
+	tw.field(id, thriftBinary)
+	tw.str(s)
+}
+
+// list starts a list of n elements of typ.
+func (tw *thriftWriter) list(id int16, typ byte, n int) {
+	tw.field(id, thriftList)
+	if n < 15 {
+		tw.WriteByte(byte(n)<<4 | typ)
+		return
+	}
+	tw.WriteByte(0xf0 | typ)
+	tw.varint(uint64(n))
+}
+
+// begin starts a struct, either a field if id isn't 0 or an element of a
+// list.
+func (tw *thriftWriter) begin(id int16) {
+	if id != 0 {
+		tw.field(id, thriftStruct)
+	}
+	tw.last = append(tw.last, 0)
+}
+
+func (tw *thriftWriter) end() {
+	tw.WriteByte(0)
+	tw.last = tw.last[:len(tw.last)-1]
+}
+
+// parquetColumn is a required column whose values are PLAIN encoded.
+type parquetColumn struct {
+	name      string
+	typ       int32
+	converted int32
+	values    bytes.Buffer
+}
+
+func (pc *parquetColumn) addString(s string) {
+	binary.Write(&pc.values, binary.LittleEndian, uint32(len(s)))
+	pc.values.WriteString(s)
+}
+
+func (pc *parquetColumn) addInt32(v int32) {
+	binary.Write(&pc.values, binary.LittleEndian, v)
+}
+
+func (pc *parquetColumn) addInt64(v int64) {
+	binary.Write(&pc.values, binary.LittleEndian, v)
+}
+
+func (pc *parquetColumn) addDouble(v float64) {
+	binary.Write(&pc.values, binary.LittleEndian, math.Float64bits(v))
+}
+
+// addTime adds t in microseconds since the epoch, 0 if it is unset.
+func (pc *parquetColumn) addTime(t time.Time) {
+	if t.IsZero() {
+		pc.addInt64(0)
+		return
+	}
+	pc.addInt64(t.UnixNano() / 1000)
+}
+
+// columnChunk is where a column of a row group was written.
+type columnChunk struct {
+	column       *parquetColumn
+	offset       int64
+	uncompressed int64
+	compressed   int64
+}
+
+type rowGroup struct {
+	chunks []columnChunk
+	rows   int64
+}
+
+// parquetSink writes a row per request to a Parquet file, as the csv output
+// does, for analysis with DuckDB or Spark. Every column is required, with
+// empty strings and zeroes for what a request lacks, and every row group
+// has a single GZIP compressed page per column.
+type parquetSink struct {
+	w       io.WriteCloser
+	written int64
+	err     error
+	columns []*parquetColumn
+	fields  []string
+	rows    int64
+	groups  []rowGroup
+}
+
+func newParquetSink(target string) (OutputSink, error) {
+	w, err := openTarget(target)
+	if err != nil {
+		return nil, err
+	}
+	ps := &parquetSink{w: w, fields: capturedHeaders.names()}
+	str := func(name string) *parquetColumn {
+		return &parquetColumn{name: name, typ: parquetByteArray, converted: parquetUTF8}
+	}
+	ps.columns = []*parquetColumn{
+		str("run_id"), str("kind"), str("uri"), str("range"),
+		{name: "requested_at", typ: parquetInt64, converted: parquetTimestampMicros},
+		{name: "completed_at", typ: parquetInt64, converted: parquetTimestampMicros},
+		{name: "status_code", typ: parquetInt32, converted: -1},
+		{name: "bytes", typ: parquetInt64, converted: -1},
+		str("error_category"), str("error"), str("request_id"),
+	}
+	for _, name := range []string{"dns_lookup_ms", "tcp_connection_ms", "tls_handshake_ms", "server_processing_ms", "content_transfer_ms", "total_ms"} {
+		ps.columns = append(ps.columns, &parquetColumn{name: name, typ: parquetDouble, converted: -1})
+	}
+	ps.columns = append(ps.columns,
+		str("encryption"), str("rendition"), str("class"),
+		&parquetColumn{name: "segments", typ: parquetInt32, converted: -1},
+		&parquetColumn{name: "parse_ms", typ: parquetDouble, converted: -1},
+		str("protocol"), str("pop"),
+		&parquetColumn{name: "mbps", typ: parquetDouble, converted: -1})
+	for _, field := range ps.fields {
+		ps.columns = append(ps.columns, str(field))
+	}
+	ps.write([]byte("PAR1"))
+	return ps, nil
+}
+
+func (ps *parquetSink) write(b []byte) {
+	if ps.err != nil {
+		return
+	}
+	var n int
+	n, ps.err = ps.w.Write(b)
+	ps.written += int64(n)
+}
+
+func (ps *parquetSink) Result(r *RequestResult) {
+	t := r.Timings
+	if t == nil {
+		t = &Timings{}
+	}
+	c := ps.columns
+	c[0].addString(r.RunID)
+	c[1].addString(r.Kind)
+	c[2].addString(r.URI)
+	c[3].addString(r.Range)
+	c[4].addTime(r.RequestedAt)
+	c[5].addTime(r.CompletedAt)
+	c[6].addInt32(int32(r.StatusCode))
+	c[7].addInt64(r.Bytes)
+	c[8].addString(string(r.ErrorCategory))
+	c[9].addString(r.Error)
+	c[10].addString(r.RequestID)
+	for i, d := range []time.Duration{t.DNSLookup, t.TCPConnection, t.TLSHandshake, t.ServerProcessing, t.ContentTransfer, t.Total} {
+		c[11+i].addDouble(d.Seconds() * 1000)
+	}
+	c[17].addString(r.Encryption)
+	c[18].addString(r.Rendition)
+	c[19].addString(r.Class)
+	c[20].addInt32(int32(r.Segments))
+	c[21].addDouble(r.ParseTime.Seconds() * 1000)
+	c[22].addString(r.Protocol)
+	c[23].addString(r.POP)
+	c[24].addDouble(r.Mbps)
+	for i, field := range ps.fields {
+		c[25+i].addString(r.Fields[field])
+	}
+	ps.rows++
+	if ps.rows%parquetRowGroupRows == 0 {
+		ps.flush()
+	}
+}
+
+// flush writes the rows buffered since the last row group as another.
+func (ps *parquetSink) flush() {
+	rows := ps.rows
+	for _, g := range ps.groups {
+		rows -= g.rows
+	}
+	if rows == 0 {
+		return
+	}
+	group := rowGroup{rows: rows}
+	for _, c := range ps.columns {
+		var compressed bytes.Buffer
+		zw := gzip.NewWriter(&compressed)
+		zw.Write(c.values.Bytes())
+		zw.Close()
+
+		header := newThriftWriter()
+		header.i32(1, 0) // DATA_PAGE
+		header.i32(2, int32(c.values.Len()))
+		header.i32(3, int32(compressed.Len()))
+		header.begin(5)
+		header.i32(1, int32(rows))
+		header.i32(2, parquetPlain)
+		header.i32(3, parquetRLE)
+		header.i32(4, parquetRLE)
+		header.end()
+		header.end()
+
+		chunk := columnChunk{
+			column:       c,
+			offset:       ps.written,
+			uncompressed: int64(header.Len() + c.values.Len()),
+			compressed:   int64(header.Len() + compressed.Len()),
+		}
+		ps.write(header.Bytes())
+		ps.write(compressed.Bytes())
+		group.chunks = append(group.chunks, chunk)
+		c.values.Reset()
+	}
+	ps.groups = append(ps.groups, group)
+}
+
+func (ps *parquetSink) Summary(*RunSummary) {}
+
+// footer returns the FileMetaData of the file.
+func (ps *parquetSink) footer() []byte {
+	tw := newThriftWriter()
+	tw.i32(1, 1)
+	tw.list(2, thriftStruct, len(ps.columns)+1)
+	tw.begin(0)
+	tw.binary(4, "schema")
+	tw.i32(5, int32(len(ps.columns)))
+	tw.end()
+	for _, c := range ps.columns {
+		tw.begin(0)
+		tw.i32(1, c.typ)
+		tw.i32(3, 0) // REQUIRED
+		tw.binary(4, c.name)
+		if c.converted >= 0 {
+			tw.i32(6, c.converted)
+		}
+		tw.end()
+	}
+	tw.i64(3, ps.rows)
+	tw.list(4, thriftStruct, len(ps.groups))
+	for _, g := range ps.groups {
+		tw.begin(0)
+		tw.list(1, thriftStruct, len(g.chunks))
+		var total int64
+		for _, chunk := range g.chunks {
+			total += chunk.uncompressed
+			tw.begin(0)
+			tw.i64(2, chunk.offset)
+			tw.begin(3)
+			tw.i32(1, chunk.column.typ)
+			tw.list(2, thriftI32, 2)
+			tw.zigzag(parquetPlain)
+			tw.zigzag(parquetRLE)
+			tw.list(3, thriftBinary, 1)
+			tw.str(chunk.column.name)
+			tw.i32(4, parquetGzip)
+			tw.i64(5, g.rows)
+			tw.i64(6, chunk.uncompressed)
+			tw.i64(7, chunk.compressed)
+			tw.i64(9, chunk.offset)
+			tw.end()
+			tw.end()
+		}
+		tw.i64(2, total)
+		tw.i64(3, g.rows)
+		tw.end()
+	}
+	tw.binary(6, "hlsbenchmark")
+	tw.end()
+	return tw.Bytes()
+}
+
+func (ps *parquetSink) Close() error {
+	ps.flush()
+	footer := ps.footer()
+	ps.write(footer)
+	var length [4]byte
+	binary.LittleEndian.PutUint32(length[:], uint32(len(footer)))
+	ps.write(length[:])
+	ps.write([]byte("PAR1"))
+	if ps.err != nil {
+		ps.w.Close()
+		return ps.err
+	}
+	return ps.w.Close()
+}
+
+func init() {
+	RegisterOutput("parquet", newParquetSink)
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// thriftReader reads the Thrift compact protocol, decoding structs into
+// their fields by ID: integers as int64, binaries as strings, lists as
+// slices and structs as maps.
+type thriftReader struct {
+	b   []byte
+	pos int
+}
+
+func (tr *thriftReader) byte() byte {
+	b := tr.b[tr.pos]
+	tr.pos++
+	return b
+}
+
+func (tr *thriftReader) varint() uint64 {
+	v, n := binary.Uvarint(tr.b[tr.pos:])
+	tr.pos += n
+	return v
+}
+
+func (tr *thriftReader) zigzag() int64 {
+	v := tr.varint()
+	return int64(v>>1) ^ -int64(v&1)
+}
+
+func (tr *thriftReader) value(typ byte) interface{} {
+	switch typ {
+	case thriftI32, thriftI64:
+		return tr.zigzag()
+	case thriftBinary:
+		n := int(tr.varint())
+		s := string(tr.b[tr.pos : tr.pos+n])
+		tr.pos += n
+		return s
+	case thriftList:
+		header := tr.byte()
+		n, elem := int(header>>4), header&0x0f
+		if n == 15 {
+			n = int(tr.varint())
+		}
+		list := make([]interface{}, n)
+		for i := range list {
+			list[i] = tr.value(elem)
+		}
+		return list
+	case thriftStruct:
+		return tr.structure()
+	}
+	panic("unexpected Thrift type")
+}
+
+func (tr *thriftReader) structure() map[int16]interface{} {
+	fields := map[int16]interface{}{}
+	var last int16
+	for {
+		header := tr.byte()
+		if header == 0 {
+			return fields
+		}
+		id := last + int16(header>>4)
+		if header>>4 == 0 {
+			id = int16(tr.zigzag())
+		}
+		last = id
+		fields[id] = tr.value(header & 0x0f)
+	}
+}
+
+func TestParquetSink(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "results.parquet")
+	sink, err := newParquetSink(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ps := sink.(*parquetSink)
+	at := time.Date(2024, 6, 3, 10, 0, 0, 0, time.UTC)
+	results := []*RequestResult{
+		{RunID: "01ARZ3NDEKTSV4RRFFQ69G5FAV", Kind: KindPlaylist, URI: "https://cdn.example.com/index.m3u8", RequestedAt: at, StatusCode: 200, Bytes: 512},
+		{RunID: "01ARZ3NDEKTSV4RRFFQ69G5FAV", Kind: KindSegment, URI: "https://cdn.example.com/seg1.ts", Range: "0-999", RequestedAt: at.Add(time.Second), StatusCode: 206, Bytes: 1000, Timings: &Timings{Total: 1500 * time.Microsecond}},
+		{RunID: "01ARZ3NDEKTSV4RRFFQ69G5FAV", Kind: KindSegment, URI: "https://cdn.example.com/seg2.ts", RequestedAt: at.Add(2 * time.Second), StatusCode: 503, ErrorCategory: "5xx", Error: "Service Unavailable"},
+	}
+	// Two row groups, of two rows and one.
+	ps.Result(results[0])
+	ps.Result(results[1])
+	ps.flush()
+	ps.Result(results[2])
+	if err := ps.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data[:4]) != "PAR1" || string(data[len(data)-4:]) != "PAR1" {
+		t.Fatalf("file starts with %q and ends with %q, want PAR1", data[:4], data[len(data)-4:])
+	}
+	footerLength := int(binary.LittleEndian.Uint32(data[len(data)-8:]))
+	footerStart := len(data) - 8 - footerLength
+	footer := &thriftReader{b: data[footerStart : len(data)-8]}
+	meta := footer.structure()
+	if footer.pos != footerLength {
+		t.Errorf("footer of %d bytes read as %d", footerLength, footer.pos)
+	}
+
+	if meta[1] != int64(1) {
+		t.Errorf("version = %v, want 1", meta[1])
+	}
+	if meta[3] != int64(len(results)) {
+		t.Errorf("num_rows = %v, want %d", meta[3], len(results))
+	}
+	schema := meta[2].([]interface{})
+	if len(schema) != len(ps.columns)+1 {
+		t.Fatalf("%d schema elements, want %d", len(schema), len(ps.columns)+1)
+	}
+	root := schema[0].(map[int16]interface{})
+	if root[4] != "schema" || root[5] != int64(len(ps.columns)) {
+		t.Errorf("schema root = %v, want schema with %d children", root, len(ps.columns))
+	}
+	wantSchema := map[string][2]int64{
+		"run_id":       {parquetByteArray, parquetUTF8},
+		"requested_at": {parquetInt64, parquetTimestampMicros},
+		"status_code":  {parquetInt32, -1},
+		"total_ms":     {parquetDouble, -1},
+	}
+	for i, element := range schema[1:] {
+		e := element.(map[int16]interface{})
+		if e[4] != ps.columns[i].name {
+			t.Errorf("schema element %d is %v, want %v", i+1, e[4], ps.columns[i].name)
+		}
+		if e[3] != int64(0) {
+			t.Errorf("%v repetition = %v, want REQUIRED", e[4], e[3])
+		}
+		if want, ok := wantSchema[e[4].(string)]; ok {
+			converted, ok := e[6].(int64)
+			if !ok {
+				converted = -1
+			}
+			if e[1] != want[0] || converted != want[1] {
+				t.Errorf("%v type = %v, converted %v, want %v", e[4], e[1], converted, want)
+			}
+		}
+	}
+
+	groups := meta[4].([]interface{})
+	if len(groups) != 2 {
+		t.Fatalf("%d row groups, want 2", len(groups))
+	}
+	// Column chunks follow each other from the magic on, up to the footer.
+	offset := int64(4)
+	var rows []int64
+	uris := []string{}
+	for _, group := range groups {
+		g := group.(map[int16]interface{})
+		n := g[3].(int64)
+		rows = append(rows, n)
+		chunks := g[1].([]interface{})
+		if len(chunks) != len(ps.columns) {
+			t.Fatalf("%d column chunks, want %d", len(chunks), len(ps.columns))
+		}
+		for i, chunk := range chunks {
+			c := chunk.(map[int16]interface{})
+			md := c[3].(map[int16]interface{})
+			if c[2] != offset || md[9] != offset {
+				t.Errorf("column %v at %v, data page at %v, want %d", ps.columns[i].name, c[2], md[9], offset)
+			}
+			if path := md[3].([]interface{}); len(path) != 1 || path[0] != ps.columns[i].name {
+				t.Errorf("column chunk %d has path %v, want %v", i, path, ps.columns[i].name)
+			}
+			if md[5] != n {
+				t.Errorf("column %v has %v values, want %d", ps.columns[i].name, md[5], n)
+			}
+
+			page := &thriftReader{b: data[offset:]}
+			header := page.structure()
+			values := header[5].(map[int16]interface{})
+			if header[1] != int64(0) || values[1] != n {
+				t.Errorf("column %v page is of type %v with %v values, want a data page of %d", ps.columns[i].name, header[1], values[1], n)
+			}
+			compressed := int(header[3].(int64))
+			if int64(page.pos+compressed) != md[7] {
+				t.Errorf("column %v is %d bytes, want %v", ps.columns[i].name, page.pos+compressed, md[7])
+			}
+			zr, err := gzip.NewReader(bytes.NewReader(data[offset+int64(page.pos) : offset+int64(page.pos+compressed)]))
+			if err != nil {
+				t.Fatal(err)
+			}
+			plain, err := ioutil.ReadAll(zr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if int64(len(plain)) != header[2] {
+				t.Errorf("column %v decompressed to %d bytes, want %v", ps.columns[i].name, len(plain), header[2])
+			}
+			switch ps.columns[i].name {
+			case "uri":
+				for len(plain) > 0 {
+					length := binary.LittleEndian.Uint32(plain)
+					uris = append(uris, string(plain[4:4+length]))
+					plain = plain[4+length:]
+				}
+			case "requested_at":
+				if got := int64(binary.LittleEndian.Uint64(plain)); got != at.UnixNano()/1000 && got != at.Add(2*time.Second).UnixNano()/1000 {
+					t.Errorf("requested_at = %d", got)
+				}
+			case "total_ms":
+				if n == 2 {
+					if got := math.Float64frombits(binary.LittleEndian.Uint64(plain[8:])); got != 1.5 {
+						t.Errorf("total_ms of the second row = %v, want 1.5", got)
+					}
+				}
+			}
+			offset = c[2].(int64) + md[7].(int64)
+		}
+	}
+	if offset != int64(footerStart) {
+		t.Errorf("column chunks end at %d, footer starts at %d", offset, footerStart)
+	}
+	if len(rows) != 2 || rows[0] != 2 || rows[1] != 1 {
+		t.Errorf("row groups of %v rows, want [2 1]", rows)
+	}
+	for i, r := range results {
+		if i >= len(uris) || uris[i] != r.URI {
+			t.Errorf("uris = %q, want %v first", uris, r.URI)
+			break
+		}
+	}
+}
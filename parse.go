@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grafov/m3u8"
+	log "github.com/sirupsen/logrus"
+)
+
+// parseModeFlag is the -parse-mode flag.
+type parseModeFlag string
+
+func (pm *parseModeFlag) String() string {
+	return string(*pm)
+}
+
+func (pm *parseModeFlag) Set(value string) error {
+	switch value {
+	case "", "strict", "lenient":
+		*pm = parseModeFlag(value)
+		return nil
+	}
+	return fmt.Errorf("parse mode %q is neither strict nor lenient", value)
+}
+
+var parseMode parseModeFlag
+
+func init() {
+	flag.Var(&parseMode, "parse-mode", "`mode` of parsing playlists: strict fails on unknown and duplicate tags, lenient tolerates malformed tags and reports unknown ones with the segments they precede (default the decoder's own checks)")
+}
+
+// knownTags are the tags of the HLS spec, with whether a playlist may only
+// have one of them.
+var knownTags = map[string]bool{
+	"EXTM3U": true, "EXT-X-VERSION": true, "EXT-X-INDEPENDENT-SEGMENTS": true, "EXT-X-START": true, "EXT-X-DEFINE": false,
+	"EXT-X-TARGETDURATION": true, "EXT-X-MEDIA-SEQUENCE": true, "EXT-X-DISCONTINUITY-SEQUENCE": true, "EXT-X-ENDLIST": true,
+	"EXT-X-PLAYLIST-TYPE": true, "EXT-X-I-FRAMES-ONLY": true, "EXT-X-PART-INF": true, "EXT-X-SERVER-CONTROL": true,
+	"EXTINF": false, "EXT-X-BYTERANGE": false, "EXT-X-DISCONTINUITY": false, "EXT-X-KEY": false, "EXT-X-MAP": false,
+	"EXT-X-PROGRAM-DATE-TIME": false, "EXT-X-GAP": false, "EXT-X-BITRATE": false, "EXT-X-PART": false,
+	"EXT-X-DATERANGE": false, "EXT-X-SKIP": true, "EXT-X-PRELOAD-HINT": false, "EXT-X-RENDITION-REPORT": false,
+	"EXT-X-MEDIA": false, "EXT-X-STREAM-INF": false, "EXT-X-I-FRAME-STREAM-INF": false, "EXT-X-SESSION-DATA": false,
+	"EXT-X-SESSION-KEY": false, "EXT-X-CONTENT-STEERING": true,
+}
+
+// tagName returns the name of the tag on line, or "" if it isn't a tag.
+func tagName(line string) string {
+	if !strings.HasPrefix(line, "#EXT") {
+		return ""
+	}
+	return strings.SplitN(strings.TrimPrefix(line, "#"), ":", 2)[0]
+}
+
+// checkTags returns an error for the first unknown or duplicate tag of a
+// playlist, for -parse-mode strict.
+func checkTags(body []byte) error {
+	seen := map[string]bool{}
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	for line := 1; scanner.Scan(); line++ {
+		name := tagName(strings.TrimSpace(scanner.Text()))
+		if name == "" {
+			continue
+		}
+		once, known := knownTags[name]
+		if !known {
+			return fmt.Errorf("line %d: unknown tag %v", line, name)
+		}
+		if once && seen[name] {
+			return fmt.Errorf("line %d: duplicate tag %v", line, name)
+		}
+		seen[name] = true
+	}
+	return scanner.Err()
+}
+
+// captureTags gives the segments of mpl the unknown tags preceding them in
+// body, for -parse-mode lenient.
+func captureTags(body []byte, mpl *m3u8.MediaPlaylist, segments []*SegmentDownload) {
+	var tags [][]string
+	var pending []string
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "#"):
+			if name := tagName(line); name != "" {
+				if _, known := knownTags[name]; !known {
+					pending = append(pending, line)
+				}
+			}
+		default:
+			tags = append(tags, pending)
+			pending = nil
+		}
+	}
+	for _, segment := range segments {
+		if i := segment.Sequence - mpl.SeqNo; i < uint64(len(tags)) {
+			segment.Tags = tags[i]
+		}
+	}
+}
+
+// ParseStats times decoding playlists, which is separate from fetching them
+// and, for large VOD playlists, a real cost on slow clients.
+type ParseStats struct {
+	mu    sync.Mutex
+	times []time.Duration
+	bytes int64
+}
+
+func NewParseStats() *ParseStats {
+	return &ParseStats{}
+}
+
+func (ps *ParseStats) Add(d time.Duration, n int64) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	ps.times = append(ps.times, d)
+	ps.bytes += n
+}
+
+// ParseReport summarizes a ParseStats.
+type ParseReport struct {
+	Playlists int                      `json:"playlists"`
+	Bytes     int64                    `json:"bytes"`
+	Latency   map[string]time.Duration `json:"latency,omitempty"`
+}
+
+func (ps *ParseStats) Report() *ParseReport {
+	if ps == nil {
+		return nil
+	}
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	if len(ps.times) == 0 {
+		return nil
+	}
+	return &ParseReport{
+		Playlists: len(ps.times),
+		Bytes:     ps.bytes,
+		Latency:   latencyPercentiles(ps.times),
+	}
+}
+
+func (ps *ParseStats) LogSummary() {
+	r := ps.Report()
+	if r == nil {
+		return
+	}
+	entry := log.WithField("Playlists", r.Playlists).WithField("Bytes", r.Bytes)
+	for name, d := range r.Latency {
+		entry = entry.WithField("Latency"+name, d)
+	}
+	entry.Info("Playlist parsing")
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+var (
+	// azureEdge is the edge in the Ref B of an X-MSEdge-Ref header.
+	azureEdge = regexp.MustCompile(`Ref B: (\S+)`)
+	// akamaiServer is the edge server in an Akamai X-Cache header.
+	akamaiServer = regexp.MustCompile(`from (a[0-9-]+)\.deploy\.akamaitechnologies\.com`)
+)
+
+// popOf returns the CDN point of presence that served resp going by the
+// debug headers of the CDNs that have them, normalized to CDN:POP, or "".
+func popOf(resp *http.Response) string {
+	h := resp.Header
+	if ray := h.Get("CF-Ray"); ray != "" {
+		if i := strings.LastIndex(ray, "-"); i >= 0 {
+			return "cloudflare:" + ray[i+1:]
+		}
+	}
+	if pop := h.Get("X-Amz-Cf-Pop"); pop != "" {
+		return "cloudfront:" + pop
+	}
+	// Fastly lists every cache a request went through, shielding ones
+	// first, the edge last.
+	if servedBy := h.Get("X-Served-By"); strings.HasPrefix(servedBy, "cache-") {
+		caches := strings.Split(servedBy, ",")
+		edge := strings.TrimSpace(caches[len(caches)-1])
+		if i := strings.LastIndex(edge, "-"); i >= 0 {
+			return "fastly:" + edge[i+1:]
+		}
+	}
+	if m := akamaiServer.FindStringSubmatch(h.Get("X-Cache")); m != nil {
+		return "akamai:" + m[1]
+	}
+	if m := azureEdge.FindStringSubmatch(h.Get("X-MSEdge-Ref")); m != nil {
+		return "azure:" + m[1]
+	}
+	return ""
+}
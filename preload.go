@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/digitaljanitors/go-httpstat"
+	log "github.com/sirupsen/logrus"
+)
+
+// hintKey identifies a hinted part. Parts of one resource are told apart by
+// where they start.
+type hintKey struct {
+	uri   string
+	start int64
+}
+
+// HintTracker follows the parts a low-latency playlist hints with
+// EXT-X-PRELOAD-HINT until they are published as an EXT-X-PART, and times
+// the requests made for them with -preload-hints. Servers hold those
+// requests until the part is available, so Held is how long that took.
+type HintTracker struct {
+	mu          sync.Mutex
+	wg          sync.WaitGroup
+	Hinted      int
+	Published   int
+	Unpublished int
+	Failed      int
+
+	pending map[hintKey]bool
+	held    []time.Duration
+}
+
+func NewHintTracker() *HintTracker {
+	return &HintTracker{pending: map[hintKey]bool{}}
+}
+
+// Observe returns the parts hinted by a snapshot of a low-latency playlist
+// that weren't before. Parts no longer hinted without having been published
+// are recorded in defects.
+func (ht *HintTracker) Observe(ll *lowLatency, defects *StreamDefects) []*preloadHint {
+	ht.mu.Lock()
+	defer ht.mu.Unlock()
+
+	listed := map[hintKey]bool{}
+	for _, part := range ll.Parts {
+		listed[hintKey{part.URI, part.Offset}] = true
+	}
+	hinted := map[hintKey]bool{}
+	var added []*preloadHint
+	for _, hint := range ll.Hints {
+		if hint.Type != "PART" {
+			continue
+		}
+		key := hintKey{hint.URI, hint.Start}
+		hinted[key] = true
+		if ht.pending[key] || listed[key] {
+			continue
+		}
+		ht.pending[key] = true
+		ht.Hinted++
+		added = append(added, hint)
+	}
+	for key := range ht.pending {
+		switch {
+		case listed[key]:
+			ht.Published++
+			delete(ht.pending, key)
+		case !hinted[key]:
+			ht.Unpublished++
+			delete(ht.pending, key)
+			defects.Record("EXT-X-PRELOAD-HINT never published as an EXT-X-PART", fmt.Sprintf("%v from byte %d was hinted and then dropped", key.uri, key.start))
+		}
+	}
+	return added
+}
+
+// Fetch requests a hinted part in the background, giving up after timeout.
+func (ht *HintTracker) Fetch(run *Run, base *url.URL, hint *preloadHint, timeout time.Duration) {
+	uri, err := translateURI(base, hint.URI)
+	if err != nil {
+		log.Warn(err)
+		return
+	}
+	ht.wg.Add(1)
+	go func() {
+		defer ht.wg.Done()
+		ht.fetch(run, uri, hint, timeout)
+	}()
+}
+
+func (ht *HintTracker) fetch(run *Run, uri string, hint *preloadHint, timeout time.Duration) {
+	stats := &httpstat.Result{}
+	req, err := newRequest("GET", uri, stats)
+	if err != nil {
+		log.Warn(err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(req.Context(), timeout)
+	defer cancel()
+	req = req.WithContext(ctx)
+	setPriority(req, urgencyInit)
+	if hint.Start > 0 || hint.Length > 0 {
+		byteRange := fmt.Sprintf("bytes=%d-", hint.Start)
+		if hint.Length > 0 {
+			byteRange += strconv.FormatInt(hint.Start+hint.Length-1, 10)
+		}
+		req.Header.Set("Range", byteRange)
+	}
+	download := NewSegmentDownload(uri, 0, hint.Length, hint.Start)
+	requestedAt := time.Now()
+	resp, err := run.do(req)
+	result := NewRequestResult(KindPreloadHint, download, req, requestedAt)
+	if err != nil {
+		logFailedRequest(req, err)
+		ht.failed()
+		run.failed(result, nil, err)
+		return
+	}
+	if !isSuccess(resp) {
+		reason := logFailedResponse(resp, "Recieved HTTP %v for preload hint %v\n", resp.StatusCode, uri)
+		resp.Body.Close()
+		ht.failed()
+		run.fail(result, resp, categorizeStatus(resp.StatusCode), reason)
+		return
+	}
+	n, err := io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		logFailedHeaders(resp, "Failed reading preload hint %v: %v\n", uri, err)
+		ht.failed()
+		run.failed(result, resp, err)
+		return
+	}
+	stats.End(time.Now())
+	if err := hook.Response(resp, n, stats); err != nil {
+		ht.failed()
+		run.failed(result, resp, err)
+		return
+	}
+	run.succeeded(result, resp, n, stats)
+	if n == 0 {
+		log.WithField("URI", uri).Warn("Preload hint was empty")
+		run.Errors.Record(ErrValidation, "preload hint: empty response")
+	}
+	log.WithField("Held", stats.ServerProcessing).
+		WithField("Bytes", n).
+		Debugf("Preload hint %v available", uri)
+	ht.mu.Lock()
+	ht.held = append(ht.held, stats.ServerProcessing)
+	ht.mu.Unlock()
+}
+
+func (ht *HintTracker) failed() {
+	ht.mu.Lock()
+	ht.Failed++
+	ht.mu.Unlock()
+}
+
+// Wait waits for the hinted parts being fetched.
+func (ht *HintTracker) Wait() {
+	ht.wg.Wait()
+}
+
+// HintReport summarizes the parts hinted by a low-latency playlist.
+type HintReport struct {
+	Hinted      int                      `json:"hinted"`
+	Published   int                      `json:"published"`
+	Unpublished int                      `json:"unpublished"`
+	Failed      int                      `json:"failed"`
+	Held        map[string]time.Duration `json:"held,omitempty"`
+}
+
+// Report returns nil if no parts were hinted.
+func (ht *HintTracker) Report() *HintReport {
+	if ht == nil {
+		return nil
+	}
+	ht.mu.Lock()
+	defer ht.mu.Unlock()
+	if ht.Hinted == 0 {
+		return nil
+	}
+	return &HintReport{
+		Hinted:      ht.Hinted,
+		Published:   ht.Published,
+		Unpublished: ht.Unpublished,
+		Failed:      ht.Failed,
+		Held:        latencyPercentiles(ht.held),
+	}
+}
+
+func (ht *HintTracker) LogSummary() {
+	r := ht.Report()
+	if r == nil {
+		return
+	}
+	entry := log.WithField("Hinted", r.Hinted).
+		WithField("Published", r.Published).
+		WithField("Unpublished", r.Unpublished).
+		WithField("Failed", r.Failed)
+	for name, d := range r.Held {
+		entry = entry.WithField("Held"+name, d)
+	}
+	entry.Info("Preload hints")
+}
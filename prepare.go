@@ -0,0 +1,88 @@
+package main
+
+import (
+	"flag"
+	"net/http"
+	"net/url"
+	"sync"
+
+	"github.com/digitaljanitors/go-httpstat"
+	log "github.com/sirupsen/logrus"
+)
+
+var prepareHosts = flag.Bool("prepare", false, "look up and connect to every host the first media playlist references before fetching any of its segments, reporting the latency of the first segment as prepared rather than cold")
+
+// prepare connects c to the origins of segments ahead of fetching them.
+func prepare(c *http.Client, segments []*SegmentDownload) {
+	origins := map[string]bool{}
+	for _, s := range segments {
+		for _, d := range append([]*SegmentDownload{s}, s.Paired...) {
+			if u, err := url.Parse(d.URI); err == nil {
+				origins[origin(u)] = true
+			}
+		}
+	}
+	var wg sync.WaitGroup
+	for o := range origins {
+		wg.Add(1)
+		go func(o string) {
+			defer wg.Done()
+			preconnect(c, o)
+		}(o)
+	}
+	wg.Wait()
+	log.WithField("Origins", len(origins)).Info("Prepared connections")
+}
+
+// FirstSegment is the timings of the first segment fetched, and whether the
+// connections for it were prepared with -prepare.
+type FirstSegment struct {
+	URI      string   `json:"uri"`
+	Prepared bool     `json:"prepared"`
+	Timings  *Timings `json:"timings"`
+}
+
+// FirstSegmentTracker keeps the first segment fetched, as the one a player
+// starts with.
+type FirstSegmentTracker struct {
+	mu    sync.Mutex
+	first *FirstSegment
+}
+
+func NewFirstSegmentTracker() *FirstSegmentTracker {
+	return &FirstSegmentTracker{}
+}
+
+func (ft *FirstSegmentTracker) Add(v *SegmentDownload, stats *httpstat.Result) {
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+	if ft.first == nil {
+		ft.first = &FirstSegment{URI: v.URI, Prepared: *prepareHosts, Timings: NewTimings(stats)}
+	}
+}
+
+// Report returns nil if no segments were fetched.
+func (ft *FirstSegmentTracker) Report() *FirstSegment {
+	if ft == nil {
+		return nil
+	}
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+	return ft.first
+}
+
+func (ft *FirstSegmentTracker) LogSummary() {
+	first := ft.Report()
+	if first == nil {
+		return
+	}
+	state := "cold"
+	if first.Prepared {
+		state = "prepared"
+	}
+	log.WithField("DNSLookup", first.Timings.DNSLookup).
+		WithField("TCPConnection", first.Timings.TCPConnection).
+		WithField("TLSHandshake", first.Timings.TLSHandshake).
+		WithField("Total", first.Timings.Total).
+		Infof("First segment, %v", state)
+}
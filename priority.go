@@ -0,0 +1,83 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+var sendPriority = flag.Bool("priority", false, "send RFC 9218 Priority headers, most urgent for playlists, keys and licenses, then init segments and parts, then segments in sequence order, and report segment latency by urgency")
+
+// The urgencies sent with -priority. Segments start at urgencySegment and
+// get less urgent the further ahead they are of the others in flight.
+const (
+	urgencyPlaylist = 1
+	urgencyInit     = 2
+	urgencySegment  = 3
+	urgencyLowest   = 7
+)
+
+// setPriority sets the Priority header of req with -priority. Go's HTTP/2
+// client can't send PRIORITY_UPDATE frames, so priorities are only ever set
+// when a request is made, never changed.
+func setPriority(req *http.Request, urgency int) {
+	if *sendPriority {
+		req.Header.Set("Priority", fmt.Sprintf("u=%d", urgency))
+	}
+}
+
+// PriorityTracker assigns urgencies to segments and breaks their latency
+// down by them. If the server honors priorities, urgent segments should
+// come back faster than the others requested alongside them.
+type PriorityTracker struct {
+	*SegmentBreakdown
+
+	mu       sync.Mutex
+	inFlight map[*SegmentDownload]bool
+}
+
+func NewPriorityTracker() *PriorityTracker {
+	return &PriorityTracker{
+		SegmentBreakdown: NewSegmentBreakdown(),
+		inFlight:         map[*SegmentDownload]bool{},
+	}
+}
+
+// Start returns the urgency of v, noting it as in flight until Done: one
+// less urgent than urgencySegment for every segment in flight before it.
+func (pt *PriorityTracker) Start(v *SegmentDownload) int {
+	if v.Init || v.Part {
+		return urgencyInit
+	}
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+	urgency := urgencySegment
+	for other := range pt.inFlight {
+		if other.Sequence < v.Sequence && urgency < urgencyLowest {
+			urgency++
+		}
+	}
+	pt.inFlight[v] = true
+	return urgency
+}
+
+func (pt *PriorityTracker) Done(v *SegmentDownload) {
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+	delete(pt.inFlight, v)
+}
+
+// Report returns nil unless segments were sent with -priority.
+func (pt *PriorityTracker) Report() map[string]*BreakdownReport {
+	if pt == nil {
+		return nil
+	}
+	return pt.SegmentBreakdown.Report()
+}
+
+func (pt *PriorityTracker) LogSummary() {
+	if pt != nil {
+		pt.SegmentBreakdown.LogSummary()
+	}
+}
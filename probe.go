@@ -0,0 +1,77 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// probeModeFlag is the -probe flag.
+type probeModeFlag string
+
+func (pm *probeModeFlag) String() string {
+	return string(*pm)
+}
+
+func (pm *probeModeFlag) Set(value string) error {
+	switch value {
+	case "", "head", "range":
+		*pm = probeModeFlag(value)
+		return nil
+	}
+	return fmt.Errorf("probe mode %q is neither head nor range", value)
+}
+
+var probeMode probeModeFlag
+
+func init() {
+	flag.Var(&probeMode, "probe", "`mode` of checking segments are available without downloading them: head sends HEAD requests, range only GETs their first byte")
+}
+
+// probeRequest turns req, for v, into a probe with -probe.
+func probeRequest(req *http.Request, v *SegmentDownload) {
+	switch probeMode {
+	case "head":
+		req.Method = "HEAD"
+	case "range":
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", v.Offset, v.Offset))
+	}
+}
+
+// probeSize returns the size of the file a probe was answered for, or -1 if
+// the response doesn't say.
+func probeSize(resp *http.Response) int64 {
+	if resp.StatusCode != http.StatusPartialContent {
+		return resp.ContentLength
+	}
+	contentRange := resp.Header.Get("Content-Range")
+	slash := strings.LastIndexByte(contentRange, '/')
+	if slash < 0 {
+		return -1
+	}
+	size, err := strconv.ParseInt(contentRange[slash+1:], 10, 64)
+	if err != nil {
+		return -1
+	}
+	return size
+}
+
+// probeProblem returns why the response to a probe for v shows it isn't
+// all there, or "" if it doesn't.
+func probeProblem(resp *http.Response, v *SegmentDownload) string {
+	if probeMode == "range" && resp.StatusCode != http.StatusPartialContent {
+		return "range probe answered with the whole file"
+	}
+	size := probeSize(resp)
+	switch {
+	case size < 0:
+		return ""
+	case size == 0:
+		return "probe found an empty file"
+	case v.Limit > 0 && size < v.Offset+v.Limit:
+		return fmt.Sprintf("probe found a file of %d bytes, the segment ends at byte %d", size, v.Offset+v.Limit)
+	}
+	return ""
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+)
+
+var (
+	configFile  = flag.String("config", "", "JSON file of flag values and user-defined profiles")
+	profileName = flag.String("profile", "", "preset of flag values to use: "+strings.Join(profileNames(builtinProfiles), ", ")+" or one from -config")
+)
+
+// flagValues is what a flag is set to in a config file or profile: a single
+// string, number or boolean, or a list of them for a repeatable flag.
+type flagValues []string
+
+func (fv *flagValues) UnmarshalJSON(data []byte) error {
+	var list []interface{}
+	if err := json.Unmarshal(data, &list); err != nil {
+		var single interface{}
+		if err := json.Unmarshal(data, &single); err != nil {
+			return err
+		}
+		list = []interface{}{single}
+	}
+	*fv = nil
+	for _, v := range list {
+		switch v := v.(type) {
+		case string:
+			*fv = append(*fv, v)
+		case float64, bool:
+			*fv = append(*fv, fmt.Sprint(v))
+		default:
+			return fmt.Errorf("flag value %s is not a string, number or boolean", data)
+		}
+	}
+	return nil
+}
+
+// Profile bundles flag values for a kind of benchmark.
+type Profile struct {
+	Description string                `json:"description"`
+	Flags       map[string]flagValues `json:"flags"`
+}
+
+// Config is the file given to -config, e.g.
+//
+//	{
+//	  "flags": {"output": ["console", "json:results.jsonl"]},
+//	  "profiles": {
+//	    "origin": {"description": "straight to the origin", "flags": {"connect-to": "cdn:443:origin:443"}}
+//	  }
+//	}
+type Config struct {
+	Flags    map[string]flagValues `json:"flags"`
+	Profiles map[string]Profile    `json:"profiles"`
+}
+
+var builtinProfiles = map[string]Profile{
+	"realtime-player": {
+		Description: "fetch one segment at a time at playback speed, as a player would",
+		Flags: map[string]flagValues{
+			"concurrency": {"1"},
+			"realtime":    {"true"},
+		},
+	},
+	"stress": {
+		Description: "fetch segments as fast as possible over many connections",
+		Flags: map[string]flagValues{
+			"concurrency": {"16"},
+			"realtime":    {"false"},
+		},
+	},
+	"cache-validate": {
+		Description: "fetch every segment twice so the second request can be checked for a cache hit",
+		Flags: map[string]flagValues{
+			"concurrency": {"1"},
+			"repeat":      {"2"},
+		},
+	},
+}
+
+func profileNames(profiles map[string]Profile) []string {
+	names := make([]string, 0, len(profiles))
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func loadConfig(filename string) (*Config, error) {
+	config := &Config{}
+	if filename == "" {
+		return config, nil
+	}
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("%v: %v", filename, err)
+	}
+	return config, nil
+}
+
+// applyConfig fills in the flags not set on the command line or in the
+// environment, first from the -profile and then from the flags of the
+// -config file. A profile in the config file replaces a built-in one of the
+// same name.
+func applyConfig(fs *flag.FlagSet) error {
+	config, err := loadConfig(*configFile)
+	if err != nil {
+		return err
+	}
+	if *profileName != "" {
+		profile, ok := config.Profiles[*profileName]
+		if !ok {
+			profile, ok = builtinProfiles[*profileName]
+		}
+		if !ok {
+			return fmt.Errorf("no profile %q, expected one of %v or one from -config", *profileName, strings.Join(profileNames(builtinProfiles), ", "))
+		}
+		if err := setUnset(fs, profile.Flags, "profile "+*profileName); err != nil {
+			return err
+		}
+	}
+	return setUnset(fs, config.Flags, *configFile)
+}
+
+// setUnset sets the flags in values that haven't been set yet, source being
+// where the values came from for error messages.
+func setUnset(fs *flag.FlagSet, values map[string]flagValues, source string) error {
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if fs.Lookup(name) == nil {
+			return fmt.Errorf("%v: unknown flag %q", source, name)
+		}
+		if set[name] {
+			continue
+		}
+		for _, v := range values[name] {
+			if err := fs.Set(name, v); err != nil {
+				return fmt.Errorf("%v: invalid value %q for -%v: %v", source, v, name, err)
+			}
+		}
+	}
+	return nil
+}
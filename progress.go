@@ -0,0 +1,153 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var progress = flag.Bool("progress", false, "show the segments of a VOD playlist fetched out of all of them, the bytes fetched, the current rate and an estimate of the time left on stderr")
+
+// progressInterval is how often progress is shown, on a terminal. Otherwise
+// it is logged ten times less often.
+const progressInterval = time.Second
+
+// Progress follows how far through a closed playlist a run is. The segments
+// to fetch are only known once the playlist is closed: those already taken
+// from the queue and those still in it, as no more are queued.
+type Progress struct {
+	mu      sync.Mutex
+	total   int
+	taken   int
+	done    int
+	bytes   int64
+	window  []progressSample
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+// progressSample is how many bytes had been fetched when.
+type progressSample struct {
+	at    time.Time
+	bytes int64
+}
+
+func NewProgress() *Progress {
+	return &Progress{}
+}
+
+// Closed notes that the playlist is closed, so that everything left to
+// fetch is in queue.
+func (p *Progress) Closed(queue chan *SegmentDownload) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.total = p.taken + len(queue)
+}
+
+// Take notes a segment taken from the queue.
+func (p *Progress) Take() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.taken++
+	// A segment received from the queue but not yet taken when the
+	// playlist closed was counted in neither.
+	if p.total > 0 && p.taken > p.total {
+		p.total = p.taken
+	}
+}
+
+// Done notes a segment taken from the queue as fetched, whether it
+// succeeded or not.
+func (p *Progress) Done(bytes int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.done++
+	p.bytes += bytes
+}
+
+// rate returns the bytes per second fetched over the last ten seconds.
+func (p *Progress) rate(now time.Time) float64 {
+	p.window = append(p.window, progressSample{now, p.bytes})
+	for len(p.window) > 1 && now.Sub(p.window[0].at) > 10*time.Second {
+		p.window = p.window[1:]
+	}
+	first := p.window[0]
+	if elapsed := now.Sub(first.at); elapsed > 0 {
+		return float64(p.bytes-first.bytes) / elapsed.Seconds()
+	}
+	return 0
+}
+
+// line returns the progress as of now, or false while the playlist isn't
+// known to be closed.
+func (p *Progress) line(now time.Time, start time.Time) (string, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	rate := p.rate(now)
+	if p.total == 0 {
+		return "", false
+	}
+	line := fmt.Sprintf("%d/%d segments (%.0f%%), %.1f MB, %.2f Mbps", p.done, p.total, 100*float64(p.done)/float64(p.total), float64(p.bytes)/1e6, rate*8/1e6)
+	if p.done > 0 && p.done < p.total {
+		elapsed := now.Sub(start)
+		eta := time.Duration(float64(elapsed) / float64(p.done) * float64(p.total-p.done))
+		line += fmt.Sprintf(", %v left", eta.Round(time.Second))
+	}
+	return line, true
+}
+
+// Start shows the progress of the run started at start until Stop: on a
+// line redrawn every progressInterval if stderr is a terminal, or logged
+// every ten otherwise.
+func (p *Progress) Start(start time.Time) {
+	p.stop, p.stopped = make(chan struct{}), make(chan struct{})
+	go p.run(start)
+}
+
+func (p *Progress) run(start time.Time) {
+	defer close(p.stopped)
+	terminal := isTerminal(os.Stderr)
+	ticker := time.NewTicker(progressInterval)
+	defer ticker.Stop()
+	shown := false
+	for ticks := 1; ; ticks++ {
+		select {
+		case <-p.stop:
+			if shown {
+				fmt.Fprintln(os.Stderr)
+			}
+			return
+		case now := <-ticker.C:
+			line, ok := p.line(now, start)
+			if !ok {
+				continue
+			}
+			if terminal {
+				fmt.Fprintf(os.Stderr, "\r\033[K%s", line)
+				shown = true
+			} else if ticks%10 == 0 {
+				log.Info(line)
+			}
+		}
+	}
+}
+
+// Stop stops showing the progress, ending its line so that the summary
+// starts on one of its own.
+func (p *Progress) Stop() {
+	if p.stop == nil {
+		return
+	}
+	close(p.stop)
+	<-p.stopped
+}
+
+// isTerminal reports whether f is a terminal rather than a file or a pipe.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
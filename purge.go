@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/digitaljanitors/go-httpstat"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	purgeCheck  = flag.Duration("purge-check", 0, "re-request the segments that aged out of a live playlist's window this often, reporting whether they are still served, to validate DVR windows and purge policies")
+	purgeWindow = flag.Duration("purge-window", 10*time.Minute, "how long after aging out segments are re-requested with -purge-check")
+)
+
+// expiredSegment is a segment no longer listed by the live playlist.
+type expiredSegment struct {
+	uri         string
+	expiredAt   time.Time
+	servedUntil time.Time
+	purgedAt    time.Time
+}
+
+// PurgeChecker keeps the segments that aged out of a live playlist's window
+// and re-requests them with -purge-check. Origins and CDNs that still serve
+// them long after they aged out keep more than the DVR window, and those
+// that stop at once don't leave players behind the live edge time to
+// fetch them.
+type PurgeChecker struct {
+	mu      sync.Mutex
+	listed  map[string]bool
+	expired []*expiredSegment
+	checks  int
+	errors  int
+}
+
+func NewPurgeChecker() *PurgeChecker {
+	return &PurgeChecker{}
+}
+
+// Observe notes the segments a refresh of the playlist no longer lists.
+func (pc *PurgeChecker) Observe(at time.Time, segments []*SegmentDownload) {
+	if *purgeCheck <= 0 {
+		return
+	}
+	listed := map[string]bool{}
+	for _, segment := range segments {
+		listed[segment.URI] = true
+	}
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	for uri := range pc.listed {
+		if !listed[uri] {
+			pc.expired = append(pc.expired, &expiredSegment{uri: uri, expiredAt: at})
+		}
+	}
+	pc.listed = listed
+}
+
+// Run re-requests the expired segments every -purge-check with c, as part of
+// the run's trace, until ctx is done.
+func (pc *PurgeChecker) Run(ctx context.Context, c *http.Client, traceID string) {
+	ticker := time.NewTicker(*purgeCheck)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		for _, es := range pc.due(time.Now()) {
+			if ctx.Err() != nil {
+				return
+			}
+			pc.check(ctx, c, traceID, es)
+		}
+	}
+}
+
+// due returns the expired segments to check: those not yet purged that
+// aged out less than -purge-window ago.
+func (pc *PurgeChecker) due(now time.Time) []*expiredSegment {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	var due []*expiredSegment
+	for _, es := range pc.expired {
+		if es.purgedAt.IsZero() && now.Sub(es.expiredAt) < *purgeWindow {
+			due = append(due, es)
+		}
+	}
+	return due
+}
+
+// check requests the first byte of an expired segment.
+func (pc *PurgeChecker) check(ctx context.Context, c *http.Client, traceID string, es *expiredSegment) {
+	req, err := newRequest("GET", es.uri, &httpstat.Result{})
+	if err != nil {
+		log.Warn(err)
+		return
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Range", "bytes=0-0")
+	resp, err := doRequest(c, traceID, req)
+	now := time.Now()
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	pc.checks++
+	if err != nil {
+		pc.errors++
+		log.WithField("URI", es.uri).Debugf("Checking expired segment: %v", err)
+		return
+	}
+	resp.Body.Close()
+	switch {
+	case isSuccess(resp):
+		es.servedUntil = now
+		log.WithField("URI", es.uri).
+			WithField("Expired", now.Sub(es.expiredAt)).
+			Debug("Expired segment still served")
+	case resp.StatusCode == http.StatusNotFound, resp.StatusCode == http.StatusGone:
+		es.purgedAt = now
+		log.WithField("URI", es.uri).
+			WithField("Expired", now.Sub(es.expiredAt)).
+			Debugf("Expired segment purged, HTTP %v", resp.StatusCode)
+	default:
+		pc.errors++
+		log.WithField("URI", es.uri).Debugf("Checking expired segment: HTTP %v", resp.StatusCode)
+	}
+}
+
+// PurgeReport summarizes the checks of expired segments. Served counts the
+// segments still served after aging out, Purged those that then stopped
+// being, after PurgedAfter, and ServedFor is the longest one was still
+// served.
+type PurgeReport struct {
+	Expired     int                      `json:"expired"`
+	Checks      int                      `json:"checks"`
+	Errors      int                      `json:"errors"`
+	Served      int                      `json:"served"`
+	Purged      int                      `json:"purged"`
+	PurgedAfter map[string]time.Duration `json:"purged_after,omitempty"`
+	ServedFor   time.Duration            `json:"served_for"`
+}
+
+// Report returns nil unless expired segments were checked.
+func (pc *PurgeChecker) Report() *PurgeReport {
+	if pc == nil {
+		return nil
+	}
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	if pc.checks == 0 {
+		return nil
+	}
+	report := &PurgeReport{
+		Expired: len(pc.expired),
+		Checks:  pc.checks,
+		Errors:  pc.errors,
+	}
+	var purgedAfter []time.Duration
+	for _, es := range pc.expired {
+		if !es.servedUntil.IsZero() {
+			report.Served++
+			if d := es.servedUntil.Sub(es.expiredAt); d > report.ServedFor {
+				report.ServedFor = d
+			}
+		}
+		if !es.purgedAt.IsZero() {
+			report.Purged++
+			purgedAfter = append(purgedAfter, es.purgedAt.Sub(es.expiredAt))
+		}
+	}
+	report.PurgedAfter = latencyPercentiles(purgedAfter)
+	return report
+}
+
+func (pc *PurgeChecker) LogSummary() {
+	r := pc.Report()
+	if r == nil {
+		return
+	}
+	entry := log.WithField("Expired", r.Expired).
+		WithField("Checks", r.Checks).
+		WithField("Errors", r.Errors).
+		WithField("Served", r.Served).
+		WithField("Purged", r.Purged).
+		WithField("ServedFor", r.ServedFor)
+	for name, d := range r.PurgedAfter {
+		entry = entry.WithField("PurgedAfter"+name, d)
+	}
+	entry.Info("Expired segments")
+}
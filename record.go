@@ -0,0 +1,225 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"flag"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var recordTo = flag.String("record", "", "archive every playlist snapshot and segment with timestamps and response headers to this directory, or to a .tar/.tar.gz file")
+
+// RunInfo is written to run.json when the archive is closed.
+type RunInfo struct {
+	RunID       string    `json:"run_id"`
+	PlaylistURL string    `json:"playlist_url"`
+	Version     string    `json:"version"`
+	TraceID     string    `json:"trace_id"`
+	Start       time.Time `json:"start"`
+	End         time.Time `json:"end"`
+	// Environment is where the run was made from, which archives recorded
+	// before it was kept don't know.
+	Environment *Environment `json:"environment,omitempty"`
+}
+
+// Recorder archives a run as:
+//
+//	run.json
+//	events.jsonl
+//	playlists/000001-name.m3u8
+//	segments/000002-name.ts
+type Recorder struct {
+	mu      sync.Mutex
+	dir     string
+	tarball string
+	seq     int
+	info    RunInfo
+	events  *os.File
+	enc     *json.Encoder
+	// err is the first error archiving the run, returned by Close.
+	err error
+}
+
+func isTarball(name string) bool {
+	return strings.HasSuffix(name, ".tar") || strings.HasSuffix(name, ".tar.gz") || strings.HasSuffix(name, ".tgz")
+}
+
+func NewRecorder(target string, info RunInfo) (*Recorder, error) {
+	r := &Recorder{
+		dir:  target,
+		info: info,
+	}
+	if isTarball(target) {
+		dir, err := ioutil.TempDir("", "hlsbenchmark-record")
+		if err != nil {
+			return nil, err
+		}
+		r.dir = dir
+		r.tarball = target
+	}
+	for _, sub := range []string{"playlists", "segments"} {
+		if err := os.MkdirAll(filepath.Join(r.dir, sub), 0755); err != nil {
+			return nil, err
+		}
+	}
+	events, err := os.Create(filepath.Join(r.dir, "events.jsonl"))
+	if err != nil {
+		return nil, err
+	}
+	r.events = events
+	r.enc = json.NewEncoder(events)
+	return r, nil
+}
+
+type teeBody struct {
+	io.Reader
+	body io.Closer
+	file io.Closer
+}
+
+func (t teeBody) Close() error {
+	t.file.Close()
+	return t.body.Close()
+}
+
+// CaptureBody swaps resp.Body for one that copies everything read into a new
+// archive file, and returns that file's path relative to the archive, or ""
+// if the body can't be archived.
+func (r *Recorder) CaptureBody(kind string, segment *SegmentDownload, resp *http.Response) string {
+	if r == nil {
+		return ""
+	}
+	r.mu.Lock()
+	r.seq++
+	seq := r.seq
+	r.mu.Unlock()
+
+	name := filepath.Join(kind+"s", archiveName(seq, segment))
+	file, err := os.Create(filepath.Join(r.dir, name))
+	if err != nil {
+		r.failed(err)
+		return ""
+	}
+	resp.Body = teeBody{
+		Reader: io.TeeReader(resp.Body, &archiveWriter{r: r, file: file}),
+		body:   resp.Body,
+		file:   file,
+	}
+	return filepath.ToSlash(name)
+}
+
+// archiveWriter writes a body into the archive until that fails, without
+// failing the request along with it.
+type archiveWriter struct {
+	r    *Recorder
+	file *os.File
+	err  error
+}
+
+func (aw *archiveWriter) Write(p []byte) (int, error) {
+	if aw.err == nil {
+		if _, aw.err = aw.file.Write(p); aw.err != nil {
+			aw.r.failed(aw.err)
+		}
+	}
+	return len(p), nil
+}
+
+// Result writes a line to events.jsonl, making the recorder an OutputSink.
+func (r *Recorder) Result(result *RequestResult) {
+	r.mu.Lock()
+	err := r.enc.Encode(result)
+	r.mu.Unlock()
+	if err != nil {
+		r.failed(err)
+	}
+}
+
+// failed logs the first error archiving the run, which carries on without
+// what couldn't be archived.
+func (r *Recorder) failed(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err == nil {
+		r.err = err
+		log.Errorf("Recording incomplete: %v", err)
+	}
+}
+
+func (r *Recorder) Summary(*RunSummary) {}
+
+func (r *Recorder) Close() error {
+	r.info.End = time.Now()
+	if err := r.events.Close(); err != nil {
+		return err
+	}
+	info, err := json.MarshalIndent(r.info, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(r.dir, "run.json"), info, 0644); err != nil {
+		return err
+	}
+	if r.tarball == "" {
+		return r.err
+	}
+	if err := writeTarball(r.tarball, r.dir); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(r.dir); err != nil {
+		return err
+	}
+	return r.err
+}
+
+func writeTarball(target, dir string) error {
+	f, err := os.Create(target)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var w io.Writer = f
+	if !strings.HasSuffix(target, ".tar") {
+		gz := gzip.NewWriter(f)
+		defer gz.Close()
+		w = gz
+	}
+	tw := tar.NewWriter(w)
+	defer tw.Close()
+
+	return filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil || fi.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		hdr, err := tar.FileInfoHeader(fi, "")
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(rel)
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		src, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+		_, err = io.Copy(tw, src)
+		return err
+	})
+}
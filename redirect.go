@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"flag"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var maxRedirects = flag.Int("max-redirects", 10, "follow at most `n` redirects per request, 0 to treat redirects as failed responses")
+
+type redirectKey struct{}
+
+// Hop is one request of a redirect chain. httpstat only times the last one,
+// so the phases of every hop are timed separately, Total being the time
+// until its first response byte.
+type Hop struct {
+	URL              string        `json:"url"`
+	Addr             string        `json:"addr,omitempty"`
+	Reused           bool          `json:"reused,omitempty"`
+	DNSLookup        time.Duration `json:"dns_lookup"`
+	TCPConnection    time.Duration `json:"tcp_connection"`
+	TLSHandshake     time.Duration `json:"tls_handshake"`
+	ServerProcessing time.Duration `json:"server_processing"`
+	Total            time.Duration `json:"total"`
+
+	start, dnsStart, connectStart, tlsStart, wrote time.Time
+}
+
+// edgeAddrOf returns the address resp came from, or "".
+func edgeAddrOf(resp *http.Response) string {
+	chain := redirectsOf(resp.Request)
+	if chain == nil {
+		return ""
+	}
+	chain.mu.Lock()
+	defer chain.mu.Unlock()
+	if len(chain.Hops) == 0 {
+		return ""
+	}
+	return chain.Hops[len(chain.Hops)-1].Addr
+}
+
+// edgeOf returns the IP address resp came from, or "".
+func edgeOf(resp *http.Response) string {
+	host, _, err := net.SplitHostPort(edgeAddrOf(resp))
+	if err != nil {
+		return ""
+	}
+	return host
+}
+
+// redirectChain is the URLs a request was redirected to, when the last of
+// them was requested, and the timings of every hop.
+type redirectChain struct {
+	mu    sync.Mutex
+	start time.Time
+	last  time.Time
+	URLs  []string
+	Hops  []*Hop
+}
+
+// hop calls f with the hop in progress, if there is one.
+func (rc *redirectChain) hop(f func(h *Hop, now time.Time)) {
+	now := time.Now()
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if len(rc.Hops) > 0 {
+		f(rc.Hops[len(rc.Hops)-1], now)
+	}
+}
+
+// withRedirects returns req set up to record the redirects it follows and
+// time every hop.
+func withRedirects(req *http.Request) *http.Request {
+	chain := &redirectChain{start: time.Now()}
+	first := req.URL.String()
+	trace := &httptrace.ClientTrace{
+		GetConn: func(string) {
+			chain.mu.Lock()
+			defer chain.mu.Unlock()
+			url := first
+			if len(chain.URLs) > 0 {
+				url = chain.URLs[len(chain.URLs)-1]
+			}
+			chain.Hops = append(chain.Hops, &Hop{URL: url, start: time.Now()})
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			chain.hop(func(h *Hop, now time.Time) {
+				h.Reused = info.Reused
+				if info.Conn != nil {
+					h.Addr = info.Conn.RemoteAddr().String()
+				}
+			})
+		},
+		DNSStart: func(httptrace.DNSStartInfo) {
+			chain.hop(func(h *Hop, now time.Time) { h.dnsStart = now })
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			chain.hop(func(h *Hop, now time.Time) { h.DNSLookup = now.Sub(h.dnsStart) })
+		},
+		ConnectStart: func(string, string) {
+			chain.hop(func(h *Hop, now time.Time) { h.connectStart = now })
+		},
+		ConnectDone: func(string, string, error) {
+			chain.hop(func(h *Hop, now time.Time) { h.TCPConnection = now.Sub(h.connectStart) })
+		},
+		TLSHandshakeStart: func() {
+			chain.hop(func(h *Hop, now time.Time) { h.tlsStart = now })
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			chain.hop(func(h *Hop, now time.Time) { h.TLSHandshake = now.Sub(h.tlsStart) })
+		},
+		WroteRequest: func(httptrace.WroteRequestInfo) {
+			chain.hop(func(h *Hop, now time.Time) { h.wrote = now })
+		},
+		GotFirstResponseByte: func() {
+			chain.hop(func(h *Hop, now time.Time) {
+				h.ServerProcessing = now.Sub(h.wrote)
+				h.Total = now.Sub(h.start)
+			})
+		},
+	}
+	ctx := httptrace.WithClientTrace(context.WithValue(req.Context(), redirectKey{}, chain), trace)
+	return req.WithContext(ctx)
+}
+
+// redirectsOf returns the redirects followed by the request req ended up as,
+// or nil.
+func redirectsOf(req *http.Request) *redirectChain {
+	if req == nil {
+		return nil
+	}
+	chain, _ := req.Context().Value(redirectKey{}).(*redirectChain)
+	return chain
+}
+
+// checkRedirect is the CheckRedirect of the client, which stops following
+// redirects past -max-redirects, refuses those of remote URLs to local files
+// and notes those it follows.
+func checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) > *maxRedirects {
+		return http.ErrUseLastResponse
+	}
+	if err := checkLocalURI(via[len(via)-1].URL, req.URL); err != nil {
+		return err
+	}
+	if chain := redirectsOf(req); chain != nil {
+		chain.mu.Lock()
+		chain.URLs = append(chain.URLs, req.URL.String())
+		chain.last = time.Now()
+		chain.mu.Unlock()
+	}
+	return nil
+}
+
+// Elapsed returns the time spent being redirected: from the first request
+// until the last redirect was requested.
+func (rc *redirectChain) Elapsed() time.Duration {
+	if len(rc.URLs) == 0 {
+		return 0
+	}
+	return rc.last.Sub(rc.start)
+}
+
+// RedirectStats counts the requests that were redirected and how long that
+// took, and times the hops that redirected apart from those that didn't.
+type RedirectStats struct {
+	mu           sync.Mutex
+	hops         int
+	times        []time.Duration
+	redirectHops []time.Duration
+	finalHops    []time.Duration
+}
+
+func NewRedirectStats() *RedirectStats {
+	return &RedirectStats{}
+}
+
+func (rs *RedirectStats) Add(result *RequestResult) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rs.hops += len(result.Redirects)
+	rs.times = append(rs.times, result.RedirectTime)
+	for i, h := range result.Hops {
+		if i < len(result.Hops)-1 {
+			rs.redirectHops = append(rs.redirectHops, h.Total)
+		} else {
+			rs.finalHops = append(rs.finalHops, h.Total)
+		}
+	}
+}
+
+// RedirectReport summarizes the redirected requests. RedirectHop and
+// FinalHop are the time to the first byte of the hops answered with a
+// redirect and of those answered with the response.
+type RedirectReport struct {
+	Requests    int                      `json:"requests"`
+	Hops        int                      `json:"hops"`
+	Time        map[string]time.Duration `json:"time"`
+	RedirectHop map[string]time.Duration `json:"redirect_hop,omitempty"`
+	FinalHop    map[string]time.Duration `json:"final_hop,omitempty"`
+}
+
+// Report returns nil if no requests were redirected.
+func (rs *RedirectStats) Report() *RedirectReport {
+	if rs == nil {
+		return nil
+	}
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if len(rs.times) == 0 {
+		return nil
+	}
+	return &RedirectReport{
+		Requests:    len(rs.times),
+		Hops:        rs.hops,
+		Time:        latencyPercentiles(rs.times),
+		RedirectHop: latencyPercentiles(rs.redirectHops),
+		FinalHop:    latencyPercentiles(rs.finalHops),
+	}
+}
+
+func (rs *RedirectStats) LogSummary() {
+	r := rs.Report()
+	if r == nil {
+		return
+	}
+	entry := log.WithField("Requests", r.Requests).WithField("Hops", r.Hops)
+	for name, d := range r.Time {
+		entry = entry.WithField("Time"+name, d)
+	}
+	for name, d := range r.RedirectHop {
+		entry = entry.WithField("RedirectHop"+name, d)
+	}
+	for name, d := range r.FinalHop {
+		entry = entry.WithField("FinalHop"+name, d)
+	}
+	entry.Info("Redirects")
+}
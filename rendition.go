@@ -0,0 +1,151 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/grafov/m3u8"
+)
+
+var (
+	audioLang  = flag.String("audio-lang", "", "comma separated `languages` of the audio renditions of a master playlist to benchmark instead of the default one, e.g. en,es")
+	subsLang   = flag.String("subs-lang", "", "comma separated `languages` of the subtitle renditions of a master playlist to benchmark as well")
+	audioGroup = flag.String("audio-group", "", "benchmark the first variant of a master playlist whose audio is in this EXT-X-MEDIA `GROUP-ID`")
+)
+
+// variantLockFlag is the -variant flag.
+type variantLockFlag string
+
+func (vl *variantLockFlag) String() string {
+	return string(*vl)
+}
+
+func (vl *variantLockFlag) Set(value string) error {
+	switch value {
+	case "", "first", "lowest", "highest":
+		*vl = variantLockFlag(value)
+		return nil
+	}
+	return fmt.Errorf("variant %q is not first, lowest or highest", value)
+}
+
+var variantLock variantLockFlag
+
+func init() {
+	flag.Var(&variantLock, "variant", "`which` variant of a master playlist to benchmark for the whole run: first, where players start, or lowest or highest BANDWIDTH, the extremes of the ladder (default first)")
+}
+
+// The Rendition of segments and playlists of EXT-X-MEDIA renditions, of
+// I-frame playlists and of image playlists.
+const (
+	RenditionAudio     = "AUDIO"
+	RenditionSubtitles = "SUBTITLES"
+	RenditionIFrames   = "I-FRAMES"
+	RenditionImages    = "IMAGES"
+)
+
+// renditionPlaylist is an EXT-X-MEDIA rendition whose segments are fetched
+// along with the variant's.
+type renditionPlaylist struct {
+	Type     string
+	Language string
+	URL      string
+	base     *url.URL
+}
+
+// selectVariant returns the variant of a master playlist to benchmark out
+// of those that aren't I-frame playlists and use -audio-group if given: the
+// first, as that is where players start, or the one of the lowest or
+// highest BANDWIDTH with -variant.
+func selectVariant(master *m3u8.MasterPlaylist) (*m3u8.Variant, error) {
+	var selected *m3u8.Variant
+	for _, v := range master.Variants {
+		if v == nil || v.Iframe || (*audioGroup != "" && v.Audio != *audioGroup) {
+			continue
+		}
+		switch {
+		case selected == nil,
+			variantLock == "lowest" && v.Bandwidth < selected.Bandwidth,
+			variantLock == "highest" && v.Bandwidth > selected.Bandwidth:
+			selected = v
+		}
+	}
+	if selected != nil {
+		return selected, nil
+	}
+	if *audioGroup != "" {
+		return nil, fmt.Errorf("no variant of the master playlist uses audio group %q", *audioGroup)
+	}
+	return nil, errors.New("master playlist has no variants")
+}
+
+// masterAlternatives returns every EXT-X-MEDIA of a master playlist. The
+// decoder attaches them to the variants that follow them, so not every
+// variant has all of those it refers to.
+func masterAlternatives(master *m3u8.MasterPlaylist) []*m3u8.Alternative {
+	seen := map[*m3u8.Alternative]bool{}
+	var alts []*m3u8.Alternative
+	for _, v := range master.Variants {
+		if v == nil {
+			continue
+		}
+		for _, alt := range v.Alternatives {
+			if alt != nil && !seen[alt] {
+				seen[alt] = true
+				alts = append(alts, alt)
+			}
+		}
+	}
+	return alts
+}
+
+// matchesLanguage reports whether lang is one of the comma separated
+// languages, "en" matching "en-US" too.
+func matchesLanguage(lang, languages string) bool {
+	for _, want := range strings.Split(languages, ",") {
+		want = strings.TrimSpace(want)
+		if strings.EqualFold(lang, want) || strings.HasPrefix(strings.ToLower(lang), strings.ToLower(want)+"-") {
+			return true
+		}
+	}
+	return false
+}
+
+// selectRenditions returns the renditions of variant to benchmark along with
+// it: the audio rendition players pick by default, unless its audio is muxed
+// into its own segments, or those in -audio-lang, and those in -subs-lang.
+func selectRenditions(master *m3u8.MasterPlaylist, variant *m3u8.Variant) ([]*m3u8.Alternative, error) {
+	var audio, subtitles []*m3u8.Alternative
+	var defaultAudio *m3u8.Alternative
+	for _, alt := range masterAlternatives(master) {
+		if alt.URI == "" {
+			continue
+		}
+		switch {
+		case alt.Type == RenditionAudio && alt.GroupId == variant.Audio:
+			if *audioLang != "" && matchesLanguage(alt.Language, *audioLang) {
+				audio = append(audio, alt)
+			}
+			if defaultAudio == nil || (alt.Default && !defaultAudio.Default) {
+				defaultAudio = alt
+			}
+		case alt.Type == RenditionSubtitles && alt.GroupId == variant.Subtitles:
+			if *subsLang != "" && matchesLanguage(alt.Language, *subsLang) {
+				subtitles = append(subtitles, alt)
+			}
+		}
+	}
+	if *audioLang == "" && defaultAudio != nil {
+		audio = append(audio, defaultAudio)
+	}
+	if *audioLang != "" && len(audio) == 0 {
+		return nil, fmt.Errorf("audio group %q has no rendition in %v", variant.Audio, *audioLang)
+	}
+	if *subsLang != "" && len(subtitles) == 0 {
+		return nil, fmt.Errorf("subtitle group %q has no rendition in %v", variant.Subtitles, *subsLang)
+	}
+	return append(audio, subtitles...), nil
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/digitaljanitors/go-httpstat"
+	log "github.com/sirupsen/logrus"
+)
+
+type harFile struct {
+	Log struct {
+		Entries []harEntry `json:"entries"`
+	} `json:"log"`
+}
+
+type harHeader struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harEntry struct {
+	StartedDateTime time.Time `json:"startedDateTime"`
+	Time            float64   `json:"time"`
+	Request         struct {
+		Method  string      `json:"method"`
+		URL     string      `json:"url"`
+		Headers []harHeader `json:"headers"`
+	} `json:"request"`
+	Response struct {
+		Status int `json:"status"`
+	} `json:"response"`
+	Timings struct {
+		Blocked float64 `json:"blocked"`
+		DNS     float64 `json:"dns"`
+		Connect float64 `json:"connect"`
+		SSL     float64 `json:"ssl"`
+		Send    float64 `json:"send"`
+		Wait    float64 `json:"wait"`
+		Receive float64 `json:"receive"`
+	} `json:"timings"`
+}
+
+// harDuration converts HAR milliseconds, where -1 means not applicable.
+func harDuration(ms float64) time.Duration {
+	if ms < 0 {
+		return 0
+	}
+	return time.Duration(ms * float64(time.Millisecond))
+}
+
+// skipReplayHeaders are set by the transport, or would stop it from doing
+// its job, so they aren't copied from the HAR.
+var skipReplayHeaders = map[string]bool{
+	"host":              true,
+	"connection":        true,
+	"content-length":    true,
+	"accept-encoding":   true,
+	"user-agent":        true,
+	"transfer-encoding": true,
+}
+
+type replayResult struct {
+	entry   *harEntry
+	status  int
+	stats   *httpstat.Result
+	bytes   int64
+	skipped bool
+}
+
+func replayEntry(entry *harEntry, target *url.URL, traceID string, errs *ErrorSummary) replayResult {
+	result := replayResult{entry: entry, stats: &httpstat.Result{}}
+	u, err := url.Parse(entry.Request.URL)
+	if err != nil {
+		log.Warn(err)
+		result.skipped = true
+		return result
+	}
+	if target != nil {
+		u.Scheme, u.Host = target.Scheme, target.Host
+	}
+	req, err := newRequest(entry.Request.Method, u.String(), result.stats)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, h := range entry.Request.Headers {
+		if strings.HasPrefix(h.Name, ":") || skipReplayHeaders[strings.ToLower(h.Name)] {
+			continue
+		}
+		req.Header.Add(h.Name, h.Value)
+	}
+	resp, err := doRequest(client, traceID, req)
+	if err != nil {
+		logFailedRequest(req, err)
+		errs.RecordError(err)
+		result.skipped = true
+		return result
+	}
+	result.status = resp.StatusCode
+	if !isSuccess(resp) {
+		reason := logFailedResponse(resp, "Recieved HTTP %v for %v\n", resp.StatusCode, u)
+		errs.Record(categorizeStatus(resp.StatusCode), reason)
+	}
+	result.bytes, err = io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		errs.RecordError(err)
+	}
+	result.stats.End(time.Now())
+
+	original := harDuration(entry.Time)
+	log.WithFields(traceFields(req)).
+		WithField("Status", resp.StatusCode).
+		WithField("OriginalStatus", entry.Response.Status).
+		WithField("OriginalTotal", original).
+		WithField("ReplayTotal", result.stats.Total).
+		WithField("OriginalWait", harDuration(entry.Timings.Wait)).
+		WithField("ReplayWait", result.stats.ServerProcessing).
+		WithField("Delta", result.stats.Total-original).
+		Infof("Replayed %v %v", entry.Request.Method, u)
+	return result
+}
+
+func replayCommand(args []string) {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	targetURL := fs.String("target", "", "replay against this scheme://host instead of the one recorded")
+	speed := fs.Float64("speed", 1, "replay speed relative to the original timing, 0 to send requests back to back")
+	fs.Usage = func() {
+		os.Stderr.Write([]byte("Usage: hlsbenchmark replay [flags] capture.har\n"))
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, envPrefix+"REPLAY_", args)
+	if fs.NArg() < 1 || *speed < 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	var target *url.URL
+	if *targetURL != "" {
+		var err error
+		target, err = url.Parse(*targetURL)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	data, err := ioutil.ReadFile(fs.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	var har harFile
+	if err := json.Unmarshal(data, &har); err != nil {
+		log.Fatal(err)
+	}
+	entries := har.Log.Entries
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].StartedDateTime.Before(entries[j].StartedDateTime)
+	})
+	if len(entries) == 0 {
+		log.Fatal("No entries in HAR")
+	}
+
+	// Requests are started at the same offsets they were in the capture, so
+	// the original concurrency is preserved.
+	errs := NewErrorSummary()
+	traceID := randomHex(16)
+	start := time.Now()
+	first := entries[0].StartedDateTime
+	results := make([]replayResult, len(entries))
+	var wg sync.WaitGroup
+	for i := range entries {
+		entry := &entries[i]
+		if entry.Request.Method != "GET" && entry.Request.Method != "HEAD" {
+			log.Debugf("Skipping %v %v", entry.Request.Method, entry.Request.URL)
+			results[i].skipped = true
+			continue
+		}
+		if *speed > 0 {
+			offset := time.Duration(float64(entry.StartedDateTime.Sub(first)) / *speed)
+			time.Sleep(time.Until(start.Add(offset)))
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = replayEntry(entry, target, traceID, errs)
+		}(i)
+		if *speed == 0 {
+			wg.Wait()
+		}
+	}
+	wg.Wait()
+
+	replayed := ResultSummary{}
+	var originalTotal, replayTotal, originalWait, replayWait time.Duration
+	count := 0
+	for _, result := range results {
+		if result.skipped {
+			continue
+		}
+		count++
+		replayed.Add(&RequestResult{Bytes: result.bytes, Timings: NewTimings(result.stats)})
+		originalTotal += harDuration(result.entry.Time)
+		originalWait += harDuration(result.entry.Timings.Wait)
+		replayTotal += result.stats.Total
+		replayWait += result.stats.ServerProcessing
+	}
+	replayed.LogSummary()
+	if count > 0 {
+		n := time.Duration(count)
+		log.WithField("Requests", count).
+			WithField("OriginalTotal", originalTotal/n).
+			WithField("ReplayTotal", replayTotal/n).
+			WithField("OriginalWait", originalWait/n).
+			WithField("ReplayWait", replayWait/n).
+			Info("Replay comparison averages")
+	}
+	errs.LogSummary()
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+type rewriteRule struct {
+	pattern     *regexp.Regexp
+	replacement string
+}
+
+// rewriteRules is a repeatable flag of "regexp=>replacement" rules, applied
+// in order. Replacements may refer to capture groups as $1 or ${name}.
+type rewriteRules []rewriteRule
+
+func (rr *rewriteRules) String() string {
+	var rules []string
+	for _, r := range *rr {
+		rules = append(rules, r.pattern.String()+"=>"+r.replacement)
+	}
+	return strings.Join(rules, ", ")
+}
+
+func (rr *rewriteRules) Set(value string) error {
+	parts := strings.SplitN(value, "=>", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("rewrite rule %q is not of the form regexp=>replacement", value)
+	}
+	pattern, err := regexp.Compile(parts[0])
+	if err != nil {
+		return err
+	}
+	*rr = append(*rr, rewriteRule{pattern, parts[1]})
+	return nil
+}
+
+func (rr rewriteRules) Apply(uri string) string {
+	for _, r := range rr {
+		uri = r.pattern.ReplaceAllString(uri, r.replacement)
+	}
+	return uri
+}
+
+var uriRewrites rewriteRules
+
+func init() {
+	flag.Var(&uriRewrites, "rewrite", "`regexp=>replacement` applied to every URI taken from a playlist, can be repeated")
+}
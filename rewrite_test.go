@@ -0,0 +1,37 @@
+package main
+
+import "testing"
+
+func TestRewriteRules(t *testing.T) {
+	var rr rewriteRules
+	for _, bad := range []string{"no arrow", "([=>x"} {
+		if err := rr.Set(bad); err == nil {
+			t.Errorf("Set(%q) succeeded, want an error", bad)
+		}
+	}
+	for _, rule := range []string{
+		`^https://cdn\.example\.com/=>https://origin.example.com/`,
+		`/(\d+)p/=>/v-$1/`,
+		`\.ts(\?.*)?$=>.ts?nocache=1`,
+		`(?P<name>seg)_=>${name}-`,
+	} {
+		if err := rr.Set(rule); err != nil {
+			t.Fatalf("Set(%q): %v", rule, err)
+		}
+	}
+	tests := []struct {
+		uri, want string
+	}{
+		{"https://cdn.example.com/720p/seg_1.ts", "https://origin.example.com/v-720/seg-1.ts?nocache=1"},
+		{"https://cdn.example.com/720p/seg_1.ts?token=abc", "https://origin.example.com/v-720/seg-1.ts?nocache=1"},
+		{"https://other.example.com/key.bin", "https://other.example.com/key.bin"},
+	}
+	for _, test := range tests {
+		if got := rr.Apply(test.uri); got != test.want {
+			t.Errorf("Apply(%q) = %q, want %q", test.uri, got, test.want)
+		}
+	}
+	if got, want := rr[1].pattern.String()+"=>"+rr[1].replacement, `/(\d+)p/=>/v-$1/`; got != want {
+		t.Errorf("rule %q, want %q", got, want)
+	}
+}
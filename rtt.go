@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"net"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var rttInterval = flag.Duration("rtt-interval", 0, "time TCP connections to every edge responses came from this often, as a baseline round trip time to tell network latency apart from slow servers")
+
+// rttTimeout bounds how long a baseline connection may take.
+const rttTimeout = 5 * time.Second
+
+// RTTProber times TCP handshakes to the edges seen during a run. ICMP would
+// need raw sockets, so a connect that is closed straight away stands in for
+// a ping.
+type RTTProber struct {
+	mu       sync.Mutex
+	edges    map[string]bool
+	times    map[string][]time.Duration
+	failures map[string]int
+}
+
+func NewRTTProber() *RTTProber {
+	return &RTTProber{
+		edges:    map[string]bool{},
+		times:    map[string][]time.Duration{},
+		failures: map[string]int{},
+	}
+}
+
+// Seen adds the address of an edge to those probed.
+func (rp *RTTProber) Seen(addr string) {
+	if addr == "" {
+		return
+	}
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+	rp.edges[addr] = true
+}
+
+// Latest returns the last round trip time measured to addr, or 0.
+func (rp *RTTProber) Latest(addr string) time.Duration {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+	times := rp.times[addr]
+	if len(times) == 0 {
+		return 0
+	}
+	return times[len(times)-1]
+}
+
+func (rp *RTTProber) probe(addr string) {
+	start := time.Now()
+	conn, err := net.DialTimeout("tcp", addr, rttTimeout)
+	d := time.Since(start)
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+	if err != nil {
+		log.Debugf("Baseline connection to %v: %v", addr, err)
+		rp.failures[addr]++
+		return
+	}
+	conn.Close()
+	rp.times[addr] = append(rp.times[addr], d)
+}
+
+// Run probes every edge seen every -rtt-interval until ctx is done.
+func (rp *RTTProber) Run(ctx context.Context) {
+	ticker := time.NewTicker(*rttInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		rp.mu.Lock()
+		var edges []string
+		for addr := range rp.edges {
+			edges = append(edges, addr)
+		}
+		rp.mu.Unlock()
+		for _, addr := range edges {
+			rp.probe(addr)
+		}
+	}
+}
+
+// RTTReport is the baseline round trip times to one edge.
+type RTTReport struct {
+	Probes   int                      `json:"probes"`
+	Failures int                      `json:"failures"`
+	RTT      map[string]time.Duration `json:"rtt,omitempty"`
+}
+
+// Report returns nil if no edges were probed.
+func (rp *RTTProber) Report() map[string]*RTTReport {
+	if rp == nil {
+		return nil
+	}
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+	if len(rp.times) == 0 && len(rp.failures) == 0 {
+		return nil
+	}
+	reports := map[string]*RTTReport{}
+	for addr := range rp.edges {
+		times, failures := rp.times[addr], rp.failures[addr]
+		if len(times) == 0 && failures == 0 {
+			continue
+		}
+		reports[addr] = &RTTReport{
+			Probes:   len(times) + failures,
+			Failures: failures,
+			RTT:      latencyPercentiles(times),
+		}
+	}
+	return reports
+}
+
+func (rp *RTTProber) LogSummary() {
+	for addr, r := range rp.Report() {
+		entry := log.WithField("Probes", r.Probes).WithField("Failures", r.Failures)
+		for name, d := range r.RTT {
+			entry = entry.WithField("RTT"+name, d)
+		}
+		entry.Infof("Baseline RTT to %v", addr)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var runDir = flag.String("run-dir", "", "directory in which every run gets a directory named after its ID holding the flags used, its log and its results")
+
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// newULID returns a ULID: 48 bits of millisecond timestamp followed by 80
+// random bits in Crockford's base32, so run IDs sort by start time.
+func newULID(t time.Time) string {
+	var b [16]byte
+	ms := uint64(t.UnixNano() / int64(time.Millisecond))
+	for i := 0; i < 6; i++ {
+		b[i] = byte(ms >> uint(40-8*i))
+	}
+	if _, err := rand.Read(b[6:]); err != nil {
+		log.Fatal(err)
+	}
+
+	// 128 bits make 26 characters of 5 bits, the first holding only 3.
+	var id [26]byte
+	var acc uint32
+	bits := 2
+	n := 0
+	for _, c := range b {
+		acc = acc<<8 | uint32(c)
+		bits += 8
+		for bits >= 5 {
+			bits -= 5
+			id[n] = crockford[(acc>>uint(bits))&31]
+			n++
+		}
+	}
+	return string(id[:])
+}
+
+// runDirSink keeps a run's results in its -run-dir directory:
+//
+//	config.json
+//	run.log         only when benchmarking a single playlist
+//	results.jsonl
+//	summary.json
+type runDirSink struct {
+	dir     string
+	results OutputSink
+}
+
+func newRunDirSink(dir string) (*runDirSink, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	config, err := json.MarshalIndent(flagConfig(), "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "config.json"), config, 0644); err != nil {
+		return nil, err
+	}
+	results, err := newJSONSink(filepath.Join(dir, "results.jsonl"))
+	if err != nil {
+		return nil, err
+	}
+	return &runDirSink{dir: dir, results: results}, nil
+}
+
+func (rd *runDirSink) Result(result *RequestResult) {
+	rd.results.Result(result)
+}
+
+func (rd *runDirSink) Summary(s *RunSummary) {
+	rd.results.Summary(s)
+	summary, err := json.MarshalIndent(newJSONSummary(s), "", "  ")
+	if err == nil {
+		err = ioutil.WriteFile(filepath.Join(rd.dir, "summary.json"), summary, 0644)
+	}
+	if err != nil {
+		log.Error(err)
+	}
+}
+
+func (rd *runDirSink) Close() error {
+	return rd.results.Close()
+}
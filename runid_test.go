@@ -0,0 +1,44 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// ulidTime decodes the millisecond timestamp of the first 10 characters of a
+// ULID.
+func ulidTime(id string) time.Time {
+	var ms int64
+	for _, c := range id[:10] {
+		ms = ms<<5 | int64(strings.IndexRune(crockford, c))
+	}
+	return time.Unix(0, ms*int64(time.Millisecond))
+}
+
+func TestNewULID(t *testing.T) {
+	// The example of the ULID specification.
+	at := time.Unix(0, 1469918176385*int64(time.Millisecond))
+	id := newULID(at)
+	if len(id) != 26 {
+		t.Fatalf("newULID = %q, want 26 characters", id)
+	}
+	if !strings.HasPrefix(id, "01ARYZ6S41") {
+		t.Errorf("newULID = %q, want the timestamp 01ARYZ6S41", id)
+	}
+	if strings.Trim(id, crockford) != "" {
+		t.Errorf("newULID = %q, want only Crockford base32", id)
+	}
+	if got := ulidTime(id); !got.Equal(at) {
+		t.Errorf("timestamp of %q = %v, want %v", id, got, at)
+	}
+	if other := newULID(at); other == id {
+		t.Errorf("newULID returned %q twice for the same time", id)
+	}
+
+	// IDs sort by time.
+	earlier, later := newULID(at), newULID(at.Add(time.Millisecond))
+	if earlier >= later {
+		t.Errorf("%q sorts after %q", earlier, later)
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !aix && !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris
+// +build !aix,!darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris
+
+package main
+
+import "time"
+
+// cpuTime can't tell the CPU time the process has used on this platform.
+func cpuTime() (time.Duration, time.Duration, bool) {
+	return 0, 0, false
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+)
+
+var saveDir = flag.String("save-dir", "", "directory to write downloaded segments and a manifest.jsonl of what was fetched to")
+
+// SavedSegment is one line of the manifest written alongside saved segments.
+type SavedSegment struct {
+	File       string    `json:"file"`
+	URI        string    `json:"uri"`
+	Range      string    `json:"range,omitempty"`
+	FetchedAt  time.Time `json:"fetched_at"`
+	Bytes      int64     `json:"bytes"`
+	StatusCode int       `json:"status_code"`
+	RequestID  string    `json:"request_id,omitempty"`
+}
+
+type SegmentStore struct {
+	mu       sync.Mutex
+	dir      string
+	seq      int
+	manifest *os.File
+	enc      *json.Encoder
+}
+
+func NewSegmentStore(dir string) (*SegmentStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	manifest, err := os.Create(filepath.Join(dir, "manifest.jsonl"))
+	if err != nil {
+		return nil, err
+	}
+	return &SegmentStore{
+		dir:      dir,
+		manifest: manifest,
+		enc:      json.NewEncoder(manifest),
+	}, nil
+}
+
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+// archiveName builds a file name for a downloaded segment. Names are prefixed
+// with a sequence number since live streams and byte ranges reuse URIs.
+func archiveName(seq int, segment *SegmentDownload) string {
+	name := "segment"
+	if u, err := url.Parse(segment.URI); err == nil && path.Base(u.Path) != "/" && path.Base(u.Path) != "." {
+		name = unsafeFileChars.ReplaceAllString(path.Base(u.Path), "_")
+	}
+	if segment.Limit > 0 {
+		name = fmt.Sprintf("%s@%d-%d", name, segment.SegmentStart(), segment.SegmentEnd())
+	}
+	return fmt.Sprintf("%06d-%s", seq, name)
+}
+
+// Save writes the response body to a new file and records it in the
+// manifest. Failing to save the segment fails its request, like failing to
+// read it.
+func (ss *SegmentStore) Save(segment *SegmentDownload, resp *http.Response, fetchedAt time.Time) (int64, error) {
+	ss.mu.Lock()
+	ss.seq++
+	seq := ss.seq
+	ss.mu.Unlock()
+
+	name := archiveName(seq, segment)
+
+	file, err := os.Create(filepath.Join(ss.dir, name))
+	if err != nil {
+		return 0, err
+	}
+	n, copyErr := io.Copy(file, resp.Body)
+	if err := file.Close(); err != nil {
+		return n, err
+	}
+
+	saved := SavedSegment{
+		File:       name,
+		URI:        segment.URI,
+		FetchedAt:  fetchedAt,
+		Bytes:      n,
+		StatusCode: resp.StatusCode,
+		Range:      segment.Range(),
+	}
+	if *requestIDHeader != "" {
+		saved.RequestID = resp.Request.Header.Get(*requestIDHeader)
+	}
+	ss.mu.Lock()
+	err = ss.enc.Encode(saved)
+	ss.mu.Unlock()
+	if err != nil {
+		return n, err
+	}
+	return n, copyErr
+}
+
+func (ss *SegmentStore) Close() error {
+	return ss.manifest.Close()
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var corsOrigin = flag.String("origin", "", "send this `origin` with every request, as a browser-based player on it would, checking CORS and Timing-Allow-Origin headers allow it")
+
+// The security headers checked, and the kinds of response they are checked
+// on.
+var (
+	securityHeaders = []string{"Strict-Transport-Security", "Access-Control-Allow-Origin", "Timing-Allow-Origin"}
+	securityKinds   = map[string]bool{KindPlaylist: true, KindSegment: true, KindPart: true}
+)
+
+// setOrigin sets the Origin header of req with -origin.
+func setOrigin(req *http.Request) {
+	if *corsOrigin != "" {
+		req.Header.Set("Origin", *corsOrigin)
+	}
+}
+
+// allowsOrigin reports whether an Access-Control-Allow-Origin or
+// Timing-Allow-Origin value lets -origin in, which any value does without
+// it.
+func allowsOrigin(values []string) bool {
+	if *corsOrigin == "" {
+		return true
+	}
+	for _, v := range values {
+		for _, o := range strings.Split(v, ",") {
+			if o = strings.TrimSpace(o); o == "*" || o == *corsOrigin {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// securityProblem returns what is wrong with header in resp, and whether it
+// is missing rather than misconfigured.
+func securityProblem(resp *http.Response, header string) (string, bool) {
+	values := resp.Header[header]
+	if len(values) == 0 {
+		switch header {
+		case "Strict-Transport-Security":
+			// Browsers ignore HSTS sent over plain HTTP.
+			if resp.TLS == nil {
+				return "", false
+			}
+			return "no HSTS, so players can be downgraded to plain HTTP", true
+		case "Access-Control-Allow-Origin":
+			return "no CORS, so browser-based players on other origins can't load it", true
+		default:
+			return "no Timing-Allow-Origin, so players can't see its Resource Timing details", true
+		}
+	}
+	switch header {
+	case "Strict-Transport-Security":
+		maxAge := -1
+		for _, directive := range strings.Split(values[0], ";") {
+			name := strings.TrimSpace(directive)
+			if strings.HasPrefix(strings.ToLower(name), "max-age=") {
+				maxAge, _ = strconv.Atoi(strings.Trim(name[len("max-age="):], `"`))
+			}
+		}
+		if maxAge <= 0 {
+			return fmt.Sprintf("HSTS %q has no positive max-age", values[0]), false
+		}
+	case "Access-Control-Allow-Origin":
+		if len(values) > 1 || strings.Contains(values[0], ",") {
+			return fmt.Sprintf("Access-Control-Allow-Origin has several origins, %q, which browsers reject", strings.Join(values, ", ")), false
+		}
+		if values[0] == "*" && strings.EqualFold(resp.Header.Get("Access-Control-Allow-Credentials"), "true") {
+			return "Access-Control-Allow-Origin * with credentials, which browsers reject", false
+		}
+		if !allowsOrigin(values) {
+			return fmt.Sprintf("Access-Control-Allow-Origin %q doesn't allow %v", values[0], *corsOrigin), false
+		}
+	case "Timing-Allow-Origin":
+		if !allowsOrigin(values) {
+			return fmt.Sprintf("Timing-Allow-Origin %q doesn't allow %v", strings.Join(values, ", "), *corsOrigin), false
+		}
+	}
+	return "", false
+}
+
+// HeaderCheck counts the responses of a kind missing a security header or
+// sending a misconfigured one, with the first problem found.
+type HeaderCheck struct {
+	Responses     int    `json:"responses"`
+	Missing       int    `json:"missing"`
+	Misconfigured int    `json:"misconfigured"`
+	Problem       string `json:"problem,omitempty"`
+}
+
+// HeaderChecks are the checks of the responses of one kind by header.
+type HeaderChecks map[string]*HeaderCheck
+
+// SecurityHeaderTracker checks the headers browser-based players depend on
+// on playlists, segments and parts.
+type SecurityHeaderTracker struct {
+	mu     sync.Mutex
+	checks map[string]HeaderChecks
+}
+
+func NewSecurityHeaderTracker() *SecurityHeaderTracker {
+	return &SecurityHeaderTracker{checks: map[string]HeaderChecks{}}
+}
+
+func (st *SecurityHeaderTracker) Add(kind string, resp *http.Response) {
+	if !securityKinds[kind] {
+		return
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	checks, ok := st.checks[kind]
+	if !ok {
+		checks = HeaderChecks{}
+		st.checks[kind] = checks
+	}
+	for _, header := range securityHeaders {
+		check, ok := checks[header]
+		if !ok {
+			check = &HeaderCheck{}
+			checks[header] = check
+		}
+		check.Responses++
+		problem, missing := securityProblem(resp, header)
+		if problem == "" {
+			continue
+		}
+		if missing {
+			check.Missing++
+		} else {
+			check.Misconfigured++
+		}
+		if check.Problem == "" {
+			check.Problem = problem
+		}
+	}
+}
+
+// Report returns the checks by kind and header, or nil if no playlists,
+// segments or parts were fetched.
+func (st *SecurityHeaderTracker) Report() map[string]HeaderChecks {
+	if st == nil {
+		return nil
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if len(st.checks) == 0 {
+		return nil
+	}
+	reports := map[string]HeaderChecks{}
+	for kind, checks := range st.checks {
+		reports[kind] = HeaderChecks{}
+		for header, check := range checks {
+			copied := *check
+			reports[kind][header] = &copied
+		}
+	}
+	return reports
+}
+
+func (st *SecurityHeaderTracker) LogSummary() {
+	reports := st.Report()
+	var kinds []string
+	for kind := range reports {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	for _, kind := range kinds {
+		for _, header := range securityHeaders {
+			check := reports[kind][header]
+			if check == nil || check.Problem == "" {
+				continue
+			}
+			log.WithField("Responses", check.Responses).
+				WithField("Missing", check.Missing).
+				WithField("Misconfigured", check.Misconfigured).
+				Warnf("%v of %vs: %v", header, kind, check.Problem)
+		}
+	}
+}
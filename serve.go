@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// mockOrigin serves a synthetic HLS stream: /vod.m3u8 is a closed playlist
+// and /live.m3u8 a sliding window that advances in real time. Both reference
+// /segments/<n>.ts, which honours Range requests.
+type mockOrigin struct {
+	start           time.Time
+	segmentDuration time.Duration
+	segments        int
+	window          int
+	latency         time.Duration
+	jitter          time.Duration
+	errorRate       float64
+	errorStatus     int
+	payload         []byte
+
+	mu  sync.Mutex
+	rng *rand.Rand
+}
+
+const tsPacketSize = 188
+
+// tsPayload builds size bytes of MPEG-TS null packets, enough to look like a
+// segment to anything that only checks sync bytes.
+func tsPayload(size int) []byte {
+	payload := bytes.Repeat([]byte{0xff}, size)
+	for i := 0; i < size; i += tsPacketSize {
+		payload[i] = 0x47
+	}
+	return payload
+}
+
+func (o *mockOrigin) random() float64 {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.rng.Float64()
+}
+
+// inject delays the response and, at the configured rate, fails it. It
+// reports whether the request was answered with an error.
+func (o *mockOrigin) inject(w http.ResponseWriter) bool {
+	time.Sleep(o.latency + time.Duration(o.random()*float64(o.jitter)))
+	if o.errorRate <= 0 || o.random() >= o.errorRate {
+		return false
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(o.errorStatus)
+	fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?>`+"\n"+
+		`<Error><Code>InjectedError</Code><Message>Error injected by hlsbenchmark serve</Message></Error>`)
+	return true
+}
+
+// liveHead is the sequence number of the next segment to be published. The
+// stream is treated as having started a full window before the server so
+// the live playlist is never empty.
+func (o *mockOrigin) liveHead() int {
+	return o.window + int(time.Since(o.start)/o.segmentDuration)
+}
+
+func (o *mockOrigin) writePlaylist(w http.ResponseWriter, first, last int, closed bool) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "#EXTM3U\n#EXT-X-VERSION:4\n#EXT-X-TARGETDURATION:%d\n", int(o.segmentDuration.Seconds()+0.5))
+	fmt.Fprintf(&b, "#EXT-X-MEDIA-SEQUENCE:%d\n", first)
+	if closed {
+		b.WriteString("#EXT-X-PLAYLIST-TYPE:VOD\n")
+	}
+	for seq := first; seq < last; seq++ {
+		fmt.Fprintf(&b, "#EXTINF:%.3f,\nsegments/%d.ts\n", o.segmentDuration.Seconds(), seq)
+	}
+	if closed {
+		b.WriteString("#EXT-X-ENDLIST\n")
+	}
+	w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+	w.Header().Set("Cache-Control", "max-age=1")
+	w.Header().Set("X-Cache", "MISS from hlsbenchmark")
+	w.Write([]byte(b.String()))
+}
+
+func (o *mockOrigin) serveVOD(w http.ResponseWriter, r *http.Request) {
+	if o.inject(w) {
+		return
+	}
+	o.writePlaylist(w, 0, o.segments, true)
+}
+
+func (o *mockOrigin) serveLive(w http.ResponseWriter, r *http.Request) {
+	if o.inject(w) {
+		return
+	}
+	head := o.liveHead()
+	o.writePlaylist(w, head-o.window, head, false)
+}
+
+func (o *mockOrigin) serveSegment(w http.ResponseWriter, r *http.Request) {
+	name := path.Base(r.URL.Path)
+	seq, err := strconv.Atoi(strings.TrimSuffix(name, ".ts"))
+	if err != nil || seq < 0 || (seq >= o.segments && seq >= o.liveHead()) {
+		http.NotFound(w, r)
+		return
+	}
+	if o.inject(w) {
+		return
+	}
+	w.Header().Set("Content-Type", "video/mp2t")
+	w.Header().Set("Cache-Control", "max-age=3600")
+	w.Header().Set("X-Cache", "MISS from hlsbenchmark")
+	http.ServeContent(w, r, name, o.start, bytes.NewReader(o.payload))
+}
+
+// handler serves the playlists and segments of the stream.
+func (o *mockOrigin) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/vod.m3u8", o.serveVOD)
+	mux.HandleFunc("/live.m3u8", o.serveLive)
+	mux.HandleFunc("/segments/", o.serveSegment)
+	return mux
+}
+
+func serveCommand(args []string) {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := fs.String("addr", "localhost:8080", "address to listen on")
+	segmentDuration := fs.Duration("segment-duration", 6*time.Second, "duration of each segment")
+	segmentSize := fs.Int("segment-size", 1<<20, "size of each segment in bytes")
+	segments := fs.Int("segments", 100, "number of segments in /vod.m3u8")
+	window := fs.Int("window", 6, "number of segments in the /live.m3u8 window")
+	latency := fs.Duration("latency", 0, "delay before every response")
+	jitter := fs.Duration("jitter", 0, "maximum random delay added to every response")
+	errorRate := fs.Float64("error-rate", 0, "fraction of requests, 0 to 1, answered with -error-status")
+	errorStatus := fs.Int("error-status", http.StatusServiceUnavailable, "status code for injected errors")
+	fs.Usage = func() {
+		os.Stderr.Write([]byte("Usage: hlsbenchmark serve [flags]\n"))
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, envPrefix+"SERVE_", args)
+	if *segmentDuration <= 0 || *segmentSize <= 0 || *window <= 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	origin := &mockOrigin{
+		start:           time.Now(),
+		segmentDuration: *segmentDuration,
+		segments:        *segments,
+		window:          *window,
+		latency:         *latency,
+		jitter:          *jitter,
+		errorRate:       *errorRate,
+		errorStatus:     *errorStatus,
+		payload:         tsPayload(*segmentSize),
+		rng:             rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	log.Infof("Serving http://%v/vod.m3u8 and http://%v/live.m3u8", *addr, *addr)
+	log.Fatal(http.ListenAndServe(*addr, origin.handler()))
+}
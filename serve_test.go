@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// TestServeBenchmark benchmarks the VOD playlist of a mock origin, checking
+// the segments and errors the benchmark counts against what was served.
+func TestServeBenchmark(t *testing.T) {
+	level := log.GetLevel()
+	log.SetLevel(log.ErrorLevel)
+	defer log.SetLevel(level)
+
+	tests := []struct {
+		name     string
+		failing  string
+		segments int
+		errors   map[ErrorCategory]int
+	}{
+		{"healthy", "", 5, nil},
+		{"failing segment", "/segments/2.ts", 4, map[ErrorCategory]int{Err5xx: 1}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			origin := &mockOrigin{
+				start:           time.Now(),
+				segmentDuration: time.Second,
+				segments:        5,
+				window:          3,
+				payload:         tsPayload(16 * tsPacketSize),
+				rng:             rand.New(rand.NewSource(1)),
+			}
+			handler := origin.handler()
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == test.failing {
+					http.Error(w, "failing", http.StatusServiceUnavailable)
+					return
+				}
+				handler.ServeHTTP(w, r)
+			}))
+			defer server.Close()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			run, err := newRun(ctx, server.URL+"/vod.m3u8", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			summary := run.Execute()
+
+			if got := len(summary.Results.Total); got != test.segments {
+				t.Errorf("%d segments, want %d", got, test.segments)
+			}
+			errors := summary.Errors.Snapshot()
+			for _, category := range errorCategories {
+				got := 0
+				if stats, ok := errors.Categories[category]; ok {
+					got = stats.Count
+				}
+				if got != test.errors[category] {
+					t.Errorf("%d %v errors, want %d", got, category, test.errors[category])
+				}
+			}
+		})
+	}
+}
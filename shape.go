@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"math"
+	"math/rand"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	bandwidthSchedule = flag.String("bandwidth-schedule", "", "`file` of offset,bits-per-second lines capping the benchmark's download bandwidth from that far into the run on, 0 for no cap, to reproduce customers' network traces")
+	injectLatency     = flag.Duration("inject-latency", 0, "add this much to the round trip time of every connection, delaying every write")
+	injectLoss        = flag.Float64("inject-loss", 0, "emulate losing this `percent` of packets, delaying reads as long as a retransmission would")
+)
+
+// The size of a packet and how long it takes to retransmit a lost one on
+// top of the round trip time, Linux's minimum retransmission timeout, as
+// far as -inject-loss is concerned.
+const (
+	emulatedPacketSize = 1460
+	retransmitTimeout  = 200 * time.Millisecond
+)
+
+// shapedReadSize bounds how much a shaped connection reads at once, so
+// that it waits in small steps rather than a large one.
+const shapedReadSize = 16 * 1024
+
+// bandwidthStep is a cap on bandwidth from Offset into the run on.
+type bandwidthStep struct {
+	Offset time.Duration
+	Bps    float64
+}
+
+// bandwidthShaper caps the bandwidth of all connections together going by a
+// schedule, and emulates latency and packet loss. Reads are delayed after
+// the fact, so the cap only holds on average, over more than what the
+// kernel buffers.
+type bandwidthShaper struct {
+	mu      sync.Mutex
+	steps   []bandwidthStep
+	start   time.Time
+	step    int
+	next    time.Time
+	started bool
+	latency time.Duration
+	loss    float64
+}
+
+// shaper shapes every connection with -bandwidth-schedule, -inject-latency
+// or -inject-loss.
+var shaper *bandwidthShaper
+
+func newBandwidthShaper() *bandwidthShaper {
+	return &bandwidthShaper{step: -1}
+}
+
+// Emulate sets the latency and the percentage of packets lost to emulate
+// from now on, on connections old and new.
+func (bs *bandwidthShaper) Emulate(latency time.Duration, loss float64) {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	bs.latency, bs.loss = latency, loss
+}
+
+// delay delays a write by the latency emulated.
+func (bs *bandwidthShaper) delay() {
+	bs.mu.Lock()
+	latency := bs.latency
+	bs.mu.Unlock()
+	if latency > 0 {
+		time.Sleep(latency)
+	}
+}
+
+// lose delays the reader of n bytes as long as retransmitting them would
+// take, if any of the packets they came in would have been lost.
+func (bs *bandwidthShaper) lose(n int) {
+	bs.mu.Lock()
+	latency, loss := bs.latency, bs.loss
+	bs.mu.Unlock()
+	if loss <= 0 {
+		return
+	}
+	packets := float64((n + emulatedPacketSize - 1) / emulatedPacketSize)
+	if rand.Float64() < 1-math.Pow(1-loss/100, packets) {
+		time.Sleep(retransmitTimeout + latency)
+	}
+}
+
+// loadBandwidthSchedule reads a schedule of offset,bps lines, offsets being
+// durations such as 1m30s or seconds. Blank lines and # comments are
+// skipped.
+func loadBandwidthSchedule(name string) (*bandwidthShaper, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	bs := newBandwidthShaper()
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Split(text, ",")
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%v:%d: %q is not of the form offset,bps", name, line, text)
+		}
+		offset, err := time.ParseDuration(strings.TrimSpace(fields[0]))
+		if err != nil {
+			seconds, serr := strconv.ParseFloat(strings.TrimSpace(fields[0]), 64)
+			if serr != nil {
+				return nil, fmt.Errorf("%v:%d: offset %q is neither a duration nor seconds", name, line, fields[0])
+			}
+			offset = time.Duration(seconds * float64(time.Second))
+		}
+		bps, err := strconv.ParseFloat(strings.TrimSpace(fields[1]), 64)
+		if err != nil || bps < 0 {
+			return nil, fmt.Errorf("%v:%d: bandwidth %q is not a number of bits per second", name, line, fields[1])
+		}
+		bs.steps = append(bs.steps, bandwidthStep{offset, bps})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(bs.steps, func(i, j int) bool { return bs.steps[i].Offset < bs.steps[j].Offset })
+	return bs, nil
+}
+
+// Start starts the schedule from now, unless it has started already: the
+// runs of the process share it as they do the connections it shapes, so a
+// run starting it over would do so for the runs going on too.
+func (bs *bandwidthShaper) Start(now time.Time) {
+	if bs == nil {
+		return
+	}
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	if bs.started {
+		return
+	}
+	bs.start, bs.step, bs.next, bs.started = now, -1, time.Time{}, true
+}
+
+// wait delays the reader of n bytes as long as the current cap demands.
+func (bs *bandwidthShaper) wait(n int) {
+	now := time.Now()
+	bs.mu.Lock()
+	step := -1
+	for i, s := range bs.steps {
+		if now.Sub(bs.start) >= s.Offset {
+			step = i
+		}
+	}
+	if step != bs.step {
+		bs.step = step
+		if step >= 0 && bs.steps[step].Bps > 0 {
+			log.Infof("Capping bandwidth at %.0f b/s", bs.steps[step].Bps)
+		} else {
+			log.Info("Not capping bandwidth")
+		}
+	}
+	if step < 0 || bs.steps[step].Bps == 0 {
+		bs.mu.Unlock()
+		return
+	}
+	if bs.next.Before(now) {
+		bs.next = now
+	}
+	bs.next = bs.next.Add(time.Duration(float64(n) * 8 / bs.steps[step].Bps * float64(time.Second)))
+	delay := bs.next.Sub(now)
+	bs.mu.Unlock()
+	time.Sleep(delay)
+}
+
+// shapedConn is a connection whose reads are shaped by a bandwidthShaper.
+type shapedConn struct {
+	net.Conn
+	shaper *bandwidthShaper
+}
+
+func (sc shapedConn) Read(p []byte) (int, error) {
+	if len(p) > shapedReadSize {
+		p = p[:shapedReadSize]
+	}
+	n, err := sc.Conn.Read(p)
+	if n > 0 {
+		sc.shaper.wait(n)
+		sc.shaper.lose(n)
+	}
+	return n, err
+}
+
+func (sc shapedConn) Write(p []byte) (int, error) {
+	sc.shaper.delay()
+	return sc.Conn.Write(p)
+}
+
+// shapeConn wraps a newly dialed connection with -bandwidth-schedule,
+// -inject-latency or -inject-loss, delaying it as long as the handshake
+// would have been.
+func shapeConn(conn net.Conn, err error) (net.Conn, error) {
+	if err != nil || shaper == nil {
+		return conn, err
+	}
+	shaper.delay()
+	return shapedConn{conn, shaper}, nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"flag"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var maxClockSkew = flag.Duration("max-clock-skew", 30*time.Second, "warn about hosts whose Date headers are this far off the local clock on median, enough to break signed URLs and cache validation")
+
+// ClockSkewTracker compares the Date header of every response with the time
+// it was sent according to the local clock, corrected by -ntp-server, and
+// gathers the differences by host. Dates only have a resolution of a second.
+type ClockSkewTracker struct {
+	mu    sync.Mutex
+	hosts map[string][]time.Duration
+}
+
+func NewClockSkewTracker() *ClockSkewTracker {
+	return &ClockSkewTracker{hosts: map[string][]time.Duration{}}
+}
+
+// Add records the skew of a response whose first byte arrived at
+// receivedAt.
+func (ct *ClockSkewTracker) Add(resp *http.Response, receivedAt time.Time) {
+	date, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		return
+	}
+	skew := date.Sub(wallClock(receivedAt).Truncate(time.Second))
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+	host := resp.Request.URL.Host
+	ct.hosts[host] = append(ct.hosts[host], skew)
+}
+
+// ClockSkewReport is how far ahead of the local clock the Date headers of a
+// host were. Skewed is set if the median is more than -max-clock-skew
+// either way.
+type ClockSkewReport struct {
+	Responses int           `json:"responses"`
+	Median    time.Duration `json:"median"`
+	Min       time.Duration `json:"min"`
+	Max       time.Duration `json:"max"`
+	Skewed    bool          `json:"skewed,omitempty"`
+}
+
+// Report returns nil if no responses had a Date header.
+func (ct *ClockSkewTracker) Report() map[string]*ClockSkewReport {
+	if ct == nil {
+		return nil
+	}
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+	if len(ct.hosts) == 0 {
+		return nil
+	}
+	reports := map[string]*ClockSkewReport{}
+	for host, skews := range ct.hosts {
+		sorted := append([]time.Duration(nil), skews...)
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+		median := sorted[len(sorted)/2]
+		reports[host] = &ClockSkewReport{
+			Responses: len(sorted),
+			Median:    median,
+			Min:       sorted[0],
+			Max:       sorted[len(sorted)-1],
+			Skewed:    median > *maxClockSkew || median < -*maxClockSkew,
+		}
+	}
+	return reports
+}
+
+func (ct *ClockSkewTracker) LogSummary() {
+	for host, r := range ct.Report() {
+		entry := log.WithField("Responses", r.Responses).
+			WithField("Median", r.Median).
+			WithField("Min", r.Min).
+			WithField("Max", r.Max)
+		if r.Skewed {
+			entry.Warnf("Clock of %v is off by more than %v", host, *maxClockSkew)
+		} else {
+			entry.Infof("Clock skew of %v", host)
+		}
+	}
+}
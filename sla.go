@@ -0,0 +1,88 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+)
+
+var (
+	streamLabel = flag.String("label", "", "name of the stream being benchmarked, used to tell runs apart in alerts and metrics (default the playlist URL)")
+
+	slaErrorRate   = flag.Float64("sla-error-rate", 0, "fraction of failed requests above which the SLA is breached, 0 for no limit")
+	slaSegmentTime = flag.Duration("sla-segment-time", 0, "average segment download time above which the SLA is breached, 0 for no limit")
+	slaMinimum     = flag.Int("sla-min-requests", 20, "number of requests made before the SLA is checked")
+
+	slaPlaylistBytes    = flag.Int("sla-playlist-bytes", 0, "size of a playlist above which the SLA is breached, e.g. as an EVENT playlist grows, 0 for no limit")
+	slaPlaylistSegments = flag.Int("sla-playlist-segments", 0, "number of segments in a playlist above which the SLA is breached, 0 for no limit")
+)
+
+// runLabel is the -label for a run of playlistURL.
+func runLabel(playlistURL string) string {
+	if *streamLabel != "" {
+		return *streamLabel
+	}
+	return playlistURL
+}
+
+// slaMonitor keeps the running totals the SLA thresholds are checked
+// against.
+type slaMonitor struct {
+	requests int
+	failures int
+	segments int
+	total    time.Duration
+	// playlist is why the largest playlist breaches the SLA.
+	playlist string
+}
+
+func slaEnabled() bool {
+	return *slaErrorRate > 0 || *slaSegmentTime > 0 || *slaPlaylistBytes > 0 || *slaPlaylistSegments > 0
+}
+
+// playlistTooLarge returns why a playlist of n bytes and segments breaches
+// the SLA, or "" if it doesn't. Giant playlists take slow clients long to
+// download and parse before they can start playing.
+func playlistTooLarge(n, segments int) string {
+	if *slaPlaylistBytes > 0 && n > *slaPlaylistBytes {
+		return fmt.Sprintf("playlist is %d bytes, above the %d byte SLA", n, *slaPlaylistBytes)
+	}
+	if *slaPlaylistSegments > 0 && segments > *slaPlaylistSegments {
+		return fmt.Sprintf("playlist has %d segments, above the %d segment SLA", segments, *slaPlaylistSegments)
+	}
+	return ""
+}
+
+// Observe adds result to the totals and returns why the SLA is breached, or
+// "" if it isn't.
+func (m *slaMonitor) Observe(result *RequestResult) string {
+	m.requests++
+	if result.ErrorCategory != "" {
+		m.failures++
+	} else if result.Kind == KindSegment && result.Timings != nil {
+		m.segments++
+		m.total += result.Timings.Total
+	} else if result.Kind == KindPlaylist && m.playlist == "" {
+		m.playlist = playlistTooLarge(int(result.Bytes), result.Segments)
+	}
+	return m.Breach()
+}
+
+// Breach returns why the SLA is breached by the totals so far, or "".
+func (m *slaMonitor) Breach() string {
+	if m.playlist != "" {
+		return m.playlist
+	}
+	if m.requests < *slaMinimum {
+		return ""
+	}
+	if rate := float64(m.failures) / float64(m.requests); *slaErrorRate > 0 && rate > *slaErrorRate {
+		return fmt.Sprintf("%.1f%% of %d requests failed, above the %.1f%% SLA", rate*100, m.requests, *slaErrorRate*100)
+	}
+	if m.segments > 0 && *slaSegmentTime > 0 {
+		if avg := m.total / time.Duration(m.segments); avg > *slaSegmentTime {
+			return fmt.Sprintf("segments took %v on average to download, above the %v SLA", avg.Round(time.Millisecond), *slaSegmentTime)
+		}
+	}
+	return ""
+}
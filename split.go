@@ -0,0 +1,187 @@
+package main
+
+import (
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/digitaljanitors/go-httpstat"
+	log "github.com/sirupsen/logrus"
+)
+
+var splitRanges = flag.Int("split-ranges", 0, "fetch every segment again as `n` range requests in parallel, each over its own connection, and compare with fetching it whole, to tell whether splitting helps on paths of high bandwidth-delay product")
+
+// splitClient fetches the ranges of -split-ranges. It speaks HTTP/1.1 only,
+// so that every range gets a connection, and congestion window, of its own
+// rather than being multiplexed over one.
+var splitClient *http.Client
+
+func newSplitClient() *http.Client {
+	transport := newTransport(nil)
+	transport.ForceAttemptHTTP2 = false
+	transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	transport.MaxIdleConnsPerHost = *splitRanges
+	return &http.Client{Transport: transport, CheckRedirect: checkRedirect}
+}
+
+// splitSample is a segment fetched whole and split.
+type splitSample struct {
+	bytes        int64
+	whole, split time.Duration
+}
+
+// SplitStats compares fetching segments whole with fetching them split
+// with -split-ranges. Segments are split after being fetched whole, so the
+// split fetch may be served from a cache the whole one filled.
+type SplitStats struct {
+	mu       sync.Mutex
+	samples  []splitSample
+	failures int
+}
+
+func NewSplitStats() *SplitStats {
+	return &SplitStats{}
+}
+
+// Fetch fetches the size bytes of v, already fetched whole in whole, as
+// -split-ranges range requests in parallel, reassembling them. They are
+// traced as part of traceID, the run's.
+func (ss *SplitStats) Fetch(v *SegmentDownload, size int64, whole time.Duration, traceID string) {
+	n := int64(*splitRanges)
+	if size < n {
+		return
+	}
+	var base int64
+	if v.Limit > 0 {
+		base = v.Offset
+	}
+	body := make([]byte, size)
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	started := time.Now()
+	for i := int64(0); i < n; i++ {
+		from, to := size*i/n, size*(i+1)/n
+		wg.Add(1)
+		go func(i int64) {
+			defer wg.Done()
+			errs[i] = fetchRange(v.URI, traceID, base+from, body[from:to])
+		}(i)
+	}
+	wg.Wait()
+	elapsed := time.Since(started)
+	for _, err := range errs {
+		if err != nil {
+			log.WithField("URI", v.URI).Warnf("Fetching segment split in %d ranges: %v", n, err)
+			ss.mu.Lock()
+			ss.failures++
+			ss.mu.Unlock()
+			return
+		}
+	}
+	log.WithField("Whole", whole).
+		WithField("Split", elapsed).
+		Debugf("Fetched %v split in %d ranges", v.URI, n)
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	ss.samples = append(ss.samples, splitSample{size, whole, elapsed})
+}
+
+// fetchRange reads len(buf) bytes of uri from offset into buf.
+func fetchRange(uri, traceID string, offset int64, buf []byte) error {
+	req, err := newRequest("GET", uri, &httpstat.Result{})
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+int64(len(buf))-1))
+	resp, err := doRequest(splitClient, traceID, req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusPartialContent {
+		return fmt.Errorf("HTTP %v for bytes %d-%d, not 206", resp.StatusCode, offset, offset+int64(len(buf))-1)
+	}
+	_, err = io.ReadFull(resp.Body, buf)
+	return err
+}
+
+// SplitReport compares the segments fetched whole and split: their
+// throughput weighted by size, their latency, and how many times faster
+// splitting was for the median segment.
+type SplitReport struct {
+	Ranges       int                      `json:"ranges"`
+	Segments     int                      `json:"segments"`
+	Failures     int                      `json:"failures"`
+	WholeMbps    float64                  `json:"whole_mbps"`
+	SplitMbps    float64                  `json:"split_mbps"`
+	WholeLatency map[string]time.Duration `json:"whole_latency,omitempty"`
+	SplitLatency map[string]time.Duration `json:"split_latency,omitempty"`
+	Speedup      float64                  `json:"speedup"`
+}
+
+// Report returns nil without -split-ranges.
+func (ss *SplitStats) Report() *SplitReport {
+	if ss == nil {
+		return nil
+	}
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	if len(ss.samples) == 0 && ss.failures == 0 {
+		return nil
+	}
+	report := &SplitReport{
+		Ranges:   *splitRanges,
+		Segments: len(ss.samples),
+		Failures: ss.failures,
+	}
+	if len(ss.samples) == 0 {
+		return report
+	}
+	var bytes int64
+	var wholeTotal, splitTotal time.Duration
+	var whole, split []time.Duration
+	var speedups []float64
+	for _, s := range ss.samples {
+		bytes += s.bytes
+		wholeTotal += s.whole
+		splitTotal += s.split
+		whole = append(whole, s.whole)
+		split = append(split, s.split)
+		if s.split > 0 {
+			speedups = append(speedups, float64(s.whole)/float64(s.split))
+		}
+	}
+	report.WholeMbps = mbps(bytes, wholeTotal)
+	report.SplitMbps = mbps(bytes, splitTotal)
+	report.WholeLatency = latencyPercentiles(whole)
+	report.SplitLatency = latencyPercentiles(split)
+	if len(speedups) > 0 {
+		sort.Float64s(speedups)
+		report.Speedup = speedups[len(speedups)/2]
+	}
+	return report
+}
+
+func (ss *SplitStats) LogSummary() {
+	r := ss.Report()
+	if r == nil {
+		return
+	}
+	entry := log.WithField("Segments", r.Segments).
+		WithField("Failures", r.Failures).
+		WithField("WholeMbps", r.WholeMbps).
+		WithField("SplitMbps", r.SplitMbps).
+		WithField("Speedup", r.Speedup)
+	for name, d := range r.WholeLatency {
+		entry = entry.WithField("Whole"+name, d)
+	}
+	for name, d := range r.SplitLatency {
+		entry = entry.WithField("Split"+name, d)
+	}
+	entry.Infof("Segments split in %d ranges", r.Ranges)
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// sseKeepAlive is how often a comment is sent on quiet event streams so
+// proxies don't time them out.
+const sseKeepAlive = 15 * time.Second
+
+// runEvent is either a result of a run or a change of its state.
+type runEvent struct {
+	Run    string         `json:"run"`
+	State  string         `json:"state,omitempty"`
+	Result *RequestResult `json:"result,omitempty"`
+}
+
+// eventFeed broadcasts the events of every daemon run. Like streamSink, slow
+// subscribers miss events rather than holding up runs.
+type eventFeed struct {
+	mu          sync.Mutex
+	subscribers map[chan runEvent]bool
+}
+
+func newEventFeed() *eventFeed {
+	return &eventFeed{subscribers: map[chan runEvent]bool{}}
+}
+
+func (ef *eventFeed) Subscribe() chan runEvent {
+	ef.mu.Lock()
+	defer ef.mu.Unlock()
+	ch := make(chan runEvent, 256)
+	ef.subscribers[ch] = true
+	return ch
+}
+
+func (ef *eventFeed) Unsubscribe(ch chan runEvent) {
+	ef.mu.Lock()
+	defer ef.mu.Unlock()
+	delete(ef.subscribers, ch)
+}
+
+func (ef *eventFeed) Publish(event runEvent) {
+	if ef == nil {
+		return
+	}
+	ef.mu.Lock()
+	defer ef.mu.Unlock()
+	for ch := range ef.subscribers {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+// ServeEvents streams the results of the run with the given ID, or of every
+// run if id is empty, as Server-Sent Events. Results are sent as "result"
+// events and runs starting and finishing as "state" events; the stream of a
+// single run ends once it has finished.
+func (d *Daemon) ServeEvents(w http.ResponseWriter, r *http.Request, id string) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("streaming is not supported"))
+		return
+	}
+	ch := d.feed.Subscribe()
+	defer d.feed.Unsubscribe(ch)
+
+	var finished bool
+	if id != "" {
+		run, ok := d.get(id)
+		if !ok {
+			writeError(w, http.StatusNotFound, fmt.Errorf("no run %v", id))
+			return
+		}
+		finished = run.State == stateFinished
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	send := func(event runEvent) bool {
+		name := "result"
+		if event.Result == nil {
+			name = "state"
+		}
+		data, err := json.Marshal(event)
+		if err != nil {
+			return false
+		}
+		_, err = fmt.Fprintf(w, "event: %v\ndata: %s\n\n", name, data)
+		flusher.Flush()
+		return err == nil
+	}
+	if finished {
+		send(runEvent{Run: id, State: stateFinished})
+		return
+	}
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(sseKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case event := <-ch:
+			if id != "" && event.Run != id {
+				continue
+			}
+			if !send(event) {
+				return
+			}
+			if id != "" && event.State == stateFinished {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"flag"
+	"sync"
+	"time"
+
+	"github.com/grafov/m3u8"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	stallAfter   = flag.Float64("stall-after", 3, "alert as soon as a live playlist has come back identical for this many target durations, as its encoder has likely stalled, 0 to never")
+	stallWebhook = flag.String("stall-webhook", "", "POST a JSON event to this `URL` when a live playlist stalls and when it recovers")
+)
+
+// StallEvent is a live playlist coming back identical for longer than
+// -stall-after target durations.
+type StallEvent struct {
+	Event          string        `json:"event"`
+	RunID          string        `json:"run_id"`
+	Label          string        `json:"label"`
+	PlaylistURL    string        `json:"playlist_url"`
+	Since          time.Time     `json:"since"`
+	Duration       time.Duration `json:"duration"`
+	TargetDuration float64       `json:"target_duration"`
+}
+
+// StallDetector watches the refreshes of a live playlist for it staying
+// identical, alerting as soon as it has for too long rather than once the
+// run is over.
+type StallDetector struct {
+	mu      sync.Mutex
+	sum     [sha256.Size]byte
+	changed time.Time
+	stalled *StallEvent
+	stalls  []*StallEvent
+}
+
+func NewStallDetector() *StallDetector {
+	return &StallDetector{}
+}
+
+// Observe compares a refresh of the playlist at uri with the one before,
+// alerting when it starts or stops being stalled.
+func (sd *StallDetector) Observe(run *Run, at time.Time, uri string, body []byte, mpl *m3u8.MediaPlaylist) {
+	if *stallAfter <= 0 || mpl.Closed {
+		return
+	}
+	sum := sha256.Sum256(body)
+	sd.mu.Lock()
+	defer sd.mu.Unlock()
+	if sd.changed.IsZero() || sum != sd.sum {
+		if sd.stalled != nil {
+			sd.stalled.Event = "recovered"
+			sd.stalled.Duration = at.Sub(sd.changed)
+			log.WithField("Duration", sd.stalled.Duration).
+				Warnf("Playlist %v changed again after stalling", uri)
+			sd.alert(*sd.stalled)
+			sd.stalled = nil
+		}
+		sd.sum, sd.changed = sum, at
+		return
+	}
+	unchanged := at.Sub(sd.changed)
+	if sd.stalled != nil {
+		sd.stalled.Duration = unchanged
+		return
+	}
+	if unchanged < time.Duration(*stallAfter*mpl.TargetDuration*float64(time.Second)) {
+		return
+	}
+	sd.stalled = &StallEvent{
+		Event:          "stalled",
+		RunID:          run.ID,
+		Label:          runLabel(run.PlaylistURL),
+		PlaylistURL:    uri,
+		Since:          sd.changed,
+		Duration:       unchanged,
+		TargetDuration: mpl.TargetDuration,
+	}
+	sd.stalls = append(sd.stalls, sd.stalled)
+	log.WithField("Since", sd.changed).
+		WithField("TargetDuration", mpl.TargetDuration).
+		Errorf("Playlist %v has not changed for %v, its encoder may have stalled", uri, unchanged)
+	sd.alert(*sd.stalled)
+}
+
+// alert posts event to -stall-webhook in the background.
+func (sd *StallDetector) alert(event StallEvent) {
+	if *stallWebhook == "" {
+		return
+	}
+	go func() {
+		body, err := json.Marshal(&event)
+		if err != nil {
+			log.Error(err)
+			return
+		}
+		resp, err := alertClient.Post(*stallWebhook, "application/json", bytes.NewReader(body))
+		if err != nil {
+			log.Errorf("Sending stall alert: %v", err)
+			return
+		}
+		resp.Body.Close()
+		if !isSuccess(resp) {
+			log.Errorf("Sending stall alert: webhook returned HTTP %v", resp.StatusCode)
+		}
+	}()
+}
+
+// StallReport is every stall of a run. Stalls still going on when the run
+// ended last as long as they had by the last refresh.
+type StallReport struct {
+	Stalls  []*StallEvent `json:"stalls"`
+	Longest time.Duration `json:"longest"`
+}
+
+// Report returns nil if the playlist never stalled.
+func (sd *StallDetector) Report() *StallReport {
+	if sd == nil {
+		return nil
+	}
+	sd.mu.Lock()
+	defer sd.mu.Unlock()
+	if len(sd.stalls) == 0 {
+		return nil
+	}
+	report := &StallReport{}
+	for _, stall := range sd.stalls {
+		copied := *stall
+		report.Stalls = append(report.Stalls, &copied)
+		if copied.Duration > report.Longest {
+			report.Longest = copied.Duration
+		}
+	}
+	return report
+}
+
+func (sd *StallDetector) LogSummary() {
+	r := sd.Report()
+	if r == nil {
+		return
+	}
+	log.WithField("Stalls", len(r.Stalls)).
+		WithField("Longest", r.Longest).
+		Warn("Playlist stalled")
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"flag"
+	"strconv"
+
+	"github.com/grafov/m3u8"
+	log "github.com/sirupsen/logrus"
+)
+
+var startOffset = flag.String("start-offset", "", "start this many `seconds` into the playlist, or from its end if negative, instead of where its EXT-X-START says; 0 ignores EXT-X-START")
+
+// startSequence returns the media sequence number of the segment players
+// join mpl at: the one holding its EXT-X-START TIME-OFFSET, or -start-offset.
+// Offsets beyond either end of the playlist start at that end.
+func startSequence(mpl *m3u8.MediaPlaylist) (uint64, error) {
+	offset, precise := mpl.StartTime, mpl.StartTimePrecise
+	if *startOffset != "" {
+		var err error
+		if offset, err = strconv.ParseFloat(*startOffset, 64); err != nil {
+			return 0, err
+		}
+	}
+	if offset == 0 {
+		return mpl.SeqNo, nil
+	}
+
+	var total float64
+	for _, s := range mpl.Segments {
+		if s != nil {
+			total += s.Duration
+		}
+	}
+	if offset < 0 {
+		offset += total
+	}
+	var t, start float64
+	seq := mpl.SeqNo
+	for i, s := range mpl.Segments {
+		if s == nil {
+			continue
+		}
+		seq, start = mpl.SeqNo+uint64(i), t
+		if offset < t+s.Duration {
+			break
+		}
+		t += s.Duration
+	}
+	log.WithField("TimeOffset", offset).
+		WithField("Precise", precise).
+		WithField("MediaSequence", seq).
+		Infof("Starting %.3fs into the playlist", start)
+	return seq, nil
+}
+
+// segmentsFrom drops the segments before media sequence number seq.
+func segmentsFrom(segments []*SegmentDownload, seq uint64) []*SegmentDownload {
+	var kept []*SegmentDownload
+	for _, s := range segments {
+		if s.Sequence >= seq {
+			kept = append(kept, s)
+		}
+	}
+	return kept
+}
+
+// segmentsAfter drops the segments of a rendition that end before the
+// variant's first segment to be fetched starts at t.
+func segmentsAfter(segments []*SegmentDownload, t float64) []*SegmentDownload {
+	var kept []*SegmentDownload
+	for _, s := range segments {
+		if s.MediaTime+s.Duration > t+pairTolerance {
+			kept = append(kept, s)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	concurrencySweep = flag.Int("concurrency-sweep", 0, "run the benchmark over and over at a -concurrency of 1, 2, 4 and so on up to this many, reporting where segment latency starts to degrade")
+	latencySweep     = flag.String("latency-sweep", "", "run the benchmark once per comma separated -inject-latency `durations`, such as 0,50ms,100ms,200ms, reporting where the stream stops being deliverable in real time")
+	lossSweep        = flag.String("loss-sweep", "", "run the benchmark once per comma separated -inject-loss `percentages`, such as 0,1,2,5, reporting where the stream stops being deliverable in real time")
+	sweepDuration    = flag.Duration("sweep-duration", time.Minute, "how long every run of a sweep lasts at most")
+	sweepReport      = flag.String("sweep-report", "", "also write the report of a sweep as JSON to this `file`")
+)
+
+// sweepDegradation is how much slower than the first run of a sweep the
+// median segment has to be for a run to count as degraded.
+const sweepDegradation = 1.5
+
+// sweepLateShare is the share of segments that may fail or take longer to
+// download than they play before a run no longer counts as deliverable in
+// real time.
+const sweepLateShare = 0.05
+
+// SweepStep is how one run of a sweep went.
+type SweepStep struct {
+	Setting  string                   `json:"setting"`
+	RunID    string                   `json:"run_id"`
+	Segments int                      `json:"segments"`
+	Errors   int                      `json:"errors"`
+	Late     int                      `json:"late"`
+	Latency  map[string]time.Duration `json:"latency,omitempty"`
+	Mbps     float64                  `json:"mbps"`
+	Degraded bool                     `json:"degraded,omitempty"`
+	// Undeliverable is whether too many segments failed or were late.
+	Undeliverable bool `json:"undeliverable,omitempty"`
+}
+
+// SweepReport is every run of a sweep of a setting, and the first settings
+// at which segment latency degraded and the stream stopped being deliverable
+// in real time, if they did.
+type SweepReport struct {
+	Sweep           string       `json:"sweep"`
+	Steps           []*SweepStep `json:"steps"`
+	DegradedAt      string       `json:"degraded_at,omitempty"`
+	UndeliverableAt string       `json:"undeliverable_at,omitempty"`
+}
+
+// runSweep runs the benchmark of playlistURL once per setting, applying each
+// before its run, and reports how segment latency changed. Once ctx is done,
+// the run going on stops and no other starts.
+func runSweep(ctx context.Context, playlistURL, name string, settings []string, apply func(string)) *SweepReport {
+	report := &SweepReport{Sweep: name}
+	var baseline time.Duration
+	for _, setting := range settings {
+		if ctx.Err() != nil {
+			break
+		}
+		apply(setting)
+		log.Infof("Sweeping %v at %v", name, setting)
+		// Every step starts cold, rather than on the connections of the
+		// step before made at another setting. Runs with -transport
+		// isolated get a connection pool of their own anyway.
+		client.CloseIdleConnections()
+		stepCtx, cancel := context.WithTimeout(ctx, *sweepDuration)
+		run, err := NewRun(stepCtx, playlistURL)
+		if err != nil {
+			cancel()
+			log.Fatal(err)
+		}
+		summary := run.Execute()
+		cancel()
+		step := &SweepStep{
+			Setting:  setting,
+			RunID:    run.ID,
+			Segments: len(summary.Results.Total),
+			Errors:   summary.Errors.Total(),
+			Late:     summary.Results.Late,
+			Latency:  latencyPercentiles(summary.Results.Total),
+		}
+		if tr := summary.Results.Throughput(); tr != nil {
+			step.Mbps = tr.Mbps
+		}
+		p50 := step.Latency["p50"]
+		if baseline == 0 {
+			baseline = p50
+		} else if float64(p50) > sweepDegradation*float64(baseline) {
+			step.Degraded = true
+			if report.DegradedAt == "" {
+				report.DegradedAt = setting
+			}
+		}
+		if attempts := step.Segments + step.Errors; attempts == 0 || float64(step.Late+step.Errors) > sweepLateShare*float64(attempts) {
+			step.Undeliverable = true
+			if report.UndeliverableAt == "" {
+				report.UndeliverableAt = setting
+			}
+		}
+		report.Steps = append(report.Steps, step)
+	}
+	return report
+}
+
+// LogSummary logs every step of the sweep and where it degraded.
+func (sr *SweepReport) LogSummary() {
+	for _, step := range sr.Steps {
+		entry := log.WithField("RunID", step.RunID).
+			WithField("Segments", step.Segments).
+			WithField("Errors", step.Errors).
+			WithField("Late", step.Late).
+			WithField("Mbps", step.Mbps)
+		for name, d := range step.Latency {
+			entry = entry.WithField("Latency"+name, d)
+		}
+		entry.Infof("%v %v", sr.Sweep, step.Setting)
+	}
+	if sr.DegradedAt != "" {
+		log.Warnf("Median segment latency degraded by more than %vx at %v %v", sweepDegradation, sr.Sweep, sr.DegradedAt)
+	} else {
+		log.Infof("Median segment latency never degraded by more than %vx", sweepDegradation)
+	}
+	if sr.UndeliverableAt != "" {
+		log.Warnf("More than %v%% of segments failed or were late from %v %v on", sweepLateShare*100, sr.Sweep, sr.UndeliverableAt)
+	}
+}
+
+// Write writes the report to -sweep-report, if given.
+func (sr *SweepReport) Write() error {
+	if *sweepReport == "" {
+		return nil
+	}
+	b, err := json.MarshalIndent(sr, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(*sweepReport, b, 0644)
+}
+
+// concurrencySettings returns 1, 2, 4 and so on up to max, ending on max.
+func concurrencySettings(max int) []string {
+	var settings []string
+	c := 1
+	for ; c < max; c *= 2 {
+		settings = append(settings, strconv.Itoa(c))
+	}
+	return append(settings, strconv.Itoa(max))
+}
+
+// runConcurrencySweep runs the benchmark at increasing -concurrency.
+func runConcurrencySweep(ctx context.Context, playlistURL string) *SweepReport {
+	return runSweep(ctx, playlistURL, "concurrency", concurrencySettings(*concurrencySweep), func(setting string) {
+		*concurrency, _ = strconv.Atoi(setting)
+	})
+}
+
+// splitSettings splits a comma separated list of settings, checking each.
+func splitSettings(list string, check func(string) error) ([]string, error) {
+	var settings []string
+	for _, setting := range strings.Split(list, ",") {
+		setting = strings.TrimSpace(setting)
+		if err := check(setting); err != nil {
+			return nil, err
+		}
+		settings = append(settings, setting)
+	}
+	return settings, nil
+}
+
+// runSweeps runs the sweep asked for, if any, returning nil otherwise.
+// Only one setting can be swept at a time.
+func runSweeps(ctx context.Context, playlistURL string) (*SweepReport, error) {
+	asked := 0
+	for _, on := range []bool{*concurrencySweep > 0, *latencySweep != "", *lossSweep != ""} {
+		if on {
+			asked++
+		}
+	}
+	switch {
+	case asked == 0:
+		return nil, nil
+	case asked > 1:
+		return nil, errors.New("only one of -concurrency-sweep, -latency-sweep and -loss-sweep can be given")
+	case *latencySweep != "":
+		settings, err := splitSettings(*latencySweep, func(setting string) error {
+			if _, err := time.ParseDuration(setting); err != nil {
+				return fmt.Errorf("-latency-sweep: %v", err)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		return runSweep(ctx, playlistURL, "latency", settings, func(setting string) {
+			*injectLatency, _ = time.ParseDuration(setting)
+			shaper.Emulate(*injectLatency, *injectLoss)
+		}), nil
+	case *lossSweep != "":
+		settings, err := splitSettings(*lossSweep, func(setting string) error {
+			if loss, err := strconv.ParseFloat(setting, 64); err != nil || loss < 0 || loss >= 100 {
+				return fmt.Errorf("-loss-sweep: %q is not a percentage below 100", setting)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		return runSweep(ctx, playlistURL, "loss", settings, func(setting string) {
+			*injectLoss, _ = strconv.ParseFloat(setting, 64)
+			shaper.Emulate(*injectLatency, *injectLoss)
+		}), nil
+	}
+	return runConcurrencySweep(ctx, playlistURL), nil
+}
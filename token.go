@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	tokenCommand = flag.String("token-command", "", "shell command that prints an auth token to send with every request")
+	tokenURL     = flag.String("token-url", "", "URL returning an auth token to send with every request, as text or JSON with a token or access_token field")
+	tokenRefresh = flag.Duration("token-refresh", 0, "how often to refresh the auth token; it is also refreshed after any 401 or 403")
+	tokenHeader  = flag.String("token-header", "Authorization", "header the auth token is sent in, empty for none")
+	tokenParam   = flag.String("token-param", "", "query parameter the auth token is sent in")
+)
+
+// tokenTimeout bounds how long fetching a token, with -token-command or
+// -token-url, can take.
+const tokenTimeout = 30 * time.Second
+
+// tokenClient fetches tokens outside of the benchmark's own client so token
+// requests never show up in the results.
+var tokenClient = &http.Client{Timeout: tokenTimeout}
+
+type tokenSource struct {
+	mu    sync.Mutex
+	token string
+	// refreshing is closed once the refresh going on, if there is one,
+	// is done. Tokens are fetched without mu held, so that requests with
+	// the current token don't wait for them.
+	refreshing chan struct{}
+}
+
+var tokens = &tokenSource{}
+
+func (ts *tokenSource) enabled() bool {
+	return *tokenCommand != "" || *tokenURL != ""
+}
+
+func fetchToken() (string, error) {
+	if *tokenCommand != "" {
+		shell, arg := "sh", "-c"
+		if runtime.GOOS == "windows" {
+			shell, arg = "cmd", "/C"
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), tokenTimeout)
+		defer cancel()
+		cmd := exec.CommandContext(ctx, shell, arg, *tokenCommand)
+		// Processes the shell started can hold its output open after it
+		// is killed.
+		cmd.WaitDelay = time.Second
+		out, err := cmd.Output()
+		if ctx.Err() == context.DeadlineExceeded {
+			return "", fmt.Errorf("token command took longer than %v", tokenTimeout)
+		}
+		if err != nil {
+			return "", fmt.Errorf("token command failed: %v", err)
+		}
+		return strings.TrimSpace(string(out)), nil
+	}
+
+	resp, err := tokenClient.Get(*tokenURL)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if !isSuccess(resp) {
+		return "", fmt.Errorf("token URL returned HTTP %v", resp.StatusCode)
+	}
+	var doc struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if json.Unmarshal(body, &doc) == nil {
+		if doc.AccessToken != "" {
+			return doc.AccessToken, nil
+		}
+		if doc.Token != "" {
+			return doc.Token, nil
+		}
+	}
+	return strings.TrimSpace(string(body)), nil
+}
+
+// Refresh fetches a new token, unless the one that was rejected has already
+// been replaced by another request. If another request is refreshing it
+// already, Refresh waits for that instead.
+func (ts *tokenSource) Refresh(rejected string) {
+	ts.mu.Lock()
+	if ts.token != rejected {
+		ts.mu.Unlock()
+		return
+	}
+	if done := ts.refreshing; done != nil {
+		ts.mu.Unlock()
+		<-done
+		return
+	}
+	done := make(chan struct{})
+	ts.refreshing = done
+	ts.mu.Unlock()
+
+	token, err := fetchToken()
+	ts.mu.Lock()
+	if err == nil {
+		ts.token = token
+	}
+	ts.refreshing = nil
+	ts.mu.Unlock()
+	close(done)
+	if err != nil {
+		log.WithError(err).Warn("Unable to refresh auth token")
+		return
+	}
+	log.Info("Refreshed auth token")
+}
+
+// Apply adds the current token to req and returns it.
+func (ts *tokenSource) Apply(req *http.Request) string {
+	if !ts.enabled() {
+		return ""
+	}
+	ts.mu.Lock()
+	token := ts.token
+	ts.mu.Unlock()
+	if token == "" {
+		ts.Refresh("")
+		ts.mu.Lock()
+		token = ts.token
+		ts.mu.Unlock()
+	}
+	if *tokenHeader != "" {
+		req.Header.Set(*tokenHeader, token)
+	}
+	if *tokenParam != "" {
+		queryParams{{*tokenParam, token}}.Apply(req.URL)
+	}
+	return token
+}
+
+func (ts *tokenSource) Rejected(resp *http.Response, token string) {
+	if ts.enabled() && (resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden) {
+		ts.Refresh(token)
+	}
+}
+
+func (ts *tokenSource) Start(interval time.Duration) {
+	if !ts.enabled() || interval <= 0 {
+		return
+	}
+	go func() {
+		for range time.Tick(interval) {
+			ts.mu.Lock()
+			token := ts.token
+			ts.mu.Unlock()
+			ts.Refresh(token)
+		}
+	}()
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/sirupsen/logrus"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	requestIDHeader = flag.String("request-id-header", "X-Request-ID", "header carrying a unique ID for every request, empty to disable")
+	requestIDPrefix = flag.String("request-id-prefix", "", "prefix for generated request IDs (default is the run's trace ID)")
+)
+
+var requestSeq uint64
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		log.Fatal(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// setTraceHeaders sets the headers tracing req as part of traceID, which is
+// shared by every request of a run so that all of them can be found in
+// CDN/origin logs with a single search; each request gets its own span ID.
+func setTraceHeaders(req *http.Request, traceID string) {
+	req.Header.Set("traceparent", fmt.Sprintf("00-%s-%s-01", traceID, randomHex(8)))
+	if *requestIDHeader != "" {
+		prefix := *requestIDPrefix
+		if prefix == "" {
+			prefix = traceID
+		}
+		req.Header.Set(*requestIDHeader, fmt.Sprintf("%s-%d", prefix, atomic.AddUint64(&requestSeq, 1)))
+	}
+}
+
+// traceIDOf returns the trace ID of a traceparent header.
+func traceIDOf(traceparent string) string {
+	parts := strings.Split(traceparent, "-")
+	if len(parts) < 2 {
+		return ""
+	}
+	return parts[1]
+}
+
+func traceFields(req *http.Request) logrus.Fields {
+	fields := logrus.Fields{}
+	if req == nil {
+		return fields
+	}
+	fields["TraceParent"] = req.Header.Get("traceparent")
+	if *requestIDHeader != "" {
+		fields["RequestID"] = req.Header.Get(*requestIDHeader)
+	}
+	return fields
+}
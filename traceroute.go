@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"os/exec"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var tracerouteOver = flag.Duration("traceroute-over", 0, "trace the network path to the edge of every segment taking longer than this, with mtr or else traceroute, attaching the report to the segment's result")
+
+// tracerouteTimeout bounds how long a path is traced for.
+const tracerouteTimeout = time.Minute
+
+// PathTracer traces the network paths to the edges of a run's slow
+// segments in the background, only reporting a segment once the path to its
+// edge is traced so that its result carries the report. Every edge is only
+// traced once per run; later slow segments get the same report.
+type PathTracer struct {
+	mu     sync.Mutex
+	traces map[string]*pathTrace
+	wg     sync.WaitGroup
+}
+
+// pathTrace is the tracing of the path to an edge, with its report once
+// done is closed.
+type pathTrace struct {
+	done chan struct{}
+	path string
+}
+
+func NewPathTracer() *PathTracer {
+	return &PathTracer{traces: map[string]*pathTrace{}}
+}
+
+// Report reports result once the path to edge is traced, tracing it unless
+// it already is or is being traced.
+func (pt *PathTracer) Report(run *Run, result *RequestResult, edge string) {
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+	t, ok := pt.traces[edge]
+	if !ok {
+		t = &pathTrace{done: make(chan struct{})}
+		pt.traces[edge] = t
+		pt.wg.Add(1)
+		go func() {
+			defer pt.wg.Done()
+			t.path = tracePath(run.ctx, edge)
+			close(t.done)
+		}()
+	}
+	pt.wg.Add(1)
+	go func() {
+		defer pt.wg.Done()
+		<-t.done
+		result.Path = t.path
+		run.report(result)
+	}()
+}
+
+// Wait waits for the paths being traced and the segments waiting on them to
+// be reported.
+func (pt *PathTracer) Wait() {
+	pt.wg.Wait()
+}
+
+// tracePath returns a report of the network path to the IP address edge.
+func tracePath(ctx context.Context, edge string) string {
+	ctx, cancel := context.WithTimeout(ctx, tracerouteTimeout)
+	defer cancel()
+	var cmd *exec.Cmd
+	if _, err := exec.LookPath("mtr"); err == nil {
+		cmd = exec.CommandContext(ctx, "mtr", "--report", "--report-cycles", "3", "--no-dns", edge)
+	} else {
+		cmd = exec.CommandContext(ctx, "traceroute", "-n", "-q", "1", "-w", "2", edge)
+	}
+	log.Infof("Tracing the path to %v", edge)
+	out, err := cmd.CombinedOutput()
+	path := string(out)
+	if err != nil {
+		log.Warnf("Tracing the path to %v: %v", edge, err)
+		path += err.Error()
+	}
+	return path
+}
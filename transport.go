@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type connectToRule struct {
+	host, port     string
+	toHost, toPort string
+}
+
+// connectToRules is a repeatable flag using curl's --connect-to syntax,
+// HOST1:PORT1:HOST2:PORT2. Requests for HOST1:PORT1 are sent to HOST2:PORT2
+// while keeping the original Host header and SNI. Empty fields match
+// anything, or keep the original value.
+type connectToRules []connectToRule
+
+// splitHostPorts splits on colons outside of [IPv6] brackets.
+func splitHostPorts(value string) []string {
+	var parts []string
+	depth, start := 0, 0
+	for i, c := range value {
+		switch c {
+		case '[':
+			depth++
+		case ']':
+			depth--
+		case ':':
+			if depth == 0 {
+				parts = append(parts, strings.Trim(value[start:i], "[]"))
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, strings.Trim(value[start:], "[]"))
+}
+
+func (ct *connectToRules) String() string {
+	var rules []string
+	for _, r := range *ct {
+		rules = append(rules, strings.Join([]string{r.host, r.port, r.toHost, r.toPort}, ":"))
+	}
+	return strings.Join(rules, ", ")
+}
+
+func (ct *connectToRules) Set(value string) error {
+	parts := splitHostPorts(value)
+	if len(parts) != 4 {
+		return fmt.Errorf("connect-to %q is not of the form HOST1:PORT1:HOST2:PORT2", value)
+	}
+	*ct = append(*ct, connectToRule{parts[0], parts[1], parts[2], parts[3]})
+	return nil
+}
+
+// Resolve returns the address to dial for addr, applying the first matching
+// rule.
+func (ct connectToRules) Resolve(addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	for _, r := range ct {
+		if (r.host != "" && r.host != host) || (r.port != "" && r.port != port) {
+			continue
+		}
+		if r.toHost != "" {
+			host = r.toHost
+		}
+		if r.toPort != "" {
+			port = r.toPort
+		}
+		return net.JoinHostPort(host, port)
+	}
+	return addr
+}
+
+var connectTo connectToRules
+
+// transportModeFlag is the -transport flag.
+type transportModeFlag string
+
+func (tm *transportModeFlag) String() string {
+	return string(*tm)
+}
+
+func (tm *transportModeFlag) Set(value string) error {
+	switch value {
+	case "shared", "isolated":
+		*tm = transportModeFlag(value)
+		return nil
+	}
+	return fmt.Errorf("transport %q is not shared or isolated", value)
+}
+
+var transportMode transportModeFlag = "shared"
+
+// runClient returns the client a new run makes its requests with: the one
+// all runs share, or with -transport isolated one with connections of its
+// own, as separate players would have. With -failover-after a run has
+// connections of its own too, as its edge blocked in bl must only be for it.
+func runClient(bl *connBlocklist) *http.Client {
+	if transportMode != "isolated" && *failoverAfter <= 0 {
+		return client
+	}
+	return &http.Client{Transport: newTransport(bl), CheckRedirect: checkRedirect}
+}
+
+func init() {
+	flag.Var(&transportMode, "transport", "`whether` runs made at once, with -whole-ladder or by the daemon, share connections: shared, reusing each other's, or isolated, each with a connection pool of its own")
+	flag.Var(&connectTo, "connect-to", "`HOST1:PORT1:HOST2:PORT2` connect to HOST2:PORT2 for requests to HOST1:PORT1, keeping the Host header and SNI, can be repeated")
+}
+
+// newTransport makes a transport refusing to connect to the edges blocked in
+// bl, if there is one.
+func newTransport(bl *connBlocklist) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// Local playlists, see localPlaylist.
+	if localFiles {
+		transport.RegisterProtocol("file", http.NewFileTransport(http.Dir("/")))
+	}
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+	if bl != nil {
+		dialer.Control = bl.control
+	}
+	if *dnsGoResolver {
+		dialer.Resolver = &net.Resolver{PreferGo: true}
+	}
+	transport.DisableKeepAlives = *dnsFresh
+	if *dnsCacheTTL > 0 {
+		resolver := dialer.Resolver
+		if resolver == nil {
+			resolver = net.DefaultResolver
+		}
+		cache := newDNSCache(resolver)
+		transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return bl.track(shapeConn(cache.dial(ctx, dialer, network, connectTo.Resolve(addr))))
+		}
+		return transport
+	}
+	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return bl.track(shapeConn(dialer.DialContext(ctx, network, connectTo.Resolve(addr))))
+	}
+	return transport
+}
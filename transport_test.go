@@ -0,0 +1,77 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitHostPorts(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"cdn.example.com:443:origin.example.com:8443", []string{"cdn.example.com", "443", "origin.example.com", "8443"}},
+		{"::origin:", []string{"", "", "origin", ""}},
+		{"[2001:db8::1]:443:[::1]:8443", []string{"2001:db8::1", "443", "::1", "8443"}},
+		{"host", []string{"host"}},
+		{"", []string{""}},
+	}
+	for _, test := range tests {
+		if got := splitHostPorts(test.in); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("splitHostPorts(%q) = %q, want %q", test.in, got, test.want)
+		}
+	}
+}
+
+func TestConnectToSet(t *testing.T) {
+	var ct connectToRules
+	for _, bad := range []string{"cdn:443:origin", "cdn:443:origin:443:extra", "cdn"} {
+		if err := ct.Set(bad); err == nil {
+			t.Errorf("Set(%q) succeeded, want an error", bad)
+		}
+	}
+	if len(ct) != 0 {
+		t.Errorf("invalid rules were kept: %v", ct.String())
+	}
+	if err := ct.Set("[2001:db8::1]:443:origin:80"); err != nil {
+		t.Fatal(err)
+	}
+	if want := (connectToRule{"2001:db8::1", "443", "origin", "80"}); ct[0] != want {
+		t.Errorf("Set parsed %+v, want %+v", ct[0], want)
+	}
+}
+
+func TestConnectToResolve(t *testing.T) {
+	var ct connectToRules
+	for _, rule := range []string{
+		"cdn.example.com:443:origin.example.com:8443",
+		"cdn.example.com::edge.example.com:",
+		"[2001:db8::1]:80:[::1]:8080",
+		"::catchall.example.com:",
+	} {
+		if err := ct.Set(rule); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		addr, want string
+	}{
+		// The first matching rule wins.
+		{"cdn.example.com:443", "origin.example.com:8443"},
+		// Empty fields match any port and keep it.
+		{"cdn.example.com:80", "edge.example.com:80"},
+		{"other.example.com:443", "catchall.example.com:443"},
+		{"[2001:db8::1]:80", "[::1]:8080"},
+		{"not an address", "not an address"},
+	}
+	for _, test := range tests {
+		if got := ct.Resolve(test.addr); got != test.want {
+			t.Errorf("Resolve(%q) = %q, want %q", test.addr, got, test.want)
+		}
+	}
+
+	var none connectToRules
+	if got := none.Resolve("cdn.example.com:443"); got != "cdn.example.com:443" {
+		t.Errorf("Resolve without rules = %q", got)
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grafov/m3u8"
+	log "github.com/sirupsen/logrus"
+)
+
+var trickPlayDuration = flag.Duration("trick-play-duration", 30*time.Second, "how long to fast forward at each -trick-play rate")
+
+// trickPlayOnTime is the share of I-frames that have to arrive before they
+// are due for trick play at a rate to count as sustained.
+const trickPlayOnTime = 0.95
+
+// trickPlayRates is the -trick-play flag.
+type trickPlayRates []float64
+
+func (tr *trickPlayRates) String() string {
+	var rates []string
+	for _, rate := range *tr {
+		rates = append(rates, strconv.FormatFloat(rate, 'g', -1, 64))
+	}
+	return strings.Join(rates, ",")
+}
+
+func (tr *trickPlayRates) Set(value string) error {
+	*tr = nil
+	for _, s := range strings.Split(value, ",") {
+		rate, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(s), "x"), 64)
+		if err != nil || rate <= 0 {
+			return fmt.Errorf("trick play rate %q is not a positive number", s)
+		}
+		*tr = append(*tr, rate)
+	}
+	return nil
+}
+
+var trickPlay trickPlayRates
+
+func init() {
+	flag.Var(&trickPlay, "trick-play", "instead of playing the stream, fast forward through its I-frame playlist at each of these comma separated `rates`, e.g. 2,4,8")
+}
+
+// TrickPlayResult is how well I-frames were delivered at one trick play rate.
+type TrickPlayResult struct {
+	Rate     float64 `json:"rate"`
+	Frames   int     `json:"frames"`
+	Failures int     `json:"failures"`
+	// Late counts frames that failed or arrived after they were due.
+	Late      int                      `json:"late"`
+	Latency   map[string]time.Duration `json:"latency,omitempty"`
+	Sustained bool                     `json:"sustained"`
+}
+
+func (tp *TrickPlayResult) LogSummary() {
+	entry := log.WithField("Rate", tp.Rate).
+		WithField("Frames", tp.Frames).
+		WithField("Failures", tp.Failures).
+		WithField("Late", tp.Late).
+		WithField("Sustained", tp.Sustained)
+	for name, d := range tp.Latency {
+		entry = entry.WithField("Latency"+name, d)
+	}
+	if tp.Sustained {
+		entry.Info("Trick play")
+	} else {
+		entry.Warn("Trick play")
+	}
+}
+
+// iframeSegments returns the I-frames of run's playlist, which is either an
+// I-frame playlist or a master playlist with one, and the EXT-X-MAP they need.
+func iframeSegments(run *Run) (*SegmentDownload, []*SegmentDownload, error) {
+	urlStr := run.PlaylistURL
+	playlistUrl, err := url.Parse(urlStr)
+	if err != nil {
+		return nil, nil, err
+	}
+	if *baseURL != "" {
+		if playlistUrl, err = url.Parse(*baseURL); err != nil {
+			return nil, nil, err
+		}
+	}
+	playlist, err := fetchPlaylist(run, urlStr, "")
+	if err != nil {
+		return nil, nil, err
+	}
+	if playlist != nil && playlist.Type == m3u8.MASTER {
+		var variant *m3u8.Variant
+		for _, v := range playlist.Playlist.(*m3u8.MasterPlaylist).Variants {
+			if v != nil && v.Iframe {
+				variant = v
+				break
+			}
+		}
+		if variant == nil {
+			return nil, nil, errors.New("master playlist has no I-frame playlists")
+		}
+		if urlStr, playlistUrl, err = resolvePlaylist(playlistUrl, variant.URI); err != nil {
+			return nil, nil, err
+		}
+		log.WithField("Bandwidth", variant.Bandwidth).Infof("Fast forwarding through %v", urlStr)
+		if playlist, err = fetchPlaylist(run, urlStr, RenditionIFrames); err != nil {
+			return nil, nil, err
+		}
+	}
+	if playlist == nil {
+		return nil, nil, fmt.Errorf("couldn't fetch %v", urlStr)
+	}
+	if playlist.Type != m3u8.MEDIA || !playlist.Playlist.(*m3u8.MediaPlaylist).Iframe {
+		return nil, nil, fmt.Errorf("%v is not an I-frame playlist", urlStr)
+	}
+	return mediaSegments(playlistUrl, playlist.Playlist.(*m3u8.MediaPlaylist), RenditionIFrames, false)
+}
+
+// fastForward fetches frames one at a time as a player showing them at rate
+// would, for at most -trick-play-duration. Every I-frame is due once the one
+// before it has been on screen for its duration divided by the rate.
+func fastForward(run *Run, frames []*SegmentDownload, rate float64, results *ResultSummary) *TrickPlayResult {
+	tp := &TrickPlayResult{Rate: rate}
+	var times []time.Duration
+	started := time.Now()
+	for _, frame := range frames {
+		if run.ctx.Err() != nil || time.Since(started) >= *trickPlayDuration {
+			break
+		}
+		due := time.Duration(frame.Duration / rate * float64(time.Second))
+		fetchedAt := time.Now()
+		result := downloadSegment(run, nil, frame)
+		elapsed := time.Since(fetchedAt)
+		if result == nil {
+			tp.Failures++
+		} else {
+			results.Add(result)
+			times = append(times, result.Timings.Total)
+			tp.Frames++
+		}
+		if result == nil || elapsed > due {
+			tp.Late++
+		}
+		run.sleep(due - elapsed)
+	}
+	tp.Latency = latencyPercentiles(times)
+	total := tp.Frames + tp.Failures
+	tp.Sustained = total > 0 && float64(total-tp.Late) >= trickPlayOnTime*float64(total)
+	return tp
+}
+
+// executeTrickPlay fast forwards through run's I-frame playlist at every
+// -trick-play rate in turn.
+func executeTrickPlay(run *Run) ResultSummary {
+	results := ResultSummary{}
+	initSegment, frames, err := iframeSegments(run)
+	if err != nil {
+		log.Error(err)
+		run.Err = err
+		return results
+	}
+	if initSegment != nil {
+		if result := downloadSegment(run, nil, initSegment); result != nil {
+			results.Add(result)
+		}
+	}
+	for _, rate := range trickPlay {
+		if run.ctx.Err() != nil {
+			break
+		}
+		log.Infof("Fast forwarding at %vx", rate)
+		run.TrickPlay = append(run.TrickPlay, fastForward(run, frames, rate, &results))
+	}
+	return results
+}
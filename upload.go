@@ -0,0 +1,344 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var uploadTo = flag.String("upload", "", "`s3://bucket/prefix or gs://bucket/prefix` to upload the summary, -output files, -record archive, -save-dir segments, -bundle and -metrics-file to once the run finishes")
+
+// uploadClient is kept apart from the benchmark's client, like tokenClient,
+// so uploads never show up in the results.
+var uploadClient = &http.Client{Timeout: 10 * time.Minute}
+
+// objectStore is somewhere run artifacts can be uploaded to.
+type objectStore interface {
+	Put(key string, body io.Reader, size int64) error
+}
+
+// newObjectStore returns the store for an s3:// or gs:// URI along with the
+// key prefix within it.
+//
+// S3 credentials come from AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and
+// AWS_SESSION_TOKEN, the region from AWS_REGION and AWS_ENDPOINT_URL can point
+// at an S3 compatible store. GCS uses the token in GOOGLE_OAUTH_ACCESS_TOKEN,
+// or failing that the instance's service account.
+func newObjectStore(uri string) (objectStore, string, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, "", err
+	}
+	prefix := strings.Trim(u.Path, "/")
+	switch u.Scheme {
+	case "s3":
+		store := &s3Store{
+			bucket:       u.Host,
+			region:       os.Getenv("AWS_REGION"),
+			endpoint:     os.Getenv("AWS_ENDPOINT_URL"),
+			accessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+			secretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			sessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+		}
+		if store.region == "" {
+			store.region = os.Getenv("AWS_DEFAULT_REGION")
+		}
+		if store.region == "" {
+			store.region = "us-east-1"
+		}
+		if store.accessKey == "" || store.secretKey == "" {
+			return nil, "", fmt.Errorf("uploading to %v needs AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY", uri)
+		}
+		return store, prefix, nil
+	case "gs":
+		return &gcsStore{bucket: u.Host}, prefix, nil
+	}
+	return nil, "", fmt.Errorf("can't upload to %q, expected an s3:// or gs:// URI", uri)
+}
+
+func putObject(req *http.Request) error {
+	resp, err := uploadClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if !isSuccess(resp) {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, classifyBodyBytes))
+		return fmt.Errorf("uploading %v returned HTTP %v: %v", req.URL, resp.StatusCode, classifyErrorBody(body))
+	}
+	return nil
+}
+
+type s3Store struct {
+	bucket       string
+	region       string
+	endpoint     string
+	accessKey    string
+	secretKey    string
+	sessionToken string
+}
+
+// awsEscape escapes s the way SigV4 canonical URIs expect, leaving slashes
+// alone.
+func awsEscape(s string) string {
+	return awsEscapeExcept(s, "-_.~/")
+}
+
+func awsEscapeExcept(s, unreserved string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || strings.IndexByte(unreserved, c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// awsCanonicalQuery sorts the query parameters of u by name and value and
+// escapes them, slashes included.
+func awsCanonicalQuery(u *url.URL) string {
+	var params []string
+	for name, values := range u.Query() {
+		for _, value := range values {
+			params = append(params, awsEscapeExcept(name, "-_.~")+"="+awsEscapeExcept(value, "-_.~"))
+		}
+	}
+	sort.Strings(params)
+	return strings.Join(params, "&")
+}
+
+// signV4 signs req with AWS Signature Version 4 at now, covering its host
+// and every header already set, for a body hashing to payloadHash.
+func signV4(req *http.Request, accessKey, secretKey, region, service, payloadHash string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	values := map[string]string{"host": host}
+	for name, v := range req.Header {
+		values[strings.ToLower(name)] = strings.Join(strings.Fields(strings.Join(v, ",")), " ")
+	}
+	signed := make([]string, 0, len(values))
+	for name := range values {
+		signed = append(signed, name)
+	}
+	sort.Strings(signed)
+	var headers strings.Builder
+	for _, name := range signed {
+		fmt.Fprintf(&headers, "%v:%v\n", name, values[name])
+	}
+
+	canonical := strings.Join([]string{req.Method, awsEscape(req.URL.Path), awsCanonicalQuery(req.URL), headers.String(), strings.Join(signed, ";"), payloadHash}, "\n")
+	hash := sha256.Sum256([]byte(canonical))
+	scope := fmt.Sprintf("%v/%v/%v/aws4_request", day, region, service)
+	toSign := fmt.Sprintf("AWS4-HMAC-SHA256\n%v\n%v\n%v", amzDate, scope, hex.EncodeToString(hash[:]))
+
+	signingKey := hmacSHA256([]byte("AWS4"+secretKey), day)
+	signingKey = hmacSHA256(signingKey, region)
+	signingKey = hmacSHA256(signingKey, service)
+	signingKey = hmacSHA256(signingKey, "aws4_request")
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%v/%v, SignedHeaders=%v, Signature=%v",
+		accessKey, scope, strings.Join(signed, ";"), hex.EncodeToString(hmacSHA256(signingKey, toSign))))
+}
+
+func (s *s3Store) Put(key string, body io.Reader, size int64) error {
+	endpoint := fmt.Sprintf("https://%v.s3.%v.amazonaws.com", s.bucket, s.region)
+	escaped := "/" + awsEscape(key)
+	if s.endpoint != "" {
+		endpoint = strings.TrimRight(s.endpoint, "/")
+		escaped = "/" + awsEscape(s.bucket) + escaped
+	}
+	u, err := url.Parse(endpoint + escaped)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("PUT", u.String(), body)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	req.Header.Set("x-amz-content-sha256", "UNSIGNED-PAYLOAD")
+	if s.sessionToken != "" {
+		req.Header.Set("x-amz-security-token", s.sessionToken)
+	}
+	signV4(req, s.accessKey, s.secretKey, s.region, "s3", "UNSIGNED-PAYLOAD", time.Now())
+	return putObject(req)
+}
+
+type gcsStore struct {
+	bucket string
+	token  string
+}
+
+// accessToken returns GOOGLE_OAUTH_ACCESS_TOKEN or asks the metadata server
+// for the instance's service account token.
+func (g *gcsStore) accessToken() (string, error) {
+	if g.token != "" {
+		return g.token, nil
+	}
+	if token := os.Getenv("GOOGLE_OAUTH_ACCESS_TOKEN"); token != "" {
+		g.token = token
+		return token, nil
+	}
+	req, err := http.NewRequest("GET", "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token", nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	resp, err := tokenClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("no GOOGLE_OAUTH_ACCESS_TOKEN and no metadata server: %v", err)
+	}
+	defer resp.Body.Close()
+	var doc struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil || doc.AccessToken == "" {
+		return "", fmt.Errorf("metadata server returned HTTP %v without a token", resp.StatusCode)
+	}
+	g.token = doc.AccessToken
+	return g.token, nil
+}
+
+func (g *gcsStore) Put(key string, body io.Reader, size int64) error {
+	token, err := g.accessToken()
+	if err != nil {
+		return err
+	}
+	u := fmt.Sprintf("https://storage.googleapis.com/upload/storage/v1/b/%v/o?uploadType=media&name=%v", url.PathEscape(g.bucket), url.QueryEscape(key))
+	req, err := http.NewRequest("POST", u, body)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/octet-stream")
+	return putObject(req)
+}
+
+// uploadFile uploads the file at name, or everything under it if it's a
+// directory, to key.
+func uploadFile(store objectStore, key, name string) error {
+	return filepath.Walk(name, func(p string, info os.FileInfo, err error) error {
+		if err != nil || !info.Mode().IsRegular() {
+			return err
+		}
+		rel, err := filepath.Rel(name, p)
+		if err != nil {
+			return err
+		}
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		k := key
+		if rel != "." {
+			k = path.Join(key, filepath.ToSlash(rel))
+		}
+		log.Infof("Uploading %v to %v", p, k)
+		return store.Put(k, f, info.Size())
+	})
+}
+
+// outputFiles returns the files written by the -output sinks of specs.
+func outputFiles(specs []string) []string {
+	var names []string
+	for _, spec := range specs {
+		parts := strings.SplitN(spec, ":", 2)
+		if len(parts) == 2 && parts[1] != "-" {
+			if info, err := os.Stat(parts[1]); err == nil && info.Mode().IsRegular() {
+				names = append(names, parts[1])
+			}
+		}
+	}
+	return names
+}
+
+// artifacts returns the local files and directories written by a run: the
+// files of its own -output sinks, the -record archive, the -save-dir
+// segments, the -bundle, the -metrics-file, the -charts and the run's own
+// -run-dir directory. The -output files the runs of -whole-ladder share are
+// uploaded once they all are over, see uploadLadderOutputs.
+func artifacts(run *Run) []string {
+	names := outputFiles(run.outputSpecs)
+	for _, name := range []string{run.Files.Record, *saveDir, run.Files.Bundle, run.Files.Metrics, run.Files.Charts, run.Dir} {
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// uploadArtifacts uploads a run's summary and artifacts under a directory of
+// the -upload prefix named after the run's ID.
+func uploadArtifacts(uri string, run *Run, summary *RunSummary) error {
+	store, prefix, err := newObjectStore(uri)
+	if err != nil {
+		return err
+	}
+	prefix = path.Join(prefix, summary.RunID)
+
+	report, err := json.MarshalIndent(newJSONSummary(summary), "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := store.Put(path.Join(prefix, "summary.json"), bytes.NewReader(report), int64(len(report))); err != nil {
+		return err
+	}
+	for _, name := range artifacts(run) {
+		if err := uploadFile(store, path.Join(prefix, filepath.Base(name)), name); err != nil {
+			return err
+		}
+	}
+	log.WithField("RunID", summary.RunID).Infof("Uploaded run artifacts to %v/%v", strings.TrimRight(uri, "/"), summary.RunID)
+	return nil
+}
+
+// uploadLadderOutputs uploads the -output files of specs, which the runs of
+// a -whole-ladder shared, under a directory of the -upload prefix named after
+// the ladder's ID.
+func uploadLadderOutputs(uri, id string, specs []string) error {
+	store, prefix, err := newObjectStore(uri)
+	if err != nil {
+		return err
+	}
+	prefix = path.Join(prefix, id)
+	for _, name := range outputFiles(specs) {
+		if err := uploadFile(store, path.Join(prefix, filepath.Base(name)), name); err != nil {
+			return err
+		}
+	}
+	log.Infof("Uploaded the ladder's outputs to %v/%v", strings.TrimRight(uri, "/"), id)
+	return nil
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"net/http"
+	_ "net/http/pprof"
+	"runtime"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var pprofAddr = flag.String("pprof", "", "serve Go's pprof profiles of the benchmark itself on this `address`, e.g. localhost:6060")
+
+// resourceInterval is how often ResourceMonitor samples memory use.
+const resourceInterval = time.Second
+
+// startPprof serves the profiles net/http/pprof registers with -pprof.
+func startPprof() {
+	if *pprofAddr == "" {
+		return
+	}
+	go func() {
+		log.Infof("Serving pprof profiles on http://%v/debug/pprof/", *pprofAddr)
+		if err := http.ListenAndServe(*pprofAddr, nil); err != nil {
+			log.Errorf("Serving pprof profiles: %v", err)
+		}
+	}()
+}
+
+// ResourceMonitor records the CPU, memory and garbage collection the
+// benchmark itself uses over a run, to tell whether the host running it,
+// rather than what it benchmarks, was the bottleneck.
+type ResourceMonitor struct {
+	mu         sync.Mutex
+	start      time.Time
+	user, sys  time.Duration
+	numGC      uint32
+	pauseTotal uint64
+	maxHeap    uint64
+	maxSys     uint64
+	maxRoutine int
+}
+
+func NewResourceMonitor() *ResourceMonitor {
+	rm := &ResourceMonitor{start: time.Now()}
+	rm.user, rm.sys, _ = cpuTime()
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	rm.numGC, rm.pauseTotal = ms.NumGC, ms.PauseTotalNs
+	return rm
+}
+
+func (rm *ResourceMonitor) sample() {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	routines := runtime.NumGoroutine()
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	if ms.HeapAlloc > rm.maxHeap {
+		rm.maxHeap = ms.HeapAlloc
+	}
+	if ms.Sys > rm.maxSys {
+		rm.maxSys = ms.Sys
+	}
+	if routines > rm.maxRoutine {
+		rm.maxRoutine = routines
+	}
+}
+
+// Run samples memory use every second until ctx is done.
+func (rm *ResourceMonitor) Run(ctx context.Context) {
+	ticker := time.NewTicker(resourceInterval)
+	defer ticker.Stop()
+	for {
+		rm.sample()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ResourceReport is what the benchmark used over a run. CPU is the share
+// of all cores' time it used, and GCPause the share of the run it spent
+// paused for garbage collection.
+type ResourceReport struct {
+	CPUs          int           `json:"cpus"`
+	UserCPU       time.Duration `json:"user_cpu,omitempty"`
+	SystemCPU     time.Duration `json:"system_cpu,omitempty"`
+	CPU           float64       `json:"cpu,omitempty"`
+	MaxHeapBytes  uint64        `json:"max_heap_bytes"`
+	MaxSysBytes   uint64        `json:"max_sys_bytes"`
+	MaxGoroutines int           `json:"max_goroutines"`
+	GCs           uint32        `json:"gcs"`
+	GCPauseTotal  time.Duration `json:"gc_pause_total"`
+	GCPause       float64       `json:"gc_pause"`
+}
+
+func (rm *ResourceMonitor) Report() *ResourceReport {
+	if rm == nil {
+		return nil
+	}
+	rm.sample()
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	elapsed := time.Since(rm.start)
+	report := &ResourceReport{
+		CPUs:          runtime.NumCPU(),
+		MaxHeapBytes:  rm.maxHeap,
+		MaxSysBytes:   rm.maxSys,
+		MaxGoroutines: rm.maxRoutine,
+		GCs:           ms.NumGC - rm.numGC,
+		GCPauseTotal:  time.Duration(ms.PauseTotalNs - rm.pauseTotal),
+	}
+	if user, sys, ok := cpuTime(); ok {
+		report.UserCPU, report.SystemCPU = user-rm.user, sys-rm.sys
+		if elapsed > 0 {
+			report.CPU = float64(report.UserCPU+report.SystemCPU) / float64(elapsed) / float64(report.CPUs)
+		}
+	}
+	if elapsed > 0 {
+		report.GCPause = float64(report.GCPauseTotal) / float64(elapsed)
+	}
+	return report
+}
+
+func (rm *ResourceMonitor) LogSummary() {
+	r := rm.Report()
+	if r == nil {
+		return
+	}
+	entry := log.WithField("CPU", r.CPU).
+		WithField("MaxHeapBytes", r.MaxHeapBytes).
+		WithField("MaxGoroutines", r.MaxGoroutines).
+		WithField("GCs", r.GCs).
+		WithField("GCPause", r.GCPause)
+	if r.CPU > 0.8 {
+		entry.Warn("The benchmark was using most of the host's CPU, so it may have been the bottleneck")
+	} else {
+		entry.Info("Benchmark resource usage")
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// viaChain returns the intermediaries a response passed through going by its
+// Via headers, the one closest to the origin first. Each is named by the
+// comment after it if it has one, as CDNs name themselves there while
+// giving every edge a pseudonym of its own, or else by its pseudonym.
+func viaChain(header http.Header) []string {
+	var chain []string
+	for _, via := range header["Via"] {
+		depth, start := 0, 0
+		var entries []string
+		for i, c := range via {
+			switch c {
+			case '(':
+				depth++
+			case ')':
+				depth--
+			case ',':
+				if depth == 0 {
+					entries = append(entries, via[start:i])
+					start = i + 1
+				}
+			}
+		}
+		entries = append(entries, via[start:])
+		for _, entry := range entries {
+			entry = strings.TrimSpace(entry)
+			if entry == "" {
+				continue
+			}
+			if i, j := strings.Index(entry, "("), strings.LastIndex(entry, ")"); i >= 0 && j > i {
+				chain = append(chain, strings.TrimSpace(entry[i+1:j]))
+				continue
+			}
+			fields := strings.Fields(entry)
+			chain = append(chain, fields[len(fields)-1])
+		}
+	}
+	return chain
+}
+
+// ViaStats counts how many intermediaries responses passed through and
+// which, to expose caches nobody expected in the way.
+type ViaStats struct {
+	mu        sync.Mutex
+	responses int
+	lengths   map[int]int
+	proxies   map[string]int
+}
+
+func NewViaStats() *ViaStats {
+	return &ViaStats{lengths: map[int]int{}, proxies: map[string]int{}}
+}
+
+func (vs *ViaStats) Add(chain []string) {
+	vs.mu.Lock()
+	defer vs.mu.Unlock()
+	vs.responses++
+	vs.lengths[len(chain)]++
+	for _, proxy := range chain {
+		vs.proxies[proxy]++
+	}
+}
+
+// ViaReport is the number of responses by the length of their Via chain,
+// and the number of responses every intermediary appeared in.
+type ViaReport struct {
+	Responses int            `json:"responses"`
+	Lengths   map[string]int `json:"lengths"`
+	Proxies   map[string]int `json:"proxies,omitempty"`
+}
+
+// Report returns nil if no responses came through an intermediary.
+func (vs *ViaStats) Report() *ViaReport {
+	if vs == nil {
+		return nil
+	}
+	vs.mu.Lock()
+	defer vs.mu.Unlock()
+	if len(vs.proxies) == 0 {
+		return nil
+	}
+	report := &ViaReport{Responses: vs.responses, Lengths: map[string]int{}, Proxies: map[string]int{}}
+	for length, n := range vs.lengths {
+		report.Lengths[strconv.Itoa(length)] = n
+	}
+	for proxy, n := range vs.proxies {
+		report.Proxies[proxy] = n
+	}
+	return report
+}
+
+func (vs *ViaStats) LogSummary() {
+	r := vs.Report()
+	if r == nil {
+		return
+	}
+	log.WithField("Responses", r.Responses).
+		WithField("Lengths", r.Lengths).
+		WithField("Proxies", r.Proxies).
+		Info("Via chains")
+	if len(r.Lengths) > 1 {
+		var lengths []string
+		for length := range r.Lengths {
+			lengths = append(lengths, length)
+		}
+		sort.Strings(lengths)
+		log.Warnf("Responses passed through different numbers of intermediaries: %v", strings.Join(lengths, ", "))
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestViaChain(t *testing.T) {
+	tests := []struct {
+		name string
+		via  []string
+		want []string
+	}{
+		{"none", nil, nil},
+		{"pseudonym", []string{"1.1 varnish"}, []string{"varnish"}},
+		{"host and port", []string{"HTTP/1.1 proxy.example.com:8080"}, []string{"proxy.example.com:8080"}},
+		{
+			"comments name CDNs",
+			[]string{"1.1 a3b5c7.cloudfront.net (CloudFront), 1.1 varnish"},
+			[]string{"CloudFront", "varnish"},
+		},
+		{
+			"comma inside a comment",
+			[]string{"1.1 edge (Cache, v2), 2 shield"},
+			[]string{"Cache, v2", "shield"},
+		},
+		{
+			"several fields",
+			[]string{"1.1 origin-shield", "1.1 edge (Fastly)"},
+			[]string{"origin-shield", "Fastly"},
+		},
+		{"empty entries", []string{" , 1.1 varnish,"}, []string{"varnish"}},
+	}
+	for _, test := range tests {
+		header := http.Header{}
+		for _, v := range test.via {
+			header.Add("Via", v)
+		}
+		if got := viaChain(header); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%v: viaChain = %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestViaStatsReport(t *testing.T) {
+	vs := NewViaStats()
+	if r := vs.Report(); r != nil {
+		t.Errorf("Report without intermediaries = %+v, want nil", r)
+	}
+	vs.Add(nil)
+	vs.Add([]string{"CloudFront", "varnish"})
+	vs.Add([]string{"CloudFront"})
+	want := &ViaReport{
+		Responses: 3,
+		Lengths:   map[string]int{"0": 1, "1": 1, "2": 1},
+		Proxies:   map[string]int{"CloudFront": 2, "varnish": 1},
+	}
+	if got := vs.Report(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Report = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// isWebVTT reports whether a segment is a WebVTT subtitle segment, going by
+// its Content-Type or, as servers often send text/plain, its extension.
+func isWebVTT(resp *http.Response) bool {
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "text/vtt") {
+		return true
+	}
+	ext := path.Ext(resp.Request.URL.Path)
+	return ext == ".vtt" || ext == ".webvtt"
+}
+
+// parseVTTTimestamp parses a WebVTT timestamp, [hh:]mm:ss.ttt, the hours
+// being of two digits or more.
+func parseVTTTimestamp(s string) (time.Duration, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) < 2 || len(parts) > 3 || len(parts[0]) < 2 || len(parts[len(parts)-2]) != 2 {
+		return 0, fmt.Errorf("malformed timestamp %q", s)
+	}
+	secs := strings.SplitN(parts[len(parts)-1], ".", 2)
+	if len(secs) != 2 || len(secs[0]) != 2 || len(secs[1]) != 3 {
+		return 0, fmt.Errorf("malformed timestamp %q", s)
+	}
+	fields := append(parts[:len(parts)-1], secs...)
+	units := []time.Duration{time.Millisecond, time.Second, time.Minute, time.Hour}
+	var d time.Duration
+	for i, f := range fields {
+		n, err := strconv.ParseUint(f, 10, 32)
+		if err != nil {
+			return 0, fmt.Errorf("malformed timestamp %q", s)
+		}
+		unit := units[len(fields)-1-i]
+		if (unit == time.Minute || unit == time.Second) && n > 59 {
+			return 0, fmt.Errorf("malformed timestamp %q", s)
+		}
+		d += time.Duration(n) * unit
+	}
+	return d, nil
+}
+
+// validateTimestampMap checks the value of an X-TIMESTAMP-MAP header, which
+// maps cue times onto the MPEG-2 timestamps of the other renditions.
+func validateTimestampMap(value string) error {
+	var mpegts, local bool
+	for _, field := range strings.Split(value, ",") {
+		kv := strings.SplitN(strings.TrimSpace(field), ":", 2)
+		if len(kv) != 2 {
+			return fmt.Errorf("malformed X-TIMESTAMP-MAP %q", value)
+		}
+		switch kv[0] {
+		case "MPEGTS":
+			if _, err := strconv.ParseUint(kv[1], 10, 64); err != nil {
+				return fmt.Errorf("malformed X-TIMESTAMP-MAP %q", value)
+			}
+			mpegts = true
+		case "LOCAL":
+			if _, err := parseVTTTimestamp(kv[1]); err != nil {
+				return fmt.Errorf("malformed X-TIMESTAMP-MAP %q", value)
+			}
+			local = true
+		}
+	}
+	if !mpegts || !local {
+		return fmt.Errorf("X-TIMESTAMP-MAP %q needs both MPEGTS and LOCAL", value)
+	}
+	return nil
+}
+
+// validateWebVTT returns what is wrong with a WebVTT segment: a missing
+// header or X-TIMESTAMP-MAP, cue timings that don't parse, end before they
+// start or go back in time, and segments without any cues.
+func validateWebVTT(body []byte) []string {
+	body = bytes.TrimPrefix(body, []byte("\xef\xbb\xbf"))
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	if !scanner.Scan() || !strings.HasPrefix(scanner.Text(), "WEBVTT") {
+		return []string{"missing WEBVTT header"}
+	}
+
+	var problems []string
+	header, timestampMap := true, false
+	cues := 0
+	var previous time.Duration
+	for line := 1; scanner.Scan(); {
+		line++
+		text := strings.TrimRight(scanner.Text(), "\r")
+		if header {
+			if text == "" {
+				header = false
+			} else if strings.HasPrefix(text, "X-TIMESTAMP-MAP=") {
+				timestampMap = true
+				if err := validateTimestampMap(strings.TrimPrefix(text, "X-TIMESTAMP-MAP=")); err != nil {
+					problems = append(problems, err.Error())
+				}
+			}
+			continue
+		}
+		if !strings.Contains(text, "-->") {
+			continue
+		}
+		cues++
+		timing := strings.SplitN(text, "-->", 2)
+		end := strings.Fields(timing[1])
+		if len(end) == 0 {
+			problems = append(problems, fmt.Sprintf("line %d: malformed cue timing %q", line, text))
+			continue
+		}
+		start, err := parseVTTTimestamp(strings.TrimSpace(timing[0]))
+		if err == nil {
+			var stop time.Duration
+			if stop, err = parseVTTTimestamp(end[0]); err == nil && stop <= start {
+				err = fmt.Errorf("cue ends at %v before it starts at %v", stop, start)
+			}
+		}
+		if err == nil && start < previous {
+			err = fmt.Errorf("cue starts at %v, before the previous one at %v", start, previous)
+		}
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("line %d: %v", line, err))
+			continue
+		}
+		previous = start
+	}
+	if err := scanner.Err(); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if !timestampMap {
+		problems = append(problems, "missing X-TIMESTAMP-MAP")
+	}
+	if cues == 0 {
+		problems = append(problems, "no cues")
+	}
+	return problems
+}
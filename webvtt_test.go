@@ -0,0 +1,92 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseVTTTimestamp(t *testing.T) {
+	tests := []struct {
+		in   string
+		want time.Duration
+		ok   bool
+	}{
+		{"00:01.000", time.Second, true},
+		{"01:02.003", time.Minute + 2*time.Second + 3*time.Millisecond, true},
+		{"01:00:00.500", time.Hour + 500*time.Millisecond, true},
+		{"100:00:00.000", 100 * time.Hour, true},
+		{"00:60.000", 0, false},
+		{"60:00.000", 0, false},
+		{"00:01.00", 0, false},
+		{"0:01.000", 0, false},
+		{"1:00:00.000", 0, false},
+		{"00:1:00.000", 0, false},
+		{"00:01", 0, false},
+		{"01.000", 0, false},
+		{"aa:01.000", 0, false},
+		{"1:00:00:00.000", 0, false},
+	}
+	for _, test := range tests {
+		got, err := parseVTTTimestamp(test.in)
+		if (err == nil) != test.ok || got != test.want {
+			t.Errorf("parseVTTTimestamp(%q) = %v, %v; want %v, ok %v", test.in, got, err, test.want, test.ok)
+		}
+	}
+}
+
+func TestValidateWebVTT(t *testing.T) {
+	const timestampMap = "X-TIMESTAMP-MAP=MPEGTS:900000,LOCAL:00:00:00.000\n"
+	tests := []struct {
+		name string
+		body string
+		want []string
+	}{
+		{
+			"valid",
+			"WEBVTT\n" + timestampMap + "\n00:00.000 --> 00:02.000 align:start\nHello\n\n00:02.000 --> 00:04.000\nWorld\n",
+			nil,
+		},
+		{
+			"byte order mark and CRLF",
+			"\xef\xbb\xbfWEBVTT\r\n" + "X-TIMESTAMP-MAP=LOCAL:00:00.000,MPEGTS:0\r\n\r\n1\r\n00:00.000 --> 00:02.000\r\nHello\r\n",
+			nil,
+		},
+		{"no header", "00:00.000 --> 00:02.000\nHello\n", []string{"missing WEBVTT header"}},
+		{"empty", "", []string{"missing WEBVTT header"}},
+		{
+			"no timestamp map or cues",
+			"WEBVTT\n\nNOTE nothing yet\n",
+			[]string{"missing X-TIMESTAMP-MAP", "no cues"},
+		},
+		{
+			"malformed timestamp map",
+			"WEBVTT\nX-TIMESTAMP-MAP=MPEGTS:abc,LOCAL:00:00:00.000\n\n00:00.000 --> 00:02.000\nHello\n",
+			[]string{`malformed X-TIMESTAMP-MAP "MPEGTS:abc,LOCAL:00:00:00.000"`},
+		},
+		{
+			"incomplete timestamp map",
+			"WEBVTT\nX-TIMESTAMP-MAP=MPEGTS:900000\n\n00:00.000 --> 00:02.000\nHello\n",
+			[]string{`X-TIMESTAMP-MAP "MPEGTS:900000" needs both MPEGTS and LOCAL`},
+		},
+		{
+			"bad cues",
+			"WEBVTT\n" + timestampMap + "\n00:05.000 --> 00:04.000\nBackwards\n\n00:03.000 --> 00:06.000\nEarlier\n\n00:0x.000 --> 00:07.000\nGarbled\n\n00:08.000 -->\nNo end\n",
+			[]string{
+				"line 4: cue ends at 4s before it starts at 5s",
+				"line 10: malformed timestamp \"00:0x.000\"",
+				"line 13: malformed cue timing \"00:08.000 -->\"",
+			},
+		},
+		{
+			"out of order",
+			"WEBVTT\n" + timestampMap + "\n00:05.000 --> 00:06.000\nLater\n\n00:03.000 --> 00:04.000\nEarlier\n",
+			[]string{"line 7: cue starts at 3s, before the previous one at 5s"},
+		},
+	}
+	for _, test := range tests {
+		if got := validateWebVTT([]byte(test.body)); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%v: validateWebVTT = %q, want %q", test.name, got, test.want)
+		}
+	}
+}